@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"log"
 	"os"
 	"path/filepath"
@@ -10,8 +11,8 @@ import (
 	"strconv"
 	"time"
 
-	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/amns13/shipboard/internal/sqlite"
+	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/joho/godotenv"
 )
 
@@ -27,11 +28,34 @@ func (this *Migration) MigrationFileName() string {
 
 const MigrationDir = "migrations"
 
-const InitialMigrationCheckQuery = `
+// Dialect is what differs between the databases migrations are applied to.
+// Both share the migration numbers, each has its own files.
+type Dialect struct {
+	Name string
+	// Directory of the migration files, within MigrationDir
+	Dir                        string
+	InitialMigrationCheckQuery string
+}
+
+var PostgresDialect = Dialect{
+	Name: "postgres",
+	Dir:  "",
+	InitialMigrationCheckQuery: `
 SELECT EXISTS(
     SELECT * FROM pg_tables WHERE schemaname='public' AND tablename='migrations'
 );
-`
+`,
+}
+
+var SQLiteDialect = Dialect{
+	Name: "sqlite",
+	Dir:  "sqlite",
+	InitialMigrationCheckQuery: `
+SELECT EXISTS(
+    SELECT * FROM sqlite_master WHERE type='table' AND name='migrations'
+);
+`,
+}
 
 const LatestMigrationQuery = `
 SELECT id, name, applied_on
@@ -41,12 +65,12 @@ LIMIT 1;
 `
 
 const InsertMigration = `
-INSERT INTO migrations(id, name) VALUES ($1, $2) RETURNING *;
+INSERT INTO migrations(id, name) VALUES ($1, $2) RETURNING id, name, applied_on;
 `
 
-func getLastMigration(ctx context.Context, dbpool *pgxpool.Pool) (*Migration, error) {
-	rows, _ := dbpool.Query(ctx, InitialMigrationCheckQuery)
-	isDbInitiated, err := pgx.CollectExactlyOneRow(rows, pgx.RowTo[bool])
+func getLastMigration(ctx context.Context, db *sql.DB, dialect Dialect) (*Migration, error) {
+	var isDbInitiated bool
+	err := db.QueryRowContext(ctx, dialect.InitialMigrationCheckQuery).Scan(&isDbInitiated)
 	if err != nil {
 		return nil, err
 	}
@@ -55,57 +79,60 @@ func getLastMigration(ctx context.Context, dbpool *pgxpool.Pool) (*Migration, er
 		return nil, nil
 	}
 
-	rows, _ = dbpool.Query(ctx, LatestMigrationQuery)
-	migration, err := pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByName[Migration])
-	return migration, nil
+	var migration Migration
+	err = db.QueryRowContext(ctx, LatestMigrationQuery).Scan(&migration.Id, &migration.Name, &migration.AppliedOn)
+	return &migration, nil
 }
 
 func stripFileExtension(fileName string) string {
 	return fileName[:len(fileName)-len(filepath.Ext(fileName))]
 }
 
-func applyMigration(ctx context.Context, dbpool *pgxpool.Pool, fileName string, migrationNum int) *Migration {
-	filePath := filepath.Join(".", MigrationDir, fileName)
+func applyMigration(ctx context.Context, db *sql.DB, dialect Dialect, fileName string, migrationNum int) *Migration {
+	filePath := filepath.Join(".", MigrationDir, dialect.Dir, fileName)
 	b, err := os.ReadFile(filePath)
 	if err != nil {
 		log.Fatalf("Error while reading initial migration file: %v", err)
 	}
 	// Convert byte array to string
 	query := string(b)
-	tx, err := dbpool.Begin(ctx)
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		log.Fatalf("Error starting transaction: %v", err)
 	}
 	// Rollback is safe to call even if the tx is already closed, so if
 	// the tx commits successfully, this is a no-op
-	defer tx.Rollback(ctx)
+	defer tx.Rollback()
 	// Execute the DDL command for creating initial migration
 
-	_, err = tx.Exec(ctx, query)
+	_, err = tx.ExecContext(ctx, query)
 	if err != nil {
 		log.Fatalf("Error while executing initial migration query: %v", err)
 	}
 
-	rows, _ := tx.Query(ctx, InsertMigration, migrationNum, stripFileExtension(fileName))
-	migration, err := pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByName[Migration])
+	var migration Migration
+	err = tx.QueryRowContext(ctx, InsertMigration, migrationNum, stripFileExtension(fileName)).Scan(&migration.Id, &migration.Name, &migration.AppliedOn)
 	if err != nil {
 		log.Fatalf("Error while executing create query for initial migration: %v", err)
 	}
-	err = tx.Commit(ctx)
+	err = tx.Commit()
+	if err != nil {
+		log.Fatalf("Error while committing migration: %v", err)
+	}
 
 	log.Printf("Successfully applied migration from %s", fileName)
 
-	return migration
+	return &migration
 }
 
-func applyInitialMigration(ctx context.Context, dbpool *pgxpool.Pool) *Migration {
+func applyInitialMigration(ctx context.Context, db *sql.DB, dialect Dialect) *Migration {
 	initialMigrationFileName := "migration_0000.sql"
-	return applyMigration(ctx, dbpool, initialMigrationFileName, 0)
+	return applyMigration(ctx, db, dialect, initialMigrationFileName, 0)
 }
 
-func applyPendingMigrations(ctx context.Context, dbpool *pgxpool.Pool, lastMigration *Migration) {
+func applyPendingMigrations(ctx context.Context, db *sql.DB, dialect Dialect, lastMigration *Migration) {
 
-	files, err := os.ReadDir(filepath.Join(".", MigrationDir))
+	files, err := os.ReadDir(filepath.Join(".", MigrationDir, dialect.Dir))
 	if err != nil {
 		log.Fatalf("Error while fetching migration files: %v\n", err)
 	}
@@ -117,6 +144,10 @@ func applyPendingMigrations(ctx context.Context, dbpool *pgxpool.Pool, lastMigra
 	var pendingMigrationNums []int
 
 	for _, file := range files {
+		// Migrations of the other dialects
+		if file.IsDir() {
+			continue
+		}
 		fileName := file.Name()
 		matches := pattern.FindStringSubmatch(fileName)
 		if len(matches) != 2 {
@@ -139,34 +170,46 @@ func applyPendingMigrations(ctx context.Context, dbpool *pgxpool.Pool, lastMigra
 	for _, num := range pendingMigrationNums {
 		pendingMigrationFileName := pendingMigrationNumToFileName[num]
 		log.Printf("Pending migration file: %s\n", pendingMigrationFileName)
-		applyMigration(ctx, dbpool, pendingMigrationFileName, num)
+		applyMigration(ctx, db, dialect, pendingMigrationFileName, num)
 	}
 
 }
 
+// openDb connects to the SQLite file at SQLITE_PATH if it is set, to Postgres
+// at POSTGRES_URI otherwise.
+func openDb() (*sql.DB, Dialect, error) {
+	if path := os.Getenv("SQLITE_PATH"); path != "" {
+		db, err := sqlite.Open(path)
+		return db, SQLiteDialect, err
+	}
+	db, err := sql.Open("pgx", os.Getenv("POSTGRES_URI"))
+	return db, PostgresDialect, err
+}
+
 func main() {
 	ctx := context.Background()
 	err := godotenv.Load()
 	if err != nil {
 		log.Fatalf("Error loading .env file: %v", err)
 	}
-	dbpool, err := pgxpool.New(context.Background(), os.Getenv("POSTGRES_URI"))
+	db, dialect, err := openDb()
 	if err != nil {
 		log.Fatalf("Error connecting to DB: %v\n", err)
 	}
-	defer dbpool.Close()
-	lastMigration, err := getLastMigration(ctx, dbpool)
+	defer db.Close()
+	log.Printf("Applying %s migrations\n", dialect.Name)
+	lastMigration, err := getLastMigration(ctx, db, dialect)
 	if err != nil {
 		log.Fatalf("Error initiating migrations: %v\n", err)
 	}
 
 	if lastMigration == nil {
 		log.Println("No previously applied migration. Initial migration will be applied first")
-		lastMigration = applyInitialMigration(ctx, dbpool)
+		lastMigration = applyInitialMigration(ctx, db, dialect)
 
 	} else {
 		log.Printf("Last migration | id: %d | name: %v\n", lastMigration.Id, lastMigration.Name)
 	}
-	applyPendingMigrations(ctx, dbpool, lastMigration)
+	applyPendingMigrations(ctx, db, dialect, lastMigration)
 
 }
@@ -3,17 +3,36 @@
 package main
 
 import (
+	"expvar"
+	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/amns13/shipboard/internal/api"
 	"github.com/amns13/shipboard/internal/conf"
 	"github.com/amns13/shipboard/internal/middleware"
+	"github.com/amns13/shipboard/internal/model"
+	"github.com/amns13/shipboard/internal/services"
+	"github.com/amns13/shipboard/internal/sqlite"
 	"github.com/joho/godotenv"
 )
 
-var templates = []string{"templates/index.html", "templates/register.html", "templates/login.html"}
+var templates = []string{
+	"templates/index.html",
+	"templates/register.html",
+	"templates/login.html",
+	"templates/settings.html",
+	"templates/admin.html",
+	"templates/teams.html",
+	"templates/team.html",
+	"templates/share.html",
+	"templates/paste.html",
+	"templates/search.html",
+}
 
 func startServer(mux *http.ServeMux) {
 	s := &http.Server{
@@ -27,6 +46,16 @@ func registerEndpoints(mux *http.ServeMux, env *conf.Env) {
 	// Create middleware functions
 	requestMiddleware := middleware.LogRequestResponse(env)
 	authMiddleware := middleware.RequireAuth(env)
+	csrfMiddleware := middleware.RequireCSRFToken(env)
+	idempotencyMiddleware := middleware.Idempotent(env)
+	// Cookie authenticated routes
+	protected := func(handler http.HandlerFunc) http.Handler {
+		return requestMiddleware(authMiddleware(csrfMiddleware(handler)))
+	}
+	adminMiddleware := middleware.RequireRole(env, model.ROLE_ADMIN)
+	adminOnly := func(handler http.HandlerFunc) http.Handler {
+		return requestMiddleware(authMiddleware(csrfMiddleware(adminMiddleware(handler))))
+	}
 
 	// Restrict root path
 	mux.Handle("/", http.NotFoundHandler())
@@ -40,11 +69,69 @@ func registerEndpoints(mux *http.ServeMux, env *conf.Env) {
 	mux.Handle("GET /login/", requestMiddleware(http.HandlerFunc(api.LoginForm(env))))
 	mux.Handle("POST /login/", requestMiddleware(http.HandlerFunc(api.Login(env))))
 
-	// Protected routes with both logging and auth
+	// Protected routes with logging, auth and CSRF protection
+	mux.Handle("DELETE /logout/", protected(api.Logout(env)))
+	mux.Handle("GET /clip/", protected(api.Clip(env)))
+	mux.Handle("GET /clip/paste/{$}", protected(api.Paste(env)))
+	mux.Handle("GET /clip/paste/preview", protected(api.PastePreview(env)))
+	// Serves download, thumbnail and reveal, see ClipResource
+	mux.Handle("GET /clip/{id}/{resource}", protected(api.ClipResource(env)))
+
+	// Only the routes above are backed by the SQLite stores, and idempotency
+	// keys are kept in redis
+	if !env.HasPostgres() {
+		mux.Handle("POST /clip/", protected(api.Broadcast(env)))
+		return
+	}
+	mux.Handle("POST /clip/", protected(idempotencyMiddleware(api.Broadcast(env)).ServeHTTP))
 
-	mux.Handle("DELETE /logout/", requestMiddleware(authMiddleware(http.HandlerFunc(api.Logout(env)))))
-	mux.Handle("GET /clip/", requestMiddleware(authMiddleware(http.HandlerFunc(api.Clip(env)))))
-	mux.Handle("POST /clip/", requestMiddleware(authMiddleware(http.HandlerFunc(api.Broadcast(env)))))
+	mux.Handle("GET /account/email/verify/", requestMiddleware(http.HandlerFunc(api.VerifyEmail(env))))
+	mux.Handle("GET /s/{token}", requestMiddleware(http.HandlerFunc(api.SharedClip(env))))
+	mux.Handle("POST /s/{token}", requestMiddleware(http.HandlerFunc(api.SharedClip(env))))
+
+	mux.Handle("GET /devices/", protected(api.Devices(env)))
+	mux.Handle("POST /devices/", protected(api.RegisterDevice(env)))
+	mux.Handle("POST /devices/{device}/delete/", protected(api.DeleteDevice(env)))
+	mux.Handle("GET /devices/{device}/inbox/", protected(api.DeviceInbox(env)))
+	mux.Handle("POST /devices/{device}/inbox/{clip}/ack", protected(api.AckDelivery(env)))
+	mux.Handle("POST /clip/{id}/share", protected(api.ShareClip(env)))
+	mux.Handle("GET /clip/search", protected(api.SearchClips(env)))
+	mux.Handle("POST /clip/{id}/tags", protected(api.TagClip(env)))
+	mux.Handle("GET /clip/tags/{$}", protected(api.Tags(env)))
+	mux.Handle("POST /clip/tags/{name}/rename", protected(api.RenameTag(env)))
+	mux.Handle("GET /clip/slots/{$}", protected(api.Slots(env)))
+	mux.Handle("POST /clip/slots/{$}", protected(api.SetSlot(env)))
+	mux.Handle("GET /clip/slots/{name}", protected(api.Slot(env)))
+	mux.Handle("PUT /clip/slots/{name}", protected(api.SetSlot(env)))
+	mux.Handle("DELETE /clip/slots/{name}", protected(api.DeleteSlot(env)))
+	mux.Handle("POST /uploads/", protected(api.CreateUpload(env)))
+	mux.Handle("HEAD /uploads/{id}", protected(api.UploadOffset(env)))
+	mux.Handle("PATCH /uploads/{id}", protected(api.PatchUpload(env)))
+	mux.Handle("POST /uploads/{id}/finalize", protected(api.FinalizeUpload(env)))
+	mux.Handle("DELETE /uploads/{id}", protected(api.DeleteUpload(env)))
+	mux.Handle("GET /account/", protected(api.AccountSettings(env)))
+	mux.Handle("POST /account/password/", protected(api.ChangePassword(env)))
+	mux.Handle("POST /account/email/", protected(api.ChangeEmail(env)))
+	mux.Handle("POST /account/delete/", protected(api.DeleteAccount(env)))
+	mux.Handle("POST /account/retention/", protected(api.ChangeRetention(env)))
+	mux.Handle("GET /account/export", protected(api.ExportAccount(env)))
+	mux.Handle("GET /account/export/{token}", protected(api.DownloadExport(env)))
+	mux.Handle("GET /teams/", protected(api.Teams(env)))
+	mux.Handle("POST /teams/", protected(api.CreateTeam(env)))
+	mux.Handle("GET /teams/{id}/", protected(api.TeamDetail(env)))
+	mux.Handle("POST /teams/{id}/members/", protected(api.InviteTeamMember(env)))
+	mux.Handle("POST /teams/{id}/members/{user_id}/remove/", protected(api.RemoveTeamMember(env)))
+	mux.Handle("POST /teams/{id}/channels/", protected(api.CreateTeamChannel(env)))
+	mux.Handle("GET /teams/{id}/channels/{channel}/clip/", protected(api.TeamClip(env)))
+	mux.Handle("POST /teams/{id}/channels/{channel}/clip/", protected(api.BroadcastToTeam(env)))
+
+	// Admin routes
+	mux.Handle("GET /admin/", adminOnly(api.AdminDashboard(env)))
+	mux.Handle("POST /admin/users/{id}/disable/", adminOnly(api.DisableUser(env)))
+	mux.Handle("POST /admin/users/{id}/enable/", adminOnly(api.EnableUser(env)))
+	mux.Handle("POST /admin/users/{id}/logout/", adminOnly(api.ForceLogout(env)))
+	mux.Handle("POST /admin/users/{id}/quota/", adminOnly(api.SetUserQuota(env)))
+	mux.Handle("GET /debug/vars", adminOnly(expvar.Handler().ServeHTTP))
 }
 
 func loadEnvironment() (*conf.Env, error) {
@@ -54,22 +141,332 @@ func loadEnvironment() (*conf.Env, error) {
 		return nil, err
 	}
 
-	env, err := conf.LoadEnv(os.Getenv("POSTGRES_URI"), os.Getenv("REDIS_URI"), templates)
+	var env *conf.Env
+	if path := os.Getenv("SQLITE_PATH"); path != "" {
+		env, err = conf.LoadSQLiteEnv(path, templates)
+	} else {
+		env, err = conf.LoadEnv(os.Getenv("POSTGRES_URI"), os.Getenv("REDIS_URI"), templates)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if env.HasPostgres() {
+		model.UsePostgres(env)
+	}
+
+	env.PasswordHasher, err = loadPasswordHasher()
 	if err != nil {
 		return nil, err
 	}
+
+	env.PublicURL = os.Getenv("PUBLIC_URL")
+	if env.PublicURL == "" {
+		env.PublicURL = "http://localhost:8080"
+	}
+
+	if secret := os.Getenv("CSRF_SECRET"); secret != "" {
+		env.CSRFSecret = []byte(secret)
+	} else {
+		env.Logger.Println("CSRF_SECRET is not set, using a random secret")
+	}
+
+	if value := os.Getenv("MAX_CLIP_SIZE"); value != "" {
+		env.MaxClipSize, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || env.MaxClipSize <= 0 {
+			return nil, fmt.Errorf("invalid value for MAX_CLIP_SIZE: %q", value)
+		}
+	}
+
+	if value := os.Getenv("BLOB_INLINE_LIMIT"); value != "" {
+		env.BlobInlineLimit, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || env.BlobInlineLimit < 0 {
+			return nil, fmt.Errorf("invalid value for BLOB_INLINE_LIMIT: %q", value)
+		}
+	}
+
+	env.BlobStore, err = loadBlobStore(env.BlobStore, env.HasPostgres())
+	if err != nil {
+		return nil, err
+	}
+
+	env.Retention, err = loadRetentionPolicy()
+	if err != nil {
+		return nil, err
+	}
+
+	for name, limit := range map[string]*int64{
+		"QUOTA_MAX_CLIPS": &env.Quota.MaxClips,
+		"QUOTA_MAX_BYTES": &env.Quota.MaxBytes,
+	} {
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+		*limit, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || *limit < 0 {
+			return nil, fmt.Errorf("invalid value for %s: %q", name, value)
+		}
+	}
 	return env, err
 }
 
+// loadRetentionPolicy builds the global retention policy from RETENTION_MAX_AGE
+// (a duration like 720h), RETENTION_MAX_CLIPS and RETENTION_MAX_BYTES. Unset
+// variables don't limit.
+func loadRetentionPolicy() (services.RetentionPolicy, error) {
+	var policy services.RetentionPolicy
+	if value := os.Getenv("RETENTION_MAX_AGE"); value != "" {
+		maxAge, err := time.ParseDuration(value)
+		if err != nil || maxAge <= 0 {
+			return policy, fmt.Errorf("invalid value for RETENTION_MAX_AGE: %q", value)
+		}
+		policy.MaxAge = maxAge
+	}
+	if value := os.Getenv("RETENTION_MAX_CLIPS"); value != "" {
+		maxClips, err := strconv.Atoi(value)
+		if err != nil || maxClips <= 0 {
+			return policy, fmt.Errorf("invalid value for RETENTION_MAX_CLIPS: %q", value)
+		}
+		policy.MaxClips = maxClips
+	}
+	if value := os.Getenv("RETENTION_MAX_BYTES"); value != "" {
+		maxBytes, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || maxBytes <= 0 {
+			return policy, fmt.Errorf("invalid value for RETENTION_MAX_BYTES: %q", value)
+		}
+		policy.MaxBytes = maxBytes
+	}
+	return policy, nil
+}
+
+// loadPasswordHasher builds the password hasher from the PASSWORD_HASH_*
+// variables. Unset variables fall back to the defaults of the algorithm.
+func loadPasswordHasher() (services.PasswordHasher, error) {
+	params := make(map[string]int)
+	// Largest value of each parameter, larger ones would wrap around when
+	// converted
+	for name, max := range map[string]int{
+		"PASSWORD_HASH_BCRYPT_COST":      math.MaxInt32,
+		"PASSWORD_HASH_ARGON2ID_TIME":    math.MaxUint32,
+		"PASSWORD_HASH_ARGON2ID_MEMORY":  math.MaxUint32,
+		"PASSWORD_HASH_ARGON2ID_THREADS": math.MaxUint8,
+	} {
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 || parsed > max {
+			return nil, fmt.Errorf("invalid value for %s: %q", name, value)
+		}
+		params[name] = parsed
+	}
+
+	return services.NewPasswordHasher(
+		os.Getenv("PASSWORD_HASH_ALGORITHM"),
+		params["PASSWORD_HASH_BCRYPT_COST"],
+		uint32(params["PASSWORD_HASH_ARGON2ID_TIME"]),
+		uint32(params["PASSWORD_HASH_ARGON2ID_MEMORY"]),
+		uint8(params["PASSWORD_HASH_ARGON2ID_THREADS"]),
+	)
+}
+
+// loadBlobStore builds the blob store from BLOB_STORE, either fs with the
+// BLOB_DIR directory or s3 with the S3_* variables. BLOB_DIR alone means fs.
+// Returns fallback if neither is set, unless the blob store must be
+// persistent, i.e. with Postgres, since the fallback is a temporary directory.
+func loadBlobStore(fallback services.BlobStore, persistent bool) (services.BlobStore, error) {
+	switch os.Getenv("BLOB_STORE") {
+	case "":
+		if dir := os.Getenv("BLOB_DIR"); dir != "" {
+			return &services.FileBlobStore{Root: dir}, nil
+		}
+		if persistent {
+			return nil, fmt.Errorf("BLOB_STORE or BLOB_DIR must be set with Postgres, files would be lost from the temporary directory")
+		}
+		return fallback, nil
+	case "fs":
+		dir := os.Getenv("BLOB_DIR")
+		if dir == "" {
+			return nil, fmt.Errorf("BLOB_DIR must be set for the fs blob store")
+		}
+		return &services.FileBlobStore{Root: dir}, nil
+	case "s3":
+		store := &services.S3BlobStore{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+		}
+		if store.Endpoint == "" || store.Region == "" || store.Bucket == "" {
+			return nil, fmt.Errorf("S3_ENDPOINT, S3_REGION and S3_BUCKET must be set for the s3 blob store")
+		}
+		return store, nil
+	default:
+		return nil, fmt.Errorf("invalid value for BLOB_STORE: %q", os.Getenv("BLOB_STORE"))
+	}
+}
+
+const (
+	blobCollectionInterval = time.Hour
+	// Blobs are written before their clip is created
+	blobCollectionGracePeriod = time.Hour
+)
+
+// collectOrphanBlobs periodically deletes blobs and thumbnails of deleted
+// clips, chunks of expired uploads and expired export files. Running it on
+// several instances at once is safe.
+func collectOrphanBlobs(env *conf.Env) {
+	ticker := time.NewTicker(blobCollectionInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		deleted, err := model.CollectOrphanBlobs(env, blobCollectionGracePeriod)
+		if err != nil {
+			env.Logger.Printf("Error occurred while collecting orphan blobs: %v", err)
+		}
+		if deleted > 0 {
+			env.Logger.Printf("Deleted %d orphan blobs", deleted)
+		}
+
+		deleted, err = model.CollectOrphanThumbnails(env)
+		if err != nil {
+			env.Logger.Printf("Error occurred while collecting orphan thumbnails: %v", err)
+		}
+		if deleted > 0 {
+			env.Logger.Printf("Deleted %d orphan thumbnails", deleted)
+		}
+
+		// Uploads expire in redis, their chunks must be deleted here
+		cutoff := time.Now().Add(-services.UploadTTL)
+		deleted, err = services.DeleteBlobsBefore(env.BlobStore, services.UPLOAD_BLOB_PREFIX, cutoff)
+		if err != nil {
+			env.Logger.Printf("Error occurred while deleting chunks of expired uploads: %v", err)
+		}
+		if deleted > 0 {
+			env.Logger.Printf("Deleted %d chunks of expired uploads", deleted)
+		}
+
+		// Exports are written to the disk of the instance that generated them
+		deleted, err = services.DeleteExportsBefore(time.Now().Add(-services.ExportTTL))
+		if err != nil {
+			env.Logger.Printf("Error occurred while deleting expired exports: %v", err)
+		}
+		if deleted > 0 {
+			env.Logger.Printf("Deleted %d expired exports", deleted)
+		}
+	}
+}
+
+const (
+	retentionSweepInterval = 10 * time.Minute
+	retentionLockName      = "retention"
+)
+
+// Published at /debug/vars
+var retentionMetrics = expvar.NewMap("retention")
+
+// sweepRetention periodically deletes the clips beyond the retention policy
+// of each user. Only the instance holding the lock sweeps.
+func sweepRetention(env *conf.Env) {
+	lockStore := services.LockStore{Client: env.Rdb}
+	ticker := time.NewTicker(retentionSweepInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		// Expires by the next tick if this instance dies while sweeping
+		token, err := lockStore.Acquire(retentionLockName, retentionSweepInterval)
+		if err != nil {
+			env.Logger.Printf("Error occurred while acquiring retention lock: %v", err)
+			continue
+		}
+		if token == "" {
+			continue
+		}
+		started := time.Now()
+		sweep, err := sweepRetentionOnce(env)
+		retentionMetrics.Add("sweeps", 1)
+		retentionMetrics.Add("clips_deleted", int64(sweep.Clips))
+		retentionMetrics.Add("bytes_deleted", sweep.Bytes)
+		retentionMetrics.Add("blobs_deleted", int64(sweep.Blobs))
+		duration := new(expvar.Float)
+		duration.Set(time.Since(started).Seconds())
+		retentionMetrics.Set("last_sweep_seconds", duration)
+		if err != nil {
+			retentionMetrics.Add("errors", 1)
+			env.Logger.Printf("Error occurred while sweeping clips: %v", err)
+		}
+		if sweep.Clips > 0 {
+			env.Logger.Printf("Deleted %d clips (%d bytes) beyond retention", sweep.Clips, sweep.Bytes)
+		}
+
+		err = lockStore.Release(retentionLockName, token)
+		if err != nil {
+			env.Logger.Printf("Error occurred while releasing retention lock: %v", err)
+		}
+	}
+}
+
+// sweepRetentionOnce sweeps the clips of every user with a policy. An error
+// stops the sweep, the next one picks up where it left.
+func sweepRetentionOnce(env *conf.Env) (model.RetentionSweep, error) {
+	var total model.RetentionSweep
+	usage, err := model.GetClipStorageUsage(env)
+	if err != nil {
+		return total, err
+	}
+	policies, err := model.GetRetentionPolicies(env)
+	if err != nil {
+		return total, err
+	}
+	for userID := range usage {
+		sweep, err := model.SweepUserClips(env, userID, env.Retention.Stricter(policies[userID]))
+		total.Clips += sweep.Clips
+		total.Bytes += sweep.Bytes
+		total.Blobs += sweep.Blobs
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+const sqliteSweepInterval = time.Minute
+
+// sweepSQLite periodically deletes the expired sessions and clipboards, which
+// redis expires by itself.
+func sweepSQLite(env *conf.Env) {
+	ticker := time.NewTicker(sqliteSweepInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		deleted, err := sqlite.Sweep(env.SQLiteDb)
+		if err != nil {
+			env.Logger.Printf("Error occurred while sweeping expired rows: %v", err)
+		}
+		if deleted > 0 {
+			env.Logger.Printf("Deleted %d expired sessions and clipboards", deleted)
+		}
+	}
+}
+
 func main() {
 	env, err := loadEnvironment()
 	if err != nil {
 		log.Fatalf("Error initializing environment: %v", err)
 	}
 	env.Logger.Println("Initialized environment")
-	defer env.Db.Close()
 
 	mux := http.NewServeMux()
 	registerEndpoints(mux, env)
+	if env.HasPostgres() {
+		defer env.Db.Close()
+		go collectOrphanBlobs(env)
+		go sweepRetention(env)
+	} else {
+		// Clips are kept inline in SQLite, there are no blobs to collect.
+		// Retention is not supported on SQLite yet.
+		defer env.SQLiteDb.Close()
+		env.Logger.Println("Running on SQLite, only the clipboard and history are available")
+		go sweepSQLite(env)
+	}
 	startServer(mux)
 }
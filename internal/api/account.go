@@ -0,0 +1,301 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/amns13/shipboard/internal/conf"
+	"github.com/amns13/shipboard/internal/middleware"
+	"github.com/amns13/shipboard/internal/model"
+	"github.com/amns13/shipboard/internal/services"
+	"github.com/redis/go-redis/v9"
+)
+
+type accountSettingsData struct {
+	Name      string
+	Email     string
+	CSRFToken string
+	// Set by the user, and by the server for everyone
+	Retention       retentionLimits
+	GlobalRetention retentionLimits
+}
+
+// authenticatedUser fetches the user set in the request context by RequireAuth
+func authenticatedUser(env *conf.Env, req *http.Request) (*model.User, error) {
+	userID, ok := req.Context().Value(middleware.AuthUserID).(int32)
+	if !ok {
+		return nil, fmt.Errorf("invalid user id in context: %v", req.Context().Value(middleware.AuthUserID))
+	}
+	return env.Users.GetUserByID(userID)
+}
+
+// reauthenticate checks the password sent with the request. It writes the
+// error response and returns false if the password does not match.
+func reauthenticate(env *conf.Env, w http.ResponseWriter, req *http.Request, user *model.User) bool {
+	matched, err := env.PasswordHasher.Verify(user.PasswordHash, req.PostFormValue("password"))
+	if err != nil {
+		env.Logger.Printf("Error occurred while verifying password of user %d: %v", user.Id, err)
+		http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+		return false
+	}
+	if !matched {
+		http.Error(w, "Invalid password", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func AccountSettings(env *conf.Env) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		user, err := authenticatedUser(env, req)
+		if err != nil {
+			env.Logger.Printf("Error occurred while fetching user: %v", err)
+			http.Redirect(w, req, "/login/", http.StatusTemporaryRedirect)
+			return
+		}
+		csrfToken, _ := req.Context().Value(middleware.CSRFToken).(string)
+		policy, err := env.Users.GetUserRetentionPolicy(user.Id)
+		if err != nil {
+			env.Logger.Printf("Error occurred while fetching retention policy: %v", err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		data := accountSettingsData{
+			Name:            user.Name,
+			Email:           user.Email,
+			CSRFToken:       csrfToken,
+			Retention:       newRetentionLimits(policy),
+			GlobalRetention: newRetentionLimits(env.Retention),
+		}
+		err = env.Templates.ExecuteTemplate(w, "settings.html", data)
+		if err != nil {
+			env.Logger.Printf("Error occurred while rendering settings: %v", err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func ChangePassword(env *conf.Env) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		user, err := authenticatedUser(env, req)
+		if err != nil {
+			env.Logger.Printf("Error occurred while fetching user: %v", err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		if !reauthenticate(env, w, req, user) {
+			return
+		}
+
+		newPassword := req.PostFormValue("new_password")
+		if newPassword == "" {
+			http.Error(w, "New password is required", http.StatusBadRequest)
+			return
+		}
+		passwordHash, err := env.PasswordHasher.Hash(newPassword)
+		if err != nil {
+			env.Logger.Printf("Error occurred while generating password hash: %v", err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		err = env.Users.UpdatePasswordHash(user.Id, passwordHash)
+		if err != nil {
+			env.Logger.Printf("Error occurred while updating password of user %d: %v", user.Id, err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+
+		// Anyone holding a session from before the change must log in again
+		sessionID, _ := req.Context().Value(middleware.AuthSessionID).(string)
+		err = env.Sessions.ExpireUserSessions(user.Id, sessionID)
+		if err != nil {
+			env.Logger.Printf("Error occurred while expiring sessions of user %d: %v", user.Id, err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("Password changed. Other sessions have been logged out."))
+	}
+}
+
+func ChangeEmail(env *conf.Env) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		user, err := authenticatedUser(env, req)
+		if err != nil {
+			env.Logger.Printf("Error occurred while fetching user: %v", err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		if !reauthenticate(env, w, req, user) {
+			return
+		}
+
+		email, err := mail.ParseAddress(req.PostFormValue("email"))
+		if err != nil {
+			http.Error(w, "Invalid email address", http.StatusBadRequest)
+			return
+		}
+		exists, err := env.Users.UserExists(email.Address)
+		if err != nil {
+			env.Logger.Printf("Error occurred: %v", err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		if exists {
+			http.Error(w, "Email already exists", http.StatusBadRequest)
+			return
+		}
+		if !requireStore(w, env.Verifications != nil, "Email changes") {
+			return
+		}
+
+		token, err := env.Verifications.Create(services.EmailVerification{UserID: user.Id, Email: email.Address})
+		if err != nil {
+			env.Logger.Printf("Error occurred while creating email verification: %v", err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		link := fmt.Sprintf("%s/account/email/verify/?token=%s", env.PublicURL, url.QueryEscape(token))
+		body := fmt.Sprintf(
+			"Open the link below to confirm your new shipboard email address. It expires in %s.\n\n%s",
+			services.EmailVerificationTTL, link,
+		)
+		err = env.Mailer.Send(email.Address, "Confirm your new email address", body)
+		if err != nil {
+			env.Logger.Printf("Error occurred while sending verification email: %v", err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("Check your inbox to confirm the new email address."))
+	}
+}
+
+// VerifyEmail is public, possession of the token is the proof.
+func VerifyEmail(env *conf.Env) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireStore(w, env.Verifications != nil, "Email changes") {
+			return
+		}
+		verification, err := env.Verifications.Consume(req.URL.Query().Get("token"))
+		if err == redis.Nil {
+			http.Error(w, "Invalid or expired link", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			env.Logger.Printf("Error occurred while verifying email: %v", err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+
+		// The address could have been registered since the change was requested
+		exists, err := env.Users.UserExists(verification.Email)
+		if err != nil {
+			env.Logger.Printf("Error occurred: %v", err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		if exists {
+			http.Error(w, "Email already exists", http.StatusBadRequest)
+			return
+		}
+		err = env.Users.UpdateEmail(verification.UserID, verification.Email)
+		if err != nil {
+			env.Logger.Printf("Error occurred while updating email of user %d: %v", verification.UserID, err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, req, "/account/", http.StatusSeeOther)
+	}
+}
+
+func DeleteAccount(env *conf.Env) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		user, err := authenticatedUser(env, req)
+		if err != nil {
+			env.Logger.Printf("Error occurred while fetching user: %v", err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		if !reauthenticate(env, w, req, user) {
+			return
+		}
+
+		err = env.Users.DeleteUser(user.Id, func() error {
+			return deleteUserData(env, user)
+		})
+		if err != nil {
+			env.Logger.Printf("Error occurred while deleting user %d: %v", user.Id, err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		env.Logger.Printf("Deleted user %d", user.Id)
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     "session_id",
+			Value:    "",
+			Expires:  time.Unix(0, 0),
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteStrictMode,
+			Path:     "/",
+		})
+		w.Header().Set("HX-Redirect", "/register/")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// deleteUserData deletes everything the redis stores, the blob store and the
+// export directory keep for the user.
+func deleteUserData(env *conf.Env, user *model.User) error {
+	err := env.Clipboard.Clear(user.Uid.String())
+	if err != nil {
+		return err
+	}
+	if env.Verifications != nil {
+		err = env.Verifications.Cancel(user.Id)
+		if err != nil {
+			return err
+		}
+	}
+	if env.Shares != nil {
+		err = env.Shares.DeleteUserShares(user.Id)
+		if err != nil {
+			return err
+		}
+	}
+	if env.Uploads != nil {
+		uploads, err := env.Uploads.UserUploads(user.Id)
+		if err != nil {
+			return err
+		}
+		for _, upload := range uploads {
+			err = deleteUpload(env, &upload)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if env.Exports != nil {
+		jobs, err := env.Exports.DeleteUserExports(user.Id)
+		if err != nil {
+			return err
+		}
+		for _, job := range jobs {
+			if job.Path == "" {
+				continue
+			}
+			err = os.Remove(job.Path)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	// Idempotency keys only hold status codes and expire within a day, and
+	// team memberships are deleted along with the user.
+	return env.Sessions.ExpireUserSessions(user.Id, "")
+}
@@ -0,0 +1,280 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amns13/shipboard/internal/conf"
+	"github.com/amns13/shipboard/internal/middleware"
+	"github.com/amns13/shipboard/internal/model"
+	"github.com/amns13/shipboard/internal/services"
+	"github.com/jackc/pgx/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+const testPassword = "correct horse"
+
+// newAccountTestEnv returns an env kept in memory, with a single user whose
+// password is testPassword.
+func newAccountTestEnv(t *testing.T) (*conf.Env, *model.User) {
+	t.Helper()
+	env, _ := newTestEnv(t)
+	passwordHash, err := env.PasswordHasher.Hash(testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := env.Users.CreateUser(model.UserCreator{Name: "Account", Email: "account@example.com", PasswordHash: passwordHash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return env, user
+}
+
+func createTestSession(t *testing.T, env *conf.Env, user *model.User) string {
+	t.Helper()
+	sessionID, err := env.Sessions.CreateSession(services.SessionData{
+		UserID:    user.Id,
+		Email:     user.Email,
+		LoginTime: time.Now(),
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sessionID
+}
+
+// postAccount sends the form to the handler as the user logged in with
+// sessionID.
+func postAccount(handler http.HandlerFunc, user *model.User, sessionID string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/account/", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req = authenticated(req, user)
+	req = req.WithContext(context.WithValue(req.Context(), middleware.AuthSessionID, sessionID))
+	w := httptest.NewRecorder()
+	handler(w, req)
+	return w
+}
+
+func TestAccountWrongPassword(t *testing.T) {
+	env, user := newAccountTestEnv(t)
+	sessionID := createTestSession(t, env, user)
+
+	handlers := map[string]struct {
+		handler http.HandlerFunc
+		form    url.Values
+	}{
+		"ChangePassword": {ChangePassword(env), url.Values{"new_password": {"new password"}}},
+		"ChangeEmail":    {ChangeEmail(env), url.Values{"email": {"new@example.com"}}},
+		"DeleteAccount":  {DeleteAccount(env), url.Values{}},
+	}
+	for name, tt := range handlers {
+		tt.form.Set("password", "wrong password")
+		w := postAccount(tt.handler, user, sessionID, tt.form)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s: expected %d, got %d", name, http.StatusForbidden, w.Code)
+		}
+	}
+
+	found, err := env.Users.GetUserByID(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.PasswordHash != user.PasswordHash || found.Email != user.Email {
+		t.Errorf("expected the account unchanged, got %+v", found)
+	}
+	if _, err := env.Sessions.Get(sessionID); err != nil {
+		t.Errorf("expected the session to be kept, got %v", err)
+	}
+}
+
+func TestChangePasswordRevokesOtherSessions(t *testing.T) {
+	env, user := newAccountTestEnv(t)
+	sessionID := createTestSession(t, env, user)
+	otherSessionID := createTestSession(t, env, user)
+
+	form := url.Values{"password": {testPassword}, "new_password": {"new password"}}
+	w := postAccount(ChangePassword(env), user, sessionID, form)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d %q", http.StatusOK, w.Code, w.Body)
+	}
+
+	if _, err := env.Sessions.Get(sessionID); err != nil {
+		t.Errorf("expected the current session to be kept, got %v", err)
+	}
+	if _, err := env.Sessions.Get(otherSessionID); err != redis.Nil {
+		t.Errorf("expected the other session to be revoked, got %v", err)
+	}
+	found, err := env.Users.GetUserByID(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if matched, _ := env.PasswordHasher.Verify(found.PasswordHash, "new password"); !matched {
+		t.Error("expected the new password to be saved")
+	}
+}
+
+// testMailer keeps the emails instead of sending them.
+type testMailer struct {
+	bodies []string
+}
+
+func (m *testMailer) Send(to string, subject string, body string) error {
+	m.bodies = append(m.bodies, body)
+	return nil
+}
+
+// verificationToken returns the token of the link in the last email.
+func verificationToken(t *testing.T, mailer *testMailer) string {
+	t.Helper()
+	if len(mailer.bodies) == 0 {
+		t.Fatal("expected a verification email")
+	}
+	body := mailer.bodies[len(mailer.bodies)-1]
+	_, link, _ := strings.Cut(body, "?token=")
+	token, err := url.QueryUnescape(strings.TrimSpace(link))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func verifyEmail(env *conf.Env, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/account/email/verify/?token="+url.QueryEscape(token), nil)
+	w := httptest.NewRecorder()
+	VerifyEmail(env)(w, req)
+	return w
+}
+
+func TestChangeEmail(t *testing.T) {
+	env, user := newAccountTestEnv(t)
+	mailer := &testMailer{}
+	env.Mailer = mailer
+	sessionID := createTestSession(t, env, user)
+
+	form := url.Values{"password": {testPassword}, "email": {"first@example.com"}}
+	if w := postAccount(ChangeEmail(env), user, sessionID, form); w.Code != http.StatusAccepted {
+		t.Fatalf("expected %d, got %d %q", http.StatusAccepted, w.Code, w.Body)
+	}
+	firstToken := verificationToken(t, mailer)
+	form.Set("email", "second@example.com")
+	if w := postAccount(ChangeEmail(env), user, sessionID, form); w.Code != http.StatusAccepted {
+		t.Fatalf("expected %d, got %d %q", http.StatusAccepted, w.Code, w.Body)
+	}
+	secondToken := verificationToken(t, mailer)
+
+	// A new change invalidates the link of the previous one
+	if w := verifyEmail(env, firstToken); w.Code != http.StatusBadRequest {
+		t.Errorf("expected %d for the previous link, got %d", http.StatusBadRequest, w.Code)
+	}
+	if w := verifyEmail(env, secondToken); w.Code != http.StatusSeeOther {
+		t.Fatalf("expected %d, got %d %q", http.StatusSeeOther, w.Code, w.Body)
+	}
+	found, err := env.Users.GetUserByID(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Email != "second@example.com" {
+		t.Errorf("expected the new email to be saved, got %s", found.Email)
+	}
+	// Links can be used only once
+	if w := verifyEmail(env, secondToken); w.Code != http.StatusBadRequest {
+		t.Errorf("expected %d for a used link, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestChangeEmailTaken(t *testing.T) {
+	env, user := newAccountTestEnv(t)
+	mailer := &testMailer{}
+	env.Mailer = mailer
+	sessionID := createTestSession(t, env, user)
+
+	form := url.Values{"password": {testPassword}, "email": {"taken@example.com"}}
+	if w := postAccount(ChangeEmail(env), user, sessionID, form); w.Code != http.StatusAccepted {
+		t.Fatalf("expected %d, got %d %q", http.StatusAccepted, w.Code, w.Body)
+	}
+	// Registered between the request and the confirmation
+	_, err := env.Users.CreateUser(model.UserCreator{Name: "Other", Email: "taken@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := verifyEmail(env, verificationToken(t, mailer)); w.Code != http.StatusBadRequest {
+		t.Errorf("expected %d, got %d", http.StatusBadRequest, w.Code)
+	}
+	if w := postAccount(ChangeEmail(env), user, sessionID, form); w.Code != http.StatusBadRequest {
+		t.Errorf("expected %d for a taken email, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestDeleteAccount(t *testing.T) {
+	env, user := newAccountTestEnv(t)
+	sessionID := createTestSession(t, env, user)
+	otherSessionID := createTestSession(t, env, user)
+	if err := env.Clipboard.Set(user.Uid.String(), "abcd", 0); err != nil {
+		t.Fatal(err)
+	}
+	token, err := env.Verifications.Create(services.EmailVerification{UserID: user.Id, Email: "new@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	shareToken, err := env.Shares.Create(services.Share{UserID: user.Id, ViewsLeft: 1}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploadID := startUpload(t, env, user, 8)
+	uploadChunks(t, env, user, uploadID, "abcd")
+	chunks, err := env.Uploads.Chunks(uploadID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exportPath := filepath.Join(t.TempDir(), "export.zip")
+	if err := os.WriteFile(exportPath, []byte("abcd"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	exportToken, err := env.Exports.Create(services.ExportJob{UserID: user.Id, Path: exportPath, ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := postAccount(DeleteAccount(env), user, sessionID, url.Values{"password": {testPassword}})
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected %d, got %d %q", http.StatusNoContent, w.Code, w.Body)
+	}
+
+	if _, err := env.Users.GetUserByID(user.Id); err != pgx.ErrNoRows {
+		t.Errorf("expected the user to be deleted, got %v", err)
+	}
+	for _, id := range []string{sessionID, otherSessionID} {
+		if _, err := env.Sessions.Get(id); err != redis.Nil {
+			t.Errorf("expected session %s to be revoked, got %v", id, err)
+		}
+	}
+	if _, err := env.Clipboard.Paste(user.Uid.String()); err != redis.Nil {
+		t.Errorf("expected the clipboard to be cleared, got %v", err)
+	}
+	if _, err := env.Verifications.Consume(token); err != redis.Nil {
+		t.Errorf("expected the pending email change to be cancelled, got %v", err)
+	}
+	if _, err := env.Shares.Get(shareToken); err != redis.Nil {
+		t.Errorf("expected the share to be deleted, got %v", err)
+	}
+	if _, err := env.Uploads.Get(uploadID); err != redis.Nil {
+		t.Errorf("expected the upload to be deleted, got %v", err)
+	}
+	if _, err := env.BlobStore.Get(chunks[0]); err == nil {
+		t.Errorf("expected the chunks of the upload to be deleted")
+	}
+	if _, err := env.Exports.Get(exportToken); err != redis.Nil {
+		t.Errorf("expected the export to be deleted, got %v", err)
+	}
+	if _, err := os.Stat(exportPath); !os.IsNotExist(err) {
+		t.Errorf("expected the export file to be deleted, got %v", err)
+	}
+}
@@ -0,0 +1,220 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/amns13/shipboard/internal/conf"
+	"github.com/amns13/shipboard/internal/middleware"
+	"github.com/amns13/shipboard/internal/model"
+	"github.com/amns13/shipboard/internal/services"
+	"github.com/jackc/pgx/v5"
+)
+
+type adminUserRow struct {
+	Id           int32
+	Name         string
+	Email        string
+	Role         string
+	Disabled     bool
+	CreatedAt    time.Time
+	StorageBytes int64
+	Clips        int64
+	// Set if an admin overrode the global quota
+	Override model.QuotaOverride
+	Quota    services.Quota
+}
+
+type adminPageData struct {
+	Users             []adminUserRow
+	TotalStorageBytes int64
+	GlobalQuota       services.Quota
+	CSRFToken         string
+}
+
+func AdminDashboard(env *conf.Env) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireStore(w, env.HasPostgres(), "Admin dashboards") {
+			return
+		}
+		users, err := model.ListUsers(env)
+		if err != nil {
+			env.Logger.Printf("Error occurred while listing users: %v", err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		usage, err := model.GetUsage(env)
+		if err != nil {
+			env.Logger.Printf("Error occurred while fetching storage usage: %v", err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		overrides, err := model.GetQuotaOverrides(env)
+		if err != nil {
+			env.Logger.Printf("Error occurred while fetching quotas: %v", err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+
+		csrfToken, _ := req.Context().Value(middleware.CSRFToken).(string)
+		data := adminPageData{CSRFToken: csrfToken, GlobalQuota: env.Quota}
+		for _, user := range users {
+			override := overrides[user.Id]
+			data.Users = append(data.Users, adminUserRow{
+				Id:           user.Id,
+				Name:         user.Name,
+				Email:        user.Email,
+				Role:         user.Role,
+				Disabled:     user.IsDisabled(),
+				CreatedAt:    user.CreatedAt,
+				StorageBytes: usage[user.Id].Bytes,
+				Clips:        usage[user.Id].Clips,
+				Override:     override,
+				Quota:        override.Apply(env.Quota),
+			})
+			data.TotalStorageBytes += usage[user.Id].Bytes
+		}
+
+		err = env.Templates.ExecuteTemplate(w, "admin.html", data)
+		if err != nil {
+			env.Logger.Printf("Error occurred while rendering admin dashboard: %v", err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// targetUserID parses the {id} path value. Admins cannot target themselves,
+// so that the last admin cannot lock themselves out.
+func targetUserID(w http.ResponseWriter, req *http.Request) (int32, bool) {
+	id, err := strconv.ParseInt(req.PathValue("id"), 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid user id", http.StatusBadRequest)
+		return 0, false
+	}
+	adminID, _ := req.Context().Value(middleware.AuthUserID).(int32)
+	if int32(id) == adminID {
+		http.Error(w, "You cannot do this to your own account", http.StatusBadRequest)
+		return 0, false
+	}
+	return int32(id), true
+}
+
+func DisableUser(env *conf.Env) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		userID, ok := targetUserID(w, req)
+		if !ok {
+			return
+		}
+		err := env.Users.SetUserDisabled(userID, true)
+		if err != nil {
+			env.Logger.Printf("Error occurred while disabling user %d: %v", userID, err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		err = env.Sessions.ExpireUserSessions(userID, "")
+		if err != nil {
+			env.Logger.Printf("Error occurred while expiring sessions of user %d: %v", userID, err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		env.Logger.Printf("Disabled user %d", userID)
+		w.Header().Set("HX-Refresh", "true")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func EnableUser(env *conf.Env) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		userID, ok := targetUserID(w, req)
+		if !ok {
+			return
+		}
+		err := env.Users.SetUserDisabled(userID, false)
+		if err != nil {
+			env.Logger.Printf("Error occurred while enabling user %d: %v", userID, err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		env.Logger.Printf("Enabled user %d", userID)
+		w.Header().Set("HX-Refresh", "true")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func ForceLogout(env *conf.Env) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		userID, ok := targetUserID(w, req)
+		if !ok {
+			return
+		}
+		err := env.Sessions.ExpireUserSessions(userID, "")
+		if err != nil {
+			env.Logger.Printf("Error occurred while expiring sessions of user %d: %v", userID, err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		env.Logger.Printf("Logged out all sessions of user %d", userID)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// parseQuotaLimit parses a limit of the quota form. Empty values keep the
+// global quota and return nil, 0 is unlimited.
+func parseQuotaLimit(value string) (*int64, error) {
+	if value == "" {
+		return nil, nil
+	}
+	limit, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || limit < 0 {
+		return nil, fmt.Errorf("invalid quota limit %q", value)
+	}
+	return &limit, nil
+}
+
+// SetUserQuota overrides the global quota of a user with the max_clips and
+// max_bytes fields. Empty fields restore the global quota, 0 is unlimited.
+func SetUserQuota(env *conf.Env) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireStore(w, env.HasPostgres(), "Quota overrides") {
+			return
+		}
+		id, err := strconv.ParseInt(req.PathValue("id"), 10, 32)
+		if err != nil {
+			http.Error(w, "Invalid user id", http.StatusBadRequest)
+			return
+		}
+		_, err = env.Users.GetUserByID(int32(id))
+		if err == pgx.ErrNoRows {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			env.Logger.Printf("Error occurred while fetching user %d: %v", id, err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		override := model.QuotaOverride{UserID: int32(id)}
+		override.MaxClips, err = parseQuotaLimit(req.PostFormValue("max_clips"))
+		if err != nil {
+			http.Error(w, "Max clips must be a number, or empty for the global quota", http.StatusBadRequest)
+			return
+		}
+		override.MaxBytes, err = parseQuotaLimit(req.PostFormValue("max_bytes"))
+		if err != nil {
+			http.Error(w, "Max bytes must be a number, or empty for the global quota", http.StatusBadRequest)
+			return
+		}
+		err = model.SetQuotaOverride(env, override)
+		if err != nil {
+			env.Logger.Printf("Error occurred while setting quota of user %d: %v", id, err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		env.Logger.Printf("Set quota of user %d", id)
+		w.Header().Set("HX-Refresh", "true")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -10,7 +10,6 @@ import (
 	"github.com/amns13/shipboard/internal/model"
 	"github.com/amns13/shipboard/internal/services"
 	"github.com/jackc/pgx/v5"
-	"golang.org/x/crypto/bcrypt"
 )
 
 const INTERNAL_SERVER_ERROR = "An error occurred. Please contact support."
@@ -23,7 +22,7 @@ func Register(env *conf.Env) http.HandlerFunc {
 			return
 		}
 
-		exists, err := model.UserExists(env, email)
+		exists, err := env.Users.UserExists(email.Address)
 		if err != nil {
 			env.Logger.Printf("Error occurred: %v", err)
 			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
@@ -39,8 +38,7 @@ func Register(env *conf.Env) http.HandlerFunc {
 		// TODO: Password strength validation
 		name := req.PostFormValue("name")
 		password := req.PostFormValue("password")
-		// TODO: Randomly giving cost 14. Confirm an optimal value
-		passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), 14)
+		passwordHash, err := env.PasswordHasher.Hash(password)
 		if err != nil {
 			env.Logger.Printf("Error occurred while generating password hash: %v", err)
 			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
@@ -50,9 +48,9 @@ func Register(env *conf.Env) http.HandlerFunc {
 		userData := model.UserCreator{
 			Name:         name,
 			Email:        email.Address,
-			PasswordHash: string(passwordHash),
+			PasswordHash: passwordHash,
 		}
-		_, err = userData.Create(env)
+		_, err = env.Users.CreateUser(userData)
 		if err != nil {
 			env.Logger.Printf("Error occurred while creating user: %v", err)
 			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
@@ -81,7 +79,7 @@ func Login(env *conf.Env) http.HandlerFunc {
 			return
 		}
 
-		user, err := model.GetUserByEmail(env, email.Address)
+		user, err := env.Users.GetUserByEmail(email.Address)
 		if err != nil {
 			if err == pgx.ErrNoRows {
 				env.Logger.Printf("Email not found: %v", email.Address)
@@ -94,12 +92,24 @@ func Login(env *conf.Env) http.HandlerFunc {
 		}
 
 		password := req.PostFormValue("password")
-		// TODO: Randomly giving cost 14. Confirm an optimal value
-		err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password))
+		matched, err := env.PasswordHasher.Verify(user.PasswordHash, password)
 		if err != nil {
+			env.Logger.Printf("Error occurred while verifying password of user %d: %v", user.Id, err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		if !matched {
 			http.Error(w, "Invalid email or password", http.StatusBadRequest)
 			return
 		}
+		if user.IsDisabled() {
+			env.Logger.Printf("Disabled user %d tried to log in", user.Id)
+			http.Error(w, "This account has been disabled", http.StatusForbidden)
+			return
+		}
+		if env.PasswordHasher.NeedsRehash(user.PasswordHash) {
+			upgradePasswordHash(env, user, password)
+		}
 
 		sessionData := services.SessionData{
 			UserID:    user.Id,
@@ -107,10 +117,7 @@ func Login(env *conf.Env) http.HandlerFunc {
 			LoginTime: time.Now(),
 			ExpiresAt: time.Now().Add(24 * time.Hour),
 		}
-		sessionStore := services.SessionStore{
-			Client: env.Rdb,
-		}
-		sessionID, err := sessionStore.CreateSession(sessionData)
+		sessionID, err := env.Sessions.CreateSession(sessionData)
 		if err != nil {
 			env.Logger.Printf("Error occurred while creating user session: %v", err)
 			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
@@ -130,6 +137,23 @@ func Login(env *conf.Env) http.HandlerFunc {
 	}
 }
 
+// upgradePasswordHash re-hashes the password with the currently configured
+// algorithm. Failing to do so must not fail the login, the old hash is still
+// valid and the upgrade is retried on the next login.
+func upgradePasswordHash(env *conf.Env, user *model.User, password string) {
+	passwordHash, err := env.PasswordHasher.Hash(password)
+	if err != nil {
+		env.Logger.Printf("Error occurred while upgrading password hash of user %d: %v", user.Id, err)
+		return
+	}
+	err = env.Users.UpdatePasswordHash(user.Id, passwordHash)
+	if err != nil {
+		env.Logger.Printf("Error occurred while saving upgraded password hash of user %d: %v", user.Id, err)
+		return
+	}
+	env.Logger.Printf("Upgraded password hash of user %d", user.Id)
+}
+
 func LoginForm(env *conf.Env) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
 		err := env.Templates.ExecuteTemplate(w, "login.html", nil)
@@ -149,10 +173,7 @@ func Logout(env *conf.Env) http.HandlerFunc {
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
-		sessionStore := services.SessionStore{
-			Client: env.Rdb,
-		}
-		err := sessionStore.Expire(sessionID)
+		err := env.Sessions.Expire(sessionID)
 		if err != nil {
 			env.Logger.Printf("Error occurred while expiring session %s: %v", sessionID, err)
 			w.WriteHeader(http.StatusBadRequest)
@@ -1,28 +1,285 @@
 package api
 
 import (
-	"context"
 	"fmt"
+	"html/template"
 	"net/http"
-
-	// "time"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/amns13/shipboard/internal/conf"
 	"github.com/amns13/shipboard/internal/middleware"
 	"github.com/amns13/shipboard/internal/model"
+	"github.com/amns13/shipboard/internal/services"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/redis/go-redis/v9"
 )
 
-const clipboardPrefix = "__clip__"
+func isChecked(value string) bool {
+	return value == "on" || value == "true" || value == "1"
+}
+
+// Language of clips that must not be highlighted
+const plainTextLanguage = "text"
+
+// clipLanguage returns the language a text clip is highlighted in. The
+// language is detected unless one is given, and false is returned for
+// unknown ones.
+func clipLanguage(hint string, content string) (string, bool) {
+	switch {
+	case hint == "":
+		return services.DetectLanguage(content), true
+	case hint == plainTextLanguage:
+		return "", true
+	case services.IsLanguage(hint):
+		return hint, true
+	}
+	return "", false
+}
+
+// clipSensitive reports whether a text clip is handled as a secret. Secrets
+// are detected, unless the sensitive field overrides it either way.
+func clipSensitive(value string, content string) bool {
+	switch {
+	case isChecked(value):
+		return true
+	case value == "off" || value == "false" || value == "0":
+		return false
+	}
+	return services.DetectSecret(content) != ""
+}
+
+// fileSensitive reports whether an uploaded file is handled as a secret, like
+// clipSensitive does for text clips. Only text files can be secrets.
+func fileSensitive(value string, contentType string, data []byte) bool {
+	if !strings.HasPrefix(mediaTypeOf(contentType), "text/") || !utf8.Valid(data) {
+		return false
+	}
+	return clipSensitive(value, string(data))
+}
+
+type clipPageData struct {
+	CSRFToken       string
+	IsAdmin         bool
+	Clips           []model.Clip
+	Deliveries      map[int32][]model.Delivery
+	Representations map[int32][]model.Representation
+	// Sanitized text/html representations, keyed by clip id
+	HTMLPreviews map[int32]template.HTML
+	// Highlighted content of code clips, keyed by clip id
+	Highlighted map[int32]template.HTML
+	// Languages that can be picked when broadcasting
+	Languages []string
+	Slots     []model.Slot
+	// Tag names of the clips, keyed by clip id
+	ClipTags map[int32][]string
+	Tags     []model.Tag
+	// Tag the history is filtered by, if any
+	Tag     string
+	Usage   *quotaUsage
+	Devices []model.Device
+	// Device of this browser, if registered
+	Device *model.Device
+	Inbox  []model.InboxItem
+}
+
+// Number of clips shown in the history
+const clipHistoryLength = 20
+
+// Broadcasting the same payload again within this window bumps the earlier
+// clip instead of adding a new one, e.g. when a client reconnects
+const clipDedupeWindow = 10 * time.Minute
+
+// Larger text/html representations are not previewed
+const htmlPreviewLimit = 64 << 10
+
+// htmlPreviews sanitizes the text/html representations of the clips.
+func htmlPreviews(env *conf.Env, representations map[int32][]model.Representation) (map[int32]template.HTML, error) {
+	previews := make(map[int32]template.HTML)
+	for clipID := range representations {
+		for _, representation := range representations[clipID] {
+			if mediaTypeOf(representation.ContentType) != "text/html" || representation.Size > htmlPreviewLimit {
+				continue
+			}
+			data, err := model.GetRepresentationData(env, &representation)
+			if err != nil {
+				return nil, err
+			}
+			previews[clipID] = template.HTML(services.SanitizeHTML(string(data)))
+		}
+	}
+	return previews, nil
+}
+
+// highlightedClips highlights the inline content of the code clips.
+func highlightedClips(clips []model.Clip) map[int32]template.HTML {
+	highlighted := make(map[int32]template.HTML)
+	for _, clip := range clips {
+		if clip.Language != nil && !clip.Once && !clip.IsFile() && !clip.IsStored() && !clip.IsCompressed() {
+			highlighted[clip.Id] = services.Highlight(clip.Content, *clip.Language)
+		}
+	}
+	return highlighted
+}
+
+// requireStore writes 501 and returns false if the store the feature needs
+// is not available, e.g. when running on SQLite. Stores are only missing
+// without Postgres, see conf.Env.
+func requireStore(w http.ResponseWriter, available bool, feature string) bool {
+	if available {
+		return true
+	}
+	http.Error(w, feature+" are not supported without Postgres", http.StatusNotImplemented)
+	return false
+}
+
+// addStorePageData adds what the clip page shows from the optional stores:
+// deliveries, representations, slots, tags, devices and the inbox of this
+// device. Sections whose store is not available are left empty.
+func addStorePageData(env *conf.Env, req *http.Request, userID int32, data *clipPageData) error {
+	clipIDs := make([]int32, len(data.Clips))
+	for i := range data.Clips {
+		clipIDs[i] = data.Clips[i].Id
+	}
+	var err error
+	// Representations are kept in Postgres only
+	if env.HasPostgres() {
+		data.Representations, err = model.GetClipRepresentations(env, clipIDs)
+		if err != nil {
+			return fmt.Errorf("fetching clip representations: %w", err)
+		}
+		data.HTMLPreviews, err = htmlPreviews(env, data.Representations)
+		if err != nil {
+			return fmt.Errorf("rendering html previews: %w", err)
+		}
+	}
+	if env.Slots != nil {
+		data.Slots, err = env.Slots.GetUserSlots(userID)
+		if err != nil {
+			return fmt.Errorf("fetching slots: %w", err)
+		}
+	}
+	if env.Tags != nil {
+		data.ClipTags, err = env.Tags.GetClipTags(clipIDs)
+		if err != nil {
+			return fmt.Errorf("fetching clip tags: %w", err)
+		}
+		data.Tags, err = env.Tags.GetUserTags(userID)
+		if err != nil {
+			return fmt.Errorf("fetching tags: %w", err)
+		}
+	}
+	if env.Devices == nil {
+		return nil
+	}
+	data.Deliveries, err = env.Devices.GetClipDeliveries(clipIDs)
+	if err != nil {
+		return fmt.Errorf("fetching clip deliveries: %w", err)
+	}
+	data.Devices, err = env.Devices.GetUserDevices(userID)
+	if err != nil {
+		return fmt.Errorf("fetching devices: %w", err)
+	}
+
+	data.Device, err = currentDevice(env, req, userID)
+	if err != nil {
+		return fmt.Errorf("fetching current device: %w", err)
+	}
+	if data.Device != nil {
+		states := []string{model.DELIVERY_STATE_PENDING, model.DELIVERY_STATE_DELIVERED}
+		data.Inbox, err = env.Devices.GetDeviceInbox(data.Device.Id, states)
+		if err != nil {
+			return fmt.Errorf("fetching inbox: %w", err)
+		}
+		// Showing the inbox on the page delivers it
+		for _, item := range data.Inbox {
+			_, err = env.Devices.AckDelivery(item.ClipID, data.Device.Id, model.DELIVERY_STATE_DELIVERED)
+			if err != nil {
+				env.Logger.Printf("Error occurred while acknowledging clip %d: %v", item.ClipID, err)
+			}
+		}
+	}
+	return nil
+}
 
 func Clip(env *conf.Env) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
-		err := env.Templates.ExecuteTemplate(w, "index.html", nil)
+		userID, ok := req.Context().Value(middleware.AuthUserID).(int32)
+		if !ok {
+			env.Logger.Println("Invalid user id", userID)
+			http.Redirect(w, req, "/login/", http.StatusTemporaryRedirect)
+			return
+		}
+		tag := req.URL.Query().Get("tag")
+		if tag != "" && !requireStore(w, env.Tags != nil, "Tags") {
+			return
+		}
+		var clips []model.Clip
+		var err error
+		if tag != "" {
+			clips, err = env.Tags.GetUserClipsByTag(userID, tag, clipHistoryLength)
+		} else {
+			clips, err = env.Clips.GetUserClips(userID, clipHistoryLength)
+		}
+		if err != nil {
+			env.Logger.Printf("Error occurred while fetching clip history: %v", err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		usage, err := userQuotaUsage(env, userID)
+		if err != nil {
+			env.Logger.Printf("Error occurred while fetching usage: %v", err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+
+		csrfToken, _ := req.Context().Value(middleware.CSRFToken).(string)
+		role, _ := req.Context().Value(middleware.AuthUserRole).(string)
+		data := clipPageData{
+			CSRFToken:   csrfToken,
+			IsAdmin:     role == model.ROLE_ADMIN,
+			Clips:       clips,
+			Highlighted: highlightedClips(clips),
+			Languages:   services.Languages,
+			Tag:         tag,
+			Usage:       usage,
+		}
+		err = addStorePageData(env, req, userID, &data)
+		if err != nil {
+			env.Logger.Printf("Error occurred while %v", err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+
+		err = env.Templates.ExecuteTemplate(w, "index.html", data)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
 	}
 }
 
+// bumpDuplicateBroadcast moves a duplicate of the broadcasted file or text
+// clip to the top of the history. Returns pgx.ErrNoRows if there is none, or
+// if the clip is never deduplicated: burn after reading, sent to devices or
+// sensitive.
+func bumpDuplicateBroadcast(env *conf.Env, req *http.Request, userID int32, upload *fileUpload, value string, language string) (*model.Clip, error) {
+	if isChecked(req.PostFormValue("once")) || req.PostForm.Has("devices") {
+		return nil, pgx.ErrNoRows
+	}
+	if upload != nil {
+		if upload.sensitive(req.PostFormValue("sensitive")) {
+			return nil, pgx.ErrNoRows
+		}
+		return bumpDuplicateFile(env, userID, upload)
+	}
+	if clipSensitive(req.PostFormValue("sensitive"), value) {
+		return nil, pgx.ErrNoRows
+	}
+	return env.Clips.BumpDuplicateClip(userID, nil, model.TEXT_CLIP_CONTENT_TYPE, language, []byte(value), clipDedupeWindow)
+}
+
 func Broadcast(env *conf.Env) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
 		userID, ok := req.Context().Value(middleware.AuthUserID).(int32)
@@ -31,21 +288,225 @@ func Broadcast(env *conf.Env) http.HandlerFunc {
 			http.Redirect(w, req, "/logout/", http.StatusTemporaryRedirect)
 			return
 		}
+		upload, err := readUpload(env, w, req)
+		if err != nil {
+			writeUploadError(env, w, err)
+			return
+		}
+		metadata, ok := readClipMetadata(w, req)
+		if !ok {
+			return
+		}
+		// Empty fields of the form must not clear the tags and note of a
+		// bumped duplicate
+		metadata.HasTags = len(metadata.Tags) > 0
+		metadata.HasNote = metadata.Note != nil
+		if (metadata.HasTags || metadata.HasNote) && !requireStore(w, env.Tags != nil, "Tags and notes") {
+			return
+		}
 		value := req.PostFormValue("content")
-		user, err := model.GetUserByID(env, userID)
+		if int64(len(value)) > env.MaxClipSize {
+			http.Error(w, fmt.Sprintf("Clips can be at most %d bytes", env.MaxClipSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+		language, ok := clipLanguage(req.PostFormValue("language"), value)
+		if !ok {
+			http.Error(w, "Unknown language", http.StatusBadRequest)
+			return
+		}
+		user, err := env.Users.GetUserByID(userID)
 		if err != nil {
 			env.Logger.Println("Invalid user id", userID)
 			http.Redirect(w, req, "/logout/", http.StatusTemporaryRedirect)
 			return
 		}
-		key := fmt.Sprintf("%s%s", clipboardPrefix, user.Uid)
+		ttl, err := clipboardTTL(env, user.Id)
+		if err != nil {
+			env.Logger.Printf("Error occurred while fetching retention policy: %v", err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		representations, err := readRepresentations(env, req)
+		if err != nil {
+			writeUploadError(env, w, err)
+			return
+		}
+		if representations != nil && !requireStore(w, env.HasPostgres(), "Clips with several representations") {
+			return
+		}
+		// Duplicates take no more space, so they are bumped even when over
+		// the quota
+		if representations == nil {
+			clip, err := bumpDuplicateBroadcast(env, req, user.Id, upload, value, language)
+			if err == nil {
+				err = metadata.save(env, clip)
+			}
+			if err == nil && (upload != nil || clip.IsStored()) {
+				err = env.Clipboard.SetRef(user.Uid.String(), clip.Id, ttl)
+			} else if err == nil {
+				err = env.Clipboard.Set(user.Uid.String(), value, ttl)
+			}
+			if err == nil {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			if err != pgx.ErrNoRows {
+				env.Logger.Printf("Error while bumping duplicate clip: %v", err)
+				http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+				return
+			}
+		}
+		size := int64(len(value))
+		if upload != nil {
+			size = upload.Size()
+		}
+		for _, representation := range representations {
+			size += representation.Size()
+		}
+		if !checkQuota(env, w, user.Id, size) {
+			return
+		}
+		// Files and clips with several representations are only kept as the
+		// latest clip of all devices
+		if representations != nil {
+			if isChecked(req.PostFormValue("once")) || req.PostForm.Has("devices") {
+				http.Error(w, "Clips with several representations can only be broadcasted to all devices", http.StatusBadRequest)
+				return
+			}
+			// Representations are kept in the history, which must not
+			// happen to secrets
+			for _, representation := range representations {
+				if representation.Stored == nil && fileSensitive(req.PostFormValue("sensitive"), representation.ContentType, representation.Data) {
+					http.Error(w, "Clip looks like a secret and cannot have several representations, set sensitive to off to send it anyway", http.StatusBadRequest)
+					return
+				}
+			}
+			var clip *model.Clip
+			clip, err = model.CreateRichClip(env, user.Id, representations)
+			if err == nil {
+				err = metadata.save(env, clip)
+			}
+			if err == nil {
+				err = env.Clipboard.SetRef(user.Uid.String(), clip.Id, ttl)
+			}
+			if err != nil {
+				env.Logger.Printf("Error while broadcasting clip representations: %v", err)
+				http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		// Text files that look like secrets are broadcasted as text clips,
+		// which keep secrets out of the history
+		if upload != nil && upload.sensitive(req.PostFormValue("sensitive")) {
+			value, upload = string(upload.Data), nil
+		}
+		if upload != nil {
+			if isChecked(req.PostFormValue("once")) || req.PostForm.Has("devices") {
+				http.Error(w, "Files can only be broadcasted to all devices", http.StatusBadRequest)
+				return
+			}
+			var clip *model.Clip
+			clip, err = createFileClip(env, user.Id, upload)
+			if err == nil {
+				err = metadata.save(env, clip)
+			}
+			if err == nil {
+				err = env.Clipboard.SetRef(user.Uid.String(), clip.Id, ttl)
+			}
+			if err != nil {
+				env.Logger.Printf("Error while broadcasting file: %v", err)
+				http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		// Clips sent to specific devices go to their inboxes instead of the
+		// clipboard shared by all devices
+		sensitive := clipSensitive(req.PostFormValue("sensitive"), value)
+		if req.PostForm.Has("devices") {
+			if !requireStore(w, env.Devices != nil, "Clips sent to specific devices") {
+				return
+			}
+			if isChecked(req.PostFormValue("once")) {
+				http.Error(w, "Burn after reading clips cannot be sent to specific devices", http.StatusBadRequest)
+				return
+			}
+			// Inboxes keep the content, which must not happen to secrets
+			if sensitive {
+				http.Error(w, "Clip looks like a secret and cannot be sent to specific devices, set sensitive to off to send it anyway", http.StatusBadRequest)
+				return
+			}
+			var deviceIDs []int32
+			for _, value := range req.PostForm["devices"] {
+				uid, err := uuid.Parse(value)
+				if err != nil {
+					http.Error(w, "Invalid device", http.StatusBadRequest)
+					return
+				}
+				device, err := env.Devices.GetUserDevice(user.Id, uid)
+				if err == pgx.ErrNoRows {
+					http.Error(w, "Device not found", http.StatusBadRequest)
+					return
+				}
+				if err != nil {
+					env.Logger.Printf("Error occurred while fetching device: %v", err)
+					http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+					return
+				}
+				deviceIDs = append(deviceIDs, device.Id)
+			}
+			clip, err := env.Devices.CreateDirectedClip(user.Id, value, language, deviceIDs)
+			if err == nil {
+				err = metadata.save(env, clip)
+			}
+			if err != nil {
+				env.Logger.Printf("Error while sending clip to devices: %v", err)
+				http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
 
-		ctx := context.Background()
-		//TODO: Add a default TTL
-		err = env.Rdb.Set(ctx, key, value, 0).Err()
+		// Burn after reading clips keep only metadata in the history
+		if isChecked(req.PostFormValue("once")) {
+			var clip *model.Clip
+			clip, err = env.Clips.CreateOnceClip(user.Id, len(value))
+			if err == nil {
+				err = metadata.save(env, clip)
+			}
+			if err == nil {
+				err = env.Clipboard.SetOnce(user.Uid.String(), clip.Id, value, ttl)
+			}
+		} else if sensitive {
+			// Clips that look like secrets keep only metadata in the history
+			// too, and expire from the clipboard
+			var clip *model.Clip
+			clip, err = env.Clips.CreateSensitiveClip(user.Id, len(value))
+			if err == nil {
+				err = metadata.save(env, clip)
+			}
+			if err == nil {
+				err = env.Clipboard.SetSensitive(user.Uid.String(), clip.Id, value, services.SENSITIVE_CLIP_TTL)
+			}
+		} else {
+			var clip *model.Clip
+			clip, err = env.Clips.CreateClip(user.Id, value, language)
+			if err == nil {
+				err = metadata.save(env, clip)
+			}
+			if err == nil && clip.IsStored() {
+				err = env.Clipboard.SetRef(user.Uid.String(), clip.Id, ttl)
+			} else if err == nil {
+				err = env.Clipboard.Set(user.Uid.String(), value, ttl)
+			}
+		}
 		if err != nil {
 			env.Logger.Printf("Error while broadcasting clipboard: %v", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
 			return
 		}
 		// TODO: This should return 201 created. Bu, fsr the input box is removed
@@ -53,3 +514,241 @@ func Broadcast(env *conf.Env) http.HandlerFunc {
 		w.WriteHeader(http.StatusNoContent)
 	}
 }
+
+type pastedClip struct {
+	// Compressed with Codec, if it is set
+	Content   string
+	Codec     string
+	Once      bool
+	Sensitive bool
+	// Only set for sensitive clips
+	ClipID int32
+	// Set for clips kept by reference, whose content must be loaded
+	Stored          *model.Clip
+	Representations []model.Representation
+}
+
+// pasteLatest pastes the latest clip of the user. Returns redis.Nil if there
+// is nothing to paste, and services.ErrClipConsumed if the last clip was burn
+// after reading and has already been pasted.
+func pasteLatest(env *conf.Env, user *model.User) (*pastedClip, error) {
+	clip, err := env.Clipboard.Paste(user.Uid.String())
+	if err != nil {
+		return nil, err
+	}
+	pasted := &pastedClip{Content: clip.Content, Codec: clip.Codec, Once: clip.Once, Sensitive: clip.Sensitive}
+	if clip.Sensitive {
+		pasted.ClipID = clip.ClipID
+	}
+
+	if clip.Ref {
+		pasted.Stored, err = env.Clips.GetClipByID(clip.ClipID)
+		if err == pgx.ErrNoRows {
+			return nil, redis.Nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Representations are kept in Postgres only
+		if env.HasPostgres() {
+			representations, err := model.GetClipRepresentations(env, []int32{clip.ClipID})
+			if err != nil {
+				return nil, err
+			}
+			pasted.Representations = representations[clip.ClipID]
+		}
+	}
+	if clip.Once {
+		// The clip is already gone from redis, so failing here must not
+		// fail the paste
+		err = env.Clips.MarkClipConsumed(clip.ClipID)
+		if err != nil {
+			env.Logger.Printf("Error while marking clip %d consumed: %v", clip.ClipID, err)
+		}
+	}
+	return pasted, nil
+}
+
+// Paste returns the latest clip as plain text, or as a download if it is a
+// file. For clips with several representations, the one preferred by the
+// Accept header is returned, or the primary one if none is acceptable.
+// A burn after reading clip is deleted by the first paste, later pastes get
+// 410 Gone.
+func Paste(env *conf.Env) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		user, err := authenticatedUser(env, req)
+		if err != nil {
+			env.Logger.Printf("Error occurred while fetching user: %v", err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		clip, err := pasteLatest(env, user)
+		if err == redis.Nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if err == services.ErrClipConsumed {
+			http.Error(w, "This clip was burn after reading and has already been pasted", http.StatusGone)
+			return
+		}
+		if err != nil {
+			env.Logger.Printf("Error while pasting clipboard: %v", err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+
+		if clip.Stored != nil {
+			offered := []string{clip.Stored.ContentType}
+			for _, representation := range clip.Representations {
+				offered = append(offered, representation.ContentType)
+			}
+			w.Header().Set("Vary", "Accept")
+			if best := negotiateContentType(req.Header.Get("Accept"), offered); best > 0 {
+				writeRepresentation(env, w, req, clip.Stored, &clip.Representations[best-1])
+				return
+			}
+			if clip.Stored.IsFile() {
+				writeClip(env, w, req, clip.Stored)
+			} else {
+				writeTextClip(env, w, req, clip.Stored)
+			}
+			return
+		}
+		content, err := encodePayload(w, req, []byte(clip.Content), clip.Codec)
+		if err != nil {
+			env.Logger.Printf("Error while decompressing clipboard: %v", err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		if clip.Once || clip.Sensitive {
+			w.Header().Set("Cache-Control", "no-store")
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(content)
+	}
+}
+
+type pastePreviewData struct {
+	Message string
+	Content string
+	Stored  *model.Clip
+	// Content type of the image to show a thumbnail of, if any
+	ThumbnailType string
+	// Id of the pasted clip if it looks like a secret. Its content is only
+	// shown once revealed.
+	SensitiveClipID int32
+}
+
+// PastePreview pastes the latest clip like Paste, as an html fragment for the
+// web UI. Images are shown as thumbnails.
+func PastePreview(env *conf.Env) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		user, err := authenticatedUser(env, req)
+		if err != nil {
+			env.Logger.Printf("Error occurred while fetching user: %v", err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		var data pastePreviewData
+		clip, err := pasteLatest(env, user)
+		switch {
+		case err == redis.Nil:
+			data.Message = "Nothing broadcasted yet."
+		case err == services.ErrClipConsumed:
+			data.Message = "This clip was burn after reading and has already been pasted."
+		case err != nil:
+			env.Logger.Printf("Error while pasting clipboard: %v", err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		case clip.Stored != nil:
+			data.Stored = clip.Stored
+			if clip.Stored.IsImage() {
+				data.ThumbnailType = mediaTypeOf(clip.Stored.ContentType)
+			}
+			for _, representation := range clip.Representations {
+				if data.ThumbnailType == "" && representation.IsImage() {
+					data.ThumbnailType = mediaTypeOf(representation.ContentType)
+				}
+			}
+			if !clip.Stored.IsFile() {
+				content, err := env.Clips.GetClipData(clip.Stored)
+				if err != nil {
+					env.Logger.Printf("Error while fetching data of clip %d: %v", clip.Stored.Id, err)
+					http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+					return
+				}
+				data.Content = string(content)
+			}
+		case clip.Sensitive:
+			data.SensitiveClipID = clip.ClipID
+		default:
+			content, err := services.Decompress([]byte(clip.Content), clip.Codec)
+			if err != nil {
+				env.Logger.Printf("Error while decompressing clipboard: %v", err)
+				http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+				return
+			}
+			data.Content = string(content)
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		err = env.Templates.ExecuteTemplate(w, "paste.html", data)
+		if err != nil {
+			env.Logger.Printf("Error occurred while rendering paste: %v", err)
+		}
+	}
+}
+
+// RevealClip returns the content of a sensitive clip, as long as it hasn't
+// expired or been replaced on the clipboard. The web UI masks sensitive clips
+// until they are revealed.
+func RevealClip(env *conf.Env) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		clip, ok := ownClip(env, w, req)
+		if !ok {
+			return
+		}
+		if !clip.Sensitive {
+			http.Error(w, "Clip is not sensitive", http.StatusNotFound)
+			return
+		}
+		user, err := authenticatedUser(env, req)
+		if err != nil {
+			env.Logger.Printf("Error occurred while fetching user: %v", err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		content, err := env.Clipboard.Sensitive(user.Uid.String(), clip.Id)
+		if err == redis.Nil {
+			http.Error(w, "This clip looked like a secret and has expired", http.StatusGone)
+			return
+		}
+		if err != nil {
+			env.Logger.Printf("Error while revealing clip %d: %v", clip.Id, err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(content))
+	}
+}
+
+// ClipResource serves the GET routes of a clip, /clip/{id}/download,
+// /clip/{id}/thumbnail and /clip/{id}/reveal. They share one pattern, which
+// /clip/slots/{name} takes precedence over.
+func ClipResource(env *conf.Env) http.HandlerFunc {
+	resources := map[string]http.HandlerFunc{
+		"download":  DownloadClip(env),
+		"thumbnail": ClipThumbnail(env),
+		"reveal":    RevealClip(env),
+	}
+	return func(w http.ResponseWriter, req *http.Request) {
+		handler, ok := resources[req.PathValue("resource")]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		handler(w, req)
+	}
+}
@@ -0,0 +1,249 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/amns13/shipboard/internal/conf"
+	"github.com/amns13/shipboard/internal/middleware"
+	"github.com/amns13/shipboard/internal/model"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Clients identify themselves with the header, browsers with the cookie set
+// when registering the device.
+const deviceHeader = "X-Device-ID"
+const deviceCookie = "device_id"
+
+type deviceResponse struct {
+	Uid        uuid.UUID  `json:"uid"`
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeenAt *time.Time `json:"last_seen_at"`
+}
+
+func writeJSON(env *conf.Env, w http.ResponseWriter, status int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(data)
+	if err != nil {
+		env.Logger.Printf("Error occurred while writing response: %v", err)
+	}
+}
+
+// currentDevice returns the device the request comes from, or nil if it
+// doesn't identify one of the user's devices.
+func currentDevice(env *conf.Env, req *http.Request, userID int32) (*model.Device, error) {
+	value := req.Header.Get(deviceHeader)
+	if value == "" {
+		cookie, err := req.Cookie(deviceCookie)
+		if err != nil {
+			return nil, nil
+		}
+		value = cookie.Value
+	}
+	uid, err := uuid.Parse(value)
+	if err != nil {
+		return nil, nil
+	}
+	device, err := env.Devices.GetUserDevice(userID, uid)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return device, err
+}
+
+// ownDevice returns the device in the {device} path value if it belongs to
+// the authenticated user.
+func ownDevice(env *conf.Env, w http.ResponseWriter, req *http.Request) (*model.Device, bool) {
+	userID, ok := req.Context().Value(middleware.AuthUserID).(int32)
+	if !ok {
+		env.Logger.Println("Invalid user id", userID)
+		http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+		return nil, false
+	}
+	uid, err := uuid.Parse(req.PathValue("device"))
+	if err != nil {
+		http.Error(w, "Device not found", http.StatusNotFound)
+		return nil, false
+	}
+	device, err := env.Devices.GetUserDevice(userID, uid)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Device not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		env.Logger.Printf("Error occurred while fetching device: %v", err)
+		http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+		return nil, false
+	}
+	return device, true
+}
+
+func Devices(env *conf.Env) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireStore(w, env.Devices != nil, "Devices") {
+			return
+		}
+		userID, ok := req.Context().Value(middleware.AuthUserID).(int32)
+		if !ok {
+			env.Logger.Println("Invalid user id", userID)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		devices, err := env.Devices.GetUserDevices(userID)
+		if err != nil {
+			env.Logger.Printf("Error occurred while fetching devices: %v", err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		response := []deviceResponse{}
+		for _, device := range devices {
+			response = append(response, deviceResponse{
+				Uid:        device.Uid,
+				Name:       device.Name,
+				CreatedAt:  device.CreatedAt,
+				LastSeenAt: device.LastSeenAt,
+			})
+		}
+		writeJSON(env, w, http.StatusOK, response)
+	}
+}
+
+// RegisterDevice creates a device and sets the device cookie, so that a
+// browser registering itself is recognized afterwards. Other clients must
+// send the returned uid in the X-Device-ID header.
+func RegisterDevice(env *conf.Env) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireStore(w, env.Devices != nil, "Devices") {
+			return
+		}
+		userID, ok := req.Context().Value(middleware.AuthUserID).(int32)
+		if !ok {
+			env.Logger.Println("Invalid user id", userID)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		name := req.PostFormValue("name")
+		if name == "" || len(name) > 127 {
+			http.Error(w, "Device name must be between 1 and 127 characters", http.StatusBadRequest)
+			return
+		}
+		device, err := env.Devices.CreateDevice(userID, name)
+		if err != nil {
+			env.Logger.Printf("Error occurred while creating device: %v", err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     deviceCookie,
+			Value:    device.Uid.String(),
+			Expires:  time.Now().AddDate(1, 0, 0),
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteStrictMode,
+			Path:     "/",
+		})
+		w.Header().Set("HX-Refresh", "true")
+		writeJSON(env, w, http.StatusCreated, deviceResponse{
+			Uid:       device.Uid,
+			Name:      device.Name,
+			CreatedAt: device.CreatedAt,
+		})
+	}
+}
+
+func DeleteDevice(env *conf.Env) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireStore(w, env.Devices != nil, "Devices") {
+			return
+		}
+		device, ok := ownDevice(env, w, req)
+		if !ok {
+			return
+		}
+		err := env.Devices.DeleteDevice(device.Id)
+		if err != nil {
+			env.Logger.Printf("Error occurred while deleting device %d: %v", device.Id, err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("HX-Refresh", "true")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// DeviceInbox returns the clips sent to the device that have not been read
+// yet, oldest first. Pass state=pending to get only the ones not acknowledged.
+func DeviceInbox(env *conf.Env) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireStore(w, env.Devices != nil, "Devices") {
+			return
+		}
+		device, ok := ownDevice(env, w, req)
+		if !ok {
+			return
+		}
+		states := []string{model.DELIVERY_STATE_PENDING, model.DELIVERY_STATE_DELIVERED}
+		if req.URL.Query().Get("state") == model.DELIVERY_STATE_PENDING {
+			states = []string{model.DELIVERY_STATE_PENDING}
+		}
+		items, err := env.Devices.GetDeviceInbox(device.Id, states)
+		if err != nil {
+			env.Logger.Printf("Error occurred while fetching inbox of device %d: %v", device.Id, err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		err = env.Devices.TouchDevice(device.Id)
+		if err != nil {
+			env.Logger.Printf("Error occurred while updating last seen of device %d: %v", device.Id, err)
+		}
+		if items == nil {
+			items = []model.InboxItem{}
+		}
+		writeJSON(env, w, http.StatusOK, items)
+	}
+}
+
+// AckDelivery moves a clip in the inbox of the device to state, delivered
+// when not given, or read.
+func AckDelivery(env *conf.Env) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireStore(w, env.Devices != nil, "Devices") {
+			return
+		}
+		device, ok := ownDevice(env, w, req)
+		if !ok {
+			return
+		}
+		clipID, err := strconv.ParseInt(req.PathValue("clip"), 10, 32)
+		if err != nil {
+			http.Error(w, "Clip not found", http.StatusNotFound)
+			return
+		}
+		state := req.PostFormValue("state")
+		if state == "" {
+			state = model.DELIVERY_STATE_DELIVERED
+		}
+		if state != model.DELIVERY_STATE_DELIVERED && state != model.DELIVERY_STATE_READ {
+			http.Error(w, "State must be delivered or read", http.StatusBadRequest)
+			return
+		}
+
+		found, err := env.Devices.AckDelivery(int32(clipID), device.Id, state)
+		if err != nil {
+			env.Logger.Printf("Error occurred while acknowledging clip %d on device %d: %v", clipID, device.Id, err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			http.Error(w, "Clip not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/amns13/shipboard/internal/conf"
+	"github.com/amns13/shipboard/internal/model"
+)
+
+func deviceInbox(t *testing.T, env *conf.Env, user *model.User, device *model.Device, query string) []model.InboxItem {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/devices/inbox/?"+query, nil)
+	req.SetPathValue("device", device.Uid.String())
+	w := httptest.NewRecorder()
+	DeviceInbox(env)(w, authenticated(req, user))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d %q", http.StatusOK, w.Code, w.Body)
+	}
+	var items []model.InboxItem
+	if err := json.Unmarshal(w.Body.Bytes(), &items); err != nil {
+		t.Fatal(err)
+	}
+	return items
+}
+
+func ackDelivery(env *conf.Env, user *model.User, device *model.Device, clipID int32, state string) *httptest.ResponseRecorder {
+	form := url.Values{}
+	if state != "" {
+		form.Set("state", state)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/devices/inbox/ack", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetPathValue("device", device.Uid.String())
+	req.SetPathValue("clip", strconv.Itoa(int(clipID)))
+	w := httptest.NewRecorder()
+	AckDelivery(env)(w, authenticated(req, user))
+	return w
+}
+
+// newTestDevices returns two devices of the user, and the clip sent to the
+// first one only.
+func newTestDevices(t *testing.T, content string) (*conf.Env, *model.User, *model.Device, *model.Device, *model.InboxItem) {
+	t.Helper()
+	env, user := newTestEnv(t)
+	laptop, err := env.Devices.CreateDevice(user.Id, "laptop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	phone, err := env.Devices.CreateDevice(user.Id, "phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := broadcast(env, user, url.Values{"content": {content}, "devices": {laptop.Uid.String()}})
+	if res.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected %d, got %d", http.StatusNoContent, res.StatusCode)
+	}
+	items := deviceInbox(t, env, user, laptop, "")
+	if len(items) != 1 {
+		t.Fatalf("expected the clip in the inbox, got %+v", items)
+	}
+	return env, user, laptop, phone, &items[0]
+}
+
+func TestDeviceInbox(t *testing.T) {
+	content := strings.Repeat("abcd", 4096)
+	env, user, laptop, phone, item := newTestDevices(t, content)
+
+	if item.Content != content || item.State != model.DELIVERY_STATE_PENDING {
+		t.Errorf("expected the pending clip with its content, got %s of %d bytes", item.State, len(item.Content))
+	}
+	if items := deviceInbox(t, env, user, phone, ""); len(items) != 0 {
+		t.Errorf("expected nothing in the inbox of the other device, got %+v", items)
+	}
+
+	if w := ackDelivery(env, user, laptop, item.ClipID, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("expected %d, got %d %q", http.StatusNoContent, w.Code, w.Body)
+	}
+	if items := deviceInbox(t, env, user, laptop, "state=pending"); len(items) != 0 {
+		t.Errorf("expected no pending clips once delivered, got %+v", items)
+	}
+	items := deviceInbox(t, env, user, laptop, "")
+	if len(items) != 1 || items[0].State != model.DELIVERY_STATE_DELIVERED {
+		t.Errorf("expected the delivered clip in the inbox, got %+v", items)
+	}
+
+	if w := ackDelivery(env, user, laptop, item.ClipID, model.DELIVERY_STATE_READ); w.Code != http.StatusNoContent {
+		t.Fatalf("expected %d, got %d %q", http.StatusNoContent, w.Code, w.Body)
+	}
+	if items := deviceInbox(t, env, user, laptop, ""); len(items) != 0 {
+		t.Errorf("expected read clips to leave the inbox, got %+v", items)
+	}
+}
+
+func TestAckDelivery(t *testing.T) {
+	env, user, laptop, phone, item := newTestDevices(t, "abcd")
+
+	if w := ackDelivery(env, user, laptop, item.ClipID, model.DELIVERY_STATE_PENDING); w.Code != http.StatusBadRequest {
+		t.Errorf("expected %d for a state going back, got %d", http.StatusBadRequest, w.Code)
+	}
+	if w := ackDelivery(env, user, phone, item.ClipID, ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected %d for a clip not sent to the device, got %d", http.StatusNotFound, w.Code)
+	}
+
+	// States only move forward, a late delivered ack keeps the clip read
+	if w := ackDelivery(env, user, laptop, item.ClipID, model.DELIVERY_STATE_READ); w.Code != http.StatusNoContent {
+		t.Fatalf("expected %d, got %d %q", http.StatusNoContent, w.Code, w.Body)
+	}
+	if w := ackDelivery(env, user, laptop, item.ClipID, model.DELIVERY_STATE_DELIVERED); w.Code != http.StatusNoContent {
+		t.Fatalf("expected %d, got %d %q", http.StatusNoContent, w.Code, w.Body)
+	}
+	deliveries, err := env.Devices.GetClipDeliveries([]int32{item.ClipID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	delivery := deliveries[item.ClipID]
+	if len(delivery) != 1 || delivery[0].State != model.DELIVERY_STATE_READ || delivery[0].DeliveredAt == nil || delivery[0].ReadAt == nil {
+		t.Errorf("expected the clip to stay read, got %+v", delivery)
+	}
+}
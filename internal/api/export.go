@@ -0,0 +1,404 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/amns13/shipboard/internal/conf"
+	"github.com/amns13/shipboard/internal/model"
+	"github.com/amns13/shipboard/internal/services"
+	"github.com/redis/go-redis/v9"
+)
+
+// Exports estimated to be larger than this are generated in the background
+const exportAsyncThreshold = 1 << 20
+
+type exportProfile struct {
+	Uid       string    `json:"uid"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type exportTeam struct {
+	Uid  string `json:"uid"`
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+type exportClip struct {
+	Content     string     `json:"content"`
+	CreatedAt   time.Time  `json:"created_at"`
+	Once        bool       `json:"once"`
+	ConsumedAt  *time.Time `json:"consumed_at,omitempty"`
+	ContentType string     `json:"content_type"`
+	FileName    *string    `json:"file_name,omitempty"`
+	Size        int64      `json:"size"`
+	Language    *string    `json:"language,omitempty"`
+	Tags        []string   `json:"tags,omitempty"`
+	Note        *string    `json:"note,omitempty"`
+	// Bytes of file clips, base64 encoded
+	Data            []byte                 `json:"data,omitempty"`
+	Representations []exportRepresentation `json:"representations,omitempty"`
+}
+
+type exportRepresentation struct {
+	ContentType string `json:"content_type"`
+	// Base64 encoded
+	Data []byte `json:"data"`
+}
+
+type exportDevice struct {
+	Uid        string     `json:"uid"`
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeenAt *time.Time `json:"last_seen_at"`
+}
+
+// Slots point to their clip by its creation time, clips have no id in the
+// export.
+type exportSlot struct {
+	Name          string    `json:"name"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	ClipCreatedAt time.Time `json:"clip_created_at"`
+}
+
+// Session ids are deliberately left out, they are credentials.
+type exportSession struct {
+	LoginTime time.Time `json:"login_time"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// exportSections lists everything shipboard holds about a user. New kinds of
+// user data must be added here, e.g. audit events once they are recorded.
+func exportSections(env *conf.Env, user *model.User) []services.ExportSection {
+	return []services.ExportSection{
+		{
+			Name: "profile",
+			Records: func(emit func(any) error) error {
+				return emit(exportProfile{
+					Uid:       user.Uid.String(),
+					Name:      user.Name,
+					Email:     user.Email,
+					CreatedAt: user.CreatedAt,
+				})
+			},
+		},
+		{
+			Name: "clips",
+			Records: func(emit func(any) error) error {
+				clips, err := env.Clips.GetUserClips(user.Id, 0)
+				if err != nil {
+					return err
+				}
+				clipIDs := make([]int32, len(clips))
+				for i := range clips {
+					clipIDs[i] = clips[i].Id
+				}
+				// Representations are kept in Postgres only
+				var representations map[int32][]model.Representation
+				if env.HasPostgres() {
+					representations, err = model.GetClipRepresentations(env, clipIDs)
+					if err != nil {
+						return err
+					}
+				}
+				tags, err := env.Tags.GetClipTags(clipIDs)
+				if err != nil {
+					return err
+				}
+				for _, clip := range clips {
+					record := exportClip{
+						Content:     clip.Content,
+						CreatedAt:   clip.CreatedAt,
+						Once:        clip.Once,
+						ConsumedAt:  clip.ConsumedAt,
+						ContentType: clip.ContentType,
+						FileName:    clip.FileName,
+						Size:        clip.Size,
+						Language:    clip.Language,
+						Tags:        tags[clip.Id],
+						Note:        clip.Note,
+					}
+					if (clip.IsStored() || clip.IsCompressed()) && !clip.IsFile() {
+						data, err := env.Clips.GetClipData(&clip)
+						if err != nil {
+							return err
+						}
+						record.Content = string(data)
+					} else if clip.IsFile() {
+						record.Data, err = env.Clips.GetClipData(&clip)
+						if err != nil {
+							return err
+						}
+					}
+					for _, representation := range representations[clip.Id] {
+						data, err := model.GetRepresentationData(env, &representation)
+						if err != nil {
+							return err
+						}
+						record.Representations = append(record.Representations, exportRepresentation{
+							ContentType: representation.ContentType,
+							Data:        data,
+						})
+					}
+					err = emit(record)
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+		{
+			Name: "slots",
+			Records: func(emit func(any) error) error {
+				slots, err := env.Slots.GetUserSlots(user.Id)
+				if err != nil {
+					return err
+				}
+				for _, slot := range slots {
+					err = emit(exportSlot{Name: slot.Name, UpdatedAt: slot.UpdatedAt, ClipCreatedAt: slot.CreatedAt})
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+		{
+			Name: "devices",
+			Records: func(emit func(any) error) error {
+				devices, err := env.Devices.GetUserDevices(user.Id)
+				if err != nil {
+					return err
+				}
+				for _, device := range devices {
+					err = emit(exportDevice{
+						Uid:        device.Uid.String(),
+						Name:       device.Name,
+						CreatedAt:  device.CreatedAt,
+						LastSeenAt: device.LastSeenAt,
+					})
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+		{
+			Name: "teams",
+			Records: func(emit func(any) error) error {
+				teams, err := env.Teams.GetUserTeams(user.Id)
+				if err != nil {
+					return err
+				}
+				for _, team := range teams {
+					err = emit(exportTeam{Uid: team.Uid.String(), Name: team.Name, Role: team.Role})
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+		{
+			Name: "sessions",
+			Records: func(emit func(any) error) error {
+				sessions, err := env.Sessions.UserSessions(user.Id)
+				if err != nil {
+					return err
+				}
+				for _, session := range sessions {
+					err = emit(exportSession{LoginTime: session.LoginTime, ExpiresAt: session.ExpiresAt})
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+	}
+}
+
+// estimateExportSize returns the approximate size of the export in bytes.
+// Only the variable sized data is taken into account.
+func estimateExportSize(env *conf.Env, user *model.User) (int64, error) {
+	usage, err := env.Clips.GetUserUsage(user.Id)
+	return usage.Bytes, err
+}
+
+func exportContentType(format string) string {
+	if format == services.EXPORT_FORMAT_ZIP {
+		return "application/zip"
+	}
+	return "application/x-ndjson"
+}
+
+func exportFileName(format string) string {
+	return fmt.Sprintf("shipboard-export-%s.%s", time.Now().Format("2006-01-02"), format)
+}
+
+// ExportAccount streams an archive of the user's data. Large exports, or when
+// async=true is passed, are generated in the background instead and the user
+// gets an expiring download link.
+func ExportAccount(env *conf.Env) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireStore(w, env.Exports != nil, "Exports") {
+			return
+		}
+		user, err := authenticatedUser(env, req)
+		if err != nil {
+			env.Logger.Printf("Error occurred while fetching user: %v", err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+
+		format := req.URL.Query().Get("format")
+		if format == "" {
+			format = services.EXPORT_FORMAT_ZIP
+		}
+		if format != services.EXPORT_FORMAT_ZIP && format != services.EXPORT_FORMAT_NDJSON {
+			http.Error(w, "Unsupported export format", http.StatusBadRequest)
+			return
+		}
+
+		size, err := estimateExportSize(env, user)
+		if err != nil {
+			env.Logger.Printf("Error occurred while estimating export size: %v", err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		if size > exportAsyncThreshold || req.URL.Query().Get("async") == "true" {
+			startExport(env, w, user, format)
+			return
+		}
+
+		w.Header().Set("Content-Type", exportContentType(format))
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", exportFileName(format)))
+		err = services.WriteExport(w, format, exportSections(env, user))
+		if err != nil {
+			// Headers are already sent, the client gets a truncated archive
+			env.Logger.Printf("Error occurred while exporting data of user %d: %v", user.Id, err)
+		}
+	}
+}
+
+func startExport(env *conf.Env, w http.ResponseWriter, user *model.User, format string) {
+	dir := services.ExportDir()
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		env.Logger.Printf("Error occurred while creating export directory: %v", err)
+		http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+		return
+	}
+	file, err := os.CreateTemp(dir, "export-*."+format)
+	if err != nil {
+		env.Logger.Printf("Error occurred while creating export file: %v", err)
+		http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+		return
+	}
+
+	job := services.ExportJob{
+		UserID:    user.Id,
+		Format:    format,
+		Status:    services.EXPORT_STATUS_PENDING,
+		Path:      file.Name(),
+		ExpiresAt: time.Now().Add(services.ExportTTL),
+	}
+	token, err := env.Exports.Create(job)
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		env.Logger.Printf("Error occurred while creating export job: %v", err)
+		http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+		return
+	}
+	link := fmt.Sprintf("%s/account/export/%s", env.PublicURL, token)
+
+	// The download link stops working once the job expires in the store, the
+	// file is deleted after that by collectOrphanBlobs in cmd/server
+	go func() {
+		err := services.WriteExport(file, format, exportSections(env, user))
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		job.Status = services.EXPORT_STATUS_READY
+		if err != nil {
+			env.Logger.Printf("Error occurred while exporting data of user %d: %v", user.Id, err)
+			job.Status = services.EXPORT_STATUS_FAILED
+		}
+		err = env.Exports.Set(token, job)
+		if err != nil {
+			env.Logger.Printf("Error occurred while updating export job: %v", err)
+			return
+		}
+		if job.Status == services.EXPORT_STATUS_READY {
+			body := fmt.Sprintf("Your shipboard data export is ready. The link expires at %s.\n\n%s", job.ExpiresAt.Format(time.RFC1123), link)
+			err = env.Mailer.Send(user.Email, "Your data export is ready", body)
+			if err != nil {
+				env.Logger.Printf("Error occurred while sending export email: %v", err)
+			}
+		}
+	}()
+
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte(fmt.Sprintf("Your export is being generated. Download it from %s before it expires.", link)))
+}
+
+func DownloadExport(env *conf.Env) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireStore(w, env.Exports != nil, "Exports") {
+			return
+		}
+		user, err := authenticatedUser(env, req)
+		if err != nil {
+			env.Logger.Printf("Error occurred while fetching user: %v", err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+
+		job, err := env.Exports.Get(req.PathValue("token"))
+		if err != nil && err != redis.Nil {
+			env.Logger.Printf("Error occurred while fetching export job: %v", err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		// Exports of other users are reported as missing as well
+		if err == redis.Nil || job.UserID != user.Id {
+			http.Error(w, "Export not found or expired", http.StatusNotFound)
+			return
+		}
+
+		switch job.Status {
+		case services.EXPORT_STATUS_PENDING:
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte("Your export is still being generated. Try again in a bit."))
+			return
+		case services.EXPORT_STATUS_FAILED:
+			http.Error(w, "Export failed. Please request a new one.", http.StatusInternalServerError)
+			return
+		}
+
+		file, err := os.Open(job.Path)
+		if err != nil {
+			env.Logger.Printf("Error occurred while opening export file: %v", err)
+			http.Error(w, "Export not found or expired", http.StatusNotFound)
+			return
+		}
+		defer file.Close()
+		stat, err := file.Stat()
+		if err != nil {
+			env.Logger.Printf("Error occurred while opening export file: %v", err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", exportContentType(job.Format))
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", exportFileName(job.Format)))
+		http.ServeContent(w, req, "", stat.ModTime(), file)
+	}
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type exportLine struct {
+	Section string          `json:"section"`
+	Data    json.RawMessage `json:"data"`
+}
+
+func TestExportAccount(t *testing.T) {
+	env, user := newTestEnv(t)
+	clip, err := env.Clips.CreateClip(user.Id, "abcd", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = env.Tags.SetClipTags(user.Id, clip.Id, []string{"work"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = env.Slots.SetSlot(user.Id, "pinned", clip.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = env.Devices.CreateDevice(user.Id, "laptop"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = env.Teams.CreateTeam("ops", user.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/account/export/?format=ndjson", nil)
+	w := httptest.NewRecorder()
+	ExportAccount(env)(w, authenticated(req, user))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d %q", http.StatusOK, w.Code, w.Body)
+	}
+
+	records := make(map[string][]json.RawMessage)
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		var line exportLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatal(err)
+		}
+		records[line.Section] = append(records[line.Section], line.Data)
+	}
+	for _, section := range exportSections(env, user) {
+		if section.Name != "sessions" && len(records[section.Name]) != 1 {
+			t.Errorf("expected 1 %s record, got %d", section.Name, len(records[section.Name]))
+		}
+	}
+	var exported exportClip
+	if err := json.Unmarshal(records["clips"][0], &exported); err != nil {
+		t.Fatal(err)
+	}
+	if exported.Content != "abcd" || len(exported.Tags) != 1 || exported.Tags[0] != "work" {
+		t.Errorf("expected the tagged clip, got %+v", exported)
+	}
+	var slot exportSlot
+	if err := json.Unmarshal(records["slots"][0], &slot); err != nil {
+		t.Fatal(err)
+	}
+	if slot.Name != "pinned" || !slot.ClipCreatedAt.Equal(exported.CreatedAt) {
+		t.Errorf("expected the slot of the clip, got %+v", slot)
+	}
+}
@@ -0,0 +1,541 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/amns13/shipboard/internal/conf"
+	"github.com/amns13/shipboard/internal/model"
+	"github.com/amns13/shipboard/internal/services"
+)
+
+// Room for the other form fields sent along with a file
+const multipartOverhead = 1 << 20
+
+// Files are buffered in memory up to this size, larger ones go to a temporary
+// file while the request is read
+const multipartMemory = 1 << 20
+
+const defaultFileName = "clip"
+
+// File names and content types are kept in columns of this many characters
+const maxFileFieldLength = 255
+
+// errStoreUpload wraps the errors of the blob store while an upload is
+// streamed to it, unlike errors of the upload itself.
+var errStoreUpload = errors.New("storing upload")
+
+var errFileFieldTooLong = errors.New("file name or content type too long")
+
+// fileFieldsFit reports whether the file name and content type fit in their
+// columns. Like varchar, lengths are in characters.
+func fileFieldsFit(fileName string, contentType string) bool {
+	return utf8.RuneCountInString(fileName) <= maxFileFieldLength && utf8.RuneCountInString(contentType) <= maxFileFieldLength
+}
+
+// writeFileFieldTooLong responds to a file name or content type that does not
+// fit in its column.
+func writeFileFieldTooLong(w http.ResponseWriter) {
+	http.Error(w, fmt.Sprintf("File names and content types can be at most %d characters", maxFileFieldLength), http.StatusBadRequest)
+}
+
+type fileUpload struct {
+	Name        string
+	ContentType string
+	// Data is only the start of the file if it is Stored
+	Data   []byte
+	Stored *model.StoredPayload
+}
+
+// Size returns the size of the whole file.
+func (upload *fileUpload) Size() int64 {
+	if upload.Stored != nil {
+		return upload.Stored.Size
+	}
+	return int64(len(upload.Data))
+}
+
+// sensitive reports whether the file is handled as a secret, see
+// fileSensitive. Files streamed to the blob store are too large for secrets.
+func (upload *fileUpload) sensitive(value string) bool {
+	return upload.Stored == nil && fileSensitive(value, upload.ContentType, upload.Data)
+}
+
+// createFileClip adds the file to the history, wherever it is kept.
+func createFileClip(env *conf.Env, userID int32, upload *fileUpload) (*model.Clip, error) {
+	if upload.Stored != nil {
+		return model.CreateStoredFileClip(env, userID, upload.Name, upload.ContentType, upload.Stored)
+	}
+	return env.Clips.CreateFileClip(userID, upload.Name, upload.ContentType, upload.Data)
+}
+
+// bumpDuplicateFile moves a duplicate of the file to the top of the history,
+// see store.ClipStore.BumpDuplicateClip.
+func bumpDuplicateFile(env *conf.Env, userID int32, upload *fileUpload) (*model.Clip, error) {
+	if upload.Stored != nil {
+		return model.BumpDuplicateStoredClip(env, userID, upload.Name, upload.ContentType, upload.Stored, clipDedupeWindow)
+	}
+	return env.Clips.BumpDuplicateClip(userID, &upload.Name, upload.ContentType, "", upload.Data, clipDedupeWindow)
+}
+
+// detectContentType keeps the content type sent by the client unless it is
+// missing or generic, in which case it is sniffed from the data.
+func detectContentType(contentType string, data []byte) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType == "application/octet-stream" {
+		return http.DetectContentType(data)
+	}
+	return contentType
+}
+
+// readLimited reads all of r, failing with http.MaxBytesError if it is larger
+// than limit.
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, &http.MaxBytesError{Limit: limit}
+	}
+	return data, nil
+}
+
+// payloadReader hashes what is read from r, and keeps the error of r apart
+// from the errors of whoever reads it.
+type payloadReader struct {
+	r    io.Reader
+	hash hash.Hash
+	err  error
+}
+
+func (r *payloadReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.hash.Write(p[:n])
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+// readFile reads an uploaded file of size bytes, or -1 if the size is
+// unknown, failing with http.MaxBytesError if it is larger than limit. With
+// Postgres, files too large to be kept inline are streamed to the blob store
+// instead of being held in memory, only their start is returned. Errors of
+// the blob store wrap errStoreUpload.
+func readFile(env *conf.Env, r io.Reader, size int64, limit int64) ([]byte, *model.StoredPayload, error) {
+	if size > limit {
+		return nil, nil, &http.MaxBytesError{Limit: limit}
+	}
+	// Files are kept in the database without Postgres
+	if !env.HasPostgres() || (size >= 0 && size <= env.BlobInlineLimit) {
+		data, err := readLimited(r, limit)
+		return data, nil, err
+	}
+	return streamFile(env, r, size, limit)
+}
+
+// streamFile streams the file to the blob store once it is larger than the
+// inline limit, see readFile.
+func streamFile(env *conf.Env, r io.Reader, size int64, limit int64) ([]byte, *model.StoredPayload, error) {
+	// One more byte than fits inline tells whether the file must be stored
+	head, err := io.ReadAll(io.LimitReader(r, min(limit, env.BlobInlineLimit)+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if int64(len(head)) <= min(limit, env.BlobInlineLimit) {
+		return head, nil, nil
+	}
+	if int64(len(head)) > limit {
+		return nil, nil, &http.MaxBytesError{Limit: limit}
+	}
+	r = io.MultiReader(bytes.NewReader(head), r)
+
+	// Blobs are written with their size, a body of unknown length goes to a
+	// temporary file first
+	if size < 0 {
+		file, err := os.CreateTemp("", "shipboard-upload-*")
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %w", errStoreUpload, err)
+		}
+		defer os.Remove(file.Name())
+		defer file.Close()
+		size, err = io.Copy(file, io.LimitReader(r, limit+1))
+		if err == nil && size > limit {
+			err = &http.MaxBytesError{Limit: limit}
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		_, err = file.Seek(0, io.SeekStart)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %w", errStoreUpload, err)
+		}
+		r = file
+	}
+
+	reader := &payloadReader{r: io.LimitReader(r, size), hash: sha256.New()}
+	blobKey := services.NewBlobKey(model.CLIP_BLOB_PREFIX)
+	err = env.BlobStore.Put(blobKey, reader, size)
+	if reader.err != nil {
+		return nil, nil, reader.err
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", errStoreUpload, err)
+	}
+	return head, &model.StoredPayload{BlobKey: blobKey, Size: size, Hash: reader.hash.Sum(nil)}, nil
+}
+
+// readUpload returns the file uploaded with the request, or nil if the request
+// is a plain form. Files are sent either as the "file" field of a
+// multipart/form-data form, or as the raw body with any other content type.
+// The name of a raw file is taken from the filename query parameter or the
+// Content-Disposition header.
+// Form fields are available in req.PostForm afterwards in both cases.
+func readUpload(env *conf.Env, w http.ResponseWriter, req *http.Request) (*fileUpload, error) {
+	req.Body = http.MaxBytesReader(w, req.Body, env.MaxClipSize+multipartOverhead)
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	switch mediaType {
+	case "", "application/x-www-form-urlencoded":
+		return nil, req.ParseForm()
+
+	case "multipart/form-data":
+		err := req.ParseMultipartForm(multipartMemory)
+		if err != nil {
+			return nil, err
+		}
+		file, header, err := req.FormFile("file")
+		if err == http.ErrMissingFile {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		if !fileFieldsFit(header.Filename, header.Header.Get("Content-Type")) {
+			return nil, errFileFieldTooLong
+		}
+		data, stored, err := readFile(env, file, header.Size, env.MaxClipSize)
+		if err != nil {
+			return nil, err
+		}
+		upload := &fileUpload{
+			Name:        header.Filename,
+			ContentType: detectContentType(header.Header.Get("Content-Type"), data),
+			Data:        data,
+			Stored:      stored,
+		}
+		if upload.Name == "" {
+			upload.Name = defaultFileName
+		}
+		return upload, nil
+
+	default:
+		name := req.URL.Query().Get("filename")
+		if name == "" {
+			_, params, err := mime.ParseMediaType(req.Header.Get("Content-Disposition"))
+			if err == nil {
+				name = params["filename"]
+			}
+		}
+		if name == "" {
+			name = defaultFileName
+		}
+		if !fileFieldsFit(name, req.Header.Get("Content-Type")) {
+			return nil, errFileFieldTooLong
+		}
+		data, stored, err := readFile(env, req.Body, req.ContentLength, env.MaxClipSize)
+		if err != nil {
+			return nil, err
+		}
+		upload := &fileUpload{
+			Name:        name,
+			ContentType: detectContentType(req.Header.Get("Content-Type"), data),
+			Data:        data,
+			Stored:      stored,
+		}
+		// The query is the only form left, e.g. for once=true
+		req.PostForm = req.URL.Query()
+		return upload, nil
+	}
+}
+
+// writeUploadError responds to an error of readUpload.
+func writeUploadError(env *conf.Env, w http.ResponseWriter, err error) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		http.Error(w, fmt.Sprintf("Clips can be at most %d bytes", env.MaxClipSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if errors.Is(err, errFileFieldTooLong) {
+		writeFileFieldTooLong(w)
+		return
+	}
+	if errors.Is(err, errStoreUpload) {
+		env.Logger.Printf("Error occurred while storing upload: %v", err)
+		http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+		return
+	}
+	http.Error(w, "Invalid upload", http.StatusBadRequest)
+}
+
+// writePayload sends data as a file download.
+func writePayload(w http.ResponseWriter, contentType string, fileName string, data []byte) {
+	writePayloadHeaders(w, contentType, fileName, int64(len(data)))
+	w.Write(data)
+}
+
+// writePayloadHeaders sets the headers of a file download of length bytes,
+// or of unknown length if it is negative.
+func writePayloadHeaders(w http.ResponseWriter, contentType string, fileName string, length int64) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fileName}))
+	if length >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	}
+	// Uploaded files are served as is, they must never be sniffed or run as
+	// html of this site
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+}
+
+// storedPayload reads a payload from the blob store, closing the blob along
+// with it.
+type storedPayload struct {
+	io.Reader
+	blob io.Closer
+}
+
+func (p *storedPayload) Close() error {
+	return p.blob.Close()
+}
+
+// openStoredPayload opens a payload kept in the blob store so that it is
+// streamed instead of held in memory. Like encodePayload, it is decompressed
+// unless the client accepts its codec. Returns the length of what is read, or
+// -1 if it is sent compressed since only the decompressed size is known.
+func openStoredPayload(env *conf.Env, w http.ResponseWriter, req *http.Request, blobKey string, codec *string, size int64) (io.ReadCloser, int64, error) {
+	blob, err := env.BlobStore.Get(blobKey)
+	if err != nil {
+		return nil, 0, err
+	}
+	if codec == nil || *codec == "" {
+		return blob, size, nil
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+	if acceptsEncoding(req.Header.Get("Accept-Encoding"), *codec) {
+		w.Header().Set("Content-Encoding", *codec)
+		return blob, -1, nil
+	}
+	reader, err := services.DecompressReader(blob, *codec)
+	if err != nil {
+		blob.Close()
+		return nil, 0, err
+	}
+	return &storedPayload{Reader: reader, blob: blob}, size, nil
+}
+
+// streamStoredPayload sends a payload kept in the blob store, see
+// openStoredPayload. writeHeaders is called with the length once the payload
+// is opened.
+func streamStoredPayload(env *conf.Env, w http.ResponseWriter, req *http.Request, clipID int32, blobKey string, codec *string, size int64, writeHeaders func(length int64)) {
+	reader, length, err := openStoredPayload(env, w, req, blobKey, codec, size)
+	if err != nil {
+		env.Logger.Printf("Error occurred while fetching data of clip %d: %v", clipID, err)
+		http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+		return
+	}
+	defer reader.Close()
+	writeHeaders(length)
+	_, err = io.Copy(w, reader)
+	if err != nil {
+		env.Logger.Printf("Error occurred while sending data of clip %d: %v", clipID, err)
+	}
+}
+
+// encodePayload returns a compressed payload as is if the client accepts its
+// codec as content coding, setting Content-Encoding. Otherwise the payload is
+// decompressed.
+func encodePayload(w http.ResponseWriter, req *http.Request, payload []byte, codec string) ([]byte, error) {
+	if codec == "" {
+		return payload, nil
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+	if acceptsEncoding(req.Header.Get("Accept-Encoding"), codec) {
+		w.Header().Set("Content-Encoding", codec)
+		return payload, nil
+	}
+	return services.Decompress(payload, codec)
+}
+
+// clipPayload returns the payload of the clip as it is kept, compressed if the
+// clip is. The SQLite stores keep payloads uncompressed.
+func clipPayload(env *conf.Env, clip *model.Clip) ([]byte, error) {
+	if !env.HasPostgres() {
+		return env.Clips.GetClipData(clip)
+	}
+	return model.GetClipPayload(env, clip)
+}
+
+// writeClip sends a clip as a file download. Text clips are named after their
+// id.
+func writeClip(env *conf.Env, w http.ResponseWriter, req *http.Request, clip *model.Clip) {
+	fileName := fmt.Sprintf("clip-%d.txt", clip.Id)
+	if clip.IsFile() {
+		fileName = *clip.FileName
+	}
+	if env.HasPostgres() && clip.IsStored() {
+		streamStoredPayload(env, w, req, clip.Id, *clip.BlobKey, clip.Codec, clip.Size, func(length int64) {
+			writePayloadHeaders(w, clip.ContentType, fileName, length)
+		})
+		return
+	}
+	data, err := clipPayload(env, clip)
+	if err == nil && clip.IsCompressed() {
+		data, err = encodePayload(w, req, data, *clip.Codec)
+	}
+	if err != nil {
+		env.Logger.Printf("Error occurred while fetching data of clip %d: %v", clip.Id, err)
+		http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+		return
+	}
+	writePayload(w, clip.ContentType, fileName, data)
+}
+
+// writeTextClip writes a text clip as plain text, unlike writeClip which
+// serves it as a download.
+func writeTextClip(env *conf.Env, w http.ResponseWriter, req *http.Request, clip *model.Clip) {
+	if env.HasPostgres() && clip.IsStored() {
+		streamStoredPayload(env, w, req, clip.Id, *clip.BlobKey, clip.Codec, clip.Size, func(int64) {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		})
+		return
+	}
+	data, err := clipPayload(env, clip)
+	if err == nil && clip.IsCompressed() {
+		data, err = encodePayload(w, req, data, *clip.Codec)
+	}
+	if err != nil {
+		env.Logger.Printf("Error occurred while fetching data of clip %d: %v", clip.Id, err)
+		http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(data)
+}
+
+// writeRepresentation sends a representation of a clip as a file download,
+// named after the clip.
+func writeRepresentation(env *conf.Env, w http.ResponseWriter, req *http.Request, clip *model.Clip, representation *model.Representation) {
+	fileName := fmt.Sprintf("clip-%d", clip.Id)
+	if extensions, _ := mime.ExtensionsByType(representation.ContentType); len(extensions) > 0 {
+		fileName += extensions[0]
+	}
+	// Representations are kept uncompressed
+	if representation.BlobKey != nil {
+		streamStoredPayload(env, w, req, clip.Id, *representation.BlobKey, nil, representation.Size, func(length int64) {
+			writePayloadHeaders(w, representation.ContentType, fileName, length)
+		})
+		return
+	}
+	data, err := model.GetRepresentationData(env, representation)
+	if err != nil {
+		env.Logger.Printf("Error occurred while fetching %s of clip %d: %v", representation.ContentType, clip.Id, err)
+		http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+		return
+	}
+	writePayload(w, representation.ContentType, fileName, data)
+}
+
+// readRepresentations returns the "representation" files of a multipart
+// form, each with its own content type, or nil if there are none.
+func readRepresentations(env *conf.Env, req *http.Request) ([]model.ClipPayload, error) {
+	if req.MultipartForm == nil {
+		return nil, nil
+	}
+	var payloads []model.ClipPayload
+	var total int64
+	seen := make(map[string]bool)
+	for _, header := range req.MultipartForm.File["representation"] {
+		if !fileFieldsFit("", header.Header.Get("Content-Type")) {
+			return nil, errFileFieldTooLong
+		}
+		file, err := header.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, stored, err := readFile(env, file, header.Size, env.MaxClipSize-total)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+		payload := model.ClipPayload{Data: data, Stored: stored}
+		total += payload.Size()
+
+		mediaType, params, err := mime.ParseMediaType(detectContentType(header.Header.Get("Content-Type"), data))
+		if err != nil {
+			return nil, err
+		}
+		if seen[mediaType] {
+			return nil, fmt.Errorf("representation %s given twice", mediaType)
+		}
+		seen[mediaType] = true
+		payload.ContentType = mime.FormatMediaType(mediaType, params)
+		payloads = append(payloads, payload)
+	}
+	return payloads, nil
+}
+
+// DownloadClip sends one of the user's clips as a file. Pass type to get
+// another representation than the primary one.
+func DownloadClip(env *conf.Env) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		clip, ok := ownClip(env, w, req)
+		if !ok {
+			return
+		}
+		if clip.Once {
+			http.Error(w, "Burn after reading clips cannot be downloaded", http.StatusBadRequest)
+			return
+		}
+		contentType := req.URL.Query().Get("type")
+		if contentType == "" {
+			writeClip(env, w, req, clip)
+			return
+		}
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			http.Error(w, "Invalid type", http.StatusBadRequest)
+			return
+		}
+		if mediaTypeOf(clip.ContentType) == mediaType {
+			writeClip(env, w, req, clip)
+			return
+		}
+		// Clips have no other representations without Postgres
+		if env.HasPostgres() {
+			representations, err := model.GetClipRepresentations(env, []int32{clip.Id})
+			if err != nil {
+				env.Logger.Printf("Error occurred while fetching representations of clip %d: %v", clip.Id, err)
+				http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+				return
+			}
+			for _, representation := range representations[clip.Id] {
+				if mediaTypeOf(representation.ContentType) == mediaType {
+					writeRepresentation(env, w, req, clip, &representation)
+					return
+				}
+			}
+		}
+		http.Error(w, fmt.Sprintf("Clip has no %s representation", mediaType), http.StatusNotFound)
+	}
+}
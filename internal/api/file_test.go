@@ -0,0 +1,128 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/amns13/shipboard/internal/model"
+	"github.com/amns13/shipboard/internal/services"
+)
+
+func TestStreamFile(t *testing.T) {
+	env, _ := newTestEnv(t)
+	env.BlobStore = &services.FileBlobStore{Root: t.TempDir()}
+	env.BlobInlineLimit = 8
+
+	tests := []struct {
+		name   string
+		data   string
+		size   int64
+		stored bool
+	}{
+		{"small file is kept in memory", "abcd", 4, false},
+		{"file of the inline limit is kept in memory", "abcdefgh", -1, false},
+		{"large file is stored", "abcdefghijklmnop", 16, true},
+		{"large file of unknown size is stored", "abcdefghijklmnop", -1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, stored, err := streamFile(env, strings.NewReader(tt.data), tt.size, 32)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.stored {
+				if stored != nil || string(data) != tt.data {
+					t.Fatalf("expected %q in memory, got %q and %+v", tt.data, data, stored)
+				}
+				return
+			}
+			if stored == nil {
+				t.Fatal("expected the file to be stored")
+			}
+			if !strings.HasPrefix(tt.data, string(data)) {
+				t.Errorf("expected the start of the file, got %q", data)
+			}
+			hash := sha256.Sum256([]byte(tt.data))
+			if stored.Size != int64(len(tt.data)) || !bytes.Equal(stored.Hash, hash[:]) {
+				t.Errorf("expected size %d and the hash of the file, got %+v", len(tt.data), stored)
+			}
+			reader, err := env.BlobStore.Get(stored.BlobKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reader.Close()
+			blob, _ := io.ReadAll(reader)
+			if string(blob) != tt.data {
+				t.Errorf("expected %q in the blob store, got %q", tt.data, blob)
+			}
+		})
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	_, _, err := readFile(env, strings.NewReader(strings.Repeat("a", 33)), 33, 32)
+	if !errors.As(err, &maxBytesErr) {
+		t.Errorf("expected http.MaxBytesError for a file too large, got %v", err)
+	}
+	_, _, err = streamFile(env, strings.NewReader(strings.Repeat("a", 33)), -1, 32)
+	if !errors.As(err, &maxBytesErr) {
+		t.Errorf("expected http.MaxBytesError for a body too large, got %v", err)
+	}
+}
+
+func TestOpenStoredPayload(t *testing.T) {
+	env, _ := newTestEnv(t)
+	content := strings.Repeat("abcd", 4096)
+	compressed, codec, err := services.Compress([]byte(content))
+	if err != nil || codec == "" {
+		t.Fatalf("expected the content to be compressed, got %q %v", codec, err)
+	}
+	key := services.NewBlobKey(model.CLIP_BLOB_PREFIX)
+	if err := env.BlobStore.Put(key, bytes.NewReader(compressed), int64(len(compressed))); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, accept := range []string{"", codec} {
+		req := httptest.NewRequest(http.MethodGet, "/clip/", nil)
+		req.Header.Set("Accept-Encoding", accept)
+		w := httptest.NewRecorder()
+		reader, length, err := openStoredPayload(env, w, req, key, &codec, int64(len(content)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(reader)
+		reader.Close()
+		if accept == "" && (length != int64(len(content)) || string(data) != content) {
+			t.Errorf("expected the content decompressed, got %d bytes of length %d", len(data), length)
+		}
+		if accept != "" && (length != -1 || !bytes.Equal(data, compressed) || w.Header().Get("Content-Encoding") != codec) {
+			t.Errorf("expected the content as kept, got %d bytes of length %d", len(data), length)
+		}
+	}
+}
+
+func TestBroadcastFileFieldsTooLong(t *testing.T) {
+	env, user := newTestEnv(t)
+	long := strings.Repeat("é", maxFileFieldLength+1)
+
+	req := httptest.NewRequest(http.MethodPost, "/clip/?filename="+long, strings.NewReader("abcd"))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	w := httptest.NewRecorder()
+	Broadcast(env)(w, authenticated(req, user))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected %d for a file name too long, got %d %q", http.StatusBadRequest, w.Code, w.Body)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/clip/?filename=notes.txt", strings.NewReader("abcd"))
+	req.Header.Set("Content-Type", "text/plain; name="+long)
+	w = httptest.NewRecorder()
+	Broadcast(env)(w, authenticated(req, user))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected %d for a content type too long, got %d %q", http.StatusBadRequest, w.Code, w.Body)
+	}
+}
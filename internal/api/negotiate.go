@@ -0,0 +1,100 @@
+package api
+
+import (
+	"mime"
+	"strconv"
+	"strings"
+)
+
+// mediaTypeOf returns the lowercased media type of a content type, without
+// its parameters.
+func mediaTypeOf(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(contentType))
+	}
+	return mediaType
+}
+
+// negotiateContentType picks the offered content type the Accept header
+// prefers, as defined in RFC 9110. Ties go to the earlier offer, and an
+// empty header accepts the first one. Returns -1 if none is acceptable.
+func negotiateContentType(accept string, offered []string) int {
+	if strings.TrimSpace(accept) == "" {
+		if len(offered) == 0 {
+			return -1
+		}
+		return 0
+	}
+
+	type mediaRange struct {
+		mediaType string
+		quality   float64
+	}
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if value, ok := params["q"]; ok {
+			quality, err = strconv.ParseFloat(value, 64)
+			if err != nil || quality < 0 || quality > 1 {
+				continue
+			}
+		}
+		ranges = append(ranges, mediaRange{mediaType, quality})
+	}
+
+	best, bestQuality := -1, 0.0
+	for i, contentType := range offered {
+		mediaType := mediaTypeOf(contentType)
+		mainType, _, _ := strings.Cut(mediaType, "/")
+		// The most specific matching range sets the quality
+		quality, specificity := 0.0, -1
+		for _, r := range ranges {
+			rangeSpecificity := -1
+			switch {
+			case r.mediaType == mediaType:
+				rangeSpecificity = 2
+			case r.mediaType == mainType+"/*":
+				rangeSpecificity = 1
+			case r.mediaType == "*/*":
+				rangeSpecificity = 0
+			}
+			if rangeSpecificity > specificity {
+				quality, specificity = r.quality, rangeSpecificity
+			}
+		}
+		if quality > bestQuality {
+			best, bestQuality = i, quality
+		}
+	}
+	return best
+}
+
+// acceptsEncoding reports whether the Accept-Encoding header allows the
+// content coding, as defined in RFC 9110. The coding itself takes precedence
+// over a * wildcard.
+func acceptsEncoding(accept string, coding string) bool {
+	quality, specific := 0.0, false
+	for _, part := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.TrimSpace(name)
+		isCoding := strings.EqualFold(name, coding)
+		if !isCoding && (name != "*" || specific) {
+			continue
+		}
+		value := 1.0
+		if key, q, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(key) == "q" {
+			var err error
+			value, err = strconv.ParseFloat(strings.TrimSpace(q), 64)
+			if err != nil {
+				continue
+			}
+		}
+		quality, specific = value, isCoding
+	}
+	return quality > 0
+}
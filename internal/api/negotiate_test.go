@@ -0,0 +1,68 @@
+package api
+
+import "testing"
+
+func TestNegotiateContentType(t *testing.T) {
+	offered := []string{"text/plain; charset=utf-8", "text/html", "image/png"}
+
+	tests := []struct {
+		name     string
+		accept   string
+		expected int
+	}{
+		{"empty header accepts the first offer", "", 0},
+		{"blank header accepts the first offer", "  ", 0},
+		{"exact match", "image/png", 2},
+		{"match ignores parameters and case", "Text/HTML; level=1", 1},
+		{"higher quality wins", "text/plain;q=0.5, text/html;q=0.8", 1},
+		{"ties go to the earlier offer", "text/html, text/plain", 0},
+		{"subtype wildcard", "image/*", 2},
+		{"full wildcard accepts the first offer", "*/*", 0},
+		{"specific range overrides the wildcard", "text/*;q=0.9, text/plain;q=0.1", 1},
+		{"wildcard ranks below the specific range", "*/*;q=0.1, image/png", 2},
+		{"q=0 refuses the type", "text/plain;q=0, text/*", 1},
+		{"q=0 on everything is not acceptable", "*/*;q=0", -1},
+		{"no match is not acceptable", "application/json", -1},
+		{"invalid ranges are skipped", "text/html;q=2, ;;, image/png;q=0.5", 2},
+		{"invalid quality is skipped", "text/plain;q=abc, image/png;q=0.1", 2},
+		{"only invalid ranges are not acceptable", "text/plain;q=-1", -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := negotiateContentType(tt.accept, offered); got != tt.expected {
+				t.Errorf("expected %d, got %d", tt.expected, got)
+			}
+		})
+	}
+
+	if got := negotiateContentType("", nil); got != -1 {
+		t.Errorf("expected -1 without offers, got %d", got)
+	}
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	tests := []struct {
+		accept   string
+		expected bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"GZIP", true},
+		{"deflate, gzip;q=0.5", true},
+		{"gzip; q=0.5", true},
+		{"deflate", false},
+		{"identity", false},
+		{"gzip;q=0", false},
+		{"gzip;q=abc", false},
+		{"*", true},
+		{"*;q=0", false},
+		{"gzip;q=0, *", false},
+		{"*, gzip;q=0", false},
+		{"gzip;q=0.5, *;q=0", true},
+	}
+	for _, tt := range tests {
+		if got := acceptsEncoding(tt.accept, "gzip"); got != tt.expected {
+			t.Errorf("%q: expected %v, got %v", tt.accept, tt.expected, got)
+		}
+	}
+}
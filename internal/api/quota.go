@@ -0,0 +1,53 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/amns13/shipboard/internal/conf"
+	"github.com/amns13/shipboard/internal/model"
+	"github.com/amns13/shipboard/internal/services"
+)
+
+// quotaUsage is the usage of a user along with their quota, for the UI.
+type quotaUsage struct {
+	model.Usage
+	Quota services.Quota
+}
+
+func userQuotaUsage(env *conf.Env, userID int32) (*quotaUsage, error) {
+	usage, err := env.Clips.GetUserUsage(userID)
+	if err != nil {
+		return nil, err
+	}
+	override, err := env.Users.GetQuotaOverride(userID)
+	if err != nil {
+		return nil, err
+	}
+	return &quotaUsage{Usage: usage, Quota: override.Apply(env.Quota)}, nil
+}
+
+// checkQuota checks that the user can store one more clip of size bytes.
+// Writes the error and returns false if it would exceed their quota, with
+// 413 if the clip can never fit and 507 if it fits once the history is
+// smaller.
+func checkQuota(env *conf.Env, w http.ResponseWriter, userID int32, size int64) bool {
+	usage, err := userQuotaUsage(env, userID)
+	if err != nil {
+		env.Logger.Printf("Error occurred while fetching quota of user %d: %v", userID, err)
+		http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+		return false
+	}
+	switch usage.Quota.Allows(usage.Clips, usage.Bytes, size) {
+	case services.ErrLargerThanQuota:
+		http.Error(w, fmt.Sprintf("Clip of %d bytes is larger than your storage quota of %d bytes", size, usage.Quota.MaxBytes), http.StatusRequestEntityTooLarge)
+		return false
+	case services.ErrStorageQuotaExceeded:
+		http.Error(w, fmt.Sprintf("Storage quota exceeded, %d of %d bytes used", usage.Bytes, usage.Quota.MaxBytes), http.StatusInsufficientStorage)
+		return false
+	case services.ErrClipQuotaExceeded:
+		http.Error(w, fmt.Sprintf("Clip quota exceeded, %d of %d clips used", usage.Clips, usage.Quota.MaxClips), http.StatusInsufficientStorage)
+		return false
+	}
+	return true
+}
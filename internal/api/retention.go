@@ -0,0 +1,100 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/amns13/shipboard/internal/conf"
+	"github.com/amns13/shipboard/internal/services"
+)
+
+// retentionLimits is a retention policy in the units of the settings form.
+type retentionLimits struct {
+	MaxAgeDays   int64
+	MaxClips     int
+	MaxMegabytes int64
+}
+
+func newRetentionLimits(policy services.RetentionPolicy) retentionLimits {
+	return retentionLimits{
+		MaxAgeDays:   int64(policy.MaxAge / (24 * time.Hour)),
+		MaxClips:     policy.MaxClips,
+		MaxMegabytes: policy.MaxBytes >> 20,
+	}
+}
+
+// userRetentionPolicy returns the policy applied to the user, the stricter
+// of the global one and their own.
+func userRetentionPolicy(env *conf.Env, userID int32) (services.RetentionPolicy, error) {
+	policy, err := env.Users.GetUserRetentionPolicy(userID)
+	if err != nil {
+		return policy, err
+	}
+	return env.Retention.Stricter(policy), nil
+}
+
+// clipboardTTL returns the ttl to broadcast to the clipboard of the user
+// with, the max age of their clips.
+func clipboardTTL(env *conf.Env, userID int32) (time.Duration, error) {
+	policy, err := userRetentionPolicy(env, userID)
+	return policy.MaxAge, err
+}
+
+// Longer ages would overflow time.Duration
+const maxRetentionDays = 100 * 365
+
+// parseLimit parses an optional positive integer of the form. Returns 0 for
+// an empty value.
+func parseLimit(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	limit, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || limit < 0 {
+		return 0, fmt.Errorf("invalid limit %q", value)
+	}
+	return limit, nil
+}
+
+// ChangeRetention sets the retention policy of the user: max_age_days,
+// max_clips and max_megabytes. Empty fields don't limit, though the global
+// policy still applies.
+func ChangeRetention(env *conf.Env) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		user, err := authenticatedUser(env, req)
+		if err != nil {
+			env.Logger.Printf("Error occurred while fetching user: %v", err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		days, err := parseLimit(req.PostFormValue("max_age_days"))
+		if err != nil || days > maxRetentionDays {
+			http.Error(w, "Max age must be a number of days", http.StatusBadRequest)
+			return
+		}
+		clips, err := parseLimit(req.PostFormValue("max_clips"))
+		if err != nil || clips > 1<<31-1 {
+			http.Error(w, "Max clips must be a number", http.StatusBadRequest)
+			return
+		}
+		megabytes, err := parseLimit(req.PostFormValue("max_megabytes"))
+		if err != nil || megabytes > 1<<40 {
+			http.Error(w, "Max size must be a number of megabytes", http.StatusBadRequest)
+			return
+		}
+		policy := services.RetentionPolicy{
+			MaxAge:   time.Duration(days) * 24 * time.Hour,
+			MaxClips: int(clips),
+			MaxBytes: megabytes << 20,
+		}
+		err = env.Users.SetUserRetentionPolicy(user.Id, policy)
+		if err != nil {
+			env.Logger.Printf("Error occurred while setting retention policy of user %d: %v", user.Id, err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("Retention policy saved."))
+	}
+}
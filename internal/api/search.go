@@ -0,0 +1,150 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/amns13/shipboard/internal/conf"
+	"github.com/amns13/shipboard/internal/middleware"
+	"github.com/amns13/shipboard/internal/model"
+	"github.com/amns13/shipboard/internal/services"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const searchResultsLimit = 50
+
+// Dates of the from and to filters, as sent by <input type="date">
+const searchDateLayout = time.DateOnly
+
+type searchResult struct {
+	Id          int32     `json:"id"`
+	Content     string    `json:"content,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	ContentType string    `json:"content_type"`
+	FileName    *string   `json:"file_name,omitempty"`
+	Size        int64     `json:"size"`
+	Language    *string   `json:"language,omitempty"`
+	Note        *string   `json:"note,omitempty"`
+}
+
+type searchPageData struct {
+	Query   string
+	Clips   []model.Clip
+	Limited bool
+}
+
+func isSearchType(value string) bool {
+	switch value {
+	case "", model.SEARCH_TYPE_TEXT, model.SEARCH_TYPE_FILE, model.SEARCH_TYPE_IMAGE, model.SEARCH_TYPE_CODE:
+		return true
+	}
+	return services.IsLanguage(value)
+}
+
+// parseSearchDate parses a date of the from and to filters, in UTC. Returns
+// nil for an empty value.
+func parseSearchDate(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	date, err := time.Parse(searchDateLayout, value)
+	return &date, err
+}
+
+// SearchClips finds clips of the history by their words. Filters are the
+// from and to dates, both inclusive, the uid of the device a clip was sent
+// to, the type: text, file, image, code or a language, and a tag. Htmx
+// requests get the results as html for the live search of the web UI, others
+// as json.
+func SearchClips(env *conf.Env) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireStore(w, env.Search != nil, "Searches") {
+			return
+		}
+		userID, ok := req.Context().Value(middleware.AuthUserID).(int32)
+		if !ok {
+			env.Logger.Println("Invalid user id", userID)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		query := req.URL.Query()
+		search := model.ClipSearch{
+			Query: query.Get("q"),
+			Type:  query.Get("type"),
+			Tag:   query.Get("tag"),
+		}
+		if !isSearchType(search.Type) {
+			http.Error(w, "Unknown type", http.StatusBadRequest)
+			return
+		}
+		var err error
+		search.From, err = parseSearchDate(query.Get("from"))
+		if err != nil {
+			http.Error(w, "Invalid from date, expected YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		search.To, err = parseSearchDate(query.Get("to"))
+		if err != nil {
+			http.Error(w, "Invalid to date, expected YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		if search.To != nil {
+			// Clips of the to date itself are included
+			to := search.To.AddDate(0, 0, 1)
+			search.To = &to
+		}
+		if value := query.Get("device"); value != "" {
+			uid, err := uuid.Parse(value)
+			if err != nil {
+				http.Error(w, "Invalid device", http.StatusBadRequest)
+				return
+			}
+			device, err := env.Devices.GetUserDevice(userID, uid)
+			if err == pgx.ErrNoRows {
+				http.Error(w, "Device not found", http.StatusBadRequest)
+				return
+			}
+			if err != nil {
+				env.Logger.Printf("Error occurred while fetching device: %v", err)
+				http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+				return
+			}
+			search.DeviceID = &device.Id
+		}
+
+		clips, err := env.Search.SearchClips(userID, search, searchResultsLimit)
+		if err != nil {
+			env.Logger.Printf("Error occurred while searching clips: %v", err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+
+		if req.Header.Get("HX-Request") == "true" {
+			data := searchPageData{
+				Query:   search.Query,
+				Clips:   clips,
+				Limited: len(clips) == searchResultsLimit,
+			}
+			err = env.Templates.ExecuteTemplate(w, "search.html", data)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+		response := []searchResult{}
+		for _, clip := range clips {
+			response = append(response, searchResult{
+				Id:          clip.Id,
+				Content:     clip.Content,
+				CreatedAt:   clip.CreatedAt,
+				ContentType: clip.ContentType,
+				FileName:    clip.FileName,
+				Size:        clip.Size,
+				Language:    clip.Language,
+				Note:        clip.Note,
+			})
+		}
+		writeJSON(env, w, http.StatusOK, response)
+	}
+}
@@ -0,0 +1,148 @@
+package api
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amns13/shipboard/internal/conf"
+	"github.com/amns13/shipboard/internal/model"
+	"github.com/google/uuid"
+)
+
+func searchClips(env *conf.Env, user *model.User, query url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/search/?"+query.Encode(), nil)
+	w := httptest.NewRecorder()
+	SearchClips(env)(w, authenticated(req, user))
+	return w
+}
+
+// searchResults returns the ids of the clips found, in order.
+func searchResults(t *testing.T, env *conf.Env, user *model.User, query url.Values) []int32 {
+	t.Helper()
+	w := searchClips(env, user, query)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d %q", http.StatusOK, w.Code, w.Body)
+	}
+	var results []searchResult
+	if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	ids := []int32{}
+	for _, result := range results {
+		ids = append(ids, result.Id)
+	}
+	return ids
+}
+
+func createTestClips(t *testing.T, env *conf.Env, user *model.User, contents ...string) []int32 {
+	t.Helper()
+	var ids []int32
+	for _, content := range contents {
+		clip, err := env.Clips.CreateClip(user.Id, content, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, clip.Id)
+	}
+	return ids
+}
+
+func TestSearchClipsQuery(t *testing.T) {
+	env, user := newTestEnv(t)
+	ids := createTestClips(t, env, user, "deploy production", "deploy staging", "lunch order")
+	file, err := env.Clips.CreateFileClip(user.Id, "deploy.log", "application/octet-stream", []byte{0x01})
+	if err != nil {
+		t.Fatal(err)
+	}
+	today := time.Now().UTC().Format(searchDateLayout)
+	yesterday := time.Now().UTC().AddDate(0, 0, -1).Format(searchDateLayout)
+
+	tests := []struct {
+		name     string
+		query    url.Values
+		expected []int32
+	}{
+		{"words", url.Values{"q": {"lunch"}}, []int32{ids[2]}},
+		{"excluded words", url.Values{"q": {"deploy -staging"}}, []int32{file.Id, ids[0]}},
+		{"type", url.Values{"q": {"deploy"}, "type": {model.SEARCH_TYPE_FILE}}, []int32{file.Id}},
+		{"to date is included", url.Values{"q": {"lunch"}, "to": {today}}, []int32{ids[2]}},
+		{"from date", url.Values{"q": {"lunch"}, "from": {today}}, []int32{ids[2]}},
+		{"dates before the clips", url.Values{"q": {"lunch"}, "to": {yesterday}}, []int32{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := searchResults(t, env, user, tt.query)
+			if len(results) != len(tt.expected) {
+				t.Fatalf("expected clips %v, got %v", tt.expected, results)
+			}
+			for i := range results {
+				if results[i] != tt.expected[i] {
+					t.Fatalf("expected clips %v, got %v", tt.expected, results)
+				}
+			}
+		})
+	}
+
+	for name, query := range map[string]url.Values{
+		"unknown type":   {"type": {"spreadsheet"}},
+		"invalid from":   {"from": {"01/02/2026"}},
+		"invalid to":     {"to": {"tomorrow"}},
+		"invalid device": {"device": {"laptop"}},
+		"unknown device": {"device": {uuid.NewString()}},
+	} {
+		if w := searchClips(env, user, query); w.Code != http.StatusBadRequest {
+			t.Errorf("expected %d for an %s, got %d", http.StatusBadRequest, name, w.Code)
+		}
+	}
+}
+
+func TestSearchClipsRanking(t *testing.T) {
+	env, user := newTestEnv(t)
+	ids := createTestClips(t, env, user, "deploy deploy deploy", "deploy", "deploy deploy", "deploy")
+
+	// Best matches first, then the newest
+	results := searchResults(t, env, user, url.Values{"q": {"deploy"}})
+	expected := []int32{ids[0], ids[2], ids[3], ids[1]}
+	if len(results) != len(expected) {
+		t.Fatalf("expected clips %v, got %v", expected, results)
+	}
+	for i := range results {
+		if results[i] != expected[i] {
+			t.Fatalf("expected clips %v, got %v", expected, results)
+		}
+	}
+}
+
+func TestSearchClipsLimit(t *testing.T) {
+	env, user := newTestEnv(t)
+	var contents []string
+	for i := range searchResultsLimit + 1 {
+		contents = append(contents, "note "+strconv.Itoa(i))
+	}
+	ids := createTestClips(t, env, user, contents...)
+
+	results := searchResults(t, env, user, url.Values{"q": {"note"}})
+	if len(results) != searchResultsLimit {
+		t.Fatalf("expected %d clips, got %d", searchResultsLimit, len(results))
+	}
+	if results[0] != ids[len(ids)-1] {
+		t.Errorf("expected the newest clip first, got %d", results[0])
+	}
+
+	// The live search tells there are more results
+	env.Templates = template.Must(template.ParseFiles("../../templates/search.html"))
+	req := httptest.NewRequest(http.MethodGet, "/search/?q=note", nil)
+	req.Header.Set("HX-Request", "true")
+	w := httptest.NewRecorder()
+	SearchClips(env)(w, authenticated(req, user))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Only the best matches are shown") {
+		t.Errorf("expected the results to be marked as limited, got %d %q", w.Code, w.Body)
+	}
+}
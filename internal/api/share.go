@@ -0,0 +1,253 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/amns13/shipboard/internal/conf"
+	"github.com/amns13/shipboard/internal/middleware"
+	"github.com/amns13/shipboard/internal/model"
+	"github.com/amns13/shipboard/internal/services"
+	"github.com/jackc/pgx/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultShareExpiry   = 24 * time.Hour
+	maxShareExpiry       = 7 * 24 * time.Hour
+	defaultShareMaxViews = 1
+	maxShareMaxViews     = 1000
+	// Password attempts a client can make on any shares per window, on top
+	// of the wrong passwords each share takes before it is deleted
+	sharePasswordAttempts = 20
+	sharePasswordWindow   = 10 * time.Minute
+)
+
+type sharePageData struct {
+	Token string
+	// Set to ask for confirmation before the clip is shown
+	Confirm       bool
+	NeedsPassword bool
+	Error         string
+	Content       string
+	ViewsLeft     int
+}
+
+// ownClip returns the clip in the {id} path value if it belongs to the
+// authenticated user. Clips of other users are reported as not found.
+func ownClip(env *conf.Env, w http.ResponseWriter, req *http.Request) (*model.Clip, bool) {
+	userID, ok := req.Context().Value(middleware.AuthUserID).(int32)
+	if !ok {
+		env.Logger.Println("Invalid user id", userID)
+		http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+		return nil, false
+	}
+	clipID, err := strconv.ParseInt(req.PathValue("id"), 10, 32)
+	if err != nil {
+		http.Error(w, "Clip not found", http.StatusNotFound)
+		return nil, false
+	}
+	clip, err := env.Clips.GetClipByID(int32(clipID))
+	if err == pgx.ErrNoRows || (err == nil && clip.UserID != userID) {
+		http.Error(w, "Clip not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		env.Logger.Printf("Error occurred while fetching clip %d: %v", clipID, err)
+		http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+		return nil, false
+	}
+	return clip, true
+}
+
+// ShareClip creates a public link to one clip. The link expires after
+// expires_in (a duration like 30m or 24h) or max_views views, whichever comes
+// first, and can be protected with a password.
+func ShareClip(env *conf.Env) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireStore(w, env.Shares != nil, "Share links") {
+			return
+		}
+		clip, ok := ownClip(env, w, req)
+		if !ok {
+			return
+		}
+		if clip.Once {
+			http.Error(w, "Burn after reading clips cannot be shared", http.StatusBadRequest)
+			return
+		}
+		if clip.Sensitive {
+			http.Error(w, "Sensitive clips cannot be shared", http.StatusBadRequest)
+			return
+		}
+
+		expiry := defaultShareExpiry
+		if value := req.PostFormValue("expires_in"); value != "" {
+			parsed, err := time.ParseDuration(value)
+			if err != nil || parsed <= 0 || parsed > maxShareExpiry {
+				http.Error(w, fmt.Sprintf("Expiry must be a duration up to %s", maxShareExpiry), http.StatusBadRequest)
+				return
+			}
+			expiry = parsed
+		}
+		maxViews := defaultShareMaxViews
+		if value := req.PostFormValue("max_views"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 1 || parsed > maxShareMaxViews {
+				http.Error(w, fmt.Sprintf("Max views must be between 1 and %d", maxShareMaxViews), http.StatusBadRequest)
+				return
+			}
+			maxViews = parsed
+		}
+
+		share := services.Share{ClipID: clip.Id, UserID: clip.UserID, ViewsLeft: maxViews}
+		if password := req.PostFormValue("password"); password != "" {
+			passwordHash, err := env.PasswordHasher.Hash(password)
+			if err != nil {
+				env.Logger.Printf("Error occurred while generating password hash: %v", err)
+				http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+				return
+			}
+			share.PasswordHash = passwordHash
+		}
+
+		token, err := env.Shares.Create(share, expiry)
+		if err != nil {
+			env.Logger.Printf("Error occurred while sharing clip %d: %v", clip.Id, err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(fmt.Sprintf("%s/s/%s", env.PublicURL, token)))
+	}
+}
+
+// clientIP returns the address the request came from, without its port.
+// Requests through a proxy all share the address of the proxy.
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+func renderSharePage(env *conf.Env, w http.ResponseWriter, status int, data sharePageData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	err := env.Templates.ExecuteTemplate(w, "share.html", data)
+	if err != nil {
+		env.Logger.Printf("Error occurred while rendering shared clip: %v", err)
+	}
+}
+
+// SharedClip is the public page of a share link. GET only shows a form, so
+// that link previews and scanners opening the link don't use up its views.
+// The clip is shown when the form is submitted with POST, along with the
+// password of protected shares, which counts as a view. Pass download=1 to
+// download the clip as a file, which GET can do too without a password.
+// File clips are always downloaded. Password attempts are limited per client,
+// and a share is deleted after services.SHARE_PASSWORD_ATTEMPTS wrong ones.
+func SharedClip(env *conf.Env) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireStore(w, env.Shares != nil, "Share links") {
+			return
+		}
+		token := req.PathValue("token")
+		share, err := env.Shares.Get(token)
+		if err == redis.Nil {
+			renderSharePage(env, w, http.StatusNotFound, sharePageData{Error: "This link has expired or does not exist."})
+			return
+		}
+		if err != nil {
+			env.Logger.Printf("Error occurred while fetching share: %v", err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+
+		needsPassword := share.PasswordHash != ""
+		download := req.FormValue("download") == "1"
+		if req.Method != http.MethodPost && (needsPassword || !download) {
+			renderSharePage(env, w, http.StatusOK, sharePageData{Token: token, Confirm: true, NeedsPassword: needsPassword, ViewsLeft: share.ViewsLeft})
+			return
+		}
+		if needsPassword {
+			// Checked before the password is hashed, which is slow on purpose
+			allowed, err := env.RateLimits.Allow("share/"+clientIP(req), sharePasswordAttempts, sharePasswordWindow)
+			if err != nil {
+				env.Logger.Printf("Error occurred while counting share password attempt: %v", err)
+				http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+				return
+			}
+			if !allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(int(sharePasswordWindow.Seconds())))
+				renderSharePage(env, w, http.StatusTooManyRequests, sharePageData{Token: token, Confirm: true, NeedsPassword: true, ViewsLeft: share.ViewsLeft, Error: "Too many attempts, try again later"})
+				return
+			}
+			matched, err := env.PasswordHasher.Verify(share.PasswordHash, req.PostFormValue("password"))
+			if err != nil {
+				env.Logger.Printf("Error occurred while verifying share password: %v", err)
+				http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+				return
+			}
+			if !matched {
+				attemptsLeft, err := env.Shares.FailAttempt(token)
+				if err != nil && err != redis.Nil {
+					env.Logger.Printf("Error occurred while counting wrong share password: %v", err)
+					http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+					return
+				}
+				if err == redis.Nil {
+					renderSharePage(env, w, http.StatusNotFound, sharePageData{Error: "This link has expired or does not exist."})
+					return
+				}
+				if attemptsLeft == 0 {
+					renderSharePage(env, w, http.StatusNotFound, sharePageData{Error: "This link has been disabled after too many wrong passwords."})
+					return
+				}
+				renderSharePage(env, w, http.StatusForbidden, sharePageData{Token: token, Confirm: true, NeedsPassword: true, ViewsLeft: share.ViewsLeft, Error: "Wrong password"})
+				return
+			}
+		}
+
+		clip, err := env.Clips.GetClipByID(share.ClipID)
+		if err == pgx.ErrNoRows {
+			renderSharePage(env, w, http.StatusNotFound, sharePageData{Error: "This clip has been deleted."})
+			return
+		}
+		if err != nil {
+			env.Logger.Printf("Error occurred while fetching shared clip %d: %v", share.ClipID, err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+
+		viewsLeft, err := env.Shares.ConsumeView(token)
+		if err == redis.Nil {
+			renderSharePage(env, w, http.StatusNotFound, sharePageData{Error: "This link has expired or does not exist."})
+			return
+		}
+		if err != nil {
+			env.Logger.Printf("Error occurred while counting share view: %v", err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		// Files can't be shown on the page, they are always downloaded
+		if download || clip.IsFile() {
+			writeClip(env, w, req, clip)
+			return
+		}
+		content, err := env.Clips.GetClipData(clip)
+		if err != nil {
+			env.Logger.Printf("Error occurred while fetching data of shared clip %d: %v", clip.Id, err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		renderSharePage(env, w, http.StatusOK, sharePageData{Token: token, Content: string(content), ViewsLeft: viewsLeft})
+	}
+}
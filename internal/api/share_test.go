@@ -0,0 +1,169 @@
+package api
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/amns13/shipboard/internal/conf"
+	"github.com/amns13/shipboard/internal/model"
+	"github.com/amns13/shipboard/internal/services"
+)
+
+// newShareTestEnv returns a test env that renders the share page, and a clip
+// of the user to share.
+func newShareTestEnv(t *testing.T) (*conf.Env, *model.User, *model.Clip) {
+	t.Helper()
+	env, user := newTestEnv(t)
+	env.Templates = template.Must(template.ParseFiles("../../templates/share.html"))
+	// Cheap hashes keep the many password attempts fast
+	hasher, err := services.NewPasswordHasher(services.HASH_ALGORITHM_ARGON2ID, 0, 1, 64, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.PasswordHasher = hasher
+	clip, err := env.Clips.CreateClip(user.Id, "abcd", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return env, user, clip
+}
+
+// shareClip shares the clip, returning the token of the link.
+func shareClip(t *testing.T, env *conf.Env, user *model.User, clip *model.Clip, form url.Values) string {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/clip/", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetPathValue("id", strconv.Itoa(int(clip.Id)))
+	w := httptest.NewRecorder()
+	ShareClip(env)(w, authenticated(req, user))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected %d, got %d %q", http.StatusCreated, w.Code, w.Body)
+	}
+	return path.Base(w.Body.String())
+}
+
+// viewShare opens the share link, from the given client address. A nil form
+// only shows the confirmation page.
+func viewShare(env *conf.Env, token string, remoteAddr string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/s/"+token, nil)
+	if form != nil {
+		req = httptest.NewRequest(http.MethodPost, "/s/"+token, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	req.RemoteAddr = remoteAddr
+	req.SetPathValue("token", token)
+	w := httptest.NewRecorder()
+	SharedClip(env)(w, req)
+	return w
+}
+
+func TestSharedClip(t *testing.T) {
+	env, user, clip := newShareTestEnv(t)
+	token := shareClip(t, env, user, clip, url.Values{"max_views": {"2"}})
+
+	// Link previews must not use up views
+	for range 3 {
+		if w := viewShare(env, token, "192.0.2.1:1234", nil); w.Code != http.StatusOK || strings.Contains(w.Body.String(), "abcd") {
+			t.Fatalf("expected the confirmation page, got %d %q", w.Code, w.Body)
+		}
+	}
+	for range 2 {
+		if w := viewShare(env, token, "192.0.2.1:1234", url.Values{}); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "abcd") {
+			t.Fatalf("expected the clip, got %d %q", w.Code, w.Body)
+		}
+	}
+	if w := viewShare(env, token, "192.0.2.1:1234", url.Values{}); w.Code != http.StatusNotFound {
+		t.Errorf("expected %d once the views are used up, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestSharedClipExpired(t *testing.T) {
+	env, user, clip := newShareTestEnv(t)
+	token := shareClip(t, env, user, clip, url.Values{"expires_in": {"1ns"}})
+
+	if w := viewShare(env, token, "192.0.2.1:1234", url.Values{}); w.Code != http.StatusNotFound {
+		t.Errorf("expected %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestSharedClipConcurrentViews(t *testing.T) {
+	env, user, clip := newShareTestEnv(t)
+	token := shareClip(t, env, user, clip, url.Values{"max_views": {"5"}})
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	shown := 0
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if w := viewShare(env, token, "192.0.2.1:1234", url.Values{}); w.Code == http.StatusOK {
+				mu.Lock()
+				shown++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if shown != 5 {
+		t.Errorf("expected the clip to be shown 5 times, got %d", shown)
+	}
+}
+
+func TestSharedClipPassword(t *testing.T) {
+	env, user, clip := newShareTestEnv(t)
+	token := shareClip(t, env, user, clip, url.Values{"password": {"hunter2"}, "max_views": {"5"}})
+
+	if w := viewShare(env, token, "192.0.2.1:1234", url.Values{"password": {"wrong"}}); w.Code != http.StatusForbidden {
+		t.Errorf("expected %d for a wrong password, got %d", http.StatusForbidden, w.Code)
+	}
+	if w := viewShare(env, token, "192.0.2.1:1234", url.Values{"download": {"1"}}); w.Code != http.StatusForbidden {
+		t.Errorf("expected %d for a download without the password, got %d", http.StatusForbidden, w.Code)
+	}
+	w := viewShare(env, token, "192.0.2.1:1234", url.Values{"password": {"hunter2"}})
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "abcd") {
+		t.Errorf("expected the clip with the right password, got %d %q", w.Code, w.Body)
+	}
+}
+
+func TestSharedClipPasswordAttempts(t *testing.T) {
+	env, user, clip := newShareTestEnv(t)
+	token := shareClip(t, env, user, clip, url.Values{"password": {"hunter2"}})
+
+	// Each attempt comes from another client, only the share counts them
+	for i := range services.SHARE_PASSWORD_ATTEMPTS {
+		w := viewShare(env, token, "192.0.2."+strconv.Itoa(i)+":1234", url.Values{"password": {"wrong"}})
+		if i < services.SHARE_PASSWORD_ATTEMPTS-1 && w.Code != http.StatusForbidden {
+			t.Fatalf("expected %d for wrong password %d, got %d", http.StatusForbidden, i+1, w.Code)
+		}
+	}
+	if w := viewShare(env, token, "192.0.2.100:1234", url.Values{"password": {"hunter2"}}); w.Code != http.StatusNotFound {
+		t.Errorf("expected the share to be deleted after too many wrong passwords, got %d", w.Code)
+	}
+
+	// Attempts of a client are counted on all shares
+	var tokens []string
+	for range 3 {
+		tokens = append(tokens, shareClip(t, env, user, clip, url.Values{"password": {"hunter2"}}))
+	}
+	for i := range sharePasswordAttempts {
+		w := viewShare(env, tokens[i%len(tokens)], "198.51.100.1:1234", url.Values{"password": {"wrong"}})
+		if w.Code != http.StatusForbidden {
+			t.Fatalf("expected %d for wrong password %d, got %d", http.StatusForbidden, i+1, w.Code)
+		}
+	}
+	w := viewShare(env, tokens[0], "198.51.100.1:1234", url.Values{"password": {"hunter2"}})
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("expected %d with Retry-After, got %d", http.StatusTooManyRequests, w.Code)
+	}
+	if w = viewShare(env, tokens[0], "198.51.100.2:1234", url.Values{"password": {"hunter2"}}); w.Code != http.StatusOK {
+		t.Errorf("expected other clients to get through, got %d", w.Code)
+	}
+}
@@ -0,0 +1,239 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/amns13/shipboard/internal/conf"
+	"github.com/amns13/shipboard/internal/middleware"
+	"github.com/amns13/shipboard/internal/model"
+	"github.com/amns13/shipboard/internal/services"
+	"github.com/jackc/pgx/v5"
+)
+
+// Slot names are used in urls and typed in shells, e.g. deploy-cmd
+var slotNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,62}$`)
+
+type slotResponse struct {
+	Name        string    `json:"name"`
+	ClipID      int32     `json:"clip_id"`
+	ContentType string    `json:"content_type"`
+	FileName    *string   `json:"file_name,omitempty"`
+	Size        int64     `json:"size"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+func newSlotResponse(slot *model.Slot) slotResponse {
+	return slotResponse{
+		Name:        slot.Name,
+		ClipID:      slot.Id,
+		ContentType: slot.ContentType,
+		FileName:    slot.FileName,
+		Size:        slot.Size,
+		UpdatedAt:   slot.UpdatedAt,
+	}
+}
+
+// ownSlot returns the slot in the {name} path value if the authenticated user
+// has one with that name.
+func ownSlot(env *conf.Env, w http.ResponseWriter, req *http.Request) (*model.Slot, bool) {
+	userID, ok := req.Context().Value(middleware.AuthUserID).(int32)
+	if !ok {
+		env.Logger.Println("Invalid user id", userID)
+		http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+		return nil, false
+	}
+	slot, err := env.Slots.GetUserSlot(userID, req.PathValue("name"))
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Slot not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		env.Logger.Printf("Error occurred while fetching slot: %v", err)
+		http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+		return nil, false
+	}
+	return slot, true
+}
+
+func Slots(env *conf.Env) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireStore(w, env.Slots != nil, "Slots") {
+			return
+		}
+		userID, ok := req.Context().Value(middleware.AuthUserID).(int32)
+		if !ok {
+			env.Logger.Println("Invalid user id", userID)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		slots, err := env.Slots.GetUserSlots(userID)
+		if err != nil {
+			env.Logger.Printf("Error occurred while fetching slots: %v", err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		response := []slotResponse{}
+		for i := range slots {
+			response = append(response, newSlotResponse(&slots[i]))
+		}
+		writeJSON(env, w, http.StatusOK, response)
+	}
+}
+
+// Slot returns the clip pinned in a slot, as plain text or as a download if
+// it is a file.
+func Slot(env *conf.Env) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireStore(w, env.Slots != nil, "Slots") {
+			return
+		}
+		slot, ok := ownSlot(env, w, req)
+		if !ok {
+			return
+		}
+		if slot.IsFile() {
+			writeClip(env, w, req, &slot.Clip)
+			return
+		}
+		writeTextClip(env, w, req, &slot.Clip)
+	}
+}
+
+// slotClip creates the clip to pin from the request, like Broadcast does.
+// Pass clip_id instead to pin a clip of the history. Writes the error and
+// returns false if there is no valid clip in the request.
+func slotClip(env *conf.Env, w http.ResponseWriter, req *http.Request, userID int32, upload *fileUpload) (*model.Clip, bool) {
+	if value := req.PostFormValue("clip_id"); value != "" {
+		clipID, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			http.Error(w, "Clip not found", http.StatusNotFound)
+			return nil, false
+		}
+		clip, err := env.Clips.GetClipByID(int32(clipID))
+		if err == pgx.ErrNoRows || (err == nil && clip.UserID != userID) {
+			http.Error(w, "Clip not found", http.StatusNotFound)
+			return nil, false
+		}
+		if err != nil {
+			env.Logger.Printf("Error occurred while fetching clip %d: %v", clipID, err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return nil, false
+		}
+		// Their content is never saved, there is nothing to pin
+		if clip.Once || clip.Sensitive {
+			http.Error(w, "Burn after reading and sensitive clips cannot be pinned", http.StatusBadRequest)
+			return nil, false
+		}
+		return clip, true
+	}
+
+	content := req.PostFormValue("content")
+	// Raw text bodies without a file name are text, e.g. from
+	// curl -X PUT --data-binary @deploy.sh
+	if upload != nil && upload.Name == defaultFileName && mediaTypeOf(upload.ContentType) == "text/plain" && upload.Stored == nil && utf8.Valid(upload.Data) {
+		content, upload = string(upload.Data), nil
+	}
+	if upload == nil && content == "" {
+		http.Error(w, "Slots need a content, a file or a clip_id", http.StatusBadRequest)
+		return nil, false
+	}
+	// Pinned clips are kept in the history, which must not happen to secrets
+	sensitive := clipSensitive(req.PostFormValue("sensitive"), content)
+	if upload != nil {
+		sensitive = upload.sensitive(req.PostFormValue("sensitive"))
+	}
+	if sensitive {
+		http.Error(w, "Clip looks like a secret and cannot be pinned, set sensitive to off to pin it anyway", http.StatusBadRequest)
+		return nil, false
+	}
+	if int64(len(content)) > env.MaxClipSize {
+		http.Error(w, fmt.Sprintf("Clips can be at most %d bytes", env.MaxClipSize), http.StatusRequestEntityTooLarge)
+		return nil, false
+	}
+	size := int64(len(content))
+	if upload != nil {
+		size = upload.Size()
+	}
+	if !checkQuota(env, w, userID, size) {
+		return nil, false
+	}
+	var clip *model.Clip
+	var err error
+	if upload != nil {
+		clip, err = createFileClip(env, userID, upload)
+	} else {
+		clip, err = env.Clips.CreateClip(userID, content, services.DetectLanguage(content))
+	}
+	if err != nil {
+		env.Logger.Printf("Error occurred while creating clip of slot: %v", err)
+		http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+		return nil, false
+	}
+	return clip, true
+}
+
+// SetSlot pins a clip in the {name} slot, replacing the clip pinned there
+// before. The web UI posts the name as a form field instead.
+func SetSlot(env *conf.Env) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireStore(w, env.Slots != nil, "Slots") {
+			return
+		}
+		userID, ok := req.Context().Value(middleware.AuthUserID).(int32)
+		if !ok {
+			env.Logger.Println("Invalid user id", userID)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		upload, err := readUpload(env, w, req)
+		if err != nil {
+			writeUploadError(env, w, err)
+			return
+		}
+		name := req.PathValue("name")
+		if name == "" {
+			name = req.PostFormValue("name")
+		}
+		if !slotNamePattern.MatchString(name) {
+			http.Error(w, "Slot names must be 1 to 63 lowercase letters, digits, dots, dashes or underscores", http.StatusBadRequest)
+			return
+		}
+		clip, ok := slotClip(env, w, req, userID, upload)
+		if !ok {
+			return
+		}
+
+		slot, err := env.Slots.SetSlot(userID, name, clip.Id)
+		if err != nil {
+			env.Logger.Printf("Error occurred while setting slot: %v", err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		writeJSON(env, w, http.StatusOK, newSlotResponse(slot))
+	}
+}
+
+// DeleteSlot unpins the clip of a slot. The clip stays in the history.
+func DeleteSlot(env *conf.Env) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireStore(w, env.Slots != nil, "Slots") {
+			return
+		}
+		slot, ok := ownSlot(env, w, req)
+		if !ok {
+			return
+		}
+		err := env.Slots.DeleteSlot(slot.UserID, slot.Name)
+		if err != nil && err != pgx.ErrNoRows {
+			env.Logger.Printf("Error occurred while deleting slot: %v", err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
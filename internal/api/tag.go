@@ -0,0 +1,175 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/amns13/shipboard/internal/conf"
+	"github.com/amns13/shipboard/internal/middleware"
+	"github.com/amns13/shipboard/internal/model"
+	"github.com/jackc/pgx/v5"
+)
+
+// Tags are lowercased, e.g. Work becomes work
+var tagNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,62}$`)
+
+const maxClipTags = 20
+
+// In characters
+const maxClipNoteLength = 280
+
+type tagResponse struct {
+	Name  string `json:"name"`
+	Clips int64  `json:"clips"`
+}
+
+// clipMetadata is the tags and note sent along with a clip. Only the fields
+// present in the form are changed.
+type clipMetadata struct {
+	Tags    []string
+	HasTags bool
+	Note    *string
+	HasNote bool
+}
+
+// readClipMetadata reads the tag and note fields of the form. Tags are given
+// as repeated tag fields, comma separated, or both. Writes the error and
+// returns false if they are invalid.
+func readClipMetadata(w http.ResponseWriter, req *http.Request) (*clipMetadata, bool) {
+	metadata := &clipMetadata{
+		HasTags: req.PostForm.Has("tag"),
+		HasNote: req.PostForm.Has("note"),
+	}
+	seen := make(map[string]bool)
+	for _, value := range req.PostForm["tag"] {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" || seen[name] {
+				continue
+			}
+			if !tagNamePattern.MatchString(name) {
+				http.Error(w, fmt.Sprintf("Invalid tag %q, tags must be 1 to 63 letters, digits, dots, dashes or underscores", name), http.StatusBadRequest)
+				return nil, false
+			}
+			seen[name] = true
+			metadata.Tags = append(metadata.Tags, name)
+		}
+	}
+	if len(metadata.Tags) > maxClipTags {
+		http.Error(w, fmt.Sprintf("Clips can have at most %d tags", maxClipTags), http.StatusBadRequest)
+		return nil, false
+	}
+	if note := strings.TrimSpace(req.PostFormValue("note")); note != "" {
+		if utf8.RuneCountInString(note) > maxClipNoteLength {
+			http.Error(w, fmt.Sprintf("Notes can be at most %d characters", maxClipNoteLength), http.StatusBadRequest)
+			return nil, false
+		}
+		metadata.Note = &note
+	}
+	return metadata, true
+}
+
+// save sets the tags and note of the clip, if they were sent.
+func (metadata *clipMetadata) save(env *conf.Env, clip *model.Clip) error {
+	if metadata.HasTags {
+		err := env.Tags.SetClipTags(clip.UserID, clip.Id, metadata.Tags)
+		if err != nil {
+			return err
+		}
+	}
+	if metadata.HasNote {
+		return env.Tags.SetClipNote(clip.Id, metadata.Note)
+	}
+	return nil
+}
+
+// TagClip replaces the tags and note of a clip. An empty tag field removes
+// all tags, an empty note field the note.
+func TagClip(env *conf.Env) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireStore(w, env.Tags != nil, "Tags") {
+			return
+		}
+		clip, ok := ownClip(env, w, req)
+		if !ok {
+			return
+		}
+		err := req.ParseForm()
+		if err != nil {
+			http.Error(w, "Invalid form", http.StatusBadRequest)
+			return
+		}
+		metadata, ok := readClipMetadata(w, req)
+		if !ok {
+			return
+		}
+		err = metadata.save(env, clip)
+		if err != nil {
+			env.Logger.Printf("Error occurred while tagging clip %d: %v", clip.Id, err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Tags lists the tags of the user with the number of clips of each.
+func Tags(env *conf.Env) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireStore(w, env.Tags != nil, "Tags") {
+			return
+		}
+		userID, ok := req.Context().Value(middleware.AuthUserID).(int32)
+		if !ok {
+			env.Logger.Println("Invalid user id", userID)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		tags, err := env.Tags.GetUserTags(userID)
+		if err != nil {
+			env.Logger.Printf("Error occurred while fetching tags: %v", err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		response := []tagResponse{}
+		for _, tag := range tags {
+			response = append(response, tagResponse{Name: tag.Name, Clips: tag.Clips})
+		}
+		writeJSON(env, w, http.StatusOK, response)
+	}
+}
+
+// RenameTag renames the {name} tag on all of its clips to the name field.
+// Renaming to an existing tag merges both.
+func RenameTag(env *conf.Env) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireStore(w, env.Tags != nil, "Tags") {
+			return
+		}
+		userID, ok := req.Context().Value(middleware.AuthUserID).(int32)
+		if !ok {
+			env.Logger.Println("Invalid user id", userID)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		newName := strings.ToLower(strings.TrimSpace(req.PostFormValue("name")))
+		if !tagNamePattern.MatchString(newName) {
+			http.Error(w, "Tags must be 1 to 63 letters, digits, dots, dashes or underscores", http.StatusBadRequest)
+			return
+		}
+		err := env.Tags.RenameTag(userID, req.PathValue("name"), newName)
+		if err == pgx.ErrNoRows {
+			http.Error(w, "Tag not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			env.Logger.Printf("Error occurred while renaming tag: %v", err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,199 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/amns13/shipboard/internal/conf"
+	"github.com/amns13/shipboard/internal/model"
+)
+
+func tagClip(env *conf.Env, user *model.User, clip *model.Clip, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/clip/tags", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetPathValue("id", strconv.Itoa(int(clip.Id)))
+	w := httptest.NewRecorder()
+	TagClip(env)(w, authenticated(req, user))
+	return w
+}
+
+func userTags(t *testing.T, env *conf.Env, user *model.User) []tagResponse {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/tags/", nil)
+	w := httptest.NewRecorder()
+	Tags(env)(w, authenticated(req, user))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d %q", http.StatusOK, w.Code, w.Body)
+	}
+	var tags []tagResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &tags); err != nil {
+		t.Fatal(err)
+	}
+	return tags
+}
+
+func renameTag(env *conf.Env, user *model.User, name string, newName string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/tags/", strings.NewReader(url.Values{"name": {newName}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetPathValue("name", name)
+	w := httptest.NewRecorder()
+	RenameTag(env)(w, authenticated(req, user))
+	return w
+}
+
+func clipTags(t *testing.T, env *conf.Env, clip *model.Clip) []string {
+	t.Helper()
+	tags, err := env.Tags.GetClipTags([]int32{clip.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tags[clip.Id]
+}
+
+func TestTagClip(t *testing.T) {
+	env, user := newTestEnv(t)
+	clip, err := env.Clips.CreateClip(user.Id, "abcd", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := tagClip(env, user, clip, url.Values{"tag": {"Work, urgent", "work"}, "note": {" for monday "}})
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected %d, got %d %q", http.StatusNoContent, w.Code, w.Body)
+	}
+	if tags := clipTags(t, env, clip); !slices.Equal(tags, []string{"urgent", "work"}) {
+		t.Errorf("expected the tags lowercased once each, got %v", tags)
+	}
+	if clip, _ = env.Clips.GetClipByID(clip.Id); clip.Note == nil || *clip.Note != "for monday" {
+		t.Errorf("expected the note trimmed, got %v", clip.Note)
+	}
+
+	// Only the fields sent are changed
+	if w = tagClip(env, user, clip, url.Values{"tag": {"work"}}); w.Code != http.StatusNoContent {
+		t.Fatalf("expected %d, got %d %q", http.StatusNoContent, w.Code, w.Body)
+	}
+	if clip, _ = env.Clips.GetClipByID(clip.Id); clip.Note == nil || !slices.Equal(clipTags(t, env, clip), []string{"work"}) {
+		t.Errorf("expected the urgent tag removed and the note kept, got %v and %v", clipTags(t, env, clip), clip.Note)
+	}
+
+	if w = tagClip(env, user, clip, url.Values{"tag": {""}, "note": {""}}); w.Code != http.StatusNoContent {
+		t.Fatalf("expected %d, got %d %q", http.StatusNoContent, w.Code, w.Body)
+	}
+	if clip, _ = env.Clips.GetClipByID(clip.Id); clip.Note != nil || len(clipTags(t, env, clip)) != 0 {
+		t.Errorf("expected the tags and note removed, got %v and %v", clipTags(t, env, clip), clip.Note)
+	}
+}
+
+func TestTagClipInvalid(t *testing.T) {
+	env, user := newTestEnv(t)
+	clip, err := env.Clips.CreateClip(user.Id, "abcd", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tooMany []string
+	for i := range maxClipTags + 1 {
+		tooMany = append(tooMany, "tag"+strconv.Itoa(i))
+	}
+
+	for name, form := range map[string]url.Values{
+		"invalid tag":    {"tag": {"no spaces"}},
+		"too many tags":  {"tag": {strings.Join(tooMany, ",")}},
+		"note too long":  {"note": {strings.Repeat("é", maxClipNoteLength+1)}},
+		"tag too long":   {"tag": {strings.Repeat("a", 64)}},
+		"tag of a slash": {"tag": {"a/b"}},
+	} {
+		if w := tagClip(env, user, clip, form); w.Code != http.StatusBadRequest {
+			t.Errorf("expected %d for a %s, got %d", http.StatusBadRequest, name, w.Code)
+		}
+	}
+
+	other, err := env.Users.CreateUser(model.UserCreator{Name: "Other", Email: "other@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := tagClip(env, other, clip, url.Values{"tag": {"work"}}); w.Code != http.StatusNotFound {
+		t.Errorf("expected %d for the clip of another user, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+// Without a shipctl client, tags are set at copy time by sending them to
+// POST /clip/, see the README.
+func TestBroadcastTags(t *testing.T) {
+	env, user := newTestEnv(t)
+
+	res := broadcast(env, user, url.Values{"content": {"make deploy"}, "tag": {"deploy,prod"}, "note": {"friday"}})
+	if res.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected %d, got %d", http.StatusNoContent, res.StatusCode)
+	}
+	clips, err := env.Clips.GetUserClips(user.Id, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clip := &clips[0]
+	if tags := clipTags(t, env, clip); !slices.Equal(tags, []string{"deploy", "prod"}) || clip.Note == nil || *clip.Note != "friday" {
+		t.Errorf("expected the tags and note of the broadcast, got %v and %v", tags, clip.Note)
+	}
+
+	// A bumped duplicate keeps its tags and note unless new ones are sent
+	if res = broadcast(env, user, url.Values{"content": {"make deploy"}, "tag": {""}, "note": {""}}); res.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected %d, got %d", http.StatusNoContent, res.StatusCode)
+	}
+	if clip, _ = env.Clips.GetClipByID(clip.Id); clip.Note == nil || len(clipTags(t, env, clip)) != 2 {
+		t.Errorf("expected the tags and note kept, got %v and %v", clipTags(t, env, clip), clip.Note)
+	}
+	if res = broadcast(env, user, url.Values{"content": {"make deploy"}, "tag": {"staging"}}); res.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected %d, got %d", http.StatusNoContent, res.StatusCode)
+	}
+	if tags := clipTags(t, env, clip); !slices.Equal(tags, []string{"staging"}) {
+		t.Errorf("expected the tags replaced, got %v", tags)
+	}
+
+	if res = broadcast(env, user, url.Values{"content": {"abcd"}, "tag": {"no spaces"}}); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected %d for an invalid tag, got %d", http.StatusBadRequest, res.StatusCode)
+	}
+}
+
+func TestTagFilter(t *testing.T) {
+	env, user := newTestEnv(t)
+	ids := createTestClips(t, env, user, "deploy production", "deploy staging", "lunch order")
+	for i, tags := range [][]string{{"work", "prod"}, {"work"}, {"food"}} {
+		if err := env.Tags.SetClipTags(user.Id, ids[i], tags); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tags := userTags(t, env, user)
+	expected := []tagResponse{{"food", 1}, {"prod", 1}, {"work", 2}}
+	if !slices.Equal(tags, expected) {
+		t.Errorf("expected tags %v, got %v", expected, tags)
+	}
+
+	clips, err := env.Tags.GetUserClipsByTag(user.Id, "work", clipHistoryLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clips) != 2 || clips[0].Id != ids[1] || clips[1].Id != ids[0] {
+		t.Errorf("expected the work clips newest first, got %+v", clips)
+	}
+	if results := searchResults(t, env, user, url.Values{"q": {"deploy"}, "tag": {"prod"}}); !slices.Equal(results, []int32{ids[0]}) {
+		t.Errorf("expected the prod clip, got %v", results)
+	}
+
+	// Renaming to an existing tag merges both
+	if w := renameTag(env, user, "prod", "Work"); w.Code != http.StatusNoContent {
+		t.Fatalf("expected %d, got %d %q", http.StatusNoContent, w.Code, w.Body)
+	}
+	expected = []tagResponse{{"food", 1}, {"work", 2}}
+	if tags = userTags(t, env, user); !slices.Equal(tags, expected) {
+		t.Errorf("expected tags %v, got %v", expected, tags)
+	}
+	if w := renameTag(env, user, "prod", "work"); w.Code != http.StatusNotFound {
+		t.Errorf("expected %d for an unknown tag, got %d", http.StatusNotFound, w.Code)
+	}
+}
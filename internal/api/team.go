@@ -0,0 +1,425 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strconv"
+
+	"github.com/amns13/shipboard/internal/conf"
+	"github.com/amns13/shipboard/internal/middleware"
+	"github.com/amns13/shipboard/internal/model"
+	"github.com/amns13/shipboard/internal/services"
+	"github.com/jackc/pgx/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+// teamClipboardOwner returns the owner of the clipboard of the channel in
+// the clipboard store. Users are keyed by their bare uid, so the prefix keeps
+// both apart.
+func teamClipboardOwner(channel *model.TeamChannel) string {
+	return "team/" + channel.Uid.String()
+}
+
+// teamClipboard returns the content of the clipboard of the channel. Returns
+// redis.Nil if nothing has been broadcasted to it.
+func teamClipboard(env *conf.Env, channel *model.TeamChannel) (string, error) {
+	clip, err := env.Clipboard.Paste(teamClipboardOwner(channel))
+	if err != nil {
+		return "", err
+	}
+	content, err := services.Decompress([]byte(clip.Content), clip.Codec)
+	return string(content), err
+}
+
+type teamsPageData struct {
+	Teams     []model.TeamMembership
+	CSRFToken string
+}
+
+type teamChannelData struct {
+	Name    string
+	Content string
+}
+
+type teamPageData struct {
+	Team      *model.TeamMembership
+	Members   []model.TeamMember
+	Channels  []teamChannelData
+	CanWrite  bool
+	CanManage bool
+	UserID    int32
+	CSRFToken string
+}
+
+// teamMembership returns the team in the {id} path value as seen by the
+// authenticated user. Teams the user is not a member of are reported as not
+// found, so that their existence is not leaked.
+func teamMembership(env *conf.Env, w http.ResponseWriter, req *http.Request) (*model.TeamMembership, bool) {
+	userID, ok := req.Context().Value(middleware.AuthUserID).(int32)
+	if !ok {
+		env.Logger.Println("Invalid user id", userID)
+		http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+		return nil, false
+	}
+	teamID, err := strconv.ParseInt(req.PathValue("id"), 10, 32)
+	if err != nil {
+		http.Error(w, "Team not found", http.StatusNotFound)
+		return nil, false
+	}
+	membership, err := env.Teams.GetUserTeam(userID, int32(teamID))
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Team not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		env.Logger.Printf("Error occurred while fetching team %d: %v", teamID, err)
+		http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+		return nil, false
+	}
+	return membership, true
+}
+
+// teamChannel returns the channel in the {channel} path value.
+func teamChannel(env *conf.Env, w http.ResponseWriter, req *http.Request, team *model.TeamMembership) (*model.TeamChannel, bool) {
+	channel, err := env.Teams.GetTeamChannel(team.Id, req.PathValue("channel"))
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		env.Logger.Printf("Error occurred while fetching channel of team %d: %v", team.Id, err)
+		http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+		return nil, false
+	}
+	return channel, true
+}
+
+func Teams(env *conf.Env) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireStore(w, env.Teams != nil, "Teams") {
+			return
+		}
+		userID, ok := req.Context().Value(middleware.AuthUserID).(int32)
+		if !ok {
+			env.Logger.Println("Invalid user id", userID)
+			http.Redirect(w, req, "/login/", http.StatusTemporaryRedirect)
+			return
+		}
+		teams, err := env.Teams.GetUserTeams(userID)
+		if err != nil {
+			env.Logger.Printf("Error occurred while fetching teams of user %d: %v", userID, err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		csrfToken, _ := req.Context().Value(middleware.CSRFToken).(string)
+		err = env.Templates.ExecuteTemplate(w, "teams.html", teamsPageData{Teams: teams, CSRFToken: csrfToken})
+		if err != nil {
+			env.Logger.Printf("Error occurred while rendering teams: %v", err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func CreateTeam(env *conf.Env) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireStore(w, env.Teams != nil, "Teams") {
+			return
+		}
+		userID, ok := req.Context().Value(middleware.AuthUserID).(int32)
+		if !ok {
+			env.Logger.Println("Invalid user id", userID)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		name := req.PostFormValue("name")
+		if name == "" || len(name) > 127 {
+			http.Error(w, "Team name must be between 1 and 127 characters", http.StatusBadRequest)
+			return
+		}
+		team, err := env.Teams.CreateTeam(name, userID)
+		if err != nil {
+			env.Logger.Printf("Error occurred while creating team: %v", err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("HX-Redirect", fmt.Sprintf("/teams/%d/", team.Id))
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+func TeamDetail(env *conf.Env) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireStore(w, env.Teams != nil, "Teams") {
+			return
+		}
+		team, ok := teamMembership(env, w, req)
+		if !ok {
+			return
+		}
+		members, err := env.Teams.GetTeamMembers(team.Id)
+		if err != nil {
+			env.Logger.Printf("Error occurred while fetching members of team %d: %v", team.Id, err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		channels, err := env.Teams.GetTeamChannels(team.Id)
+		if err != nil {
+			env.Logger.Printf("Error occurred while fetching channels of team %d: %v", team.Id, err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+
+		userID, _ := req.Context().Value(middleware.AuthUserID).(int32)
+		csrfToken, _ := req.Context().Value(middleware.CSRFToken).(string)
+		data := teamPageData{
+			Team:      team,
+			Members:   members,
+			CanWrite:  model.CanWriteTeam(team.Role),
+			CanManage: model.CanManageTeam(team.Role),
+			UserID:    userID,
+			CSRFToken: csrfToken,
+		}
+		for i := range channels {
+			content, err := teamClipboard(env, &channels[i])
+			if err != nil && err != redis.Nil {
+				env.Logger.Printf("Error occurred while fetching team clipboard: %v", err)
+				http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+				return
+			}
+			data.Channels = append(data.Channels, teamChannelData{Name: channels[i].Name, Content: content})
+		}
+
+		err = env.Templates.ExecuteTemplate(w, "team.html", data)
+		if err != nil {
+			env.Logger.Printf("Error occurred while rendering team: %v", err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func InviteTeamMember(env *conf.Env) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireStore(w, env.Teams != nil, "Teams") {
+			return
+		}
+		team, ok := teamMembership(env, w, req)
+		if !ok {
+			return
+		}
+		if !model.CanManageTeam(team.Role) {
+			http.Error(w, "Only team owners can invite members", http.StatusForbidden)
+			return
+		}
+
+		role := req.PostFormValue("role")
+		if role != model.TEAM_ROLE_OWNER && role != model.TEAM_ROLE_WRITER && role != model.TEAM_ROLE_READER {
+			http.Error(w, "Invalid role", http.StatusBadRequest)
+			return
+		}
+		email, err := mail.ParseAddress(req.PostFormValue("email"))
+		if err != nil {
+			http.Error(w, "Invalid email address", http.StatusBadRequest)
+			return
+		}
+		user, err := env.Users.GetUserByEmail(email.Address)
+		if err == pgx.ErrNoRows {
+			http.Error(w, "No user is registered with this email", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			env.Logger.Printf("Error occurred while fetching user: %v", err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+
+		// Inviting a member again must not change their role, which could
+		// leave the team without an owner
+		added, err := env.Teams.AddTeamMember(team.Id, user.Id, role)
+		if err != nil {
+			env.Logger.Printf("Error occurred while adding member to team %d: %v", team.Id, err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		if !added {
+			http.Error(w, "This user is already a member of the team", http.StatusConflict)
+			return
+		}
+		w.Header().Set("HX-Refresh", "true")
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+// RemoveTeamMember lets owners remove anyone but themselves, and any member
+// leave the team.
+func RemoveTeamMember(env *conf.Env) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireStore(w, env.Teams != nil, "Teams") {
+			return
+		}
+		team, ok := teamMembership(env, w, req)
+		if !ok {
+			return
+		}
+		memberID, err := strconv.ParseInt(req.PathValue("user_id"), 10, 32)
+		if err != nil {
+			http.Error(w, "Invalid user id", http.StatusBadRequest)
+			return
+		}
+		userID, _ := req.Context().Value(middleware.AuthUserID).(int32)
+		isSelf := int32(memberID) == userID
+		if !isSelf && !model.CanManageTeam(team.Role) {
+			http.Error(w, "Only team owners can remove members", http.StatusForbidden)
+			return
+		}
+		// Keeps at least one owner in the team
+		if isSelf && model.CanManageTeam(team.Role) {
+			http.Error(w, "Owners cannot leave the team. Make someone else an owner first.", http.StatusBadRequest)
+			return
+		}
+
+		err = env.Teams.RemoveTeamMember(team.Id, int32(memberID))
+		if err != nil {
+			env.Logger.Printf("Error occurred while removing member from team %d: %v", team.Id, err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		if isSelf {
+			w.Header().Set("HX-Redirect", "/teams/")
+		} else {
+			w.Header().Set("HX-Refresh", "true")
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func CreateTeamChannel(env *conf.Env) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireStore(w, env.Teams != nil, "Teams") {
+			return
+		}
+		team, ok := teamMembership(env, w, req)
+		if !ok {
+			return
+		}
+		if !model.CanManageTeam(team.Role) {
+			http.Error(w, "Only team owners can create channels", http.StatusForbidden)
+			return
+		}
+		name := req.PostFormValue("name")
+		if name == "" || len(name) > 63 {
+			http.Error(w, "Channel name must be between 1 and 63 characters", http.StatusBadRequest)
+			return
+		}
+		_, err := env.Teams.GetTeamChannel(team.Id, name)
+		if err == nil {
+			http.Error(w, "Channel already exists", http.StatusBadRequest)
+			return
+		}
+		if err != pgx.ErrNoRows {
+			env.Logger.Printf("Error occurred while fetching channel of team %d: %v", team.Id, err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+
+		_, err = env.Teams.CreateTeamChannel(team.Id, name)
+		if err != nil {
+			env.Logger.Printf("Error occurred while creating channel in team %d: %v", team.Id, err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("HX-Refresh", "true")
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+// TeamClip returns the clipboard of a team channel as plain text.
+func TeamClip(env *conf.Env) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireStore(w, env.Teams != nil, "Teams") {
+			return
+		}
+		team, ok := teamMembership(env, w, req)
+		if !ok {
+			return
+		}
+		if !model.CanReadTeam(team.Role) {
+			http.Error(w, "You cannot read this team's clipboard", http.StatusForbidden)
+			return
+		}
+		channel, ok := teamChannel(env, w, req, team)
+		if !ok {
+			return
+		}
+
+		content, err := teamClipboard(env, channel)
+		if err == redis.Nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if err != nil {
+			env.Logger.Printf("Error occurred while fetching team clipboard: %v", err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(content))
+	}
+}
+
+// BroadcastToTeam is Broadcast for a team channel instead of the personal
+// clipboard. Clips that look like secrets are rejected unless sensitive is
+// off, since every member of the team can read them.
+func BroadcastToTeam(env *conf.Env) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireStore(w, env.Teams != nil, "Teams") {
+			return
+		}
+		team, ok := teamMembership(env, w, req)
+		if !ok {
+			return
+		}
+		if !model.CanWriteTeam(team.Role) {
+			http.Error(w, "You cannot write to this team's clipboard", http.StatusForbidden)
+			return
+		}
+		channel, ok := teamChannel(env, w, req, team)
+		if !ok {
+			return
+		}
+
+		req.Body = http.MaxBytesReader(w, req.Body, env.MaxClipSize+multipartOverhead)
+		err := req.ParseForm()
+		if err != nil {
+			writeUploadError(env, w, err)
+			return
+		}
+		value := req.PostFormValue("content")
+		if int64(len(value)) > env.MaxClipSize {
+			http.Error(w, fmt.Sprintf("Clips can be at most %d bytes", env.MaxClipSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+		// Team clipboards count towards the quota of whoever broadcasts to
+		// them
+		userID, _ := req.Context().Value(middleware.AuthUserID).(int32)
+		if !checkQuota(env, w, userID, int64(len(value))) {
+			return
+		}
+		if clipSensitive(req.PostFormValue("sensitive"), value) {
+			http.Error(w, "Clip looks like a secret and cannot be broadcasted to a team, set sensitive to off to send it anyway", http.StatusBadRequest)
+			return
+		}
+
+		// Team clipboards belong to no user, only the global max age applies.
+		// The clipboard store compresses large clips, like for users.
+		err = env.Clipboard.Set(teamClipboardOwner(channel), value, env.Retention.MaxAge)
+		if err != nil {
+			env.Logger.Printf("Error while broadcasting team clipboard: %v", err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
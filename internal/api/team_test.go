@@ -0,0 +1,151 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/amns13/shipboard/internal/conf"
+	"github.com/amns13/shipboard/internal/model"
+	"github.com/amns13/shipboard/internal/services"
+)
+
+// teamRequest returns a request to a channel of the team, or to the team
+// itself if channel is empty.
+func teamRequest(method string, team *model.Team, channel string, form url.Values) *http.Request {
+	req := httptest.NewRequest(method, "/teams/", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetPathValue("id", strconv.Itoa(int(team.Id)))
+	req.SetPathValue("channel", channel)
+	return req
+}
+
+func broadcastToTeam(env *conf.Env, user *model.User, team *model.Team, form url.Values) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := teamRequest(http.MethodPost, team, model.DEFAULT_TEAM_CHANNEL, form)
+	BroadcastToTeam(env)(w, authenticated(req, user))
+	return w
+}
+
+func teamClip(env *conf.Env, user *model.User, team *model.Team) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := teamRequest(http.MethodGet, team, model.DEFAULT_TEAM_CHANNEL, nil)
+	TeamClip(env)(w, authenticated(req, user))
+	return w
+}
+
+func inviteTeamMember(env *conf.Env, user *model.User, team *model.Team, email string, role string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := teamRequest(http.MethodPost, team, "", url.Values{"email": {email}, "role": {role}})
+	InviteTeamMember(env)(w, authenticated(req, user))
+	return w
+}
+
+// newTestTeam returns a team owned by the user, and another user who is not
+// a member yet.
+func newTestTeam(t *testing.T) (*conf.Env, *model.User, *model.User, *model.Team) {
+	t.Helper()
+	env, owner := newTestEnv(t)
+	other, err := env.Users.CreateUser(model.UserCreator{Name: "Other", Email: "other@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	team, err := env.Teams.CreateTeam("ops", owner.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return env, owner, other, team
+}
+
+func TestBroadcastToTeam(t *testing.T) {
+	env, owner, _, team := newTestTeam(t)
+
+	if w := broadcastToTeam(env, owner, team, url.Values{"content": {"abcd"}}); w.Code != http.StatusNoContent {
+		t.Fatalf("expected %d, got %d %q", http.StatusNoContent, w.Code, w.Body)
+	}
+	if w := teamClip(env, owner, team); w.Code != http.StatusOK || w.Body.String() != "abcd" {
+		t.Errorf("expected abcd, got %d %q", w.Code, w.Body)
+	}
+}
+
+func TestTeamRoles(t *testing.T) {
+	env, owner, other, team := newTestTeam(t)
+
+	// Teams of others are reported as missing
+	if w := teamClip(env, other, team); w.Code != http.StatusNotFound {
+		t.Errorf("expected %d for a non member, got %d", http.StatusNotFound, w.Code)
+	}
+	if w := broadcastToTeam(env, other, team, url.Values{"content": {"abcd"}}); w.Code != http.StatusNotFound {
+		t.Errorf("expected %d for a non member, got %d", http.StatusNotFound, w.Code)
+	}
+
+	if w := inviteTeamMember(env, owner, team, other.Email, model.TEAM_ROLE_READER); w.Code != http.StatusCreated {
+		t.Fatalf("expected %d, got %d %q", http.StatusCreated, w.Code, w.Body)
+	}
+	if w := broadcastToTeam(env, owner, team, url.Values{"content": {"abcd"}}); w.Code != http.StatusNoContent {
+		t.Fatalf("expected %d, got %d %q", http.StatusNoContent, w.Code, w.Body)
+	}
+	if w := teamClip(env, other, team); w.Code != http.StatusOK || w.Body.String() != "abcd" {
+		t.Errorf("expected readers to read abcd, got %d %q", w.Code, w.Body)
+	}
+	if w := broadcastToTeam(env, other, team, url.Values{"content": {"efgh"}}); w.Code != http.StatusForbidden {
+		t.Errorf("expected %d for a reader writing, got %d", http.StatusForbidden, w.Code)
+	}
+	if w := inviteTeamMember(env, other, team, owner.Email, model.TEAM_ROLE_WRITER); w.Code != http.StatusForbidden {
+		t.Errorf("expected %d for a reader inviting, got %d", http.StatusForbidden, w.Code)
+	}
+}
+
+func TestInviteTeamMember(t *testing.T) {
+	env, owner, other, team := newTestTeam(t)
+
+	if w := inviteTeamMember(env, owner, team, other.Email, "admin"); w.Code != http.StatusBadRequest {
+		t.Errorf("expected %d for an invalid role, got %d", http.StatusBadRequest, w.Code)
+	}
+	if w := inviteTeamMember(env, owner, team, "nobody@example.com", model.TEAM_ROLE_WRITER); w.Code != http.StatusNotFound {
+		t.Errorf("expected %d for an unknown email, got %d", http.StatusNotFound, w.Code)
+	}
+	if w := inviteTeamMember(env, owner, team, other.Email, model.TEAM_ROLE_WRITER); w.Code != http.StatusCreated {
+		t.Fatalf("expected %d, got %d %q", http.StatusCreated, w.Code, w.Body)
+	}
+	if w := inviteTeamMember(env, owner, team, other.Email, model.TEAM_ROLE_OWNER); w.Code != http.StatusConflict {
+		t.Errorf("expected %d for a member invited again, got %d", http.StatusConflict, w.Code)
+	}
+	membership, err := env.Teams.GetUserTeam(other.Id, team.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if membership.Role != model.TEAM_ROLE_WRITER {
+		t.Errorf("expected the role to be kept, got %s", membership.Role)
+	}
+	if w := broadcastToTeam(env, other, team, url.Values{"content": {"abcd"}}); w.Code != http.StatusNoContent {
+		t.Errorf("expected writers to write, got %d %q", w.Code, w.Body)
+	}
+}
+
+func TestBroadcastToTeamLimits(t *testing.T) {
+	env, owner, _, team := newTestTeam(t)
+	env.MaxClipSize = 8
+
+	if w := broadcastToTeam(env, owner, team, url.Values{"content": {"abcdefghi"}}); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected %d for a clip too large, got %d", http.StatusRequestEntityTooLarge, w.Code)
+	}
+
+	secret := url.Values{"content": {"API_TOKEN=Zx9fQ2mLk8RtV4pW7nB3cH6yJ1sD5gA0"}}
+	env.MaxClipSize = conf.DEFAULT_MAX_CLIP_SIZE
+	if w := broadcastToTeam(env, owner, team, secret); w.Code != http.StatusBadRequest {
+		t.Errorf("expected %d for a secret, got %d", http.StatusBadRequest, w.Code)
+	}
+	secret.Set("sensitive", "off")
+	if w := broadcastToTeam(env, owner, team, secret); w.Code != http.StatusNoContent {
+		t.Errorf("expected secrets to be sent with sensitive off, got %d %q", w.Code, w.Body)
+	}
+
+	env.Quota = services.Quota{MaxBytes: 4}
+	if w := broadcastToTeam(env, owner, team, url.Values{"content": {"abcdefgh"}}); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected %d for a clip larger than the quota, got %d", http.StatusRequestEntityTooLarge, w.Code)
+	}
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/amns13/shipboard/internal/conf"
+	"github.com/amns13/shipboard/internal/model"
+	"github.com/amns13/shipboard/internal/services"
+)
+
+// Thumbnails never change, a clip is never edited
+const thumbnailCacheControl = "private, max-age=604800, immutable"
+
+// Generating a thumbnail holds the decoded image in memory, pages of image
+// clips request many at once
+const maxConcurrentThumbnails = 2
+
+var thumbnailSlots = make(chan struct{}, maxConcurrentThumbnails)
+
+func writeThumbnail(w http.ResponseWriter, thumbnail []byte) {
+	w.Header().Set("Content-Type", services.THUMBNAIL_CONTENT_TYPE)
+	w.Header().Set("Content-Length", strconv.Itoa(len(thumbnail)))
+	w.Header().Set("Cache-Control", thumbnailCacheControl)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write(thumbnail)
+}
+
+// imageData returns the mediaType representation of the clip, or nil if the
+// clip has no such image.
+func imageData(env *conf.Env, clip *model.Clip, mediaType string) ([]byte, error) {
+	if clip.IsImage() && mediaTypeOf(clip.ContentType) == mediaType {
+		return env.Clips.GetClipData(clip)
+	}
+	// Clips have no other representations without Postgres
+	if !env.HasPostgres() {
+		return nil, nil
+	}
+	representations, err := model.GetClipRepresentations(env, []int32{clip.Id})
+	if err != nil {
+		return nil, err
+	}
+	for _, representation := range representations[clip.Id] {
+		if representation.IsImage() && mediaTypeOf(representation.ContentType) == mediaType {
+			return model.GetRepresentationData(env, &representation)
+		}
+	}
+	return nil, nil
+}
+
+// ClipThumbnail returns a small PNG of an image clip, generated on the first
+// request and cached in the blob store. Pass type for another image
+// representation than the primary one. Requests wait while
+// maxConcurrentThumbnails thumbnails are being generated.
+func ClipThumbnail(env *conf.Env) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		clip, ok := ownClip(env, w, req)
+		if !ok {
+			return
+		}
+		mediaType := mediaTypeOf(clip.ContentType)
+		if value := req.URL.Query().Get("type"); value != "" {
+			mediaType = mediaTypeOf(value)
+		}
+
+		key := model.ThumbnailKey(clip.Id, mediaType)
+		reader, err := env.BlobStore.Get(key)
+		if err == nil {
+			defer reader.Close()
+			var buf bytes.Buffer
+			_, err = io.Copy(&buf, reader)
+			if err == nil {
+				writeThumbnail(w, buf.Bytes())
+				return
+			}
+		}
+		if err != services.ErrBlobNotFound {
+			env.Logger.Printf("Error occurred while reading thumbnail %s: %v", key, err)
+		}
+
+		select {
+		case thumbnailSlots <- struct{}{}:
+			defer func() { <-thumbnailSlots }()
+		case <-req.Context().Done():
+			return
+		}
+		data, err := imageData(env, clip, mediaType)
+		if err != nil {
+			env.Logger.Printf("Error occurred while fetching image of clip %d: %v", clip.Id, err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		if data == nil {
+			http.Error(w, "Clip has no such image", http.StatusNotFound)
+			return
+		}
+		thumbnail, err := services.Thumbnail(data, services.THUMBNAIL_SIZE)
+		if errors.Is(err, services.ErrUnsupportedImage) {
+			http.Error(w, "No preview available for this image", http.StatusUnsupportedMediaType)
+			return
+		}
+		if err != nil {
+			env.Logger.Printf("Error occurred while generating thumbnail of clip %d: %v", clip.Id, err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		// The thumbnail can still be served if it can't be cached
+		err = env.BlobStore.Put(key, bytes.NewReader(thumbnail), int64(len(thumbnail)))
+		if err != nil {
+			env.Logger.Printf("Error occurred while caching thumbnail %s: %v", key, err)
+		}
+		writeThumbnail(w, thumbnail)
+	}
+}
@@ -0,0 +1,455 @@
+package api
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amns13/shipboard/internal/conf"
+	"github.com/amns13/shipboard/internal/middleware"
+	"github.com/amns13/shipboard/internal/model"
+	"github.com/amns13/shipboard/internal/services"
+	"github.com/jackc/pgx/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+// Resumable uploads follow the core protocol of tus 1.0.0, see
+// https://tus.io/protocols/resumable-upload, with a finalize step that
+// verifies the checksum of the whole file and broadcasts it.
+const (
+	tusVersion          = "1.0.0"
+	uploadOffsetType    = "application/offset+octet-stream"
+	uploadOffsetHeader  = "Upload-Offset"
+	uploadLengthHeader  = "Upload-Length"
+	uploadExpiresHeader = "Upload-Expires"
+	// Sent as "sha256 <base64 digest>" when finalizing
+	uploadChecksumHeader = "Upload-Checksum"
+	// Not in net/http, defined by the checksum extension of tus
+	statusChecksumMismatch = 460
+)
+
+type finalizedUpload struct {
+	ClipID      int32  `json:"clip_id"`
+	FileName    string `json:"file_name"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	// Set if the file looks like a secret, and was kept only on the clipboard
+	Sensitive bool `json:"sensitive"`
+}
+
+// parseUploadMetadata parses the Upload-Metadata header, a comma separated
+// list of keys and base64 encoded values.
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %s: %w", key, err)
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+func writeUploadHeaders(w http.ResponseWriter, upload *services.Upload) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set(uploadOffsetHeader, strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set(uploadLengthHeader, strconv.FormatInt(upload.Length, 10))
+	w.Header().Set(uploadExpiresHeader, upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+// ownUpload returns the upload in the {id} path value if it belongs to the
+// authenticated user.
+func ownUpload(env *conf.Env, w http.ResponseWriter, req *http.Request) (*services.Upload, bool) {
+	userID, ok := req.Context().Value(middleware.AuthUserID).(int32)
+	if !ok {
+		env.Logger.Println("Invalid user id", userID)
+		http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+		return nil, false
+	}
+	upload, err := env.Uploads.Get(req.PathValue("id"))
+	if err == redis.Nil || (err == nil && upload.UserID != userID) {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		env.Logger.Printf("Error occurred while fetching upload: %v", err)
+		http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+		return nil, false
+	}
+	return upload, true
+}
+
+// deleteUpload forgets the upload and deletes its chunks. Failing to delete
+// chunks is only logged, they expire with the upload anyway.
+func deleteUpload(env *conf.Env, upload *services.Upload) error {
+	chunks, err := env.Uploads.Chunks(upload.ID)
+	if err != nil {
+		return err
+	}
+	err = env.Uploads.Delete(upload.ID)
+	if err != nil {
+		return err
+	}
+	for _, chunk := range chunks {
+		err = env.BlobStore.Delete(chunk)
+		if err != nil {
+			env.Logger.Printf("Error occurred while deleting chunk %s: %v", chunk, err)
+		}
+	}
+	return nil
+}
+
+// CreateUpload starts a resumable upload of Upload-Length bytes. The file
+// name and content type can be given as the filename and filetype keys of
+// Upload-Metadata.
+func CreateUpload(env *conf.Env) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireStore(w, env.Uploads != nil, "Resumable uploads") {
+			return
+		}
+		userID, ok := req.Context().Value(middleware.AuthUserID).(int32)
+		if !ok {
+			env.Logger.Println("Invalid user id", userID)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		length, err := strconv.ParseInt(req.Header.Get(uploadLengthHeader), 10, 64)
+		if err != nil || length < 0 {
+			http.Error(w, "Upload-Length must be a number of bytes", http.StatusBadRequest)
+			return
+		}
+		if length > env.MaxClipSize {
+			http.Error(w, fmt.Sprintf("Clips can be at most %d bytes", env.MaxClipSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+		// Checked again when finalizing, the history may have grown since
+		if !checkQuota(env, w, userID, length) {
+			return
+		}
+		metadata, err := parseUploadMetadata(req.Header.Get("Upload-Metadata"))
+		if err != nil {
+			http.Error(w, "Invalid Upload-Metadata", http.StatusBadRequest)
+			return
+		}
+		if !fileFieldsFit(metadata["filename"], metadata["filetype"]) {
+			writeFileFieldTooLong(w)
+			return
+		}
+
+		upload := &services.Upload{
+			UserID:      userID,
+			Length:      length,
+			FileName:    metadata["filename"],
+			ContentType: metadata["filetype"],
+		}
+		if upload.FileName == "" {
+			upload.FileName = defaultFileName
+		}
+		err = env.Uploads.Create(upload)
+		if err != nil {
+			env.Logger.Printf("Error occurred while creating upload: %v", err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		writeUploadHeaders(w, upload)
+		w.Header().Set("Location", fmt.Sprintf("/uploads/%s", upload.ID))
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+// UploadOffset returns how many bytes of the upload have been received, in
+// the Upload-Offset header. Clients resume from there.
+func UploadOffset(env *conf.Env) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireStore(w, env.Uploads != nil, "Resumable uploads") {
+			return
+		}
+		upload, ok := ownUpload(env, w, req)
+		if !ok {
+			return
+		}
+		writeUploadHeaders(w, upload)
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// PatchUpload appends the body to the upload. Upload-Offset must be the
+// current offset of the upload, otherwise the chunk is rejected with 409.
+func PatchUpload(env *conf.Env) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireStore(w, env.Uploads != nil, "Resumable uploads") {
+			return
+		}
+		upload, ok := ownUpload(env, w, req)
+		if !ok {
+			return
+		}
+		w.Header().Set("Tus-Resumable", tusVersion)
+		if req.Header.Get("Content-Type") != uploadOffsetType {
+			http.Error(w, fmt.Sprintf("Content-Type must be %s", uploadOffsetType), http.StatusUnsupportedMediaType)
+			return
+		}
+		offset, err := strconv.ParseInt(req.Header.Get(uploadOffsetHeader), 10, 64)
+		if err != nil || offset != upload.Offset {
+			http.Error(w, fmt.Sprintf("Upload-Offset must be %d", upload.Offset), http.StatusConflict)
+			return
+		}
+		// Blob stores need the size of the chunk before it is read
+		if req.ContentLength < 0 {
+			http.Error(w, "Content-Length is required", http.StatusLengthRequired)
+			return
+		}
+		if offset+req.ContentLength > upload.Length {
+			http.Error(w, "Chunk exceeds Upload-Length", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		chunk := services.NewBlobKey(path.Join(services.UPLOAD_BLOB_PREFIX, upload.ID))
+		body := http.MaxBytesReader(w, req.Body, req.ContentLength)
+		err = env.BlobStore.Put(chunk, body, req.ContentLength)
+		if err != nil {
+			env.Logger.Printf("Error occurred while storing chunk of upload %s: %v", upload.ID, err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+
+		upload.Offset, err = env.Uploads.AddChunk(upload.ID, offset, req.ContentLength, chunk)
+		if err == redis.Nil || err == services.ErrUploadOffsetMismatch {
+			env.BlobStore.Delete(chunk)
+		}
+		if err == redis.Nil {
+			http.Error(w, "Upload not found", http.StatusNotFound)
+			return
+		}
+		if err == services.ErrUploadOffsetMismatch {
+			http.Error(w, "Another chunk was received at this offset", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			env.Logger.Printf("Error occurred while adding chunk to upload %s: %v", upload.ID, err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set(uploadOffsetHeader, strconv.FormatInt(upload.Offset, 10))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// FinalizeUpload verifies the Upload-Checksum of a complete upload and
+// broadcasts it as a file clip, streaming it to the blob store when it is too
+// large to be kept inline. An upload that doesn't match its checksum is
+// deleted, it must be uploaded again. Duplicates of recent clips are moved to
+// the top of the history instead, like with Broadcast. Text files that look
+// like secrets are broadcasted as sensitive clips, unless sensitive is off.
+func FinalizeUpload(env *conf.Env) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireStore(w, env.Uploads != nil, "Resumable uploads") {
+			return
+		}
+		upload, ok := ownUpload(env, w, req)
+		if !ok {
+			return
+		}
+		w.Header().Set("Tus-Resumable", tusVersion)
+		if upload.Offset != upload.Length {
+			http.Error(w, fmt.Sprintf("Upload is incomplete, %d of %d bytes received", upload.Offset, upload.Length), http.StatusConflict)
+			return
+		}
+		if !checkQuota(env, w, upload.UserID, upload.Length) {
+			return
+		}
+		algorithm, encoded, _ := strings.Cut(req.Header.Get(uploadChecksumHeader), " ")
+		expected, err := base64.StdEncoding.DecodeString(encoded)
+		if algorithm != "sha256" || err != nil || len(expected) != sha256.Size {
+			http.Error(w, "Upload-Checksum must be sha256 followed by the base64 encoded digest", http.StatusBadRequest)
+			return
+		}
+
+		claimed, err := env.Uploads.Claim(upload.ID)
+		if err == redis.Nil {
+			http.Error(w, "Upload not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			env.Logger.Printf("Error occurred while claiming upload %s: %v", upload.ID, err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		if !claimed {
+			http.Error(w, "Upload is already being finalized", http.StatusConflict)
+			return
+		}
+		// Lets the upload be finalized again if this fails, releasing a
+		// deleted upload does nothing
+		defer func() {
+			err := env.Uploads.Release(upload.ID)
+			if err != nil {
+				env.Logger.Printf("Error occurred while releasing upload %s: %v", upload.ID, err)
+			}
+		}()
+
+		chunks, err := env.Uploads.Chunks(upload.ID)
+		if err != nil {
+			env.Logger.Printf("Error occurred while fetching chunks of upload %s: %v", upload.ID, err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		reader := &chunkReader{env: env, chunks: chunks}
+		data, stored, err := readFile(env, reader, upload.Length, upload.Length)
+		reader.Close()
+		if err != nil {
+			env.Logger.Printf("Error occurred while reading upload %s: %v", upload.ID, err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+
+		var digest []byte
+		if stored != nil {
+			digest = stored.Hash
+		} else {
+			sum := sha256.Sum256(data)
+			digest = sum[:]
+		}
+		if subtle.ConstantTimeCompare(digest, expected) != 1 {
+			if stored != nil {
+				err = env.BlobStore.Delete(stored.BlobKey)
+				if err != nil {
+					env.Logger.Printf("Error occurred while deleting blob %s: %v", stored.BlobKey, err)
+				}
+			}
+			err = deleteUpload(env, upload)
+			if err != nil {
+				env.Logger.Printf("Error occurred while deleting upload %s: %v", upload.ID, err)
+			}
+			http.Error(w, "Checksum mismatch, the upload must be restarted", statusChecksumMismatch)
+			return
+		}
+
+		user, err := authenticatedUser(env, req)
+		if err != nil {
+			env.Logger.Printf("Error occurred while fetching user: %v", err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		file := &fileUpload{
+			Name:        upload.FileName,
+			ContentType: detectContentType(upload.ContentType, data),
+			Data:        data,
+			Stored:      stored,
+		}
+		// Text files that look like secrets keep only metadata in the
+		// history, like text clips
+		sensitive := file.sensitive(req.FormValue("sensitive"))
+		var clip *model.Clip
+		if sensitive {
+			clip, err = env.Clips.CreateSensitiveClip(user.Id, len(data))
+			if err == nil {
+				err = env.Clipboard.SetSensitive(user.Uid.String(), clip.Id, string(data), services.SENSITIVE_CLIP_TTL)
+			}
+		} else {
+			clip, err = bumpDuplicateFile(env, user.Id, file)
+			if err == pgx.ErrNoRows {
+				clip, err = createFileClip(env, user.Id, file)
+			}
+			if err == nil {
+				var ttl time.Duration
+				ttl, err = clipboardTTL(env, user.Id)
+				if err == nil {
+					err = env.Clipboard.SetRef(user.Uid.String(), clip.Id, ttl)
+				}
+			}
+		}
+		if err != nil {
+			env.Logger.Printf("Error while broadcasting upload %s: %v", upload.ID, err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		err = deleteUpload(env, upload)
+		if err != nil {
+			env.Logger.Printf("Error occurred while deleting upload %s: %v", upload.ID, err)
+		}
+		writeJSON(env, w, http.StatusCreated, finalizedUpload{
+			ClipID:      clip.Id,
+			FileName:    upload.FileName,
+			ContentType: file.ContentType,
+			Size:        clip.Size,
+			Sensitive:   sensitive,
+		})
+	}
+}
+
+// chunkReader reads the chunks of an upload one after the other, opening
+// each blob only once the previous one is read.
+type chunkReader struct {
+	env     *conf.Env
+	chunks  []string
+	current io.ReadCloser
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.chunks) == 0 {
+				return 0, io.EOF
+			}
+			current, err := r.env.BlobStore.Get(r.chunks[0])
+			if err != nil {
+				return 0, fmt.Errorf("reading chunk %s: %w", r.chunks[0], err)
+			}
+			r.current, r.chunks = current, r.chunks[1:]
+		}
+		n, err := r.current.Read(p)
+		if err == io.EOF {
+			r.current.Close()
+			r.current = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+// Close closes the chunk being read, if any.
+func (r *chunkReader) Close() error {
+	if r.current == nil {
+		return nil
+	}
+	return r.current.Close()
+}
+
+// DeleteUpload cancels an upload.
+func DeleteUpload(env *conf.Env) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireStore(w, env.Uploads != nil, "Resumable uploads") {
+			return
+		}
+		upload, ok := ownUpload(env, w, req)
+		if !ok {
+			return
+		}
+		err := deleteUpload(env, upload)
+		if err != nil {
+			env.Logger.Printf("Error occurred while deleting upload %s: %v", upload.ID, err)
+			http.Error(w, INTERNAL_SERVER_ERROR, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Tus-Resumable", tusVersion)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,256 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/amns13/shipboard/internal/conf"
+	"github.com/amns13/shipboard/internal/model"
+	"github.com/amns13/shipboard/internal/services"
+	"github.com/redis/go-redis/v9"
+)
+
+func createUpload(env *conf.Env, user *model.User, length int) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/uploads/", nil)
+	req.Header.Set(uploadLengthHeader, strconv.Itoa(length))
+	req.Header.Set("Upload-Metadata", "filename "+base64.StdEncoding.EncodeToString([]byte("notes.txt")))
+	w := httptest.NewRecorder()
+	CreateUpload(env)(w, authenticated(req, user))
+	return w
+}
+
+// startUpload creates an upload of length bytes, returning its id.
+func startUpload(t *testing.T, env *conf.Env, user *model.User, length int) string {
+	t.Helper()
+	w := createUpload(env, user, length)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected %d, got %d %q", http.StatusCreated, w.Code, w.Body)
+	}
+	return strings.TrimPrefix(w.Header().Get("Location"), "/uploads/")
+}
+
+func patchUpload(env *conf.Env, user *model.User, id string, offset int, chunk string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPatch, "/uploads/"+id, strings.NewReader(chunk))
+	req.SetPathValue("id", id)
+	req.Header.Set("Content-Type", uploadOffsetType)
+	req.Header.Set(uploadOffsetHeader, strconv.Itoa(offset))
+	w := httptest.NewRecorder()
+	PatchUpload(env)(w, authenticated(req, user))
+	return w
+}
+
+func finalizeUpload(env *conf.Env, user *model.User, id string, content string) *httptest.ResponseRecorder {
+	digest := sha256.Sum256([]byte(content))
+	req := httptest.NewRequest(http.MethodPost, "/uploads/"+id+"/finalize", nil)
+	req.SetPathValue("id", id)
+	req.Header.Set(uploadChecksumHeader, "sha256 "+base64.StdEncoding.EncodeToString(digest[:]))
+	w := httptest.NewRecorder()
+	FinalizeUpload(env)(w, authenticated(req, user))
+	return w
+}
+
+// uploadChunks sends the chunks of an upload one after the other.
+func uploadChunks(t *testing.T, env *conf.Env, user *model.User, id string, chunks ...string) {
+	t.Helper()
+	offset := 0
+	for _, chunk := range chunks {
+		if w := patchUpload(env, user, id, offset, chunk); w.Code != http.StatusNoContent {
+			t.Fatalf("expected %d, got %d %q", http.StatusNoContent, w.Code, w.Body)
+		}
+		offset += len(chunk)
+	}
+}
+
+func TestCreateUpload(t *testing.T) {
+	env, user := newTestEnv(t)
+	env.MaxClipSize = 16
+
+	w := createUpload(env, user, 8)
+	if w.Code != http.StatusCreated || !strings.HasPrefix(w.Header().Get("Location"), "/uploads/") {
+		t.Fatalf("expected %d with a location, got %d %q", http.StatusCreated, w.Code, w.Header().Get("Location"))
+	}
+	if w.Header().Get(uploadOffsetHeader) != "0" || w.Header().Get(uploadLengthHeader) != "8" {
+		t.Errorf("expected offset 0 of 8, got %s of %s", w.Header().Get(uploadOffsetHeader), w.Header().Get(uploadLengthHeader))
+	}
+	if w = createUpload(env, user, 17); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected %d for an upload too large, got %d", http.StatusRequestEntityTooLarge, w.Code)
+	}
+	if w = createUpload(env, user, -1); w.Code != http.StatusBadRequest {
+		t.Errorf("expected %d for a negative length, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/uploads/", nil)
+	req.Header.Set(uploadLengthHeader, "8")
+	req.Header.Set("Upload-Metadata", "filename "+base64.StdEncoding.EncodeToString([]byte(strings.Repeat("a", maxFileFieldLength+1))))
+	w = httptest.NewRecorder()
+	CreateUpload(env)(w, authenticated(req, user))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected %d for a file name too long, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestUploadOffset(t *testing.T) {
+	env, user := newTestEnv(t)
+	id := startUpload(t, env, user, 8)
+	uploadChunks(t, env, user, id, "abc")
+
+	req := httptest.NewRequest(http.MethodHead, "/uploads/"+id, nil)
+	req.SetPathValue("id", id)
+	w := httptest.NewRecorder()
+	UploadOffset(env)(w, authenticated(req, user))
+	if w.Code != http.StatusOK || w.Header().Get(uploadOffsetHeader) != "3" {
+		t.Errorf("expected offset 3, got %d %q", w.Code, w.Header().Get(uploadOffsetHeader))
+	}
+
+	other, err := env.Users.CreateUser(model.UserCreator{Name: "Other", Email: "other@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	UploadOffset(env)(w, authenticated(req, other))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected %d for the upload of another user, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestPatchUploadOffsetConflict(t *testing.T) {
+	env, user := newTestEnv(t)
+	id := startUpload(t, env, user, 8)
+	uploadChunks(t, env, user, id, "abcd")
+
+	if w := patchUpload(env, user, id, 0, "abcd"); w.Code != http.StatusConflict {
+		t.Errorf("expected %d for a chunk at an old offset, got %d", http.StatusConflict, w.Code)
+	}
+	if w := patchUpload(env, user, id, 4, "efghi"); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected %d for a chunk past the length, got %d", http.StatusRequestEntityTooLarge, w.Code)
+	}
+	chunks, err := env.Uploads.Chunks(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) != 1 {
+		t.Errorf("expected the rejected chunks to be dropped, got %d chunks", len(chunks))
+	}
+}
+
+func TestFinalizeUpload(t *testing.T) {
+	env, user := newTestEnv(t)
+	id := startUpload(t, env, user, 8)
+	uploadChunks(t, env, user, id, "abc", "defgh")
+
+	w := finalizeUpload(env, user, id, "abcdefgh")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected %d, got %d %q", http.StatusCreated, w.Code, w.Body)
+	}
+	var finalized finalizedUpload
+	if err := json.Unmarshal(w.Body.Bytes(), &finalized); err != nil {
+		t.Fatal(err)
+	}
+	if finalized.FileName != "notes.txt" || finalized.Size != 8 || finalized.Sensitive {
+		t.Errorf("expected notes.txt of 8 bytes, got %+v", finalized)
+	}
+	clip, err := env.Clips.GetClipByID(finalized.ClipID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := env.Clips.GetClipData(clip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "abcdefgh" {
+		t.Errorf("expected the chunks in order, got %q", data)
+	}
+	if _, err = env.Uploads.Get(id); err != redis.Nil {
+		t.Errorf("expected the upload to be deleted, got %v", err)
+	}
+
+	// The same file again is bumped instead of being added twice
+	id = startUpload(t, env, user, 8)
+	uploadChunks(t, env, user, id, "abcdefgh")
+	if w = finalizeUpload(env, user, id, "abcdefgh"); w.Code != http.StatusCreated {
+		t.Fatalf("expected %d, got %d %q", http.StatusCreated, w.Code, w.Body)
+	}
+	clips, err := env.Clips.GetUserClips(user.Id, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clips) != 1 {
+		t.Errorf("expected the duplicate to be bumped, got %d clips", len(clips))
+	}
+}
+
+func TestFinalizeUploadChecksumMismatch(t *testing.T) {
+	env, user := newTestEnv(t)
+	id := startUpload(t, env, user, 4)
+	uploadChunks(t, env, user, id, "abcd")
+
+	if w := finalizeUpload(env, user, id, "abce"); w.Code != statusChecksumMismatch {
+		t.Fatalf("expected %d, got %d %q", statusChecksumMismatch, w.Code, w.Body)
+	}
+	if _, err := env.Uploads.Get(id); err != redis.Nil {
+		t.Errorf("expected the upload to be deleted, got %v", err)
+	}
+	clips, err := env.Clips.GetUserClips(user.Id, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clips) != 0 {
+		t.Errorf("expected nothing in the history, got %+v", clips)
+	}
+}
+
+func TestFinalizeUploadTwice(t *testing.T) {
+	env, user := newTestEnv(t)
+	id := startUpload(t, env, user, 4)
+	uploadChunks(t, env, user, id, "abcd")
+
+	// As if another request were finalizing the upload
+	if claimed, err := env.Uploads.Claim(id); err != nil || !claimed {
+		t.Fatalf("expected to claim the upload, got %t %v", claimed, err)
+	}
+	if w := finalizeUpload(env, user, id, "abcd"); w.Code != http.StatusConflict {
+		t.Errorf("expected %d, got %d %q", http.StatusConflict, w.Code, w.Body)
+	}
+	if err := env.Uploads.Release(id); err != nil {
+		t.Fatal(err)
+	}
+	if w := finalizeUpload(env, user, id, "abcd"); w.Code != http.StatusCreated {
+		t.Errorf("expected %d once released, got %d %q", http.StatusCreated, w.Code, w.Body)
+	}
+}
+
+func TestChunkReader(t *testing.T) {
+	env, _ := newTestEnv(t)
+	env.BlobInlineLimit = 4
+	var chunks []string
+	for _, chunk := range []string{"abc", "", "defgh"} {
+		key := services.NewBlobKey(services.UPLOAD_BLOB_PREFIX)
+		if err := env.BlobStore.Put(key, strings.NewReader(chunk), int64(len(chunk))); err != nil {
+			t.Fatal(err)
+		}
+		chunks = append(chunks, key)
+	}
+
+	reader := &chunkReader{env: env, chunks: chunks}
+	defer reader.Close()
+	_, stored, err := streamFile(env, reader, 8, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, err := env.BlobStore.Get(stored.BlobKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blob.Close()
+	data, _ := io.ReadAll(blob)
+	if string(data) != "abcdefgh" {
+		t.Errorf("expected the chunks streamed in order, got %q", data)
+	}
+}
@@ -2,20 +2,75 @@ package conf
 
 import (
 	"context"
+	"crypto/rand"
+	"database/sql"
 	"html/template"
 	"log"
+	"os"
+	"path/filepath"
 
+	"github.com/amns13/shipboard/internal/services"
+	"github.com/amns13/shipboard/internal/sqlite"
+	"github.com/amns13/shipboard/internal/store"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/redis/go-redis/v9"
 )
 
 type Env struct {
-	Db        *pgxpool.Pool
-	Rdb       *redis.Client
+	// Nil when running on SQLite, see HasPostgres
+	Db  *pgxpool.Pool
+	Rdb *redis.Client
+	// Set instead of Db and Rdb by LoadSQLiteEnv
+	SQLiteDb  *sql.DB
 	Templates *template.Template
 	Logger    *log.Logger
+	// Defaults to argon2id. Can be overridden after loading the env.
+	PasswordHasher services.PasswordHasher
+	// Defaults to writing emails to the log.
+	Mailer services.Mailer
+	// Base url used for links sent outside of the app, e.g. in emails
+	PublicURL string
+	// Key for deriving CSRF tokens. Random by default, which invalidates the
+	// tokens on restart and doesn't work with multiple instances.
+	CSRFSecret []byte
+	// Largest clip that can be broadcasted, in bytes
+	MaxClipSize int64
+	// Keeps clips larger than BlobInlineLimit bytes. Defaults to files under
+	// the temporary directory, which must be configured otherwise with
+	// Postgres, see cmd/server.
+	BlobStore services.BlobStore
+	// Smaller clips are kept inline in the DB and redis
+	BlobInlineLimit int64
+	// Global retention policy, users can only set stricter ones. Clips are
+	// kept forever by default.
+	Retention services.RetentionPolicy
+	// Default quota of every user, admins can override it per user.
+	// Unlimited by default.
+	Quota services.Quota
+	// Stores the handlers keep their data in. Sessions, the clipboard and
+	// tokens are kept in redis by LoadEnv, the others must be set, see
+	// model.UsePostgres. LoadSQLiteEnv only sets users, clips, sessions and
+	// the clipboard, the features of the other stores answer 501.
+	Users         store.UserStore
+	Clips         store.ClipStore
+	Sessions      store.SessionStore
+	Clipboard     store.Clipboard
+	Devices       store.DeviceStore
+	Teams         store.TeamStore
+	Tags          store.TagStore
+	Slots         store.SlotStore
+	Search        store.SearchIndex
+	Shares        store.ShareStore
+	RateLimits    store.RateLimiter
+	Verifications store.EmailVerificationStore
+	Uploads       store.UploadStore
+	Exports       store.ExportStore
 }
 
+const DEFAULT_MAX_CLIP_SIZE = 10 << 20
+
+const DEFAULT_BLOB_INLINE_LIMIT = 64 << 10
+
 func LoadEnv(postgresUri string, redisUri string, templates []string) (*Env, error) {
 
 	opts, err := redis.ParseURL(redisUri)
@@ -31,9 +86,102 @@ func LoadEnv(postgresUri string, redisUri string, templates []string) (*Env, err
 
 	tmpls := template.Must(template.ParseFiles(templates...))
 
-	logger := log.Default()
+	env, err := newEnv(log.Default())
+	if err != nil {
+		return nil, err
+	}
+	env.Db = dbPool
+	env.Rdb = redisClient
+	env.Templates = tmpls
+	env.Sessions = &services.RedisSessionStore{Client: redisClient}
+	env.Clipboard = &services.ClipboardStore{Client: redisClient}
+	env.Shares = &services.ShareStore{Client: redisClient}
+	env.RateLimits = &services.RateLimiter{Client: redisClient}
+	env.Verifications = &services.EmailVerificationStore{Client: redisClient}
+	env.Uploads = &services.UploadStore{Client: redisClient}
+	env.Exports = &services.ExportStore{Client: redisClient}
+	return env, nil
+}
+
+// LoadSQLiteEnv returns an env keeping users, clips, sessions and the
+// clipboard in the SQLite file at path, without Postgres and redis. The file
+// must have been migrated, see cmd/migration. Handlers of the other stores,
+// and those that use the DB directly, answer 501.
+func LoadSQLiteEnv(path string, templates []string) (*Env, error) {
+	db, err := sqlite.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpls := template.Must(template.ParseFiles(templates...))
+
+	env, err := newEnv(log.Default())
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	env.SQLiteDb = db
+	env.Templates = tmpls
+	env.Users = &sqlite.UserStore{DB: db}
+	env.Clips = &sqlite.ClipStore{DB: db}
+	env.Sessions = &sqlite.SessionStore{DB: db}
+	env.Clipboard = &sqlite.Clipboard{DB: db}
+	return env, nil
+}
+
+// HasPostgres reports whether the env is backed by Postgres and redis.
+// Features without a store, e.g. representations and the admin dashboard,
+// need them.
+func (env *Env) HasPostgres() bool {
+	return env.Db != nil
+}
+
+// NewMemoryEnv returns an env keeping every store in memory, without
+// Postgres and redis. Handlers that use the DB directly answer 501, see
+// HasPostgres. There are no templates, set them if needed.
+func NewMemoryEnv(logger *log.Logger) (*Env, error) {
+	env, err := newEnv(logger)
+	if err != nil {
+		return nil, err
+	}
+	users := store.NewMemoryUserStore()
+	clips := store.NewMemoryClipStore()
+	devices := store.NewMemoryDeviceStore(clips)
+	tags := store.NewMemoryTagStore(clips)
+	env.Users = users
+	env.Clips = clips
+	env.Sessions = store.NewMemorySessionStore()
+	env.Clipboard = store.NewMemoryClipboard()
+	env.Devices = devices
+	env.Teams = store.NewMemoryTeamStore(users)
+	env.Tags = tags
+	env.Slots = store.NewMemorySlotStore(clips)
+	env.Search = store.NewMemorySearchIndex(clips, devices, tags)
+	env.Shares = store.NewMemoryShareStore()
+	env.RateLimits = store.NewMemoryRateLimiter()
+	env.Verifications = store.NewMemoryEmailVerificationStore()
+	env.Uploads = store.NewMemoryUploadStore()
+	env.Exports = store.NewMemoryExportStore()
+	return env, nil
+}
+
+// newEnv returns an env with the defaults, without any store.
+func newEnv(logger *log.Logger) (*Env, error) {
 	logger.SetFlags(log.Ldate|log.Ltime|log.Lshortfile)
 
-	env := &Env{Db: dbPool, Rdb: redisClient, Templates: tmpls, Logger: logger}
+	// Default parameters are always valid, so the error can be ignored
+	hasher, _ := services.NewPasswordHasher(services.HASH_ALGORITHM_ARGON2ID, 0, 0, 0, 0)
+
+	env := &Env{Logger: logger, PasswordHasher: hasher}
+	env.Mailer = &services.LogMailer{Logger: logger}
+	env.MaxClipSize = DEFAULT_MAX_CLIP_SIZE
+	env.BlobStore = &services.FileBlobStore{Root: filepath.Join(os.TempDir(), "shipboard-blobs")}
+	env.BlobInlineLimit = DEFAULT_BLOB_INLINE_LIMIT
+
+	env.CSRFSecret = make([]byte, 32)
+	_, err := rand.Read(env.CSRFSecret)
+	if err != nil {
+		return nil, err
+	}
 	return env, nil
 }
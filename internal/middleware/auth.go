@@ -6,11 +6,11 @@ import (
 	"time"
 
 	"github.com/amns13/shipboard/internal/conf"
-	"github.com/amns13/shipboard/internal/services"
 )
 
 const AuthUserID = "authenticated_user_id"
 const AuthSessionID = "authenticated_session_id"
+const AuthUserRole = "authenticated_user_role"
 
 func RequireAuth(env *conf.Env) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
@@ -24,12 +24,8 @@ func RequireAuth(env *conf.Env) func(http.Handler) http.Handler {
 			}
 
 			// Validate session using env
-			sessionStore := services.SessionStore{
-				Client: env.Rdb,
-			}
-
 			sessionID := cookie.Value
-			sessionData, err := sessionStore.Get(sessionID)
+			sessionData, err := env.Sessions.Get(sessionID)
 			if err != nil {
 				env.Logger.Printf("Invalid session: %v", err)
 				http.Redirect(w, r, "/login/", http.StatusTemporaryRedirect)
@@ -38,17 +34,36 @@ func RequireAuth(env *conf.Env) func(http.Handler) http.Handler {
 			if sessionData.ExpiresAt.Before(time.Now()) {
 				env.Logger.Printf("Session expired. Logging out")
 
-				err := sessionStore.Expire(sessionID)
+				err := env.Sessions.Expire(sessionID)
+				if err != nil {
+					env.Logger.Printf("Error expiring session: %v", err)
+				}
+				http.Redirect(w, r, "/login/", http.StatusTemporaryRedirect)
+				return
+			}
+
+			// Disabling a user expires their sessions, but check here as well
+			// in case a session was created concurrently
+			user, err := env.Users.GetUserByID(sessionData.UserID)
+			if err != nil {
+				env.Logger.Printf("Error fetching user %d of session: %v", sessionData.UserID, err)
+				http.Redirect(w, r, "/login/", http.StatusTemporaryRedirect)
+				return
+			}
+			if user.IsDisabled() {
+				env.Logger.Printf("User %d is disabled. Logging out", user.Id)
+				err := env.Sessions.Expire(sessionID)
 				if err != nil {
 					env.Logger.Printf("Error expiring session: %v", err)
-					http.Redirect(w, r, "/login/", http.StatusTemporaryRedirect)
-					return
 				}
+				http.Redirect(w, r, "/login/", http.StatusTemporaryRedirect)
+				return
 			}
 
 			// Add the user id to the request context to pass on to further middlewares in the chain
 			ctx := context.WithValue(r.Context(), AuthUserID, sessionData.UserID)
 			ctx = context.WithValue(ctx, AuthSessionID, sessionID)
+			ctx = context.WithValue(ctx, AuthUserRole, user.Role)
 			req := r.WithContext(ctx)
 
 			// Session is valid, continue to next handler
@@ -57,3 +72,19 @@ func RequireAuth(env *conf.Env) func(http.Handler) http.Handler {
 	}
 }
 
+// RequireRole allows only users with the given role. It must run after
+// RequireAuth.
+func RequireRole(env *conf.Env, role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userRole, _ := r.Context().Value(AuthUserRole).(string)
+			if userRole != role {
+				userID, _ := r.Context().Value(AuthUserID).(int32)
+				env.Logger.Printf("User %d with role %q denied access to %s", userID, userRole, r.URL.Path)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/amns13/shipboard/internal/conf"
+	"github.com/amns13/shipboard/internal/services"
+	"github.com/amns13/shipboard/internal/store"
+)
+
+func TestRequireRole(t *testing.T) {
+	env, err := conf.NewMemoryEnv(log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := env.Users.(*store.MemoryUserStore)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	handler := RequireAuth(env)(RequireRole(env, store.ROLE_ADMIN)(next))
+
+	tests := []struct {
+		name     string
+		role     string
+		disabled bool
+		expected int
+	}{
+		{"user is forbidden", store.ROLE_USER, false, http.StatusForbidden},
+		{"admin is allowed", store.ROLE_ADMIN, false, http.StatusNoContent},
+		{"disabled admin is logged out", store.ROLE_ADMIN, true, http.StatusTemporaryRedirect},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, err := users.CreateUser(store.UserCreator{Name: tt.name, Email: tt.name + "@example.com"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := users.SetRole(user.Id, tt.role); err != nil {
+				t.Fatal(err)
+			}
+			sessionID, err := env.Sessions.CreateSession(services.SessionData{
+				UserID:    user.Id,
+				Email:     user.Email,
+				LoginTime: time.Now(),
+				ExpiresAt: time.Now().Add(time.Hour),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := users.SetUserDisabled(user.Id, tt.disabled); err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "http://shipboard.test/admin/", nil)
+			req.AddCookie(&http.Cookie{Name: "session_id", Value: sessionID})
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.expected {
+				t.Errorf("expected %d, got %d", tt.expected, rec.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+
+	"github.com/amns13/shipboard/internal/conf"
+)
+
+const CSRFToken = "csrf_token"
+
+const CSRFHeader = "X-CSRF-Token"
+
+// csrfTokenFor derives the token from the session id, so it doesn't need to be
+// stored anywhere and changes on every login.
+func csrfTokenFor(secret []byte, sessionID string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(sessionID))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// isCrossOrigin uses the headers browsers set on every request. Non browser
+// clients usually send neither, and are then checked only against the token.
+func isCrossOrigin(r *http.Request) bool {
+	if r.Header.Get("Sec-Fetch-Site") == "cross-site" {
+		return true
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	parsed, err := url.Parse(origin)
+	return err != nil || parsed.Host != r.Host
+}
+
+// RequireCSRFToken rejects state changing requests authenticated with the
+// session cookie unless they carry the session's CSRF token in the
+// X-CSRF-Token header and are not cross origin. It must run after RequireAuth.
+// The token is added to the request context for rendering into templates.
+func RequireCSRFToken(env *conf.Env) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sessionID, ok := r.Context().Value(AuthSessionID).(string)
+			if !ok {
+				env.Logger.Println("No session in context, CSRF middleware must run after auth")
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			token := csrfTokenFor(env.CSRFSecret, sessionID)
+
+			if !isSafeMethod(r.Method) {
+				if isCrossOrigin(r) {
+					env.Logger.Printf("Rejected cross origin %s %s from %q", r.Method, r.URL.Path, r.Header.Get("Origin"))
+					http.Error(w, "Forbidden", http.StatusForbidden)
+					return
+				}
+				if !hmac.Equal([]byte(r.Header.Get(CSRFHeader)), []byte(token)) {
+					env.Logger.Printf("Rejected %s %s with invalid CSRF token", r.Method, r.URL.Path)
+					http.Error(w, "Invalid CSRF token. Reload the page and try again.", http.StatusForbidden)
+					return
+				}
+			}
+
+			ctx := context.WithValue(r.Context(), CSRFToken, token)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
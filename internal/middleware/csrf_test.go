@@ -0,0 +1,109 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/amns13/shipboard/internal/conf"
+)
+
+const testSessionID = "test-session"
+
+func newCSRFTestHandler() http.Handler {
+	env := &conf.Env{Logger: log.New(io.Discard, "", 0), CSRFSecret: []byte("secret")}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return RequireCSRFToken(env)(next)
+}
+
+// newCookieRequest builds a request as RequireAuth would pass it on
+func newCookieRequest(method string) *http.Request {
+	req := httptest.NewRequest(method, "http://shipboard.test/clip/", nil)
+	req.AddCookie(&http.Cookie{Name: "session_id", Value: testSessionID})
+	ctx := context.WithValue(req.Context(), AuthSessionID, testSessionID)
+	return req.WithContext(ctx)
+}
+
+func TestRequireCSRFToken(t *testing.T) {
+	validToken := csrfTokenFor([]byte("secret"), testSessionID)
+
+	tests := []struct {
+		name     string
+		request  func() *http.Request
+		expected int
+	}{
+		{
+			name:     "safe method without token",
+			request:  func() *http.Request { return newCookieRequest(http.MethodGet) },
+			expected: http.StatusNoContent,
+		},
+		{
+			name:     "missing token",
+			request:  func() *http.Request { return newCookieRequest(http.MethodPost) },
+			expected: http.StatusForbidden,
+		},
+		{
+			name: "token of another session",
+			request: func() *http.Request {
+				req := newCookieRequest(http.MethodPost)
+				req.Header.Set(CSRFHeader, csrfTokenFor([]byte("secret"), "other-session"))
+				return req
+			},
+			expected: http.StatusForbidden,
+		},
+		{
+			name: "valid token same origin",
+			request: func() *http.Request {
+				req := newCookieRequest(http.MethodPost)
+				req.Header.Set(CSRFHeader, validToken)
+				req.Header.Set("Origin", "http://shipboard.test")
+				req.Header.Set("Sec-Fetch-Site", "same-origin")
+				return req
+			},
+			expected: http.StatusNoContent,
+		},
+		{
+			name: "valid token cross origin",
+			request: func() *http.Request {
+				req := newCookieRequest(http.MethodDelete)
+				req.Header.Set(CSRFHeader, validToken)
+				req.Header.Set("Origin", "http://evil.test")
+				return req
+			},
+			expected: http.StatusForbidden,
+		},
+		{
+			name: "cross site fetch without origin",
+			request: func() *http.Request {
+				req := newCookieRequest(http.MethodPost)
+				req.Header.Set(CSRFHeader, validToken)
+				req.Header.Set("Sec-Fetch-Site", "cross-site")
+				return req
+			},
+			expected: http.StatusForbidden,
+		},
+		{
+			name: "request not authenticated",
+			request: func() *http.Request {
+				return httptest.NewRequest(http.MethodPost, "http://shipboard.test/clip/", nil)
+			},
+			expected: http.StatusForbidden,
+		},
+	}
+
+	handler := newCSRFTestHandler()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, tt.request())
+			if w.Code != tt.expected {
+				t.Errorf("expected %d, got %d", tt.expected, w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/amns13/shipboard/internal/conf"
+	"github.com/amns13/shipboard/internal/services"
+)
+
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// Set on responses to requests whose key had already been used
+const IdempotentReplayedHeader = "Idempotent-Replayed"
+
+const maxIdempotencyKeyLength = 255
+
+// statusRecorder keeps the status code written by the handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Idempotent makes retries of requests sent with an Idempotency-Key header
+// safe. A key that already succeeded replays the status code of the first
+// request without handling the request again. Failed requests release their
+// key. Only for handlers whose successful responses have no body, and must
+// come after RequireAuth.
+func Idempotent(env *conf.Env) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				http.Error(w, "Idempotency key is too long", http.StatusBadRequest)
+				return
+			}
+			userID, ok := r.Context().Value(AuthUserID).(int32)
+			if !ok {
+				env.Logger.Println("Invalid user id", userID)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+
+			store := services.IdempotencyStore{
+				Client: env.Rdb,
+			}
+			owner := strconv.Itoa(int(userID))
+			status, err := store.Begin(owner, key)
+			if err == services.ErrRequestInProgress {
+				http.Error(w, "A request with this idempotency key is in progress", http.StatusConflict)
+				return
+			}
+			if err != nil {
+				env.Logger.Printf("Error occurred while claiming idempotency key: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			if status != 0 {
+				w.Header().Set(IdempotentReplayedHeader, "true")
+				w.WriteHeader(status)
+				return
+			}
+
+			recorder := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(recorder, r)
+			if recorder.status >= 200 && recorder.status < 300 {
+				err = store.Complete(owner, key, recorder.status)
+			} else {
+				err = store.Release(owner, key)
+			}
+			if err != nil {
+				env.Logger.Printf("Error occurred while saving idempotency key: %v", err)
+			}
+		})
+	}
+}
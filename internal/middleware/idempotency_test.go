@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/amns13/shipboard/internal/conf"
+)
+
+// Requests that never reach redis, env has no client
+func TestIdempotentWithoutRedis(t *testing.T) {
+	env := &conf.Env{Logger: log.New(io.Discard, "", 0)}
+	calls := 0
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusNoContent)
+	})
+	handler := Idempotent(env)(next)
+
+	req := httptest.NewRequest(http.MethodPost, "http://shipboard.test/clip/", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent || calls != 1 {
+		t.Errorf("expected requests without a key to pass through, got %d after %d calls", rec.Code, calls)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "http://shipboard.test/clip/", nil)
+	req.Header.Set(IdempotencyKeyHeader, strings.Repeat("k", maxIdempotencyKeyLength+1))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest || calls != 1 {
+		t.Errorf("expected too long keys to be rejected, got %d after %d calls", rec.Code, calls)
+	}
+}
+
+func TestStatusRecorder(t *testing.T) {
+	rec := &statusRecorder{ResponseWriter: httptest.NewRecorder()}
+	rec.Write([]byte("body"))
+	if rec.status != http.StatusOK {
+		t.Errorf("expected implicit %d, got %d", http.StatusOK, rec.status)
+	}
+	rec = &statusRecorder{ResponseWriter: httptest.NewRecorder()}
+	rec.WriteHeader(http.StatusConflict)
+	rec.Write([]byte("body"))
+	if rec.status != http.StatusConflict {
+		t.Errorf("expected %d, got %d", http.StatusConflict, rec.status)
+	}
+}
@@ -0,0 +1,124 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amns13/shipboard/internal/conf"
+	"github.com/amns13/shipboard/internal/services"
+	"github.com/jackc/pgx/v5"
+)
+
+// Number of blob keys checked against the clips per query
+const orphanBlobBatchSize = 1000
+
+const referencedBlobKeysQuery = `
+SELECT blob_key FROM clips WHERE blob_key = ANY(@keys)
+UNION ALL
+SELECT blob_key FROM clip_representations WHERE blob_key = ANY(@keys);
+`
+
+// CollectOrphanBlobs deletes the clip blobs that no clip references anymore,
+// e.g. after the clip or its user was deleted. Blobs modified within
+// gracePeriod are kept, they may belong to a clip that is being created.
+// Returns the number of deleted blobs.
+func CollectOrphanBlobs(env *conf.Env, gracePeriod time.Duration) (int, error) {
+	cutoff := time.Now().Add(-gracePeriod)
+	var candidates []string
+	err := env.BlobStore.List(CLIP_BLOB_PREFIX, func(info services.BlobInfo) error {
+		if info.ModifiedAt.Before(cutoff) {
+			candidates = append(candidates, info.Key)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for start := 0; start < len(candidates); start += orphanBlobBatchSize {
+		batch := candidates[start:min(start+orphanBlobBatchSize, len(candidates))]
+		rows, _ := env.Db.Query(context.Background(), referencedBlobKeysQuery, pgx.NamedArgs{"keys": batch})
+		referenced, err := pgx.CollectRows(rows, pgx.RowTo[string])
+		if err != nil {
+			return deleted, err
+		}
+		isReferenced := make(map[string]bool, len(referenced))
+		for _, key := range referenced {
+			isReferenced[key] = true
+		}
+		for _, key := range batch {
+			if isReferenced[key] {
+				continue
+			}
+			err = env.BlobStore.Delete(key)
+			if err != nil {
+				return deleted, err
+			}
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// Thumbnails of clips are cached under this prefix, see ThumbnailKey
+const THUMBNAIL_BLOB_PREFIX = "thumbnails/"
+
+const existingClipIDsQuery = `
+SELECT id FROM clips WHERE id = ANY(@ids);
+`
+
+// ThumbnailKey returns the blob key of the thumbnail of the mediaType
+// representation of a clip.
+func ThumbnailKey(clipID int32, mediaType string) string {
+	return fmt.Sprintf("%s%d/%s", THUMBNAIL_BLOB_PREFIX, clipID, strings.ReplaceAll(mediaType, "/", "_"))
+}
+
+// CollectOrphanThumbnails deletes the cached thumbnails of deleted clips.
+// Returns the number of deleted thumbnails.
+func CollectOrphanThumbnails(env *conf.Env) (int, error) {
+	byClip := make(map[int32][]string)
+	err := env.BlobStore.List(THUMBNAIL_BLOB_PREFIX, func(info services.BlobInfo) error {
+		clipID, _, _ := strings.Cut(strings.TrimPrefix(info.Key, THUMBNAIL_BLOB_PREFIX), "/")
+		id, err := strconv.ParseInt(clipID, 10, 32)
+		if err != nil {
+			// Not a thumbnail, leave it alone
+			return nil
+		}
+		byClip[int32(id)] = append(byClip[int32(id)], info.Key)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	clipIDs := make([]int32, 0, len(byClip))
+	for id := range byClip {
+		clipIDs = append(clipIDs, id)
+	}
+
+	deleted := 0
+	for start := 0; start < len(clipIDs); start += orphanBlobBatchSize {
+		batch := clipIDs[start:min(start+orphanBlobBatchSize, len(clipIDs))]
+		rows, _ := env.Db.Query(context.Background(), existingClipIDsQuery, pgx.NamedArgs{"ids": batch})
+		existing, err := pgx.CollectRows(rows, pgx.RowTo[int32])
+		if err != nil {
+			return deleted, err
+		}
+		for _, id := range existing {
+			delete(byClip, id)
+		}
+		for _, id := range batch {
+			for _, key := range byClip[id] {
+				err = env.BlobStore.Delete(key)
+				if err != nil {
+					return deleted, err
+				}
+				deleted++
+			}
+		}
+	}
+	return deleted, nil
+}
@@ -0,0 +1,388 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/amns13/shipboard/internal/conf"
+	"github.com/amns13/shipboard/internal/services"
+	"github.com/amns13/shipboard/internal/store"
+	"github.com/jackc/pgx/v5"
+)
+
+// Clips are defined by the store, so that other stores can keep them
+type Clip = store.Clip
+
+// Content type of text clips
+const TEXT_CLIP_CONTENT_TYPE = store.TEXT_CLIP_CONTENT_TYPE
+
+// Blobs of clips are kept under this prefix
+const CLIP_BLOB_PREFIX = "clips/"
+
+const clipColumns = "id, user_id, content, created_at, once, consumed_at, content_type, file_name, size, blob_key, language, sensitive, codec, note"
+
+const insertClipQuery = `
+INSERT INTO clips (user_id, content, once, size, language, content_hash, search)
+VALUES (@user_id, @content, @once, @size, @language, @content_hash, to_tsvector('simple', @search_text))
+RETURNING ` + clipColumns + `;
+`
+
+const insertSensitiveClipQuery = `
+INSERT INTO clips (user_id, content, size, sensitive)
+VALUES (@user_id, '', @size, true)
+RETURNING ` + clipColumns + `;
+`
+
+// For clips whose payload is data or a blob instead of content
+const insertDataClipQuery = `
+INSERT INTO clips (user_id, content, content_type, file_name, data, size, blob_key, language, content_hash, codec, search)
+VALUES (@user_id, '', @content_type, @file_name, @data, @size, @blob_key, @language, @content_hash, @codec, to_tsvector('simple', @search_text))
+RETURNING ` + clipColumns + `;
+`
+
+// Moves the latest clip with the same payload within the window to the top of
+// the history
+const bumpDuplicateClipQuery = `
+UPDATE clips SET created_at = current_timestamp
+WHERE id = (
+	SELECT id FROM clips
+	WHERE user_id = @user_id
+		AND content_hash = @content_hash
+		AND content_type = @content_type
+		AND file_name IS NOT DISTINCT FROM @file_name
+		AND language IS NOT DISTINCT FROM @language
+		AND created_at > current_timestamp - @window::interval
+	ORDER BY created_at DESC, id DESC
+	LIMIT 1
+)
+RETURNING ` + clipColumns + `;
+`
+
+const clipDataQuery = `
+SELECT data FROM clips WHERE id = @id;
+`
+
+const markClipConsumedQuery = `
+UPDATE clips SET consumed_at = current_timestamp WHERE id = @id AND consumed_at IS NULL;
+`
+
+const clipSelectFromIdQuery = `
+SELECT ` + clipColumns + `
+FROM clips
+WHERE id = @id;
+`
+
+const userClipsQuery = `
+SELECT ` + clipColumns + `
+FROM clips
+WHERE user_id = @user_id
+ORDER BY created_at DESC, id DESC
+LIMIT @limit;
+`
+
+const clipStorageUsageQuery = `
+SELECT user_id, sum(size)::bigint
+FROM clips
+GROUP BY user_id;
+`
+
+// StoredPayload is a payload streamed to the blob store while it was read, so
+// that it is never held in memory. The blob is orphaned if no clip is created
+// with it, see CollectOrphanBlobs.
+type StoredPayload struct {
+	BlobKey string
+	Size    int64
+	// See contentHash
+	Hash []byte
+}
+
+// contentHash returns the hash clips are deduplicated by.
+func contentHash(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+// Only the start of long clips is indexed, a tsvector is at most 1 MB
+const searchTextLimit = 64 << 10
+
+// searchText returns the text a clip is found by: its content, and the name
+// of files.
+func searchText(content string, fileName *string) string {
+	if len(content) > searchTextLimit {
+		// Drops the rune cut in half, if any
+		content = strings.ToValidUTF8(content[:searchTextLimit], "")
+	}
+	if fileName != nil {
+		content += " " + *fileName
+	}
+	return content
+}
+
+// nullIfEmpty returns the value of an optional column, NULL when empty.
+func nullIfEmpty(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
+
+// querier runs queries on the pool, or in a transaction.
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+// CreateClip adds a text clip to the history. Text larger than the
+// compression threshold is compressed, and kept in the blob store if it is
+// still larger than the inline limit, see GetClipData. An empty language is
+// saved as prose.
+func CreateClip(env *conf.Env, userID int32, content string, language string) (*Clip, error) {
+	return createClip(env, env.Db, userID, content, language, contentHash([]byte(content)))
+}
+
+// createClip is CreateClip with the queries run on q. Clips without a hash
+// are never deduplicated.
+func createClip(env *conf.Env, q querier, userID int32, content string, language string, hash []byte) (*Clip, error) {
+	payload, codec, err := compressPayload(TEXT_CLIP_CONTENT_TYPE, []byte(content))
+	if err != nil {
+		return nil, err
+	}
+	if codec != "" || int64(len(content)) > env.BlobInlineLimit {
+		return createDataClip(env, q, userID, nil, TEXT_CLIP_CONTENT_TYPE, language, []byte(content), payload, codec, hash)
+	}
+	args := pgx.NamedArgs{
+		"user_id":      userID,
+		"content":      content,
+		"once":         false,
+		"size":         len(content),
+		"language":     nullIfEmpty(language),
+		"content_hash": hash,
+		"search_text":  searchText(content, nil),
+	}
+	// Returned error will be handled while parsing returnedRows
+	returnedRows, _ := q.Query(context.Background(), insertClipQuery, args)
+	return pgx.CollectOneRow(returnedRows, pgx.RowToAddrOfStructByName[Clip])
+}
+
+// CreateOnceClip adds a burn after reading clip to the history. Only the
+// metadata is saved, the content must be kept elsewhere.
+func CreateOnceClip(env *conf.Env, userID int32, size int) (*Clip, error) {
+	args := pgx.NamedArgs{
+		"user_id":      userID,
+		"content":      "",
+		"once":         true,
+		"size":         size,
+		"language":     nil,
+		"content_hash": nil,
+		"search_text":  "",
+	}
+	// Returned error will be handled while parsing returnedRows
+	returnedRows, _ := env.Db.Query(context.Background(), insertClipQuery, args)
+	return pgx.CollectOneRow(returnedRows, pgx.RowToAddrOfStructByName[Clip])
+}
+
+// CreateSensitiveClip adds a clip that looks like a secret to the history.
+// Only the metadata is saved, the content must be kept elsewhere.
+func CreateSensitiveClip(env *conf.Env, userID int32, size int) (*Clip, error) {
+	args := pgx.NamedArgs{
+		"user_id": userID,
+		"size":    size,
+	}
+	// Returned error will be handled while parsing returnedRows
+	returnedRows, _ := env.Db.Query(context.Background(), insertSensitiveClipQuery, args)
+	return pgx.CollectOneRow(returnedRows, pgx.RowToAddrOfStructByName[Clip])
+}
+
+// BumpDuplicateClip moves the latest clip with the same payload, broadcasted
+// within the window, to the top of the history instead of creating a new one.
+// Text clips have no file name and the default content type, files have no
+// language. Returns pgx.ErrNoRows if there is no such clip.
+func BumpDuplicateClip(env *conf.Env, userID int32, fileName *string, contentType string, language string, data []byte, window time.Duration) (*Clip, error) {
+	return bumpDuplicateClip(env, userID, fileName, contentType, language, contentHash(data), window)
+}
+
+// BumpDuplicateStoredClip is BumpDuplicateClip for a payload streamed to the
+// blob store. The blob of the payload is deleted if a duplicate is bumped.
+func BumpDuplicateStoredClip(env *conf.Env, userID int32, fileName string, contentType string, payload *StoredPayload, window time.Duration) (*Clip, error) {
+	clip, err := bumpDuplicateClip(env, userID, &fileName, contentType, "", payload.Hash, window)
+	if err != nil {
+		return nil, err
+	}
+	// The blob is orphaned if this fails, see CollectOrphanBlobs
+	env.BlobStore.Delete(payload.BlobKey)
+	return clip, nil
+}
+
+func bumpDuplicateClip(env *conf.Env, userID int32, fileName *string, contentType string, language string, hash []byte, window time.Duration) (*Clip, error) {
+	args := pgx.NamedArgs{
+		"user_id":      userID,
+		"content_hash": hash,
+		"content_type": contentType,
+		"file_name":    fileName,
+		"language":     nullIfEmpty(language),
+		"window":       window,
+	}
+	// Returned error will be handled while parsing returnedRows
+	returnedRows, _ := env.Db.Query(context.Background(), bumpDuplicateClipQuery, args)
+	return pgx.CollectOneRow(returnedRows, pgx.RowToAddrOfStructByName[Clip])
+}
+
+func CreateFileClip(env *conf.Env, userID int32, fileName string, contentType string, data []byte) (*Clip, error) {
+	payload, codec, err := compressPayload(contentType, data)
+	if err != nil {
+		return nil, err
+	}
+	return createDataClip(env, env.Db, userID, &fileName, contentType, "", data, payload, codec, contentHash(data))
+}
+
+// CreateStoredFileClip adds a file clip whose payload was streamed to the blob
+// store. Such payloads are kept uncompressed.
+func CreateStoredFileClip(env *conf.Env, userID int32, fileName string, contentType string, payload *StoredPayload) (*Clip, error) {
+	args := pgx.NamedArgs{
+		"user_id":      userID,
+		"content_type": contentType,
+		"file_name":    fileName,
+		"data":         nil,
+		"size":         payload.Size,
+		"blob_key":     payload.BlobKey,
+		"language":     nil,
+		"content_hash": payload.Hash,
+		"codec":        nil,
+		"search_text":  searchText("", &fileName),
+	}
+	// Returned error will be handled while parsing returnedRows
+	returnedRows, _ := env.Db.Query(context.Background(), insertDataClipQuery, args)
+	return pgx.CollectOneRow(returnedRows, pgx.RowToAddrOfStructByName[Clip])
+}
+
+// compressPayload compresses text payloads, see services.Compress. Other
+// files usually are compressed already.
+func compressPayload(contentType string, data []byte) ([]byte, string, error) {
+	if !isPlainText(contentType) {
+		return data, "", nil
+	}
+	return services.Compress(data)
+}
+
+// storePayload keeps data in the blob store if it is larger than the inline
+// limit, and returns its blob key. Otherwise the data is returned to be kept
+// inline. The blob is written first, so it is orphaned if the clip can't be
+// created, see CollectOrphanBlobs.
+func storePayload(env *conf.Env, data []byte) ([]byte, *string, error) {
+	if int64(len(data)) <= env.BlobInlineLimit {
+		return data, nil, nil
+	}
+	blobKey := services.NewBlobKey(CLIP_BLOB_PREFIX)
+	err := env.BlobStore.Put(blobKey, bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, nil, err
+	}
+	return nil, &blobKey, nil
+}
+
+// createDataClip adds a clip whose payload is kept in data or the blob store,
+// with the queries run on q. payload is data compressed with codec, or data
+// itself if codec is empty. hash is the content hash, see createClip.
+func createDataClip(env *conf.Env, q querier, userID int32, fileName *string, contentType string, language string, data []byte, payload []byte, codec string, hash []byte) (*Clip, error) {
+	inline, blobKey, err := storePayload(env, payload)
+	if err != nil {
+		return nil, err
+	}
+	args := pgx.NamedArgs{
+		"user_id":      userID,
+		"content_type": contentType,
+		"file_name":    fileName,
+		"data":         inline,
+		"size":         len(data),
+		"blob_key":     blobKey,
+		"language":     nullIfEmpty(language),
+		"content_hash": hash,
+		"codec":        nullIfEmpty(codec),
+		"search_text":  searchText("", fileName),
+	}
+	if fileName == nil {
+		args["search_text"] = searchText(string(data), nil)
+	}
+	// Returned error will be handled while parsing returnedRows
+	returnedRows, _ := q.Query(context.Background(), insertDataClipQuery, args)
+	return pgx.CollectOneRow(returnedRows, pgx.RowToAddrOfStructByName[Clip])
+}
+
+// GetClipData returns the payload of a clip, wherever it is kept,
+// decompressed.
+func GetClipData(env *conf.Env, clip *Clip) ([]byte, error) {
+	payload, err := GetClipPayload(env, clip)
+	if err != nil || !clip.IsCompressed() {
+		return payload, err
+	}
+	return services.Decompress(payload, *clip.Codec)
+}
+
+// GetClipPayload returns the payload of the clip as it is kept, compressed if
+// the clip is.
+func GetClipPayload(env *conf.Env, clip *Clip) ([]byte, error) {
+	if clip.IsStored() {
+		reader, err := env.BlobStore.Get(*clip.BlobKey)
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return io.ReadAll(reader)
+	}
+	if !clip.IsFile() && !clip.IsCompressed() {
+		return []byte(clip.Content), nil
+	}
+	var data []byte
+	err := env.Db.QueryRow(context.Background(), clipDataQuery, pgx.NamedArgs{"id": clip.Id}).Scan(&data)
+	return data, err
+}
+
+func GetClipByID(env *conf.Env, id int32) (*Clip, error) {
+	// Returned error will be handled while parsing returnedRows
+	returnedRows, _ := env.Db.Query(context.Background(), clipSelectFromIdQuery, pgx.NamedArgs{"id": id})
+	return pgx.CollectOneRow(returnedRows, pgx.RowToAddrOfStructByName[Clip])
+}
+
+// GetUserClips returns the latest clips of the user, newest first. A limit of
+// 0 returns every clip.
+func GetUserClips(env *conf.Env, userID int32, limit int) ([]Clip, error) {
+	args := pgx.NamedArgs{
+		"user_id": userID,
+		"limit":   nil,
+	}
+	if limit > 0 {
+		args["limit"] = limit
+	}
+	// Returned error will be handled while parsing returnedRows
+	returnedRows, _ := env.Db.Query(context.Background(), userClipsQuery, args)
+	return pgx.CollectRows(returnedRows, pgx.RowToStructByName[Clip])
+}
+
+// GetClipStorageUsage returns the bytes of clip content stored per user id.
+// Users without clips are left out.
+func GetClipStorageUsage(env *conf.Env) (map[int32]int64, error) {
+	rows, err := env.Db.Query(context.Background(), clipStorageUsageQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usage := make(map[int32]int64)
+	for rows.Next() {
+		var userID int32
+		var bytes int64
+		if err := rows.Scan(&userID, &bytes); err != nil {
+			return nil, err
+		}
+		usage[userID] = bytes
+	}
+	return usage, rows.Err()
+}
+
+func MarkClipConsumed(env *conf.Env, id int32) error {
+	_, err := env.Db.Exec(context.Background(), markClipConsumedQuery, pgx.NamedArgs{"id": id})
+	return err
+}
@@ -0,0 +1,207 @@
+package model
+
+import (
+	"context"
+
+	"github.com/amns13/shipboard/internal/conf"
+	"github.com/amns13/shipboard/internal/store"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Devices are defined by the store, so that other stores can keep them
+const (
+	DELIVERY_STATE_PENDING   = store.DELIVERY_STATE_PENDING
+	DELIVERY_STATE_DELIVERED = store.DELIVERY_STATE_DELIVERED
+	DELIVERY_STATE_READ      = store.DELIVERY_STATE_READ
+)
+
+type Device = store.Device
+
+type Delivery = store.Delivery
+
+type InboxItem = store.InboxItem
+
+const insertDeviceQuery = `
+INSERT INTO devices (uid, user_id, name)
+VALUES (@uid, @user_id, @name)
+RETURNING *;
+`
+
+const userDevicesQuery = `
+SELECT id, uid, user_id, name, created_at, last_seen_at
+FROM devices
+WHERE user_id = @user_id
+ORDER BY created_at;
+`
+
+const userDeviceQuery = `
+SELECT id, uid, user_id, name, created_at, last_seen_at
+FROM devices
+WHERE user_id = @user_id AND uid = @uid;
+`
+
+const deleteDeviceQuery = `
+DELETE FROM devices WHERE id = @id;
+`
+
+const touchDeviceQuery = `
+UPDATE devices SET last_seen_at = current_timestamp WHERE id = @id;
+`
+
+// A device listed twice gets the clip once
+const insertDeliveryQuery = `
+INSERT INTO clip_deliveries (clip_id, device_id)
+VALUES (@clip_id, @device_id)
+ON CONFLICT (clip_id, device_id) DO NOTHING;
+`
+
+const deviceInboxQuery = `
+SELECT d.state, ` + clipColumns + `
+FROM clip_deliveries d
+JOIN clips c ON c.id = d.clip_id
+WHERE d.device_id = @device_id AND d.state = ANY(@states)
+ORDER BY c.created_at;
+`
+
+// States only move forward, acknowledging a read clip as delivered is a no-op
+const ackDeliveryQuery = `
+UPDATE clip_deliveries
+SET
+    state = CASE WHEN state = 'read' THEN state ELSE @state END,
+    delivered_at = coalesce(delivered_at, current_timestamp),
+    read_at = CASE WHEN @state = 'read' THEN coalesce(read_at, current_timestamp) ELSE read_at END
+WHERE clip_id = @clip_id AND device_id = @device_id;
+`
+
+const clipDeliveriesQuery = `
+SELECT d.clip_id, dev.uid AS device_uid, dev.name AS device_name, d.state, d.delivered_at, d.read_at
+FROM clip_deliveries d
+JOIN devices dev ON dev.id = d.device_id
+WHERE d.clip_id = ANY(@clip_ids)
+ORDER BY dev.name;
+`
+
+func CreateDevice(env *conf.Env, userID int32, name string) (*Device, error) {
+	args := pgx.NamedArgs{
+		"uid":     uuid.New(),
+		"user_id": userID,
+		"name":    name,
+	}
+	// Returned error will be handled while parsing returnedRows
+	returnedRows, _ := env.Db.Query(context.Background(), insertDeviceQuery, args)
+	return pgx.CollectOneRow(returnedRows, pgx.RowToAddrOfStructByName[Device])
+}
+
+func GetUserDevices(env *conf.Env, userID int32) ([]Device, error) {
+	// Returned error will be handled while parsing returnedRows
+	returnedRows, _ := env.Db.Query(context.Background(), userDevicesQuery, pgx.NamedArgs{"user_id": userID})
+	return pgx.CollectRows(returnedRows, pgx.RowToStructByName[Device])
+}
+
+// GetUserDevice returns pgx.ErrNoRows if the device doesn't belong to the user.
+func GetUserDevice(env *conf.Env, userID int32, uid uuid.UUID) (*Device, error) {
+	args := pgx.NamedArgs{
+		"user_id": userID,
+		"uid":     uid,
+	}
+	// Returned error will be handled while parsing returnedRows
+	returnedRows, _ := env.Db.Query(context.Background(), userDeviceQuery, args)
+	return pgx.CollectOneRow(returnedRows, pgx.RowToAddrOfStructByName[Device])
+}
+
+func DeleteDevice(env *conf.Env, id int32) error {
+	_, err := env.Db.Exec(context.Background(), deleteDeviceQuery, pgx.NamedArgs{"id": id})
+	return err
+}
+
+func TouchDevice(env *conf.Env, id int32) error {
+	_, err := env.Db.Exec(context.Background(), touchDeviceQuery, pgx.NamedArgs{"id": id})
+	return err
+}
+
+// CreateDirectedClip adds the clip to the history and to the inbox of each of
+// the devices, in a transaction. The content is kept like CreateClip does.
+func CreateDirectedClip(env *conf.Env, userID int32, content string, language string, deviceIDs []int32) (*Clip, error) {
+	ctx := context.Background()
+	tx, err := env.Db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Rollback is a no-op if the tx has been committed
+	defer tx.Rollback(ctx)
+
+	// Clips sent to devices are never deduplicated
+	clip, err := createClip(env, tx, userID, content, language, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, deviceID := range deviceIDs {
+		_, err = tx.Exec(ctx, insertDeliveryQuery, pgx.NamedArgs{"clip_id": clip.Id, "device_id": deviceID})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return clip, tx.Commit(ctx)
+}
+
+type inboxRow struct {
+	State string `db:"state"`
+	Clip
+}
+
+// GetDeviceInbox returns the clips sent to the device that are in one of the
+// given states, oldest first.
+func GetDeviceInbox(env *conf.Env, deviceID int32, states []string) ([]InboxItem, error) {
+	args := pgx.NamedArgs{
+		"device_id": deviceID,
+		"states":    states,
+	}
+	// Returned error will be handled while parsing returnedRows
+	returnedRows, _ := env.Db.Query(context.Background(), deviceInboxQuery, args)
+	rows, err := pgx.CollectRows(returnedRows, pgx.RowToStructByName[inboxRow])
+	if err != nil {
+		return nil, err
+	}
+	inbox := make([]InboxItem, len(rows))
+	for i, row := range rows {
+		// Large clips are compressed or kept in the blob store
+		content, err := GetClipData(env, &row.Clip)
+		if err != nil {
+			return nil, err
+		}
+		inbox[i] = InboxItem{ClipID: row.Id, Content: string(content), State: row.State, CreatedAt: row.CreatedAt}
+	}
+	return inbox, nil
+}
+
+// AckDelivery moves the delivery to the given state. Returns false if the clip
+// was not sent to the device.
+func AckDelivery(env *conf.Env, clipID int32, deviceID int32, state string) (bool, error) {
+	args := pgx.NamedArgs{
+		"clip_id":   clipID,
+		"device_id": deviceID,
+		"state":     state,
+	}
+	tag, err := env.Db.Exec(context.Background(), ackDeliveryQuery, args)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// GetClipDeliveries returns the deliveries of the clips, keyed by clip id.
+// Clips broadcasted to every device have no deliveries.
+func GetClipDeliveries(env *conf.Env, clipIDs []int32) (map[int32][]Delivery, error) {
+	// Returned error will be handled while parsing returnedRows
+	returnedRows, _ := env.Db.Query(context.Background(), clipDeliveriesQuery, pgx.NamedArgs{"clip_ids": clipIDs})
+	deliveries, err := pgx.CollectRows(returnedRows, pgx.RowToStructByName[Delivery])
+	if err != nil {
+		return nil, err
+	}
+	byClip := make(map[int32][]Delivery)
+	for _, delivery := range deliveries {
+		byClip[delivery.ClipID] = append(byClip[delivery.ClipID], delivery)
+	}
+	return byClip, nil
+}
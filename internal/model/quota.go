@@ -0,0 +1,110 @@
+package model
+
+import (
+	"context"
+
+	"github.com/amns13/shipboard/internal/conf"
+	"github.com/amns13/shipboard/internal/store"
+	"github.com/jackc/pgx/v5"
+)
+
+// Usage is what a user stores, counted by the triggers of the clips.
+type Usage = store.Usage
+
+type QuotaOverride = store.QuotaOverride
+
+const usageQuery = `
+SELECT user_id, clips, bytes FROM user_usage;
+`
+
+const userUsageQuery = `
+SELECT user_id, clips, bytes FROM user_usage WHERE user_id = @user_id;
+`
+
+const quotaOverridesQuery = `
+SELECT user_id, max_clips, max_bytes FROM user_quotas;
+`
+
+const userQuotaOverrideQuery = `
+SELECT user_id, max_clips, max_bytes FROM user_quotas WHERE user_id = @user_id;
+`
+
+const upsertQuotaOverrideQuery = `
+INSERT INTO user_quotas (user_id, max_clips, max_bytes)
+VALUES (@user_id, @max_clips, @max_bytes)
+ON CONFLICT (user_id) DO UPDATE
+SET max_clips = EXCLUDED.max_clips, max_bytes = EXCLUDED.max_bytes, updated_at = current_timestamp;
+`
+
+const deleteQuotaOverrideQuery = `
+DELETE FROM user_quotas WHERE user_id = @user_id;
+`
+
+// GetUsage returns the usage of every user, keyed by user id. Users who never
+// stored a clip are left out.
+func GetUsage(env *conf.Env) (map[int32]Usage, error) {
+	// Returned error will be handled while parsing returnedRows
+	returnedRows, _ := env.Db.Query(context.Background(), usageQuery)
+	usages, err := pgx.CollectRows(returnedRows, pgx.RowToStructByName[Usage])
+	if err != nil {
+		return nil, err
+	}
+	byUser := make(map[int32]Usage, len(usages))
+	for _, usage := range usages {
+		byUser[usage.UserID] = usage
+	}
+	return byUser, nil
+}
+
+func GetUserUsage(env *conf.Env, userID int32) (Usage, error) {
+	// Returned error will be handled while parsing returnedRows
+	returnedRows, _ := env.Db.Query(context.Background(), userUsageQuery, pgx.NamedArgs{"user_id": userID})
+	usage, err := pgx.CollectOneRow(returnedRows, pgx.RowToStructByName[Usage])
+	if err == pgx.ErrNoRows {
+		return Usage{UserID: userID}, nil
+	}
+	return usage, err
+}
+
+// GetQuotaOverrides returns the quotas admins set, keyed by user id.
+func GetQuotaOverrides(env *conf.Env) (map[int32]QuotaOverride, error) {
+	// Returned error will be handled while parsing returnedRows
+	returnedRows, _ := env.Db.Query(context.Background(), quotaOverridesQuery)
+	overrides, err := pgx.CollectRows(returnedRows, pgx.RowToStructByName[QuotaOverride])
+	if err != nil {
+		return nil, err
+	}
+	byUser := make(map[int32]QuotaOverride, len(overrides))
+	for _, override := range overrides {
+		byUser[override.UserID] = override
+	}
+	return byUser, nil
+}
+
+// GetQuotaOverride returns an override without fields if no admin overrode
+// the quota of the user.
+func GetQuotaOverride(env *conf.Env, userID int32) (QuotaOverride, error) {
+	// Returned error will be handled while parsing returnedRows
+	returnedRows, _ := env.Db.Query(context.Background(), userQuotaOverrideQuery, pgx.NamedArgs{"user_id": userID})
+	override, err := pgx.CollectOneRow(returnedRows, pgx.RowToStructByName[QuotaOverride])
+	if err == pgx.ErrNoRows {
+		return QuotaOverride{UserID: userID}, nil
+	}
+	return override, err
+}
+
+// SetQuotaOverride replaces the quota an admin set for the user. An override
+// without fields restores the global quota.
+func SetQuotaOverride(env *conf.Env, override QuotaOverride) error {
+	if override.MaxClips == nil && override.MaxBytes == nil {
+		_, err := env.Db.Exec(context.Background(), deleteQuotaOverrideQuery, pgx.NamedArgs{"user_id": override.UserID})
+		return err
+	}
+	args := pgx.NamedArgs{
+		"user_id":   override.UserID,
+		"max_clips": override.MaxClips,
+		"max_bytes": override.MaxBytes,
+	}
+	_, err := env.Db.Exec(context.Background(), upsertQuotaOverrideQuery, args)
+	return err
+}
@@ -0,0 +1,202 @@
+package model
+
+import (
+	"context"
+	"io"
+	"mime"
+	"strings"
+
+	"github.com/amns13/shipboard/internal/conf"
+	"github.com/amns13/shipboard/internal/services"
+	"github.com/jackc/pgx/v5"
+)
+
+// Representation is one of the other flavors of a clip, e.g. text/html for a
+// clip copied from a web page. The clip itself holds the primary one.
+type Representation struct {
+	ClipID      int32   `db:"clip_id"`
+	ContentType string  `db:"content_type"`
+	Size        int64   `db:"size"`
+	BlobKey     *string `db:"blob_key"`
+}
+
+func (representation *Representation) IsImage() bool {
+	return strings.HasPrefix(representation.ContentType, "image/")
+}
+
+// ClipPayload is a representation of a clip being created.
+type ClipPayload struct {
+	ContentType string
+	// Data is only the start of the payload if it is Stored
+	Data   []byte
+	Stored *StoredPayload
+}
+
+// Size returns the size of the whole payload.
+func (payload *ClipPayload) Size() int64 {
+	if payload.Stored != nil {
+		return payload.Stored.Size
+	}
+	return int64(len(payload.Data))
+}
+
+// store keeps the payload like storePayload does, unless it is stored
+// already.
+func (payload *ClipPayload) store(env *conf.Env) ([]byte, *string, error) {
+	if payload.Stored != nil {
+		return nil, &payload.Stored.BlobKey, nil
+	}
+	return storePayload(env, payload.Data)
+}
+
+// Name of clips whose primary representation is not text
+const richClipFileName = "clip"
+
+const insertRepresentationQuery = `
+INSERT INTO clip_representations (clip_id, content_type, data, blob_key, size)
+VALUES (@clip_id, @content_type, @data, @blob_key, @size);
+`
+
+const clipRepresentationsQuery = `
+SELECT clip_id, content_type, size, blob_key
+FROM clip_representations
+WHERE clip_id = ANY(@clip_ids)
+ORDER BY clip_id, content_type;
+`
+
+const representationDataQuery = `
+SELECT data FROM clip_representations WHERE clip_id = @clip_id AND content_type = @content_type;
+`
+
+func isPlainText(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == "text/plain"
+}
+
+// CreateRichClip adds a clip with several representations to the history, in
+// a transaction. The text/plain one is the primary representation if there is
+// one, so that clients unaware of representations get text. Otherwise the
+// first one is, and the clip is a file.
+func CreateRichClip(env *conf.Env, userID int32, payloads []ClipPayload) (*Clip, error) {
+	primary := 0
+	for i, payload := range payloads {
+		if isPlainText(payload.ContentType) {
+			primary = i
+			break
+		}
+	}
+
+	ctx := context.Background()
+	tx, err := env.Db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Rollback is a no-op if the tx has been committed
+	defer tx.Rollback(ctx)
+
+	var returnedRows pgx.Rows
+	data := payloads[primary].Data
+	if isPlainText(payloads[primary].ContentType) && payloads[primary].Stored == nil && int64(len(data)) <= env.BlobInlineLimit {
+		args := pgx.NamedArgs{
+			"user_id":  userID,
+			"content":  string(data),
+			"once":     false,
+			"size":     len(data),
+			"language": nullIfEmpty(services.DetectLanguage(string(data))),
+			// Clips with several representations are never deduplicated
+			"content_hash": nil,
+			"search_text":  searchText(string(data), nil),
+		}
+		returnedRows, _ = tx.Query(ctx, insertClipQuery, args)
+	} else {
+		inline, blobKey, err := payloads[primary].store(env)
+		if err != nil {
+			return nil, err
+		}
+		args := pgx.NamedArgs{
+			"user_id":      userID,
+			"content_type": payloads[primary].ContentType,
+			"file_name":    nil,
+			"data":         inline,
+			"size":         payloads[primary].Size(),
+			"blob_key":     blobKey,
+			"language":     nil,
+			"content_hash": nil,
+			"codec":        nil,
+			"search_text":  "",
+		}
+		if !isPlainText(payloads[primary].ContentType) {
+			fileName := richClipFileName
+			if extensions, _ := mime.ExtensionsByType(payloads[primary].ContentType); len(extensions) > 0 {
+				fileName += extensions[0]
+			}
+			args["file_name"] = fileName
+		} else {
+			args["search_text"] = searchText(string(data), nil)
+		}
+		returnedRows, _ = tx.Query(ctx, insertDataClipQuery, args)
+	}
+	clip, err := pgx.CollectOneRow(returnedRows, pgx.RowToAddrOfStructByName[Clip])
+	if err != nil {
+		return nil, err
+	}
+
+	for i, payload := range payloads {
+		if i == primary {
+			continue
+		}
+		inline, blobKey, err := payload.store(env)
+		if err != nil {
+			return nil, err
+		}
+		args := pgx.NamedArgs{
+			"clip_id":      clip.Id,
+			"content_type": payload.ContentType,
+			"data":         inline,
+			"blob_key":     blobKey,
+			"size":         payload.Size(),
+		}
+		_, err = tx.Exec(ctx, insertRepresentationQuery, args)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return clip, tx.Commit(ctx)
+}
+
+// GetClipRepresentations returns the other representations of the clips,
+// keyed by clip id. Clips with only their primary representation are left
+// out.
+func GetClipRepresentations(env *conf.Env, clipIDs []int32) (map[int32][]Representation, error) {
+	// Returned error will be handled while parsing returnedRows
+	returnedRows, _ := env.Db.Query(context.Background(), clipRepresentationsQuery, pgx.NamedArgs{"clip_ids": clipIDs})
+	representations, err := pgx.CollectRows(returnedRows, pgx.RowToStructByName[Representation])
+	if err != nil {
+		return nil, err
+	}
+	byClip := make(map[int32][]Representation)
+	for _, representation := range representations {
+		byClip[representation.ClipID] = append(byClip[representation.ClipID], representation)
+	}
+	return byClip, nil
+}
+
+// GetRepresentationData returns the payload of a representation, wherever it
+// is kept.
+func GetRepresentationData(env *conf.Env, representation *Representation) ([]byte, error) {
+	if representation.BlobKey != nil {
+		reader, err := env.BlobStore.Get(*representation.BlobKey)
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return io.ReadAll(reader)
+	}
+	args := pgx.NamedArgs{
+		"clip_id":      representation.ClipID,
+		"content_type": representation.ContentType,
+	}
+	var data []byte
+	err := env.Db.QueryRow(context.Background(), representationDataQuery, args).Scan(&data)
+	return data, err
+}
@@ -0,0 +1,203 @@
+package model
+
+import (
+	"context"
+	"time"
+
+	"github.com/amns13/shipboard/internal/conf"
+	"github.com/amns13/shipboard/internal/services"
+	"github.com/jackc/pgx/v5"
+)
+
+// Number of clips deleted per query by SweepUserClips
+const retentionBatchSize = 500
+
+type retentionPolicy struct {
+	UserID   int32          `db:"user_id"`
+	MaxAge   *time.Duration `db:"max_age"`
+	MaxClips *int           `db:"max_clips"`
+	MaxBytes *int64         `db:"max_bytes"`
+}
+
+func (policy *retentionPolicy) toService() services.RetentionPolicy {
+	var result services.RetentionPolicy
+	if policy.MaxAge != nil {
+		result.MaxAge = *policy.MaxAge
+	}
+	if policy.MaxClips != nil {
+		result.MaxClips = *policy.MaxClips
+	}
+	if policy.MaxBytes != nil {
+		result.MaxBytes = *policy.MaxBytes
+	}
+	return result
+}
+
+// RetentionSweep counts what a sweep deleted.
+type RetentionSweep struct {
+	Clips int
+	Bytes int64
+	Blobs int
+}
+
+const retentionPoliciesQuery = `
+SELECT user_id, max_age, max_clips, max_bytes FROM retention_policies;
+`
+
+const userRetentionPolicyQuery = `
+SELECT user_id, max_age, max_clips, max_bytes FROM retention_policies WHERE user_id = @user_id;
+`
+
+const upsertRetentionPolicyQuery = `
+INSERT INTO retention_policies (user_id, max_age, max_clips, max_bytes)
+VALUES (@user_id, @max_age, @max_clips, @max_bytes)
+ON CONFLICT (user_id) DO UPDATE
+SET max_age = EXCLUDED.max_age, max_clips = EXCLUDED.max_clips, max_bytes = EXCLUDED.max_bytes, updated_at = current_timestamp;
+`
+
+const deleteRetentionPolicyQuery = `
+DELETE FROM retention_policies WHERE user_id = @user_id;
+`
+
+// Clips pinned in a slot are kept, and don't count towards the limits. The
+// blob keys of the representations are returned along with the ones of the
+// clips, they are deleted with the clips.
+const sweepUserClipsQuery = `
+WITH ranked AS (
+	SELECT id, created_at,
+		row_number() OVER newest AS position,
+		sum(size) OVER newest AS total
+	FROM clips c
+	WHERE user_id = @user_id AND NOT EXISTS (SELECT 1 FROM clip_slots s WHERE s.clip_id = c.id)
+	WINDOW newest AS (ORDER BY created_at DESC, id DESC)
+), expired AS (
+	SELECT id FROM ranked
+	WHERE (@max_age::interval IS NOT NULL AND created_at < current_timestamp - @max_age::interval)
+		OR (@max_clips::integer IS NOT NULL AND position > @max_clips)
+		OR (@max_bytes::bigint IS NOT NULL AND total > @max_bytes)
+	LIMIT @batch_size
+), representations AS (
+	SELECT blob_key FROM clip_representations
+	WHERE clip_id IN (SELECT id FROM expired) AND blob_key IS NOT NULL
+), deleted AS (
+	DELETE FROM clips WHERE id IN (SELECT id FROM expired)
+	RETURNING blob_key, size
+)
+SELECT true AS clip, blob_key, size FROM deleted
+UNION ALL
+SELECT false, blob_key, 0 FROM representations;
+`
+
+// nullIfZero returns nil for the zero value, which is stored as NULL.
+func nullIfZero[T comparable](value T) *T {
+	var zero T
+	if value == zero {
+		return nil
+	}
+	return &value
+}
+
+// GetRetentionPolicies returns the retention policies users have set, keyed
+// by user id.
+func GetRetentionPolicies(env *conf.Env) (map[int32]services.RetentionPolicy, error) {
+	// Returned error will be handled while parsing returnedRows
+	returnedRows, _ := env.Db.Query(context.Background(), retentionPoliciesQuery)
+	policies, err := pgx.CollectRows(returnedRows, pgx.RowToStructByName[retentionPolicy])
+	if err != nil {
+		return nil, err
+	}
+	byUser := make(map[int32]services.RetentionPolicy, len(policies))
+	for _, policy := range policies {
+		byUser[policy.UserID] = policy.toService()
+	}
+	return byUser, nil
+}
+
+// GetUserRetentionPolicy returns the zero policy if the user has not set one.
+func GetUserRetentionPolicy(env *conf.Env, userID int32) (services.RetentionPolicy, error) {
+	// Returned error will be handled while parsing returnedRows
+	returnedRows, _ := env.Db.Query(context.Background(), userRetentionPolicyQuery, pgx.NamedArgs{"user_id": userID})
+	policy, err := pgx.CollectOneRow(returnedRows, pgx.RowToStructByName[retentionPolicy])
+	if err == pgx.ErrNoRows {
+		return services.RetentionPolicy{}, nil
+	}
+	if err != nil {
+		return services.RetentionPolicy{}, err
+	}
+	return policy.toService(), nil
+}
+
+// SetUserRetentionPolicy replaces the policy of the user. The zero policy
+// removes it.
+func SetUserRetentionPolicy(env *conf.Env, userID int32, policy services.RetentionPolicy) error {
+	if policy.IsZero() {
+		_, err := env.Db.Exec(context.Background(), deleteRetentionPolicyQuery, pgx.NamedArgs{"user_id": userID})
+		return err
+	}
+	args := pgx.NamedArgs{
+		"user_id":   userID,
+		"max_age":   nullIfZero(policy.MaxAge),
+		"max_clips": nullIfZero(policy.MaxClips),
+		"max_bytes": nullIfZero(policy.MaxBytes),
+	}
+	_, err := env.Db.Exec(context.Background(), upsertRetentionPolicyQuery, args)
+	return err
+}
+
+// SweepUserClips deletes the clips of the user beyond the policy, in batches,
+// along with their blobs. Blobs that fail to be deleted are left to
+// CollectOrphanBlobs.
+func SweepUserClips(env *conf.Env, userID int32, policy services.RetentionPolicy) (RetentionSweep, error) {
+	var sweep RetentionSweep
+	if policy.IsZero() {
+		return sweep, nil
+	}
+	args := pgx.NamedArgs{
+		"user_id":    userID,
+		"max_age":    nullIfZero(policy.MaxAge),
+		"max_clips":  nullIfZero(policy.MaxClips),
+		"max_bytes":  nullIfZero(policy.MaxBytes),
+		"batch_size": retentionBatchSize,
+	}
+	for {
+		rows, err := env.Db.Query(context.Background(), sweepUserClipsQuery, args)
+		if err != nil {
+			return sweep, err
+		}
+		var blobKeys []string
+		clips := 0
+		for rows.Next() {
+			var clip bool
+			var blobKey *string
+			var size int64
+			err = rows.Scan(&clip, &blobKey, &size)
+			if err != nil {
+				rows.Close()
+				return sweep, err
+			}
+			if blobKey != nil {
+				blobKeys = append(blobKeys, *blobKey)
+			}
+			if clip {
+				clips++
+			}
+			sweep.Bytes += size
+		}
+		if err = rows.Err(); err != nil {
+			return sweep, err
+		}
+		sweep.Clips += clips
+
+		for _, key := range blobKeys {
+			err = env.BlobStore.Delete(key)
+			if err != nil {
+				env.Logger.Printf("Error occurred while deleting blob %s of swept clip: %v", key, err)
+				continue
+			}
+			sweep.Blobs++
+		}
+		if clips < retentionBatchSize {
+			return sweep, nil
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package model
+
+import (
+	"context"
+
+	"github.com/amns13/shipboard/internal/conf"
+	"github.com/amns13/shipboard/internal/store"
+	"github.com/jackc/pgx/v5"
+)
+
+// Searches are defined by the store, so that other indexes can run them
+const (
+	SEARCH_TYPE_TEXT  = store.SEARCH_TYPE_TEXT
+	SEARCH_TYPE_FILE  = store.SEARCH_TYPE_FILE
+	SEARCH_TYPE_IMAGE = store.SEARCH_TYPE_IMAGE
+	SEARCH_TYPE_CODE  = store.SEARCH_TYPE_CODE
+)
+
+type ClipSearch = store.ClipSearch
+
+// Burn after reading and sensitive clips are never indexed, their content is
+// not kept
+const searchClipsQuery = `
+SELECT ` + clipColumns + `
+FROM clips
+WHERE user_id = @user_id
+	AND NOT once AND NOT sensitive
+	AND (@query = '' OR search @@ websearch_to_tsquery('simple', @query))
+	AND (@from::timestamptz IS NULL OR created_at >= @from)
+	AND (@to::timestamptz IS NULL OR created_at < @to)
+	AND (@device_id::integer IS NULL OR EXISTS (
+		SELECT 1 FROM clip_deliveries d WHERE d.clip_id = clips.id AND d.device_id = @device_id
+	))
+	AND (@tag = '' OR id IN (
+		SELECT ct.clip_id FROM clip_tags ct JOIN tags t ON t.id = ct.tag_id WHERE t.user_id = @user_id AND t.name = @tag
+	))
+	AND CASE @type::text
+		WHEN '' THEN true
+		WHEN 'text' THEN file_name IS NULL AND content_type LIKE 'text/plain%'
+		WHEN 'file' THEN file_name IS NOT NULL
+		WHEN 'image' THEN content_type LIKE 'image/%'
+		WHEN 'code' THEN language IS NOT NULL
+		ELSE language = @type
+	END
+ORDER BY ts_rank(search, websearch_to_tsquery('simple', @query)) DESC, created_at DESC, id DESC
+LIMIT @limit;
+`
+
+// SearchClips returns the clips of the user matching search, best matches
+// first.
+func SearchClips(env *conf.Env, userID int32, search ClipSearch, limit int) ([]Clip, error) {
+	args := pgx.NamedArgs{
+		"user_id":   userID,
+		"query":     search.Query,
+		"from":      search.From,
+		"to":        search.To,
+		"device_id": search.DeviceID,
+		"type":      search.Type,
+		"tag":       search.Tag,
+		"limit":     limit,
+	}
+	// Returned error will be handled while parsing returnedRows
+	returnedRows, _ := env.Db.Query(context.Background(), searchClipsQuery, args)
+	return pgx.CollectRows(returnedRows, pgx.RowToStructByName[Clip])
+}
@@ -0,0 +1,80 @@
+package model
+
+import (
+	"context"
+
+	"github.com/amns13/shipboard/internal/conf"
+	"github.com/amns13/shipboard/internal/store"
+	"github.com/jackc/pgx/v5"
+)
+
+// Slots are defined by the store, so that other stores can keep them
+type Slot = store.Slot
+
+const slotSelectQuery = `
+SELECT s.name, s.updated_at, c.*
+FROM clip_slots s
+JOIN (SELECT ` + clipColumns + ` FROM clips) c ON c.id = s.clip_id
+`
+
+const userSlotsQuery = slotSelectQuery + `
+WHERE s.user_id = @user_id
+ORDER BY s.name;
+`
+
+const userSlotQuery = slotSelectQuery + `
+WHERE s.user_id = @user_id AND s.name = @name;
+`
+
+const upsertSlotQuery = `
+INSERT INTO clip_slots (user_id, name, clip_id)
+VALUES (@user_id, @name, @clip_id)
+ON CONFLICT (user_id, name) DO UPDATE
+SET clip_id = EXCLUDED.clip_id, updated_at = current_timestamp;
+`
+
+const deleteSlotQuery = `
+DELETE FROM clip_slots WHERE user_id = @user_id AND name = @name;
+`
+
+// SetSlot pins the clip under the name, replacing the clip pinned there
+// before if any.
+func SetSlot(env *conf.Env, userID int32, name string, clipID int32) (*Slot, error) {
+	args := pgx.NamedArgs{
+		"user_id": userID,
+		"name":    name,
+		"clip_id": clipID,
+	}
+	_, err := env.Db.Exec(context.Background(), upsertSlotQuery, args)
+	if err != nil {
+		return nil, err
+	}
+	return GetUserSlot(env, userID, name)
+}
+
+func GetUserSlots(env *conf.Env, userID int32) ([]Slot, error) {
+	// Returned error will be handled while parsing returnedRows
+	returnedRows, _ := env.Db.Query(context.Background(), userSlotsQuery, pgx.NamedArgs{"user_id": userID})
+	return pgx.CollectRows(returnedRows, pgx.RowToStructByName[Slot])
+}
+
+// GetUserSlot returns pgx.ErrNoRows if the user has no slot with the name.
+func GetUserSlot(env *conf.Env, userID int32, name string) (*Slot, error) {
+	args := pgx.NamedArgs{
+		"user_id": userID,
+		"name":    name,
+	}
+	// Returned error will be handled while parsing returnedRows
+	returnedRows, _ := env.Db.Query(context.Background(), userSlotQuery, args)
+	return pgx.CollectOneRow(returnedRows, pgx.RowToAddrOfStructByName[Slot])
+}
+
+// DeleteSlot unpins the clip of the slot, the clip stays in the history.
+// Returns pgx.ErrNoRows if the user has no slot with the name.
+func DeleteSlot(env *conf.Env, userID int32, name string) error {
+	tag, err := env.Db.Exec(context.Background(), deleteSlotQuery, pgx.NamedArgs{"user_id": userID, "name": name})
+	if err == nil && tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return err
+}
@@ -0,0 +1,272 @@
+package model
+
+import (
+	"time"
+
+	"github.com/amns13/shipboard/internal/conf"
+	"github.com/amns13/shipboard/internal/services"
+	"github.com/amns13/shipboard/internal/store"
+	"github.com/google/uuid"
+)
+
+// PostgresUserStore keeps users in Postgres, see store.UserStore.
+type PostgresUserStore struct {
+	Env *conf.Env
+}
+
+func (s *PostgresUserStore) CreateUser(creator UserCreator) (*User, error) {
+	return CreateUser(s.Env, creator)
+}
+
+func (s *PostgresUserStore) UserExists(email string) (bool, error) {
+	return UserExists(s.Env, email)
+}
+
+func (s *PostgresUserStore) GetUserByEmail(email string) (*User, error) {
+	return GetUserByEmail(s.Env, email)
+}
+
+func (s *PostgresUserStore) GetUserByID(id int32) (*User, error) {
+	return GetUserByID(s.Env, id)
+}
+
+func (s *PostgresUserStore) GetQuotaOverride(userID int32) (QuotaOverride, error) {
+	return GetQuotaOverride(s.Env, userID)
+}
+
+func (s *PostgresUserStore) GetUserRetentionPolicy(userID int32) (services.RetentionPolicy, error) {
+	return GetUserRetentionPolicy(s.Env, userID)
+}
+
+func (s *PostgresUserStore) SetUserRetentionPolicy(userID int32, policy services.RetentionPolicy) error {
+	return SetUserRetentionPolicy(s.Env, userID, policy)
+}
+
+func (s *PostgresUserStore) UpdatePasswordHash(id int32, passwordHash string) error {
+	return UpdatePasswordHash(s.Env, id, passwordHash)
+}
+
+func (s *PostgresUserStore) UpdateEmail(id int32, email string) error {
+	return UpdateEmail(s.Env, id, email)
+}
+
+func (s *PostgresUserStore) SetUserDisabled(id int32, disabled bool) error {
+	return SetUserDisabled(s.Env, id, disabled)
+}
+
+func (s *PostgresUserStore) DeleteUser(id int32, cleanup func() error) error {
+	return DeleteUser(s.Env, id, cleanup)
+}
+
+// PostgresClipStore keeps clips in Postgres, and their large payloads in the
+// blob store, see store.ClipStore.
+type PostgresClipStore struct {
+	Env *conf.Env
+}
+
+func (s *PostgresClipStore) CreateClip(userID int32, content string, language string) (*Clip, error) {
+	return CreateClip(s.Env, userID, content, language)
+}
+
+func (s *PostgresClipStore) CreateOnceClip(userID int32, size int) (*Clip, error) {
+	return CreateOnceClip(s.Env, userID, size)
+}
+
+func (s *PostgresClipStore) CreateSensitiveClip(userID int32, size int) (*Clip, error) {
+	return CreateSensitiveClip(s.Env, userID, size)
+}
+
+func (s *PostgresClipStore) CreateFileClip(userID int32, fileName string, contentType string, data []byte) (*Clip, error) {
+	return CreateFileClip(s.Env, userID, fileName, contentType, data)
+}
+
+func (s *PostgresClipStore) BumpDuplicateClip(userID int32, fileName *string, contentType string, language string, data []byte, window time.Duration) (*Clip, error) {
+	return BumpDuplicateClip(s.Env, userID, fileName, contentType, language, data, window)
+}
+
+func (s *PostgresClipStore) GetClipByID(id int32) (*Clip, error) {
+	return GetClipByID(s.Env, id)
+}
+
+func (s *PostgresClipStore) GetUserClips(userID int32, limit int) ([]Clip, error) {
+	return GetUserClips(s.Env, userID, limit)
+}
+
+func (s *PostgresClipStore) GetClipData(clip *Clip) ([]byte, error) {
+	return GetClipData(s.Env, clip)
+}
+
+func (s *PostgresClipStore) GetUserUsage(userID int32) (Usage, error) {
+	return GetUserUsage(s.Env, userID)
+}
+
+func (s *PostgresClipStore) MarkClipConsumed(id int32) error {
+	return MarkClipConsumed(s.Env, id)
+}
+
+// PostgresDeviceStore keeps devices and their inboxes in Postgres, see
+// store.DeviceStore.
+type PostgresDeviceStore struct {
+	Env *conf.Env
+}
+
+func (s *PostgresDeviceStore) CreateDevice(userID int32, name string) (*Device, error) {
+	return CreateDevice(s.Env, userID, name)
+}
+
+func (s *PostgresDeviceStore) GetUserDevices(userID int32) ([]Device, error) {
+	return GetUserDevices(s.Env, userID)
+}
+
+func (s *PostgresDeviceStore) GetUserDevice(userID int32, uid uuid.UUID) (*Device, error) {
+	return GetUserDevice(s.Env, userID, uid)
+}
+
+func (s *PostgresDeviceStore) DeleteDevice(id int32) error {
+	return DeleteDevice(s.Env, id)
+}
+
+func (s *PostgresDeviceStore) TouchDevice(id int32) error {
+	return TouchDevice(s.Env, id)
+}
+
+func (s *PostgresDeviceStore) CreateDirectedClip(userID int32, content string, language string, deviceIDs []int32) (*Clip, error) {
+	return CreateDirectedClip(s.Env, userID, content, language, deviceIDs)
+}
+
+func (s *PostgresDeviceStore) GetDeviceInbox(deviceID int32, states []string) ([]InboxItem, error) {
+	return GetDeviceInbox(s.Env, deviceID, states)
+}
+
+func (s *PostgresDeviceStore) AckDelivery(clipID int32, deviceID int32, state string) (bool, error) {
+	return AckDelivery(s.Env, clipID, deviceID, state)
+}
+
+func (s *PostgresDeviceStore) GetClipDeliveries(clipIDs []int32) (map[int32][]Delivery, error) {
+	return GetClipDeliveries(s.Env, clipIDs)
+}
+
+// PostgresTeamStore keeps teams in Postgres, see store.TeamStore.
+type PostgresTeamStore struct {
+	Env *conf.Env
+}
+
+func (s *PostgresTeamStore) CreateTeam(name string, ownerID int32) (*Team, error) {
+	return CreateTeam(s.Env, name, ownerID)
+}
+
+func (s *PostgresTeamStore) AddTeamMember(teamID int32, userID int32, role string) (bool, error) {
+	return AddTeamMember(s.Env, teamID, userID, role)
+}
+
+func (s *PostgresTeamStore) RemoveTeamMember(teamID int32, userID int32) error {
+	return RemoveTeamMember(s.Env, teamID, userID)
+}
+
+func (s *PostgresTeamStore) CreateTeamChannel(teamID int32, name string) (*TeamChannel, error) {
+	return CreateTeamChannel(s.Env, teamID, name)
+}
+
+func (s *PostgresTeamStore) GetUserTeams(userID int32) ([]TeamMembership, error) {
+	return GetUserTeams(s.Env, userID)
+}
+
+func (s *PostgresTeamStore) GetUserTeam(userID int32, teamID int32) (*TeamMembership, error) {
+	return GetUserTeam(s.Env, userID, teamID)
+}
+
+func (s *PostgresTeamStore) GetTeamMembers(teamID int32) ([]TeamMember, error) {
+	return GetTeamMembers(s.Env, teamID)
+}
+
+func (s *PostgresTeamStore) GetTeamChannels(teamID int32) ([]TeamChannel, error) {
+	return GetTeamChannels(s.Env, teamID)
+}
+
+func (s *PostgresTeamStore) GetTeamChannel(teamID int32, name string) (*TeamChannel, error) {
+	return GetTeamChannel(s.Env, teamID, name)
+}
+
+// PostgresTagStore keeps tags and notes in Postgres, see store.TagStore.
+type PostgresTagStore struct {
+	Env *conf.Env
+}
+
+func (s *PostgresTagStore) SetClipTags(userID int32, clipID int32, names []string) error {
+	return SetClipTags(s.Env, userID, clipID, names)
+}
+
+func (s *PostgresTagStore) GetClipTags(clipIDs []int32) (map[int32][]string, error) {
+	return GetClipTags(s.Env, clipIDs)
+}
+
+func (s *PostgresTagStore) GetUserTags(userID int32) ([]Tag, error) {
+	return GetUserTags(s.Env, userID)
+}
+
+func (s *PostgresTagStore) RenameTag(userID int32, name string, newName string) error {
+	return RenameTag(s.Env, userID, name, newName)
+}
+
+func (s *PostgresTagStore) SetClipNote(clipID int32, note *string) error {
+	return SetClipNote(s.Env, clipID, note)
+}
+
+func (s *PostgresTagStore) GetUserClipsByTag(userID int32, name string, limit int) ([]Clip, error) {
+	return GetUserClipsByTag(s.Env, userID, name, limit)
+}
+
+// PostgresSlotStore keeps slots in Postgres, see store.SlotStore.
+type PostgresSlotStore struct {
+	Env *conf.Env
+}
+
+func (s *PostgresSlotStore) SetSlot(userID int32, name string, clipID int32) (*Slot, error) {
+	return SetSlot(s.Env, userID, name, clipID)
+}
+
+func (s *PostgresSlotStore) GetUserSlots(userID int32) ([]Slot, error) {
+	return GetUserSlots(s.Env, userID)
+}
+
+func (s *PostgresSlotStore) GetUserSlot(userID int32, name string) (*Slot, error) {
+	return GetUserSlot(s.Env, userID, name)
+}
+
+func (s *PostgresSlotStore) DeleteSlot(userID int32, name string) error {
+	return DeleteSlot(s.Env, userID, name)
+}
+
+// PostgresSearchIndex searches clips with the full text search of Postgres,
+// see store.SearchIndex.
+type PostgresSearchIndex struct {
+	Env *conf.Env
+}
+
+func (s *PostgresSearchIndex) SearchClips(userID int32, search ClipSearch, limit int) ([]Clip, error) {
+	return SearchClips(s.Env, userID, search, limit)
+}
+
+// UsePostgres keeps the accounts, clips and everything attached to them in
+// Postgres. Sessions, the clipboard and tokens are kept in redis by
+// conf.LoadEnv.
+func UsePostgres(env *conf.Env) {
+	env.Users = &PostgresUserStore{Env: env}
+	env.Clips = &PostgresClipStore{Env: env}
+	env.Devices = &PostgresDeviceStore{Env: env}
+	env.Teams = &PostgresTeamStore{Env: env}
+	env.Tags = &PostgresTagStore{Env: env}
+	env.Slots = &PostgresSlotStore{Env: env}
+	env.Search = &PostgresSearchIndex{Env: env}
+}
+
+// Checks that the Postgres stores implement the interfaces
+var (
+	_ store.UserStore   = (*PostgresUserStore)(nil)
+	_ store.ClipStore   = (*PostgresClipStore)(nil)
+	_ store.DeviceStore = (*PostgresDeviceStore)(nil)
+	_ store.TeamStore   = (*PostgresTeamStore)(nil)
+	_ store.TagStore    = (*PostgresTagStore)(nil)
+	_ store.SlotStore   = (*PostgresSlotStore)(nil)
+	_ store.SearchIndex = (*PostgresSearchIndex)(nil)
+)
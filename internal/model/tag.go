@@ -0,0 +1,194 @@
+package model
+
+import (
+	"context"
+
+	"github.com/amns13/shipboard/internal/conf"
+	"github.com/amns13/shipboard/internal/store"
+	"github.com/jackc/pgx/v5"
+)
+
+// Tags are defined by the store, so that other stores can keep them
+type Tag = store.Tag
+
+type clipTag struct {
+	ClipID int32  `db:"clip_id"`
+	Name   string `db:"name"`
+}
+
+const deleteClipTagsQuery = `
+DELETE FROM clip_tags WHERE clip_id = @clip_id;
+`
+
+const insertTagsQuery = `
+INSERT INTO tags (user_id, name)
+SELECT @user_id, unnest(@names::text[])
+ON CONFLICT (user_id, name) DO NOTHING;
+`
+
+const insertClipTagsQuery = `
+INSERT INTO clip_tags (clip_id, tag_id)
+SELECT @clip_id, id FROM tags WHERE user_id = @user_id AND name = ANY(@names)
+ON CONFLICT DO NOTHING;
+`
+
+// Tags are only kept while they are on some clip
+const deleteUnusedTagsQuery = `
+DELETE FROM tags t
+WHERE user_id = @user_id AND NOT EXISTS (SELECT 1 FROM clip_tags ct WHERE ct.tag_id = t.id);
+`
+
+const clipTagsQuery = `
+SELECT ct.clip_id, t.name
+FROM clip_tags ct
+JOIN tags t ON t.id = ct.tag_id
+WHERE ct.clip_id = ANY(@clip_ids)
+ORDER BY t.name;
+`
+
+const userTagsQuery = `
+SELECT t.name, count(*) AS clips
+FROM tags t
+JOIN clip_tags ct ON ct.tag_id = t.id
+WHERE t.user_id = @user_id
+GROUP BY t.name
+ORDER BY t.name;
+`
+
+const userTagIDQuery = `
+SELECT id FROM tags WHERE user_id = @user_id AND name = @name;
+`
+
+const renameTagQuery = `
+UPDATE tags SET name = @name WHERE id = @id;
+`
+
+const mergeTagQuery = `
+INSERT INTO clip_tags (clip_id, tag_id)
+SELECT clip_id, @to_id FROM clip_tags WHERE tag_id = @from_id
+ON CONFLICT DO NOTHING;
+`
+
+const deleteTagQuery = `
+DELETE FROM tags WHERE id = @id;
+`
+
+const setClipNoteQuery = `
+UPDATE clips SET note = @note WHERE id = @id;
+`
+
+const userClipsByTagQuery = `
+SELECT ` + clipColumns + `
+FROM clips
+WHERE user_id = @user_id AND id IN (
+	SELECT ct.clip_id
+	FROM clip_tags ct
+	JOIN tags t ON t.id = ct.tag_id
+	WHERE t.user_id = @user_id AND t.name = @name
+)
+ORDER BY created_at DESC, id DESC
+LIMIT @limit;
+`
+
+// SetClipTags replaces the tags of a clip of the user. Tags are created as
+// needed.
+func SetClipTags(env *conf.Env, userID int32, clipID int32, names []string) error {
+	ctx := context.Background()
+	tx, err := env.Db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op if the tx has been committed
+	defer tx.Rollback(ctx)
+
+	args := pgx.NamedArgs{
+		"user_id": userID,
+		"clip_id": clipID,
+		"names":   names,
+	}
+	for _, query := range []string{deleteClipTagsQuery, insertTagsQuery, insertClipTagsQuery, deleteUnusedTagsQuery} {
+		_, err = tx.Exec(ctx, query, args)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// GetClipTags returns the tag names of the clips, keyed by clip id.
+func GetClipTags(env *conf.Env, clipIDs []int32) (map[int32][]string, error) {
+	// Returned error will be handled while parsing returnedRows
+	returnedRows, _ := env.Db.Query(context.Background(), clipTagsQuery, pgx.NamedArgs{"clip_ids": clipIDs})
+	tags, err := pgx.CollectRows(returnedRows, pgx.RowToStructByName[clipTag])
+	if err != nil {
+		return nil, err
+	}
+	byClip := make(map[int32][]string)
+	for _, tag := range tags {
+		byClip[tag.ClipID] = append(byClip[tag.ClipID], tag.Name)
+	}
+	return byClip, nil
+}
+
+func GetUserTags(env *conf.Env, userID int32) ([]Tag, error) {
+	// Returned error will be handled while parsing returnedRows
+	returnedRows, _ := env.Db.Query(context.Background(), userTagsQuery, pgx.NamedArgs{"user_id": userID})
+	return pgx.CollectRows(returnedRows, pgx.RowToStructByName[Tag])
+}
+
+// RenameTag renames a tag of the user on all of its clips. Renaming to a tag
+// the user already has merges both. Returns pgx.ErrNoRows if the user has no
+// tag with the name.
+func RenameTag(env *conf.Env, userID int32, name string, newName string) error {
+	ctx := context.Background()
+	tx, err := env.Db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op if the tx has been committed
+	defer tx.Rollback(ctx)
+
+	var fromID int32
+	err = tx.QueryRow(ctx, userTagIDQuery, pgx.NamedArgs{"user_id": userID, "name": name}).Scan(&fromID)
+	if err != nil {
+		return err
+	}
+	var toID int32
+	err = tx.QueryRow(ctx, userTagIDQuery, pgx.NamedArgs{"user_id": userID, "name": newName}).Scan(&toID)
+	switch {
+	case err == pgx.ErrNoRows:
+		_, err = tx.Exec(ctx, renameTagQuery, pgx.NamedArgs{"id": fromID, "name": newName})
+	case err != nil:
+		return err
+	case toID == fromID:
+		return nil
+	default:
+		_, err = tx.Exec(ctx, mergeTagQuery, pgx.NamedArgs{"from_id": fromID, "to_id": toID})
+		if err == nil {
+			_, err = tx.Exec(ctx, deleteTagQuery, pgx.NamedArgs{"id": fromID})
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// SetClipNote sets the note of a clip, nil removes it.
+func SetClipNote(env *conf.Env, clipID int32, note *string) error {
+	_, err := env.Db.Exec(context.Background(), setClipNoteQuery, pgx.NamedArgs{"id": clipID, "note": note})
+	return err
+}
+
+// GetUserClipsByTag returns the latest clips of the user with the tag, newest
+// first.
+func GetUserClipsByTag(env *conf.Env, userID int32, name string, limit int) ([]Clip, error) {
+	args := pgx.NamedArgs{
+		"user_id": userID,
+		"name":    name,
+		"limit":   limit,
+	}
+	// Returned error will be handled while parsing returnedRows
+	returnedRows, _ := env.Db.Query(context.Background(), userClipsByTagQuery, args)
+	return pgx.CollectRows(returnedRows, pgx.RowToStructByName[Clip])
+}
@@ -0,0 +1,206 @@
+package model
+
+import (
+	"context"
+
+	"github.com/amns13/shipboard/internal/conf"
+	"github.com/amns13/shipboard/internal/store"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Teams are defined by the store, so that other stores can keep them
+const (
+	TEAM_ROLE_OWNER  = store.TEAM_ROLE_OWNER
+	TEAM_ROLE_WRITER = store.TEAM_ROLE_WRITER
+	TEAM_ROLE_READER = store.TEAM_ROLE_READER
+)
+
+const DEFAULT_TEAM_CHANNEL = store.DEFAULT_TEAM_CHANNEL
+
+type Team = store.Team
+
+type TeamMembership = store.TeamMembership
+
+type TeamMember = store.TeamMember
+
+type TeamChannel = store.TeamChannel
+
+func CanReadTeam(role string) bool {
+	return role == TEAM_ROLE_OWNER || role == TEAM_ROLE_WRITER || role == TEAM_ROLE_READER
+}
+
+func CanWriteTeam(role string) bool {
+	return role == TEAM_ROLE_OWNER || role == TEAM_ROLE_WRITER
+}
+
+func CanManageTeam(role string) bool {
+	return role == TEAM_ROLE_OWNER
+}
+
+const insertTeamQuery = `
+INSERT INTO teams (uid, name)
+VALUES (@uid, @name)
+RETURNING *;
+`
+
+const insertTeamMemberQuery = `
+INSERT INTO team_members (team_id, user_id, role)
+VALUES (@team_id, @user_id, @role)
+ON CONFLICT (team_id, user_id) DO NOTHING;
+`
+
+const deleteTeamMemberQuery = `
+DELETE FROM team_members WHERE team_id = @team_id AND user_id = @user_id;
+`
+
+const insertTeamChannelQuery = `
+INSERT INTO team_channels (uid, team_id, name)
+VALUES (@uid, @team_id, @name)
+RETURNING *;
+`
+
+const userTeamsQuery = `
+SELECT t.id, t.uid, t.name, t.created_at, m.role
+FROM teams t
+JOIN team_members m ON m.team_id = t.id
+WHERE m.user_id = @user_id
+ORDER BY t.name;
+`
+
+const userTeamQuery = `
+SELECT t.id, t.uid, t.name, t.created_at, m.role
+FROM teams t
+JOIN team_members m ON m.team_id = t.id
+WHERE m.user_id = @user_id AND t.id = @team_id;
+`
+
+const teamMembersQuery = `
+SELECT m.user_id, u.name, u.email, m.role, m.joined_at
+FROM team_members m
+JOIN users u ON u.id = m.user_id
+WHERE m.team_id = @team_id
+ORDER BY m.joined_at;
+`
+
+const teamChannelsQuery = `
+SELECT id, uid, team_id, name, created_at
+FROM team_channels
+WHERE team_id = @team_id
+ORDER BY name;
+`
+
+const teamChannelQuery = `
+SELECT id, uid, team_id, name, created_at
+FROM team_channels
+WHERE team_id = @team_id AND name = @name;
+`
+
+// CreateTeam creates the team with the user as its owner, and the default
+// channel.
+func CreateTeam(env *conf.Env, name string, ownerID int32) (*Team, error) {
+	ctx := context.Background()
+	tx, err := env.Db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Rollback is a no-op if the tx has been committed
+	defer tx.Rollback(ctx)
+
+	returnedRows, _ := tx.Query(ctx, insertTeamQuery, pgx.NamedArgs{"uid": uuid.New(), "name": name})
+	team, err := pgx.CollectOneRow(returnedRows, pgx.RowToAddrOfStructByName[Team])
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(ctx, insertTeamMemberQuery, pgx.NamedArgs{
+		"team_id": team.Id,
+		"user_id": ownerID,
+		"role":    TEAM_ROLE_OWNER,
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(ctx, insertTeamChannelQuery, pgx.NamedArgs{
+		"uid":     uuid.New(),
+		"team_id": team.Id,
+		"name":    DEFAULT_TEAM_CHANNEL,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return team, tx.Commit(ctx)
+}
+
+// AddTeamMember adds the user to the team. Returns false if they are already
+// a member, whose role is kept.
+func AddTeamMember(env *conf.Env, teamID int32, userID int32, role string) (bool, error) {
+	args := pgx.NamedArgs{
+		"team_id": teamID,
+		"user_id": userID,
+		"role":    role,
+	}
+	tag, err := env.Db.Exec(context.Background(), insertTeamMemberQuery, args)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func RemoveTeamMember(env *conf.Env, teamID int32, userID int32) error {
+	args := pgx.NamedArgs{
+		"team_id": teamID,
+		"user_id": userID,
+	}
+	_, err := env.Db.Exec(context.Background(), deleteTeamMemberQuery, args)
+	return err
+}
+
+func CreateTeamChannel(env *conf.Env, teamID int32, name string) (*TeamChannel, error) {
+	args := pgx.NamedArgs{
+		"uid":     uuid.New(),
+		"team_id": teamID,
+		"name":    name,
+	}
+	// Returned error will be handled while parsing returnedRows
+	returnedRows, _ := env.Db.Query(context.Background(), insertTeamChannelQuery, args)
+	return pgx.CollectOneRow(returnedRows, pgx.RowToAddrOfStructByName[TeamChannel])
+}
+
+func GetUserTeams(env *conf.Env, userID int32) ([]TeamMembership, error) {
+	// Returned error will be handled while parsing returnedRows
+	returnedRows, _ := env.Db.Query(context.Background(), userTeamsQuery, pgx.NamedArgs{"user_id": userID})
+	return pgx.CollectRows(returnedRows, pgx.RowToStructByName[TeamMembership])
+}
+
+// GetUserTeam returns pgx.ErrNoRows if the user is not a member of the team.
+func GetUserTeam(env *conf.Env, userID int32, teamID int32) (*TeamMembership, error) {
+	args := pgx.NamedArgs{
+		"user_id": userID,
+		"team_id": teamID,
+	}
+	// Returned error will be handled while parsing returnedRows
+	returnedRows, _ := env.Db.Query(context.Background(), userTeamQuery, args)
+	return pgx.CollectOneRow(returnedRows, pgx.RowToAddrOfStructByName[TeamMembership])
+}
+
+func GetTeamMembers(env *conf.Env, teamID int32) ([]TeamMember, error) {
+	// Returned error will be handled while parsing returnedRows
+	returnedRows, _ := env.Db.Query(context.Background(), teamMembersQuery, pgx.NamedArgs{"team_id": teamID})
+	return pgx.CollectRows(returnedRows, pgx.RowToStructByName[TeamMember])
+}
+
+func GetTeamChannels(env *conf.Env, teamID int32) ([]TeamChannel, error) {
+	// Returned error will be handled while parsing returnedRows
+	returnedRows, _ := env.Db.Query(context.Background(), teamChannelsQuery, pgx.NamedArgs{"team_id": teamID})
+	return pgx.CollectRows(returnedRows, pgx.RowToStructByName[TeamChannel])
+}
+
+func GetTeamChannel(env *conf.Env, teamID int32, name string) (*TeamChannel, error) {
+	args := pgx.NamedArgs{
+		"team_id": teamID,
+		"name":    name,
+	}
+	// Returned error will be handled while parsing returnedRows
+	returnedRows, _ := env.Db.Query(context.Background(), teamChannelQuery, args)
+	return pgx.CollectOneRow(returnedRows, pgx.RowToAddrOfStructByName[TeamChannel])
+}
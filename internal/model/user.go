@@ -2,26 +2,22 @@ package model
 
 import (
 	"context"
-	"net/mail"
-	"time"
 
 	"github.com/amns13/shipboard/internal/conf"
+	"github.com/amns13/shipboard/internal/store"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 )
 
-type UserCreator struct {
-	Name         string `db:"name"`
-	Email        string `db:"email"`
-	PasswordHash string `db:"password_hash"`
-}
+// Users are defined by the store, so that other stores can keep them
+type UserCreator = store.UserCreator
 
-type User struct {
-	Id        int32     `db:"id"`
-	Uid       uuid.UUID `db:"uid"`
-	CreatedAt time.Time `db:"created_at"`
-	UserCreator
-}
+type User = store.User
+
+const (
+	ROLE_USER  = store.ROLE_USER
+	ROLE_ADMIN = store.ROLE_ADMIN
+)
 
 // For now, we return everything and use returning. If for some hypothetical reason,
 // we need to scale, that can be done easily.
@@ -37,18 +33,42 @@ SELECT EXISTS(SELECT 1 FROM users WHERE email = @email);
 
 // TODO: Some fields are unneeded. Keeping them for now.
 const userSelectFromEmailQuery = `
-SELECT id, uid, email, password_hash, name, created_at
+SELECT id, uid, email, password_hash, name, created_at, role, disabled_at
 FROM users
 WHERE email = @email;
 `
 
 const userSelectFromIdQuery = `
-SELECT id, uid, email, password_hash, name, created_at
+SELECT id, uid, email, password_hash, name, created_at, role, disabled_at
 FROM users
 WHERE id = @id;
 `
 
-func (usr *UserCreator) Create(env *conf.Env) (*User, error) {
+const updatePasswordHashQuery = `
+UPDATE users SET password_hash = @password_hash WHERE id = @id;
+`
+
+const updateEmailQuery = `
+UPDATE users SET email = @email WHERE id = @id;
+`
+
+const listUsersQuery = `
+SELECT id, uid, email, password_hash, name, created_at, role, disabled_at
+FROM users
+ORDER BY id;
+`
+
+const setUserDisabledQuery = `
+UPDATE users
+SET disabled_at = CASE WHEN @disabled::boolean THEN coalesce(disabled_at, current_timestamp) END
+WHERE id = @id;
+`
+
+const deleteUserQuery = `
+DELETE FROM users WHERE id = @id;
+`
+
+func CreateUser(env *conf.Env, usr UserCreator) (*User, error) {
 
 	args := pgx.NamedArgs{
 		"name":          usr.Name,
@@ -65,7 +85,7 @@ func (usr *UserCreator) Create(env *conf.Env) (*User, error) {
 	return user, nil
 }
 
-func UserExists(env *conf.Env, email *mail.Address) (bool, error) {
+func UserExists(env *conf.Env, email string) (bool, error) {
 	args := pgx.NamedArgs{
 		"email": email,
 	}
@@ -104,3 +124,61 @@ func GetUserByID(env *conf.Env, id int32) (*User, error) {
 	}
 	return user, nil
 }
+
+func UpdatePasswordHash(env *conf.Env, id int32, passwordHash string) error {
+	args := pgx.NamedArgs{
+		"id":            id,
+		"password_hash": passwordHash,
+	}
+	_, err := env.Db.Exec(context.Background(), updatePasswordHashQuery, args)
+	return err
+}
+
+func UpdateEmail(env *conf.Env, id int32, email string) error {
+	args := pgx.NamedArgs{
+		"id":    id,
+		"email": email,
+	}
+	_, err := env.Db.Exec(context.Background(), updateEmailQuery, args)
+	return err
+}
+
+// DeleteUser deletes the user row, and everything referencing it, in a
+// transaction. cleanup is called before committing, so if cleaning up data
+// outside of postgres fails, the user is kept and the deletion can be retried.
+func DeleteUser(env *conf.Env, id int32, cleanup func() error) error {
+	ctx := context.Background()
+	tx, err := env.Db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op if the tx has been committed
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, deleteUserQuery, pgx.NamedArgs{"id": id})
+	if err != nil {
+		return err
+	}
+	err = cleanup()
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func ListUsers(env *conf.Env) ([]User, error) {
+	// Returned error will be handled while parsing returnedRows
+	returnedRows, _ := env.Db.Query(context.Background(), listUsersQuery)
+	return pgx.CollectRows(returnedRows, pgx.RowToStructByName[User])
+}
+
+// SetUserDisabled disables or re-enables the user. Disabling an already
+// disabled user keeps the original disabled_at.
+func SetUserDisabled(env *conf.Env, id int32, disabled bool) error {
+	args := pgx.NamedArgs{
+		"id":       id,
+		"disabled": disabled,
+	}
+	_, err := env.Db.Exec(context.Background(), setUserDisabledQuery, args)
+	return err
+}
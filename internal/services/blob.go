@@ -0,0 +1,166 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrBlobNotFound = errors.New("blob not found")
+
+var ErrInvalidBlobKey = errors.New("invalid blob key")
+
+type BlobInfo struct {
+	Key        string
+	Size       int64
+	ModifiedAt time.Time
+}
+
+// BlobStore keeps payloads too large to be kept inline with their clip. Keys
+// are slash separated paths, see NewBlobKey.
+type BlobStore interface {
+	// Put writes size bytes from r under key, replacing any existing blob.
+	Put(key string, r io.Reader, size int64) error
+	// Get returns ErrBlobNotFound if there is no blob under key.
+	Get(key string) (io.ReadCloser, error)
+	// Delete doesn't fail if there is no blob under key.
+	Delete(key string) error
+	// List calls fn with every blob whose key starts with prefix.
+	List(prefix string, fn func(BlobInfo) error) error
+}
+
+// NewBlobKey returns a new unique key under prefix.
+func NewBlobKey(prefix string) string {
+	return path.Join(prefix, uuid.NewString())
+}
+
+func validateBlobKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || path.Clean(key) != key || strings.HasPrefix(key, "..") {
+		return fmt.Errorf("%w: %q", ErrInvalidBlobKey, key)
+	}
+	return nil
+}
+
+// FileBlobStore keeps blobs as files under Root.
+type FileBlobStore struct {
+	Root string
+}
+
+func (s *FileBlobStore) path(key string) (string, error) {
+	if err := validateBlobKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.Root, filepath.FromSlash(key)), nil
+}
+
+// Put writes the blob to a temporary file first, so that a failed write never
+// leaves a partial blob behind.
+func (s *FileBlobStore) Put(key string, r io.Reader, size int64) error {
+	name, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(name), 0o700)
+	if err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(filepath.Dir(name), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	written, err := io.Copy(file, r)
+	if err == nil && written != size {
+		err = fmt.Errorf("blob %s: wrote %d bytes, expected %d", key, written, size)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), name)
+}
+
+func (s *FileBlobStore) Get(key string) (io.ReadCloser, error) {
+	name, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrBlobNotFound
+	}
+	return file, err
+}
+
+func (s *FileBlobStore) Delete(key string) error {
+	name, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s *FileBlobStore) List(prefix string, fn func(BlobInfo) error) error {
+	err := filepath.WalkDir(s.Root, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".upload-") {
+			return nil
+		}
+		rel, err := filepath.Rel(s.Root, name)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		return fn(BlobInfo{Key: key, Size: info.Size(), ModifiedAt: info.ModTime()})
+	})
+	// Nothing has been stored yet
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// DeleteBlobsBefore deletes the blobs under prefix last modified before
+// cutoff. Returns the number of deleted blobs.
+func DeleteBlobsBefore(store BlobStore, prefix string, cutoff time.Time) (int, error) {
+	var expired []string
+	err := store.List(prefix, func(info BlobInfo) error {
+		if info.ModifiedAt.Before(cutoff) {
+			expired = append(expired, info.Key)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for i, key := range expired {
+		if err := store.Delete(key); err != nil {
+			return i, err
+		}
+	}
+	return len(expired), nil
+}
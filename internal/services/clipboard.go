@@ -0,0 +1,253 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ClipboardStore keeps the latest clip of a user for pasting. Clips are
+// keyed by the user uid, and expire after the ttl they are set with, if it is
+// not 0.
+type ClipboardStore struct {
+	Client *redis.Client
+}
+
+const CLIPBOARD_KEY_PREFIX = "__clip__"
+
+// Text clip larger than the compression threshold, as a hash of its
+// compressed content and codec
+const COMPRESSED_CLIPBOARD_KEY_PREFIX = "__clip_compressed__"
+
+// Burn after reading clip, as a hash of its content, codec and clip id
+const ONCE_CLIPBOARD_KEY_PREFIX = "__clip_once__"
+
+// Set once a burn after reading clip is pasted, until the next broadcast or
+// until the clip would have expired
+const CONSUMED_CLIPBOARD_KEY_PREFIX = "__clip_consumed__"
+
+// How long a pasted burn after reading clip that never expired is reported
+// as consumed
+const CONSUMED_CLIP_TTL = 24 * time.Hour
+
+// Id of the clip, when the latest clip is a file or too large to be cached.
+// Its payload is kept with the clip.
+const REF_CLIPBOARD_KEY_PREFIX = "__clip_ref__"
+
+// Clip that looks like a secret, as a hash of its content, codec and clip id. It
+// expires after SENSITIVE_CLIP_TTL and is never saved in the history.
+const SENSITIVE_CLIPBOARD_KEY_PREFIX = "__clip_sensitive__"
+
+var ErrClipConsumed = errors.New("clip has already been consumed")
+
+type PastedClip struct {
+	// Compressed with Codec, if it is set. See Decompress
+	Content string
+	Codec   string
+	Once    bool
+	// The clip must be loaded by its id
+	Ref       bool
+	Sensitive bool
+	// Only set for burn after reading, sensitive and referenced clips
+	ClipID int32
+}
+
+// Pastes the burn after reading clip if there is one, deleting it and marking
+// it consumed in the same step, so that only a single paste can ever get it.
+// The mark lasts as long as the clip had left, or ARGV[1] milliseconds if it
+// never expired.
+var pasteClip = redis.NewScript(`
+local once = redis.call('HMGET', KEYS[2], 'content', 'clip_id', 'codec')
+if once[1] then
+	local ttl = redis.call('PTTL', KEYS[2])
+	if ttl < 0 then
+		ttl = ARGV[1]
+	end
+	redis.call('DEL', KEYS[2])
+	redis.call('SET', KEYS[3], once[2], 'PX', ttl)
+	return {'once', once[1], once[2], once[3] or ''}
+end
+local content = redis.call('GET', KEYS[1])
+if content then
+	return {'clip', content, '', ''}
+end
+local compressed = redis.call('HMGET', KEYS[6], 'content', 'codec')
+if compressed[1] then
+	return {'clip', compressed[1], '', compressed[2]}
+end
+local sensitive = redis.call('HMGET', KEYS[5], 'content', 'clip_id', 'codec')
+if sensitive[1] then
+	return {'sensitive', sensitive[1], sensitive[2], sensitive[3] or ''}
+end
+local ref = redis.call('GET', KEYS[4])
+if ref then
+	return {'ref', '', ref, ''}
+end
+if redis.call('EXISTS', KEYS[3]) == 1 then
+	return {'consumed'}
+end
+return {}
+`)
+
+func (r *ClipboardStore) formatClipboardKey(owner string) string {
+	return fmt.Sprintf("%s%s", CLIPBOARD_KEY_PREFIX, owner)
+}
+
+func (r *ClipboardStore) formatCompressedKey(owner string) string {
+	return fmt.Sprintf("%s%s", COMPRESSED_CLIPBOARD_KEY_PREFIX, owner)
+}
+
+func (r *ClipboardStore) formatOnceKey(owner string) string {
+	return fmt.Sprintf("%s%s", ONCE_CLIPBOARD_KEY_PREFIX, owner)
+}
+
+func (r *ClipboardStore) formatConsumedKey(owner string) string {
+	return fmt.Sprintf("%s%s", CONSUMED_CLIPBOARD_KEY_PREFIX, owner)
+}
+
+func (r *ClipboardStore) formatRefKey(owner string) string {
+	return fmt.Sprintf("%s%s", REF_CLIPBOARD_KEY_PREFIX, owner)
+}
+
+func (r *ClipboardStore) formatSensitiveKey(owner string) string {
+	return fmt.Sprintf("%s%s", SENSITIVE_CLIPBOARD_KEY_PREFIX, owner)
+}
+
+// expire sets the ttl on a key, if any.
+func (r *ClipboardStore) expire(ctx context.Context, pipe redis.Pipeliner, key string, ttl time.Duration) {
+	if ttl > 0 {
+		pipe.Expire(ctx, key, ttl)
+	}
+}
+
+// Keys returns every redis key holding the clipboard of the owner.
+func (r *ClipboardStore) Keys(owner string) []string {
+	return []string{
+		r.formatClipboardKey(owner),
+		r.formatOnceKey(owner),
+		r.formatConsumedKey(owner),
+		r.formatRefKey(owner),
+		r.formatSensitiveKey(owner),
+		r.formatCompressedKey(owner),
+	}
+}
+
+// Set replaces the clipboard, including any pending burn after reading clip.
+// Large content is compressed.
+func (r *ClipboardStore) Set(owner string, content string, ttl time.Duration) error {
+	ctx := context.Background()
+	compressed, codec, err := Compress([]byte(content))
+	if err != nil {
+		return err
+	}
+	_, err = r.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, r.Keys(owner)...)
+		if codec == "" {
+			pipe.Set(ctx, r.formatClipboardKey(owner), content, ttl)
+		} else {
+			pipe.HSet(ctx, r.formatCompressedKey(owner), "content", compressed, "codec", codec)
+			r.expire(ctx, pipe, r.formatCompressedKey(owner), ttl)
+		}
+		return nil
+	})
+	return err
+}
+
+// SetOnce replaces the clipboard with a clip that can be pasted only once.
+func (r *ClipboardStore) SetOnce(owner string, clipID int32, content string, ttl time.Duration) error {
+	ctx := context.Background()
+	compressed, codec, err := Compress([]byte(content))
+	if err != nil {
+		return err
+	}
+	_, err = r.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, r.Keys(owner)...)
+		pipe.HSet(ctx, r.formatOnceKey(owner), "content", compressed, "clip_id", clipID, "codec", codec)
+		r.expire(ctx, pipe, r.formatOnceKey(owner), ttl)
+		return nil
+	})
+	return err
+}
+
+// SetRef replaces the clipboard with a clip kept only in the history, like
+// files and large clips.
+func (r *ClipboardStore) SetRef(owner string, clipID int32, ttl time.Duration) error {
+	ctx := context.Background()
+	_, err := r.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, r.Keys(owner)...)
+		pipe.Set(ctx, r.formatRefKey(owner), clipID, ttl)
+		return nil
+	})
+	return err
+}
+
+// SetSensitive replaces the clipboard with a clip that looks like a secret.
+// It can be pasted until it expires after ttl.
+func (r *ClipboardStore) SetSensitive(owner string, clipID int32, content string, ttl time.Duration) error {
+	ctx := context.Background()
+	compressed, codec, err := Compress([]byte(content))
+	if err != nil {
+		return err
+	}
+	_, err = r.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, r.Keys(owner)...)
+		pipe.HSet(ctx, r.formatSensitiveKey(owner), "content", compressed, "clip_id", clipID, "codec", codec)
+		pipe.Expire(ctx, r.formatSensitiveKey(owner), ttl)
+		return nil
+	})
+	return err
+}
+
+// Sensitive returns the content of the sensitive clip, without pasting it.
+// Returns redis.Nil if it has expired or been replaced by another clip.
+func (r *ClipboardStore) Sensitive(owner string, clipID int32) (string, error) {
+	values, err := r.Client.HMGet(context.Background(), r.formatSensitiveKey(owner), "content", "clip_id", "codec").Result()
+	if err != nil {
+		return "", err
+	}
+	content, ok := values[0].(string)
+	if !ok || values[1] != strconv.Itoa(int(clipID)) {
+		return "", redis.Nil
+	}
+	codec, _ := values[2].(string)
+	data, err := Decompress([]byte(content), codec)
+	return string(data), err
+}
+
+// Paste returns the clipboard of the owner. Returns redis.Nil if nothing has
+// been broadcasted, and ErrClipConsumed if the last clip was burn after
+// reading and has already been pasted.
+func (r *ClipboardStore) Paste(owner string) (*PastedClip, error) {
+	keys := r.Keys(owner)
+	result, err := pasteClip.Run(context.Background(), r.Client, keys, CONSUMED_CLIP_TTL.Milliseconds()).StringSlice()
+	if err != nil {
+		return nil, err
+	}
+	if len(result) == 0 {
+		return nil, redis.Nil
+	}
+
+	if result[0] == "consumed" {
+		return nil, ErrClipConsumed
+	}
+	clip := &PastedClip{
+		Content:   result[1],
+		Codec:     result[3],
+		Once:      result[0] == "once",
+		Ref:       result[0] == "ref",
+		Sensitive: result[0] == "sensitive",
+	}
+	if result[2] != "" {
+		_, err = fmt.Sscan(result[2], &clip.ClipID)
+	}
+	return clip, err
+}
+
+// Clear deletes every key holding the clipboard of the owner.
+func (r *ClipboardStore) Clear(owner string) error {
+	return r.Client.Del(context.Background(), r.Keys(owner)...).Err()
+}
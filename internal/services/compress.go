@@ -0,0 +1,65 @@
+package services
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Codecs payloads can be compressed with. The names are the HTTP content
+// codings, so compressed payloads can be served as is.
+const CODEC_GZIP = "gzip"
+
+// Smaller payloads are not worth compressing
+const COMPRESSION_THRESHOLD = 4 << 10
+
+var ErrUnknownCodec = errors.New("unknown codec")
+
+// Compress compresses the payload if it is larger than the threshold and
+// shrinks by compressing. Returns the payload as is with an empty codec
+// otherwise.
+func Compress(data []byte) ([]byte, string, error) {
+	if len(data) < COMPRESSION_THRESHOLD {
+		return data, "", nil
+	}
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	_, err := writer.Write(data)
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if buf.Len() >= len(data) {
+		return data, "", nil
+	}
+	return buf.Bytes(), CODEC_GZIP, nil
+}
+
+// Decompress returns the payload compressed with the codec. An empty codec
+// means the payload is not compressed.
+func Decompress(data []byte, codec string) ([]byte, error) {
+	if codec == "" {
+		return data, nil
+	}
+	reader, err := DecompressReader(bytes.NewReader(data), codec)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(reader)
+}
+
+// DecompressReader is Decompress for a payload read from r, such as a blob,
+// so that it is never held in memory.
+func DecompressReader(r io.Reader, codec string) (io.Reader, error) {
+	switch codec {
+	case "":
+		return r, nil
+	case CODEC_GZIP:
+		return gzip.NewReader(r)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownCodec, codec)
+}
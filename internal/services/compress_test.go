@@ -0,0 +1,58 @@
+package services
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func randomBytes(t *testing.T, n int) []byte {
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestCompress(t *testing.T) {
+	tests := []struct {
+		name          string
+		data          []byte
+		expectedCodec string
+	}{
+		{"small", []byte("hello"), ""},
+		{"log", []byte(strings.Repeat("2024-01-01 12:00:00 INFO request handled in 3ms\n", 500)), CODEC_GZIP},
+		// Random data grows when compressed
+		{"incompressible", randomBytes(t, 2*COMPRESSION_THRESHOLD), ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			compressed, codec, err := Compress(test.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if codec != test.expectedCodec {
+				t.Errorf("expected codec %q, got %q", test.expectedCodec, codec)
+			}
+			if codec != "" && len(compressed) >= len(test.data) {
+				t.Errorf("expected compressed payload to shrink, got %d from %d bytes", len(compressed), len(test.data))
+			}
+			data, err := Decompress(compressed, codec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, test.data) {
+				t.Error("expected decompressed payload to match")
+			}
+		})
+	}
+}
+
+func TestDecompressUnknownCodec(t *testing.T) {
+	_, err := Decompress([]byte("data"), "br")
+	if !errors.Is(err, ErrUnknownCodec) {
+		t.Errorf("expected ErrUnknownCodec, got %v", err)
+	}
+}
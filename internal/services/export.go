@@ -0,0 +1,206 @@
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	EXPORT_FORMAT_NDJSON = "ndjson"
+	EXPORT_FORMAT_ZIP    = "zip"
+)
+
+// ExportSection is one kind of data held about a user, e.g. the profile or
+// the clips. Records calls emit once per record, so that sections can be
+// streamed without loading everything in memory.
+type ExportSection struct {
+	Name    string
+	Records func(emit func(record any) error) error
+}
+
+type ndjsonExportLine struct {
+	Section string `json:"section"`
+	Data    any    `json:"data"`
+}
+
+// WriteExport writes the sections to w. NDJSON exports have one line per
+// record, tagged with the section name. ZIP exports have one NDJSON file per
+// section.
+func WriteExport(w io.Writer, format string, sections []ExportSection) error {
+	switch format {
+	case EXPORT_FORMAT_NDJSON:
+		encoder := json.NewEncoder(w)
+		for _, section := range sections {
+			err := section.Records(func(record any) error {
+				return encoder.Encode(ndjsonExportLine{Section: section.Name, Data: record})
+			})
+			if err != nil {
+				return fmt.Errorf("exporting %s: %w", section.Name, err)
+			}
+		}
+		return nil
+	case EXPORT_FORMAT_ZIP:
+		archive := zip.NewWriter(w)
+		for _, section := range sections {
+			file, err := archive.Create(section.Name + ".ndjson")
+			if err != nil {
+				return err
+			}
+			encoder := json.NewEncoder(file)
+			err = section.Records(func(record any) error {
+				return encoder.Encode(record)
+			})
+			if err != nil {
+				return fmt.Errorf("exporting %s: %w", section.Name, err)
+			}
+		}
+		return archive.Close()
+	default:
+		return fmt.Errorf("unsupported export format: %s", format)
+	}
+}
+
+const (
+	EXPORT_STATUS_PENDING = "pending"
+	EXPORT_STATUS_READY   = "ready"
+	EXPORT_STATUS_FAILED  = "failed"
+)
+
+type ExportJob struct {
+	UserID    int32     `json:"user_id"`
+	Format    string    `json:"format"`
+	Status    string    `json:"status"`
+	Path      string    `json:"path"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ExportStore tracks asynchronously generated exports. The token of a job is
+// the secret part of its download link.
+type ExportStore struct {
+	Client *redis.Client
+}
+
+const EXPORT_KEY_PREFIX = "__export__"
+
+// Tokens of the export jobs of each user
+const USER_EXPORTS_KEY_PREFIX = "__user_exports__"
+
+const ExportTTL = 24 * time.Hour
+
+// ExportDir returns the directory exports generated in the background are
+// written to, on the instance that generated them.
+func ExportDir() string {
+	return filepath.Join(os.TempDir(), "shipboard-exports")
+}
+
+// DeleteExportsBefore deletes the export files last modified before cutoff.
+// Returns the number of deleted files.
+func DeleteExportsBefore(cutoff time.Time) (int, error) {
+	dir := ExportDir()
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return deleted, err
+		}
+		if info.IsDir() || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		err = os.Remove(filepath.Join(dir, entry.Name()))
+		if err != nil && !os.IsNotExist(err) {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+func (r *ExportStore) formatExportKey(token string) string {
+	return fmt.Sprintf("%s%s", EXPORT_KEY_PREFIX, token)
+}
+
+func (r *ExportStore) formatUserExportsKey(userID int32) string {
+	return fmt.Sprintf("%s%d", USER_EXPORTS_KEY_PREFIX, userID)
+}
+
+func (r *ExportStore) Set(token string, job ExportJob) error {
+	json, _ := json.Marshal(job)
+	ttl := time.Until(job.ExpiresAt)
+	if ttl <= 0 {
+		return r.Client.Del(context.Background(), r.formatExportKey(token)).Err()
+	}
+	return r.Client.Set(context.Background(), r.formatExportKey(token), json, ttl).Err()
+}
+
+// Get returns redis.Nil for unknown and expired tokens.
+func (r *ExportStore) Get(token string) (*ExportJob, error) {
+	val, err := r.Client.Get(context.Background(), r.formatExportKey(token)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var job ExportJob
+	err = json.Unmarshal([]byte(val), &job)
+	return &job, err
+}
+
+func (r *ExportStore) Create(job ExportJob) (string, error) {
+	token, err := NewToken()
+	if err != nil {
+		return "", err
+	}
+	json, _ := json.Marshal(job)
+	ctx := context.Background()
+	userExportsKey := r.formatUserExportsKey(job.UserID)
+	// Jobs all last ExportTTL, the latest one expires last
+	_, err = r.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, r.formatExportKey(token), json, time.Until(job.ExpiresAt))
+		pipe.SAdd(ctx, userExportsKey, token)
+		pipe.ExpireAt(ctx, userExportsKey, job.ExpiresAt)
+		return nil
+	})
+	return token, err
+}
+
+// DeleteUserExports deletes the jobs of the user, and returns them so that
+// their files can be deleted.
+func (r *ExportStore) DeleteUserExports(userID int32) ([]ExportJob, error) {
+	ctx := context.Background()
+	userExportsKey := r.formatUserExportsKey(userID)
+	tokens, err := r.Client.SMembers(ctx, userExportsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	var jobs []ExportJob
+	keys := []string{userExportsKey}
+	for _, token := range tokens {
+		job, err := r.Get(token)
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, *job)
+		keys = append(keys, r.formatExportKey(token))
+	}
+	return jobs, r.Client.Del(ctx, keys...).Err()
+}
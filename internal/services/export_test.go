@@ -0,0 +1,42 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDeleteExportsBefore(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	if deleted, err := DeleteExportsBefore(time.Now()); err != nil || deleted != 0 {
+		t.Fatalf("expected nothing to delete before the first export, got %d %v", deleted, err)
+	}
+
+	dir := ExportDir()
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	expired := filepath.Join(dir, "export-expired.zip")
+	fresh := filepath.Join(dir, "export-fresh.zip")
+	for _, path := range []string{expired, fresh} {
+		if err := os.WriteFile(path, []byte("export"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-ExportTTL - time.Minute)
+	if err := os.Chtimes(expired, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	deleted, err := DeleteExportsBefore(time.Now().Add(-ExportTTL))
+	if err != nil || deleted != 1 {
+		t.Fatalf("expected 1 deleted export, got %d %v", deleted, err)
+	}
+	if _, err := os.Stat(expired); !os.IsNotExist(err) {
+		t.Errorf("expected the expired export to be deleted, got %v", err)
+	}
+	if _, err := os.Stat(fresh); err != nil {
+		t.Errorf("expected the fresh export to be kept, got %v", err)
+	}
+}
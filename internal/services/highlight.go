@@ -0,0 +1,409 @@
+package services
+
+import (
+	"encoding/json"
+	"html"
+	"html/template"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	LANGUAGE_GO         = "go"
+	LANGUAGE_PYTHON     = "python"
+	LANGUAGE_JAVASCRIPT = "javascript"
+	LANGUAGE_JSON       = "json"
+	LANGUAGE_SHELL      = "shell"
+	LANGUAGE_SQL        = "sql"
+	LANGUAGE_HTML       = "html"
+	LANGUAGE_YAML       = "yaml"
+	LANGUAGE_DIFF       = "diff"
+)
+
+// Languages lists the languages that can be detected and highlighted.
+var Languages = []string{
+	LANGUAGE_DIFF, LANGUAGE_GO, LANGUAGE_HTML, LANGUAGE_JAVASCRIPT,
+	LANGUAGE_JSON, LANGUAGE_PYTHON, LANGUAGE_SHELL, LANGUAGE_SQL, LANGUAGE_YAML,
+}
+
+// CSS classes of highlighted tokens
+const (
+	tokenKeyword = "tok-kw"
+	tokenString  = "tok-str"
+	tokenComment = "tok-com"
+	tokenNumber  = "tok-num"
+	tokenTag     = "tok-tag"
+	tokenAdded   = "tok-add"
+	tokenRemoved = "tok-del"
+	tokenMeta    = "tok-meta"
+)
+
+// Clips longer than this are neither detected nor highlighted
+const maxHighlightLength = 256 << 10
+
+func IsLanguage(language string) bool {
+	for _, known := range Languages {
+		if known == language {
+			return true
+		}
+	}
+	return false
+}
+
+type languageHint struct {
+	pattern *regexp.Regexp
+	weight  int
+}
+
+// Each matching hint adds its weight to the score of the language. Hints are
+// matched against the start of the clip only, so that detection stays cheap.
+var languageHints = map[string][]languageHint{
+	LANGUAGE_GO: {
+		{regexp.MustCompile(`(?m)^package \w+\s*$`), 5},
+		{regexp.MustCompile(`(?m)^func (\(\w+ \*?\w+\) )?\w+\(`), 4},
+		{regexp.MustCompile(`(?m)^import \(`), 4},
+		{regexp.MustCompile(`\w+ := `), 2},
+		{regexp.MustCompile(`\bif err != nil \{`), 4},
+		{regexp.MustCompile(`\bfmt\.\w+\(`), 2},
+	},
+	LANGUAGE_PYTHON: {
+		{regexp.MustCompile(`(?m)^\s*def \w+\(.*\)( -> [\w\[\], ]+)?:\s*$`), 5},
+		{regexp.MustCompile(`(?m)^from [\w.]+ import \w+`), 4},
+		{regexp.MustCompile(`(?m)^import \w+\s*$`), 2},
+		{regexp.MustCompile(`(?m)^\s*(elif|except|class \w+(\(.*\))?:)`), 3},
+		{regexp.MustCompile(`\bself\.\w+`), 2},
+		{regexp.MustCompile(`(?m)^if __name__ == `), 5},
+		{regexp.MustCompile(`\bprint\(`), 1},
+	},
+	LANGUAGE_JAVASCRIPT: {
+		{regexp.MustCompile(`(?m)^\s*(const|let|var) \w+ = `), 3},
+		{regexp.MustCompile(`=> \{?`), 2},
+		{regexp.MustCompile(`\bfunction\s*\w*\(`), 3},
+		{regexp.MustCompile(`\bconsole\.log\(`), 4},
+		{regexp.MustCompile(`\brequire\(['"]`), 3},
+		{regexp.MustCompile(`(?m)^(import .* from ['"]|export (default |const |function ))`), 4},
+		{regexp.MustCompile(`===|!==`), 2},
+	},
+	LANGUAGE_SHELL: {
+		{regexp.MustCompile(`^#!/(usr/)?bin/(env )?(ba|z)?sh`), 10},
+		{regexp.MustCompile(`(?m)^\$ \w`), 4},
+		{regexp.MustCompile(`(?m)^\s*(sudo|apt(-get)?|brew|cd|export|echo|curl|wget|git|docker|kubectl|ssh|chmod|mkdir|ls|cat|grep) `), 3},
+		{regexp.MustCompile(` \| (grep|awk|sed|xargs|head|tail|sort|jq) `), 3},
+		{regexp.MustCompile(` && `), 1},
+		{regexp.MustCompile(`\$\{?\w+\}?`), 1},
+	},
+	LANGUAGE_SQL: {
+		{regexp.MustCompile(`(?is)\bSELECT\b.+\bFROM\b`), 5},
+		{regexp.MustCompile(`(?i)\bINSERT INTO\b`), 5},
+		{regexp.MustCompile(`(?i)\bCREATE (TABLE|INDEX|VIEW)\b`), 5},
+		{regexp.MustCompile(`(?is)\bUPDATE\b.+\bSET\b`), 3},
+		{regexp.MustCompile(`(?i)\b(WHERE|JOIN|GROUP BY|ORDER BY)\b`), 2},
+	},
+	LANGUAGE_HTML: {
+		{regexp.MustCompile(`(?i)^\s*<!DOCTYPE html`), 10},
+		{regexp.MustCompile(`(?i)<(html|head|body|div|span|p|a|ul|table)[\s>]`), 3},
+		{regexp.MustCompile(`(?i)</(html|head|body|div|span|p|a|ul|table)>`), 3},
+	},
+	LANGUAGE_YAML: {
+		{regexp.MustCompile(`(?m)^---\s*$`), 3},
+		{regexp.MustCompile(`(?m)^[\w-]+:( [^{}\[\];]*)?$`), 2},
+		{regexp.MustCompile(`(?m)^\s+- [\w-]+`), 1},
+		{regexp.MustCompile(`(?m)^\s+[\w-]+: `), 1},
+	},
+	LANGUAGE_DIFF: {
+		{regexp.MustCompile(`(?m)^diff --git `), 10},
+		{regexp.MustCompile(`(?m)^@@ -\d+(,\d+)? \+\d+(,\d+)? @@`), 10},
+		{regexp.MustCompile(`(?m)^(---|\+\+\+) [ab/]`), 3},
+	},
+}
+
+// Scores below this are too weak to call the clip code
+const minLanguageScore = 4
+
+// Only the start of a clip is looked at for detection
+const detectionPrefixLength = 8 << 10
+
+// DetectLanguage guesses the language of a text clip from heuristics. Returns
+// an empty string for prose and anything else it doesn't recognize.
+func DetectLanguage(content string) string {
+	trimmed := strings.TrimSpace(content)
+	if trimmed == "" || len(content) > maxHighlightLength {
+		return ""
+	}
+	if (trimmed[0] == '{' || trimmed[0] == '[') && json.Valid([]byte(trimmed)) {
+		return LANGUAGE_JSON
+	}
+	if len(trimmed) > detectionPrefixLength {
+		trimmed = trimmed[:detectionPrefixLength]
+	}
+
+	best, bestScore := "", minLanguageScore-1
+	// Languages are scored in a fixed order, so that ties are stable
+	for _, language := range Languages {
+		score := 0
+		for _, hint := range languageHints[language] {
+			if hint.pattern.MatchString(trimmed) {
+				score += hint.weight
+			}
+		}
+		if score > bestScore {
+			best, bestScore = language, score
+		}
+	}
+	return best
+}
+
+type languageSyntax struct {
+	keywords map[string]bool
+	// Keywords are matched case insensitively, and listed lowercase
+	caseInsensitive bool
+	lineComments    []string
+	blockComments   [][2]string
+	quotes          string
+}
+
+func keywords(words string) map[string]bool {
+	set := make(map[string]bool)
+	for _, word := range strings.Fields(words) {
+		set[word] = true
+	}
+	return set
+}
+
+var syntaxes = map[string]languageSyntax{
+	LANGUAGE_GO: {
+		keywords: keywords(`break case chan const continue default defer else fallthrough for func go goto if
+			import interface map package range return select struct switch type var nil true false iota`),
+		lineComments:  []string{"//"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		quotes:        "\"'`",
+	},
+	LANGUAGE_PYTHON: {
+		keywords: keywords(`and as assert async await break class continue def del elif else except finally
+			for from global if import in is lambda nonlocal not or pass raise return try while with yield
+			None True False self`),
+		lineComments: []string{"#"},
+		quotes:       "\"'",
+	},
+	LANGUAGE_JAVASCRIPT: {
+		keywords: keywords(`async await break case catch class const continue default delete do else export
+			extends finally for from function if import in instanceof let new of return switch this throw
+			try typeof var void while yield null undefined true false`),
+		lineComments:  []string{"//"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		quotes:        "\"'`",
+	},
+	LANGUAGE_JSON: {
+		keywords: keywords(`true false null`),
+		quotes:   "\"",
+	},
+	LANGUAGE_SHELL: {
+		keywords: keywords(`if then else elif fi for while until do done case esac in function return
+			export local readonly sudo echo cd`),
+		lineComments: []string{"#"},
+		quotes:       "\"'",
+	},
+	LANGUAGE_SQL: {
+		keywords: keywords(`select from where and or not insert into values update set delete create table
+			index view drop alter add column primary key foreign references join left right inner outer on
+			group by order having limit offset as distinct null is in exists case when then else end union
+			all returning default constraint unique check begin commit rollback`),
+		caseInsensitive: true,
+		lineComments:    []string{"--"},
+		blockComments:   [][2]string{{"/*", "*/"}},
+		quotes:          "'\"",
+	},
+	LANGUAGE_YAML: {
+		keywords:     keywords(`true false null yes no on off`),
+		lineComments: []string{"#"},
+		quotes:       "\"'",
+	},
+}
+
+// highlighter writes the escaped source, with tokens wrapped in spans.
+type highlighter struct {
+	b strings.Builder
+}
+
+func (h *highlighter) plain(text string) {
+	h.b.WriteString(html.EscapeString(text))
+}
+
+func (h *highlighter) token(class string, text string) {
+	if text == "" {
+		return
+	}
+	h.b.WriteString(`<span class="` + class + `">`)
+	h.b.WriteString(html.EscapeString(text))
+	h.b.WriteString("</span>")
+}
+
+// Highlight returns the content as escaped html, with the tokens of the
+// language wrapped in spans with tok-* classes. The text of the result is
+// exactly the content. Unknown languages and overly long content are only
+// escaped.
+func Highlight(content string, language string) template.HTML {
+	var h highlighter
+	switch {
+	case len(content) > maxHighlightLength:
+		h.plain(content)
+	case language == LANGUAGE_DIFF:
+		highlightDiff(&h, content)
+	case language == LANGUAGE_HTML:
+		highlightHTML(&h, content)
+	default:
+		syntax, ok := syntaxes[language]
+		if !ok {
+			h.plain(content)
+			break
+		}
+		highlightCode(&h, content, syntax)
+	}
+	return template.HTML(h.b.String())
+}
+
+func highlightDiff(h *highlighter, content string) {
+	for _, line := range strings.SplitAfter(content, "\n") {
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"),
+			strings.HasPrefix(line, "@@"), strings.HasPrefix(line, "diff "):
+			h.token(tokenMeta, line)
+		case strings.HasPrefix(line, "+"):
+			h.token(tokenAdded, line)
+		case strings.HasPrefix(line, "-"):
+			h.token(tokenRemoved, line)
+		default:
+			h.plain(line)
+		}
+	}
+}
+
+func highlightHTML(h *highlighter, content string) {
+	for content != "" {
+		start := strings.IndexByte(content, '<')
+		if start < 0 {
+			h.plain(content)
+			return
+		}
+		h.plain(content[:start])
+		content = content[start:]
+
+		class, end := tokenTag, ">"
+		if strings.HasPrefix(content, "<!--") {
+			class, end = tokenComment, "-->"
+		}
+		stop := strings.Index(content, end)
+		if stop < 0 {
+			h.token(class, content)
+			return
+		}
+		stop += len(end)
+		h.token(class, content[:stop])
+		content = content[stop:]
+	}
+}
+
+func isIdentifierRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func highlightCode(h *highlighter, content string, syntax languageSyntax) {
+	plainStart := 0
+	flush := func(i int) {
+		h.plain(content[plainStart:i])
+	}
+
+	i := 0
+tokens:
+	for i < len(content) {
+		rest := content[i:]
+
+		for _, prefix := range syntax.lineComments {
+			if strings.HasPrefix(rest, prefix) {
+				end := strings.IndexByte(rest, '\n')
+				if end < 0 {
+					end = len(rest)
+				}
+				flush(i)
+				h.token(tokenComment, rest[:end])
+				i += end
+				plainStart = i
+				continue tokens
+			}
+		}
+		for _, delimiters := range syntax.blockComments {
+			if strings.HasPrefix(rest, delimiters[0]) {
+				end := strings.Index(rest[len(delimiters[0]):], delimiters[1])
+				if end < 0 {
+					end = len(rest)
+				} else {
+					end += len(delimiters[0]) + len(delimiters[1])
+				}
+				flush(i)
+				h.token(tokenComment, rest[:end])
+				i += end
+				plainStart = i
+				continue tokens
+			}
+		}
+
+		if strings.IndexByte(syntax.quotes, rest[0]) >= 0 {
+			end := stringEnd(rest)
+			flush(i)
+			h.token(tokenString, rest[:end])
+			i += end
+			plainStart = i
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(rest)
+		// Only words starting where a word can start, e.g. not the 2 in x2
+		atWordStart := i == 0 || !isIdentifierRune(lastRune(content[:i]))
+		if !atWordStart || !isIdentifierRune(r) {
+			i += size
+			continue
+		}
+		end := strings.IndexFunc(rest, func(r rune) bool { return !isIdentifierRune(r) })
+		if end < 0 {
+			end = len(rest)
+		}
+		word := rest[:end]
+		switch {
+		case unicode.IsDigit(r):
+			flush(i)
+			h.token(tokenNumber, word)
+			plainStart = i + end
+		case syntax.keywords[word] || (syntax.caseInsensitive && syntax.keywords[strings.ToLower(word)]):
+			flush(i)
+			h.token(tokenKeyword, word)
+			plainStart = i + end
+		}
+		i += end
+	}
+	flush(len(content))
+}
+
+func lastRune(s string) rune {
+	r, _ := utf8.DecodeLastRuneInString(s)
+	return r
+}
+
+// stringEnd returns the length of the string literal s starts with,
+// including its quotes. Unterminated strings end at the end of the line,
+// except for backquoted ones.
+func stringEnd(s string) int {
+	quote := s[0]
+	for i := 1; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quote != '`':
+			i++
+		case s[i] == quote:
+			return i + 1
+		case s[i] == '\n' && quote != '`':
+			return i
+		}
+	}
+	return len(s)
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"html"
+	"regexp"
+	"testing"
+)
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{"go", "package main\n\nimport (\n\t\"fmt\"\n)\n\nfunc main() {\n\tfmt.Println(\"hi\")\n}\n", LANGUAGE_GO},
+		{"go snippet", "result, err := run()\nif err != nil {\n\treturn err\n}", LANGUAGE_GO},
+		{"python", "from os import path\n\ndef main(args):\n    print(path.join(*args))\n", LANGUAGE_PYTHON},
+		{"javascript", "const total = items.reduce((sum, item) => sum + item, 0);\nconsole.log(total);", LANGUAGE_JAVASCRIPT},
+		{"json", `{"name": "shipboard", "tags": [1, 2]}`, LANGUAGE_JSON},
+		{"shell", "#!/bin/bash\nset -e\necho done", LANGUAGE_SHELL},
+		{"shell command", "curl -s https://example.com | jq .items", LANGUAGE_SHELL},
+		{"sql", "SELECT id, name FROM users WHERE email = 'a@b.c';", LANGUAGE_SQL},
+		{"html", "<!DOCTYPE html>\n<html><body><p>Hi</p></body></html>", LANGUAGE_HTML},
+		{"yaml", "---\nname: shipboard\nservices:\n  - web\n  - worker\n", LANGUAGE_YAML},
+		{"diff", "diff --git a/x.go b/x.go\n@@ -1,2 +1,2 @@\n-old\n+new\n", LANGUAGE_DIFF},
+		{"prose", "Meeting moved to 3pm, see you there.", ""},
+		{"url", "https://example.com/some/page?id=3", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := DetectLanguage(test.content); got != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, got)
+			}
+		})
+	}
+}
+
+var tagPattern = regexp.MustCompile(`<[^>]*>`)
+
+func TestHighlightKeepsText(t *testing.T) {
+	contents := map[string]string{
+		LANGUAGE_GO:     "func main() {\n\t// <b>not bold</b>\n\ts := \"a \\\" & b\"\n\tx2 := 42\n}\n",
+		LANGUAGE_SQL:    "select * from t where a = 'it''s' -- comment\n/* block",
+		LANGUAGE_HTML:   "<p class=\"x\">a &amp; b</p><!-- c -->",
+		LANGUAGE_DIFF:   "@@ -1 +1 @@\n-<old>\n+new\n",
+		LANGUAGE_PYTHON: "s = 'unterminated\nprint(s)",
+	}
+	for language, content := range contents {
+		highlighted := string(Highlight(content, language))
+		text := html.UnescapeString(tagPattern.ReplaceAllString(highlighted, ""))
+		if text != content {
+			t.Errorf("%s: expected text %q, got %q from %q", language, content, text, highlighted)
+		}
+	}
+}
+
+func TestHighlightTokens(t *testing.T) {
+	highlighted := string(Highlight("x2 := 42 // <script>", LANGUAGE_GO))
+	expected := `x2 := <span class="tok-num">42</span> <span class="tok-com">// &lt;script&gt;</span>`
+	if highlighted != expected {
+		t.Errorf("expected %q, got %q", expected, highlighted)
+	}
+	highlighted = string(Highlight("SELECT 'a' FROM t", LANGUAGE_SQL))
+	expected = `<span class="tok-kw">SELECT</span> <span class="tok-str">&#39;a&#39;</span> <span class="tok-kw">FROM</span> t`
+	if highlighted != expected {
+		t.Errorf("expected %q, got %q", expected, highlighted)
+	}
+}
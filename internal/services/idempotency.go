@@ -0,0 +1,77 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// IdempotencyStore remembers the outcome of requests sent with an
+// Idempotency-Key header, so that retrying them doesn't repeat their effect.
+type IdempotencyStore struct {
+	Client *redis.Client
+}
+
+const IDEMPOTENCY_KEY_PREFIX = "__idempotency__"
+
+// Value of the key while its request is being handled
+const idempotencyPending = "pending"
+
+// Keys of completed requests are remembered this long
+const IdempotencyKeyTTL = 24 * time.Hour
+
+// A request still pending after this long is assumed to have died without
+// releasing its key, which can then be claimed again
+const IdempotencyPendingTTL = 5 * time.Minute
+
+// Keys released while being claimed are claimed again this many times,
+// before giving up as if the request was in progress
+const maxIdempotencyClaims = 3
+
+var ErrRequestInProgress = errors.New("request with this idempotency key is in progress")
+
+func (s *IdempotencyStore) formatKey(owner string, key string) string {
+	return fmt.Sprintf("%s%s:%s", IDEMPOTENCY_KEY_PREFIX, owner, key)
+}
+
+// Begin claims the key for a request. Returns the status code the request
+// completed with if the key has been used already, and ErrRequestInProgress
+// if that request has not completed yet. Returns 0 once the key is claimed.
+func (s *IdempotencyStore) Begin(owner string, key string) (int, error) {
+	ctx := context.Background()
+	redisKey := s.formatKey(owner, key)
+	for range maxIdempotencyClaims {
+		claimed, err := s.Client.SetNX(ctx, redisKey, idempotencyPending, IdempotencyPendingTTL).Result()
+		if err != nil || claimed {
+			return 0, err
+		}
+		value, err := s.Client.Get(ctx, redisKey).Result()
+		if err == redis.Nil {
+			// Released in between, by a request that failed
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if value == idempotencyPending {
+			return 0, ErrRequestInProgress
+		}
+		return strconv.Atoi(value)
+	}
+	return 0, ErrRequestInProgress
+}
+
+// Complete records the status code of the request holding the key, for
+// IdempotencyKeyTTL.
+func (s *IdempotencyStore) Complete(owner string, key string, status int) error {
+	return s.Client.Set(context.Background(), s.formatKey(owner, key), status, IdempotencyKeyTTL).Err()
+}
+
+// Release frees the key after the request failed, so that it can be retried.
+func (s *IdempotencyStore) Release(owner string, key string) error {
+	return s.Client.Del(context.Background(), s.formatKey(owner, key)).Err()
+}
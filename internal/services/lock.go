@@ -0,0 +1,52 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// LockStore holds locks shared by all instances, e.g. so that only one of
+// them runs a background job at a time.
+type LockStore struct {
+	Client *redis.Client
+}
+
+const LOCK_KEY_PREFIX = "__lock__"
+
+// Deletes the lock only if it is still held with the token, it may have
+// expired and been acquired by another instance since
+var releaseLock = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+func (s *LockStore) formatKey(name string) string {
+	return LOCK_KEY_PREFIX + name
+}
+
+// Acquire takes the named lock for at most ttl. Returns the token to release
+// it with, or "" if another instance holds it.
+func (s *LockStore) Acquire(name string, ttl time.Duration) (string, error) {
+	random := make([]byte, 16)
+	_, err := rand.Read(random)
+	if err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(random)
+	acquired, err := s.Client.SetNX(context.Background(), s.formatKey(name), token, ttl).Result()
+	if err != nil || !acquired {
+		return "", err
+	}
+	return token, nil
+}
+
+// Release frees the lock acquired with the token.
+func (s *LockStore) Release(name string, token string) error {
+	return releaseLock.Run(context.Background(), s.Client, []string{s.formatKey(name)}, token).Err()
+}
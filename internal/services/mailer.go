@@ -0,0 +1,21 @@
+package services
+
+import (
+	"log"
+)
+
+// Mailer sends plain text emails to users.
+type Mailer interface {
+	Send(to string, subject string, body string) error
+}
+
+// LogMailer writes emails to the log instead of sending them. Useful for
+// development and for self hosted instances without an SMTP server.
+type LogMailer struct {
+	Logger *log.Logger
+}
+
+func (m *LogMailer) Send(to string, subject string, body string) error {
+	m.Logger.Printf("Email to %s | %s\n%s", to, subject, body)
+	return nil
+}
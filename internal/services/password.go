@@ -0,0 +1,222 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	HASH_ALGORITHM_BCRYPT   = "bcrypt"
+	HASH_ALGORITHM_ARGON2ID = "argon2id"
+)
+
+// Defaults for argon2id follow the second recommended option of RFC 9106,
+// scaled down a bit for memory so that a small instance can still serve logins.
+const (
+	DEFAULT_BCRYPT_COST      = 12
+	DEFAULT_ARGON2ID_TIME    = 3
+	DEFAULT_ARGON2ID_MEMORY  = 64 * 1024
+	DEFAULT_ARGON2ID_THREADS = 2
+	argon2idSaltLength       = 16
+	argon2idKeyLength        = 32
+)
+
+var ErrUnknownHashFormat = errors.New("unknown password hash format")
+
+// PasswordHasher hashes and verifies passwords. Hashes are self describing,
+// i.e. the algorithm and its parameters are stored alongside the hash, so a
+// hasher can verify hashes created with older settings and report whether
+// they should be upgraded.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	// Verify returns false with a nil error when the password does not match.
+	Verify(hash string, password string) (bool, error)
+	// NeedsRehash reports whether the hash was created with a different
+	// algorithm or parameters than the ones currently configured.
+	NeedsRehash(hash string) bool
+}
+
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (h *BcryptHasher) Verify(hash string, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (h *BcryptHasher) NeedsRehash(hash string) bool {
+	if !isBcryptHash(hash) {
+		return true
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != h.Cost
+}
+
+// Argon2idHasher stores hashes in the PHC string format used by the reference
+// implementation: $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<key>
+type Argon2idHasher struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+type argon2idHash struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, argon2idSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Time, h.Memory, h.Threads, argon2idKeyLength)
+	encoding := base64.RawStdEncoding
+	return fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Time, h.Threads,
+		encoding.EncodeToString(salt), encoding.EncodeToString(key),
+	), nil
+}
+
+func (h *Argon2idHasher) Verify(hash string, password string) (bool, error) {
+	parsed, err := parseArgon2idHash(hash)
+	if err != nil {
+		return false, err
+	}
+	key := argon2.IDKey([]byte(password), parsed.salt, parsed.time, parsed.memory, parsed.threads, uint32(len(parsed.key)))
+	return subtle.ConstantTimeCompare(key, parsed.key) == 1, nil
+}
+
+func (h *Argon2idHasher) NeedsRehash(hash string) bool {
+	parsed, err := parseArgon2idHash(hash)
+	if err != nil {
+		return true
+	}
+	return parsed.time != h.Time ||
+		parsed.memory != h.Memory ||
+		parsed.threads != h.Threads ||
+		len(parsed.key) != argon2idKeyLength
+}
+
+func parseArgon2idHash(hash string) (*argon2idHash, error) {
+	// Leading "$" results in an empty first part
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != HASH_ALGORITHM_ARGON2ID {
+		return nil, ErrUnknownHashFormat
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return nil, err
+	}
+	if version != argon2.Version {
+		return nil, fmt.Errorf("unsupported argon2 version: %d", version)
+	}
+
+	parsed := &argon2idHash{}
+	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &parsed.memory, &parsed.time, &parsed.threads)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, err
+	}
+	if parsed.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return nil, err
+	}
+	return parsed, nil
+}
+
+func isBcryptHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") ||
+		strings.HasPrefix(hash, "$2b$") ||
+		strings.HasPrefix(hash, "$2y$")
+}
+
+func isArgon2idHash(hash string) bool {
+	return strings.HasPrefix(hash, "$"+HASH_ALGORITHM_ARGON2ID+"$")
+}
+
+// MigratingHasher creates new hashes with Current, but verifies any hash
+// format that shipboard has ever produced. This lets existing users log in
+// after the algorithm changes, and their hash gets upgraded on that login.
+type MigratingHasher struct {
+	Current PasswordHasher
+	bcrypt  BcryptHasher
+	argon2  Argon2idHasher
+}
+
+func (h *MigratingHasher) Hash(password string) (string, error) {
+	return h.Current.Hash(password)
+}
+
+func (h *MigratingHasher) Verify(hash string, password string) (bool, error) {
+	switch {
+	case isBcryptHash(hash):
+		return h.bcrypt.Verify(hash, password)
+	case isArgon2idHash(hash):
+		return h.argon2.Verify(hash, password)
+	default:
+		return false, ErrUnknownHashFormat
+	}
+}
+
+func (h *MigratingHasher) NeedsRehash(hash string) bool {
+	return h.Current.NeedsRehash(hash)
+}
+
+// NewPasswordHasher returns a hasher that creates hashes with the given
+// algorithm. Zero valued parameters fall back to the defaults.
+func NewPasswordHasher(algorithm string, bcryptCost int, argon2Time uint32, argon2Memory uint32, argon2Threads uint8) (PasswordHasher, error) {
+	var current PasswordHasher
+	switch algorithm {
+	case HASH_ALGORITHM_BCRYPT:
+		if bcryptCost == 0 {
+			bcryptCost = DEFAULT_BCRYPT_COST
+		}
+		if bcryptCost < bcrypt.MinCost || bcryptCost > bcrypt.MaxCost {
+			return nil, bcrypt.InvalidCostError(bcryptCost)
+		}
+		current = &BcryptHasher{Cost: bcryptCost}
+	case HASH_ALGORITHM_ARGON2ID, "":
+		if argon2Time == 0 {
+			argon2Time = DEFAULT_ARGON2ID_TIME
+		}
+		if argon2Memory == 0 {
+			argon2Memory = DEFAULT_ARGON2ID_MEMORY
+		}
+		if argon2Threads == 0 {
+			argon2Threads = DEFAULT_ARGON2ID_THREADS
+		}
+		current = &Argon2idHasher{Time: argon2Time, Memory: argon2Memory, Threads: argon2Threads}
+	default:
+		return nil, fmt.Errorf("unsupported password hash algorithm: %s", algorithm)
+	}
+	return &MigratingHasher{Current: current}, nil
+}
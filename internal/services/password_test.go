@@ -0,0 +1,58 @@
+package services
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestMigratingHasherUpgradesBcryptToArgon2id(t *testing.T) {
+	legacy, err := NewPasswordHasher(HASH_ALGORITHM_BCRYPT, bcrypt.MinCost, 0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := legacy.Hash("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	current, err := NewPasswordHasher(HASH_ALGORITHM_ARGON2ID, 0, 1, 1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	matched, err := current.Verify(hash, "hunter2")
+	if err != nil || !matched {
+		t.Fatalf("expected bcrypt hash to verify, got %v, %v", matched, err)
+	}
+	if !current.NeedsRehash(hash) {
+		t.Error("expected bcrypt hash to need a rehash")
+	}
+
+	upgraded, err := current.Hash("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.NeedsRehash(upgraded) {
+		t.Error("expected fresh argon2id hash to not need a rehash")
+	}
+	matched, err = current.Verify(upgraded, "wrong")
+	if err != nil || matched {
+		t.Fatalf("expected wrong password to be rejected, got %v, %v", matched, err)
+	}
+}
+
+func TestArgon2idNeedsRehashOnParameterChange(t *testing.T) {
+	old := &Argon2idHasher{Time: 1, Memory: 1024, Threads: 1}
+	hash, err := old.Hash("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stronger := &Argon2idHasher{Time: 2, Memory: 1024, Threads: 1}
+	if !stronger.NeedsRehash(hash) {
+		t.Error("expected hash with outdated time cost to need a rehash")
+	}
+	matched, err := stronger.Verify(hash, "hunter2")
+	if err != nil || !matched {
+		t.Fatalf("expected hash with old parameters to verify, got %v, %v", matched, err)
+	}
+}
@@ -0,0 +1,31 @@
+package services
+
+import "errors"
+
+// Quota caps what a user stores. Zero fields don't limit.
+type Quota struct {
+	MaxClips int64
+	// Of the clips and their representations
+	MaxBytes int64
+}
+
+var (
+	ErrClipQuotaExceeded    = errors.New("clip quota exceeded")
+	ErrStorageQuotaExceeded = errors.New("storage quota exceeded")
+	// The clip is larger than the whole quota, it can never be stored
+	ErrLargerThanQuota = errors.New("clip is larger than the storage quota")
+)
+
+// Allows checks whether a clip of size bytes can be added to what the user
+// already stores.
+func (quota Quota) Allows(clips int64, bytes int64, size int64) error {
+	switch {
+	case quota.MaxBytes > 0 && size > quota.MaxBytes:
+		return ErrLargerThanQuota
+	case quota.MaxBytes > 0 && bytes+size > quota.MaxBytes:
+		return ErrStorageQuotaExceeded
+	case quota.MaxClips > 0 && clips+1 > quota.MaxClips:
+		return ErrClipQuotaExceeded
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package services
+
+import "testing"
+
+func TestQuotaAllows(t *testing.T) {
+	tests := []struct {
+		name     string
+		quota    Quota
+		clips    int64
+		bytes    int64
+		size     int64
+		expected error
+	}{
+		{"unlimited", Quota{}, 1 << 20, 1 << 40, 1 << 30, nil},
+		{"within", Quota{MaxClips: 10, MaxBytes: 100}, 9, 50, 50, nil},
+		{"too many clips", Quota{MaxClips: 10}, 10, 0, 1, ErrClipQuotaExceeded},
+		{"too many bytes", Quota{MaxBytes: 100}, 0, 60, 41, ErrStorageQuotaExceeded},
+		{"larger than quota", Quota{MaxBytes: 100}, 0, 0, 101, ErrLargerThanQuota},
+		// Users above a lowered quota can't add anything, even empty clips
+		{"over quota", Quota{MaxBytes: 100}, 0, 150, 0, ErrStorageQuotaExceeded},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.quota.Allows(test.clips, test.bytes, test.size)
+			if err != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RateLimiter counts attempts in fixed windows shared by all instances, e.g.
+// to slow down password guessing.
+type RateLimiter struct {
+	Client *redis.Client
+}
+
+const RATE_LIMIT_KEY_PREFIX = "__rate_limit__"
+
+// Counts the attempt, the window starts with the first one
+var countAttempt = redis.NewScript(`
+local attempts = redis.call('INCR', KEYS[1])
+if attempts == 1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+return attempts
+`)
+
+// Allow counts an attempt under key, and reports whether at most limit
+// attempts were made in the current window.
+func (r *RateLimiter) Allow(key string, limit int, window time.Duration) (bool, error) {
+	attempts, err := countAttempt.Run(context.Background(), r.Client, []string{RATE_LIMIT_KEY_PREFIX + key}, window.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return attempts <= limit, nil
+}
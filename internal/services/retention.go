@@ -0,0 +1,34 @@
+package services
+
+import "time"
+
+// RetentionPolicy limits how much of the history of a user is kept. Clips
+// beyond any limit are deleted, oldest first. Zero fields don't limit.
+type RetentionPolicy struct {
+	MaxAge   time.Duration
+	MaxClips int
+	// Of the content of the clips, see Clip.Size
+	MaxBytes int64
+}
+
+func (policy RetentionPolicy) IsZero() bool {
+	return policy == RetentionPolicy{}
+}
+
+// stricter returns the smaller of two limits, where 0 is no limit.
+func stricter[T int | int64 | time.Duration](a T, b T) T {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// Stricter combines two policies, keeping the stricter limit of each. Used
+// to apply the policy of a user within the global one.
+func (policy RetentionPolicy) Stricter(other RetentionPolicy) RetentionPolicy {
+	return RetentionPolicy{
+		MaxAge:   stricter(policy.MaxAge, other.MaxAge),
+		MaxClips: stricter(policy.MaxClips, other.MaxClips),
+		MaxBytes: stricter(policy.MaxBytes, other.MaxBytes),
+	}
+}
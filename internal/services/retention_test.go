@@ -0,0 +1,36 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetentionPolicyStricter(t *testing.T) {
+	tests := []struct {
+		name     string
+		global   RetentionPolicy
+		user     RetentionPolicy
+		expected RetentionPolicy
+	}{
+		{"none", RetentionPolicy{}, RetentionPolicy{}, RetentionPolicy{}},
+		{"global only", RetentionPolicy{MaxAge: time.Hour}, RetentionPolicy{}, RetentionPolicy{MaxAge: time.Hour}},
+		{"user only", RetentionPolicy{}, RetentionPolicy{MaxClips: 10}, RetentionPolicy{MaxClips: 10}},
+		{
+			"mixed",
+			RetentionPolicy{MaxAge: 30 * 24 * time.Hour, MaxClips: 1000, MaxBytes: 1 << 30},
+			RetentionPolicy{MaxAge: 7 * 24 * time.Hour, MaxClips: 5000},
+			RetentionPolicy{MaxAge: 7 * 24 * time.Hour, MaxClips: 1000, MaxBytes: 1 << 30},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.global.Stricter(test.user); got != test.expected {
+				t.Errorf("expected %+v, got %+v", test.expected, got)
+			}
+			// Order must not matter
+			if got := test.user.Stricter(test.global); got != test.expected {
+				t.Errorf("expected %+v, got %+v in reverse order", test.expected, got)
+			}
+		})
+	}
+}
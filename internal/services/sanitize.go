@@ -0,0 +1,97 @@
+package services
+
+import (
+	"io"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Tags kept by SanitizeHTML, without any of their attributes except href on
+// links.
+var allowedHTMLTags = map[string]bool{
+	"a": true, "b": true, "blockquote": true, "br": true, "code": true,
+	"del": true, "div": true, "em": true, "h1": true, "h2": true, "h3": true,
+	"h4": true, "h5": true, "h6": true, "hr": true, "i": true, "li": true,
+	"ol": true, "p": true, "pre": true, "s": true, "span": true,
+	"strong": true, "sub": true, "sup": true, "table": true, "tbody": true,
+	"td": true, "th": true, "thead": true, "tr": true, "u": true, "ul": true,
+}
+
+// Tags dropped along with everything inside them
+var droppedHTMLTags = map[string]bool{
+	"head": true, "iframe": true, "math": true, "noscript": true,
+	"object": true, "script": true, "style": true, "svg": true,
+	"template": true, "textarea": true, "title": true,
+}
+
+var allowedLinkSchemes = map[string]bool{"http": true, "https": true, "mailto": true}
+
+// SanitizeHTML keeps only basic formatting of untrusted HTML, e.g. copied from
+// a web page, so that it can be shown inline. Every attribute is removed, except
+// http, https and mailto links.
+func SanitizeHTML(input string) string {
+	var b strings.Builder
+	tokenizer := html.NewTokenizer(strings.NewReader(input))
+	// Depth inside a dropped tag, nothing is written while it is positive
+	dropped := 0
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			if tokenizer.Err() != io.EOF {
+				// Only reading from a string, this can't happen
+				return ""
+			}
+			return b.String()
+		}
+		token := tokenizer.Token()
+
+		switch tokenType {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if droppedHTMLTags[token.Data] {
+				if tokenType == html.StartTagToken {
+					dropped++
+				}
+				continue
+			}
+			if dropped > 0 || !allowedHTMLTags[token.Data] {
+				continue
+			}
+			b.WriteString("<" + token.Data)
+			if token.Data == "a" {
+				if href := sanitizeLink(token.Attr); href != "" {
+					b.WriteString(` href="` + html.EscapeString(href) + `" rel="nofollow noopener noreferrer" target="_blank"`)
+				}
+			}
+			b.WriteString(">")
+		case html.EndTagToken:
+			if droppedHTMLTags[token.Data] {
+				dropped = max(dropped-1, 0)
+				continue
+			}
+			if dropped > 0 || !allowedHTMLTags[token.Data] || token.Data == "br" || token.Data == "hr" {
+				continue
+			}
+			b.WriteString("</" + token.Data + ">")
+		case html.TextToken:
+			if dropped == 0 {
+				b.WriteString(html.EscapeString(token.Data))
+			}
+		}
+	}
+}
+
+func sanitizeLink(attrs []html.Attribute) string {
+	for _, attr := range attrs {
+		if attr.Key != "href" {
+			continue
+		}
+		link, err := url.Parse(strings.TrimSpace(attr.Val))
+		if err != nil || !allowedLinkSchemes[strings.ToLower(link.Scheme)] {
+			return ""
+		}
+		return link.String()
+	}
+	return ""
+}
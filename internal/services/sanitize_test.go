@@ -0,0 +1,31 @@
+package services
+
+import "testing"
+
+func TestSanitizeHTML(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"formatting", "<p>Hello <b>world</b><br></p>", "<p>Hello <b>world</b><br></p>"},
+		{"attributes", `<p onclick="alert(1)" style="color: red">x</p>`, "<p>x</p>"},
+		{"script", "a<script>alert(1)</script>b", "ab"},
+		{"nested dropped", "<style><script>x</script>y</style>z", "z"},
+		{"unknown tag keeps text", "<marquee>moving</marquee>", "moving"},
+		{"image", `<img src="https://tracker.example/x.png" onerror="alert(1)">`, ""},
+		{"http link", `<a href="https://example.com/?a=1&b=2" onclick="x">link</a>`,
+			`<a href="https://example.com/?a=1&amp;b=2" rel="nofollow noopener noreferrer" target="_blank">link</a>`},
+		{"javascript link", `<a href="javascript:alert(1)">link</a>`, "<a>link</a>"},
+		{"javascript link with spaces", `<a href=" JaVaScRiPt:alert(1)">link</a>`, "<a>link</a>"},
+		{"escaped text", "1 &lt; 2 &amp;&amp; <b>3 > 2</b>", "1 &lt; 2 &amp;&amp; <b>3 &gt; 2</b>"},
+		{"comment", "a<!-- <script>x</script> -->b", "ab"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := SanitizeHTML(test.input); got != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package services
+
+import (
+	"math"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Clips that look like secrets are kept on the clipboard only this long
+const SENSITIVE_CLIP_TTL = 10 * time.Minute
+
+type secretPattern struct {
+	name    string
+	pattern *regexp.Regexp
+}
+
+// Formats of well known credentials. Their prefixes make them certain enough
+// to be flagged without looking at their entropy.
+var secretPatterns = []secretPattern{
+	{"private key", regexp.MustCompile(`-----BEGIN ([A-Z0-9]+ )*PRIVATE KEY( BLOCK)?-----`)},
+	{"AWS access key", regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"GitHub token", regexp.MustCompile(`\b(gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{60,})\b`)},
+	{"GitLab token", regexp.MustCompile(`\bglpat-[A-Za-z0-9_-]{20,}\b`)},
+	{"Slack token", regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}\b`)},
+	{"Stripe key", regexp.MustCompile(`\b[rs]k_(live|test)_[A-Za-z0-9]{16,}\b`)},
+	{"Google API key", regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`)},
+	{"OpenAI key", regexp.MustCompile(`\bsk-(proj-)?[A-Za-z0-9_-]{32,}\b`)},
+	{"JSON web token", regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{10,}\.eyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}`)},
+	{"password in URL", regexp.MustCompile(`[a-z][a-z0-9+.-]*://[^/\s:@]+:[^/\s:@]{3,}@`)},
+}
+
+// Candidate tokens for the entropy check: long runs of base64, hex or url
+// safe characters
+var tokenPattern = regexp.MustCompile(`[A-Za-z0-9+/=_\-]{20,}`)
+
+// Labels of secrets, ending right before the token, e.g. "API_TOKEN=" or
+// "secret: ". Hex tokens need one, they are usually digests or ids otherwise.
+var secretLabelPattern = regexp.MustCompile(`(?i)(secret|token|key|passw(or)?d|pwd|credential|auth)[A-Za-z0-9_.-]*["']?\s*[:=]\s*["']?$`)
+
+// Digests are never secrets: "sha256:" and "h1:" prefixes in go.sum, lock
+// files and image references, and commit hashes
+var checksumLabelPattern = regexp.MustCompile(`(?i)(\b(md5|sha(1|224|256|384|512)|h1)[:=]|\bcommit\s+)$`)
+
+// Subresource integrity hashes, which the token pattern matches along with
+// their algorithm
+var integrityPattern = regexp.MustCompile(`^sha(256|384|512)-`)
+
+// Labels are looked for in this many bytes before a token
+const maxLabelLength = 64
+
+// Random tokens have more bits of entropy per character than words and
+// identifiers. Hex secrets top out at 4 bits per character.
+const (
+	minTokenEntropy    = 4.0
+	minHexTokenEntropy = 3.0
+	minHexTokenLength  = 32
+)
+
+// Only the start of a clip is scanned, secrets are pasted on their own
+const maxSecretScanLength = 64 << 10
+
+// entropy returns the Shannon entropy of s, in bits per character.
+func entropy(s string) float64 {
+	counts := make(map[rune]int)
+	for _, r := range s {
+		counts[r]++
+	}
+	var bits float64
+	for _, count := range counts {
+		p := float64(count) / float64(len(s))
+		bits -= p * math.Log2(p)
+	}
+	return bits
+}
+
+func isHex(s string) bool {
+	return strings.Trim(s, "0123456789abcdefABCDEF") == ""
+}
+
+// looksRandom reports whether the token reads like a generated secret rather
+// than a word, a path or an identifier. Hex tokens only do if labelled as a
+// secret.
+func looksRandom(token string, labelled bool) bool {
+	token = strings.TrimRight(token, "=")
+	if isHex(token) {
+		return labelled && len(token) >= minHexTokenLength && entropy(token) >= minHexTokenEntropy
+	}
+	var hasUpper, hasLower, hasDigit bool
+	for _, r := range token {
+		hasUpper = hasUpper || unicode.IsUpper(r)
+		hasLower = hasLower || unicode.IsLower(r)
+		hasDigit = hasDigit || unicode.IsDigit(r)
+	}
+	// Generated tokens mix all three, names like some_long_identifier don't
+	if !hasUpper || !hasLower || !hasDigit {
+		return false
+	}
+	return entropy(token) >= minTokenEntropy
+}
+
+// DetectSecret returns what kind of secret the content likely contains, or an
+// empty string if it doesn't look like it contains any.
+func DetectSecret(content string) string {
+	if len(content) > maxSecretScanLength {
+		content = content[:maxSecretScanLength]
+	}
+	for _, secret := range secretPatterns {
+		if secret.pattern.MatchString(content) {
+			return secret.name
+		}
+	}
+	for _, loc := range tokenPattern.FindAllStringIndex(content, -1) {
+		token := content[loc[0]:loc[1]]
+		label := content[max(0, loc[0]-maxLabelLength):loc[0]]
+		if checksumLabelPattern.MatchString(label) || integrityPattern.MatchString(token) {
+			continue
+		}
+		if looksRandom(token, secretLabelPattern.MatchString(label)) {
+			return "high entropy token"
+		}
+	}
+	return ""
+}
@@ -17,23 +17,41 @@ type SessionData struct {
 	ExpiresAt time.Time `json:"expires_at"`
 }
 
-type SessionStore struct {
+// RedisSessionStore keeps sessions in redis, they expire after a day.
+type RedisSessionStore struct {
 	Client *redis.Client
 }
 
 const SESSION_ID_KEY_PREFIX = "__session_id__"
 
-func (r *SessionStore) formatSessionID(sessionID string) string {
+// Set of the session ids of a user. Used to revoke all sessions of a user.
+// Members can outlive the session itself, so they are pruned on revocation.
+const USER_SESSIONS_KEY_PREFIX = "__user_sessions__"
+
+const sessionTTL = 24 * time.Hour
+
+func (r *RedisSessionStore) formatSessionID(sessionID string) string {
 	return fmt.Sprintf("%s%s", SESSION_ID_KEY_PREFIX, sessionID)
 }
 
-func (r *SessionStore) Set(sessionID string, data SessionData) error {
+func (r *RedisSessionStore) formatUserSessionsKey(userID int32) string {
+	return fmt.Sprintf("%s%d", USER_SESSIONS_KEY_PREFIX, userID)
+}
+
+func (r *RedisSessionStore) Set(sessionID string, data SessionData) error {
 	json, _ := json.Marshal(data)
-	sessionID = r.formatSessionID(sessionID)
-	return r.Client.Set(context.Background(), sessionID, json, 24*time.Hour).Err()
+	ctx := context.Background()
+	userSessionsKey := r.formatUserSessionsKey(data.UserID)
+	_, err := r.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, r.formatSessionID(sessionID), json, sessionTTL)
+		pipe.SAdd(ctx, userSessionsKey, sessionID)
+		pipe.Expire(ctx, userSessionsKey, sessionTTL)
+		return nil
+	})
+	return err
 }
 
-func (r *SessionStore) Get(sessionID string) (*SessionData, error) {
+func (r *RedisSessionStore) Get(sessionID string) (*SessionData, error) {
 	sessionID = r.formatSessionID(sessionID)
 	val, err := r.Client.Get(context.Background(), sessionID).Result()
 	if err != nil {
@@ -45,14 +63,74 @@ func (r *SessionStore) Get(sessionID string) (*SessionData, error) {
 	return &data, err
 }
 
-func (r *SessionStore) Expire(sessionID string) (error) {
-	sessionID = r.formatSessionID(sessionID)
-	_, err := r.Client.Del(context.Background(), sessionID).Result()
+func (r *RedisSessionStore) Expire(sessionID string) error {
+	data, err := r.Get(sessionID)
+	if err == redis.Nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	_, err = r.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, r.formatSessionID(sessionID))
+		pipe.SRem(ctx, r.formatUserSessionsKey(data.UserID), sessionID)
+		return nil
+	})
 	return err
 }
 
-func (r *SessionStore) CreateSession(data SessionData) (string, error) {
+// ExpireUserSessions expires all the sessions of a user, except the session
+// with id exceptSessionID. Pass an empty string to expire every session.
+func (r *RedisSessionStore) ExpireUserSessions(userID int32, exceptSessionID string) error {
+	ctx := context.Background()
+	userSessionsKey := r.formatUserSessionsKey(userID)
+	sessionIDs, err := r.Client.SMembers(ctx, userSessionsKey).Result()
+	if err != nil {
+		return err
+	}
+	var expired []string
+	for _, sessionID := range sessionIDs {
+		if sessionID != exceptSessionID {
+			expired = append(expired, sessionID)
+		}
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+	_, err = r.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, sessionID := range expired {
+			pipe.Del(ctx, r.formatSessionID(sessionID))
+			pipe.SRem(ctx, userSessionsKey, sessionID)
+		}
+		return nil
+	})
+	return err
+}
+
+func (r *RedisSessionStore) CreateSession(data SessionData) (string, error) {
 	sessionID := uuid.New().String()
 	err := r.Set(sessionID, data)
 	return sessionID, err
 }
+
+// UserSessions returns the data of the active sessions of a user.
+func (r *RedisSessionStore) UserSessions(userID int32) ([]SessionData, error) {
+	ctx := context.Background()
+	sessionIDs, err := r.Client.SMembers(ctx, r.formatUserSessionsKey(userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	var sessions []SessionData
+	for _, sessionID := range sessionIDs {
+		data, err := r.Get(sessionID)
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *data)
+	}
+	return sessions, nil
+}
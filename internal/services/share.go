@@ -0,0 +1,168 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Share is a public link to a single clip. It is deleted once it runs out of
+// views, or expires.
+type Share struct {
+	ClipID    int32
+	UserID    int32
+	ViewsLeft int
+	// Empty if the share is not password protected
+	PasswordHash string
+}
+
+type ShareStore struct {
+	Client *redis.Client
+}
+
+const SHARE_KEY_PREFIX = "__share__"
+
+// Tokens of the shares of each user, kept as long as the last of them
+const USER_SHARES_KEY_PREFIX = "__user_shares__"
+
+// Wrong passwords a protected share takes before it is deleted
+const SHARE_PASSWORD_ATTEMPTS = 10
+
+// Decrements the views left and deletes the share on its last view, so that
+// concurrent views can never exceed the limit. Returns the views left after
+// this one, or -1 if the share doesn't exist.
+var consumeShareView = redis.NewScript(`
+local views = tonumber(redis.call('HGET', KEYS[1], 'views_left'))
+if not views or views <= 0 then
+	return -1
+end
+if views == 1 then
+	redis.call('DEL', KEYS[1])
+else
+	redis.call('HINCRBY', KEYS[1], 'views_left', -1)
+end
+return views - 1
+`)
+
+// Counts a wrong password and deletes the share once it reaches the limit.
+// Returns the attempts left, or -1 if the share doesn't exist.
+var failShareAttempt = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return -1
+end
+local failed = redis.call('HINCRBY', KEYS[1], 'failed_attempts', 1)
+local limit = tonumber(ARGV[1])
+if failed >= limit then
+	redis.call('DEL', KEYS[1])
+	return 0
+end
+return limit - failed
+`)
+
+// Adds the token to the shares of the user, extending their expiry to the
+// share's if it lasts longer
+var indexShare = redis.NewScript(`
+redis.call('SADD', KEYS[1], ARGV[1])
+if redis.call('PTTL', KEYS[1]) < tonumber(ARGV[2]) then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 1
+`)
+
+func (r *ShareStore) formatShareKey(token string) string {
+	return fmt.Sprintf("%s%s", SHARE_KEY_PREFIX, token)
+}
+
+func (r *ShareStore) formatUserSharesKey(userID int32) string {
+	return fmt.Sprintf("%s%d", USER_SHARES_KEY_PREFIX, userID)
+}
+
+func (r *ShareStore) Create(share Share, ttl time.Duration) (string, error) {
+	token, err := NewToken()
+	if err != nil {
+		return "", err
+	}
+	ctx := context.Background()
+	key := r.formatShareKey(token)
+	_, err = r.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key,
+			"clip_id", share.ClipID,
+			"user_id", share.UserID,
+			"views_left", share.ViewsLeft,
+			"password_hash", share.PasswordHash,
+		)
+		pipe.Expire(ctx, key, ttl)
+		indexShare.Eval(ctx, pipe, []string{r.formatUserSharesKey(share.UserID)}, token, ttl.Milliseconds())
+		return nil
+	})
+	return token, err
+}
+
+// Get returns the share without counting a view. Returns redis.Nil for
+// unknown, expired and used up shares.
+func (r *ShareStore) Get(token string) (*Share, error) {
+	values, err := r.Client.HGetAll(context.Background(), r.formatShareKey(token)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return nil, redis.Nil
+	}
+
+	share := &Share{PasswordHash: values["password_hash"]}
+	clipID, err := strconv.ParseInt(values["clip_id"], 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	userID, err := strconv.ParseInt(values["user_id"], 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	share.ClipID, share.UserID = int32(clipID), int32(userID)
+	share.ViewsLeft, err = strconv.Atoi(values["views_left"])
+	return share, err
+}
+
+// FailAttempt counts a wrong password against the share. Returns the
+// attempts left, or redis.Nil if the share doesn't exist.
+func (r *ShareStore) FailAttempt(token string) (int, error) {
+	attemptsLeft, err := failShareAttempt.Run(context.Background(), r.Client, []string{r.formatShareKey(token)}, SHARE_PASSWORD_ATTEMPTS).Int()
+	if err != nil {
+		return 0, err
+	}
+	if attemptsLeft < 0 {
+		return 0, redis.Nil
+	}
+	return attemptsLeft, nil
+}
+
+// ConsumeView counts a view of the share. Returns redis.Nil if the share
+// has no views left.
+func (r *ShareStore) ConsumeView(token string) (int, error) {
+	viewsLeft, err := consumeShareView.Run(context.Background(), r.Client, []string{r.formatShareKey(token)}).Int()
+	if err != nil {
+		return 0, err
+	}
+	if viewsLeft < 0 {
+		return 0, redis.Nil
+	}
+	return viewsLeft, nil
+}
+
+// DeleteUserShares deletes every share of the user.
+func (r *ShareStore) DeleteUserShares(userID int32) error {
+	ctx := context.Background()
+	userSharesKey := r.formatUserSharesKey(userID)
+	tokens, err := r.Client.SMembers(ctx, userSharesKey).Result()
+	if err != nil {
+		return err
+	}
+	keys := []string{userSharesKey}
+	for _, token := range tokens {
+		keys = append(keys, r.formatShareKey(token))
+	}
+	return r.Client.Del(ctx, keys...).Err()
+}
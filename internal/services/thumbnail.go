@@ -0,0 +1,100 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+)
+
+// Thumbnails fit in a square of this many pixels
+const THUMBNAIL_SIZE = 256
+
+const THUMBNAIL_CONTENT_TYPE = "image/png"
+
+// Larger images are not decoded, a small file can claim huge dimensions. A
+// decoded image takes 4 bytes per pixel, up to twice while it is scaled down.
+const maxThumbnailSourcePixels = 16_000_000
+
+var ErrUnsupportedImage = errors.New("unsupported image")
+
+// Thumbnail decodes a PNG, JPEG or GIF image and returns it scaled down to fit
+// in a size by size square, encoded as PNG. Only the first frame of animated
+// GIFs is kept. Smaller images are not scaled up.
+func Thumbnail(data []byte, size int) ([]byte, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedImage, err)
+	}
+	if config.Width*config.Height > maxThumbnailSourcePixels {
+		return nil, fmt.Errorf("%w: %dx%d is too large", ErrUnsupportedImage, config.Width, config.Height)
+	}
+	source, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedImage, err)
+	}
+
+	bounds := source.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > size || height > size {
+		if width >= height {
+			width, height = size, max(1, height*size/width)
+		} else {
+			width, height = max(1, width*size/height), size
+		}
+	}
+
+	var buf bytes.Buffer
+	err = png.Encode(&buf, scaleDown(source, width, height))
+	return buf.Bytes(), err
+}
+
+// scaleDown resizes the image by averaging the source pixels covered by each
+// pixel of the result. Images not decoded as NRGBA are converted first.
+func scaleDown(source image.Image, width int, height int) image.Image {
+	rgba, ok := source.(*image.NRGBA)
+	if !ok || rgba.Bounds().Min != (image.Point{}) {
+		bounds := source.Bounds()
+		rgba = image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+		draw.Draw(rgba, rgba.Bounds(), source, bounds.Min, draw.Src)
+	}
+	bounds := rgba.Bounds()
+	if width == bounds.Dx() && height == bounds.Dy() {
+		return rgba
+	}
+
+	result := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0, y1 := y*bounds.Dy()/height, max((y+1)*bounds.Dy()/height, y*bounds.Dy()/height+1)
+		for x := 0; x < width; x++ {
+			x0, x1 := x*bounds.Dx()/width, max((x+1)*bounds.Dx()/width, x*bounds.Dx()/width+1)
+			var r, g, b, a, count uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					offset := rgba.PixOffset(sx, sy)
+					pixel := rgba.Pix[offset : offset+4]
+					// Weigh colors by alpha, so that transparent pixels don't
+					// darken the edges
+					alpha := uint64(pixel[3])
+					r += uint64(pixel[0]) * alpha
+					g += uint64(pixel[1]) * alpha
+					b += uint64(pixel[2]) * alpha
+					a += alpha
+					count++
+				}
+			}
+			offset := result.PixOffset(x, y)
+			if a > 0 {
+				result.Pix[offset] = uint8(r / a)
+				result.Pix[offset+1] = uint8(g / a)
+				result.Pix[offset+2] = uint8(b / a)
+			}
+			result.Pix[offset+3] = uint8(a / count)
+		}
+	}
+	return result
+}
@@ -0,0 +1,104 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func testImage(width int, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	return img
+}
+
+func TestThumbnail(t *testing.T) {
+	encoders := map[string]func(*bytes.Buffer, image.Image) error{
+		"png":  func(buf *bytes.Buffer, img image.Image) error { return png.Encode(buf, img) },
+		"jpeg": func(buf *bytes.Buffer, img image.Image) error { return jpeg.Encode(buf, img, nil) },
+		"gif":  func(buf *bytes.Buffer, img image.Image) error { return gif.Encode(buf, img, nil) },
+	}
+	sizes := []struct {
+		width, height                 int
+		expectedWidth, expectedHeight int
+	}{
+		{1000, 500, 256, 128},
+		{300, 900, 85, 256},
+		{100, 50, 100, 50},
+	}
+	for format, encode := range encoders {
+		for _, size := range sizes {
+			var buf bytes.Buffer
+			if err := encode(&buf, testImage(size.width, size.height)); err != nil {
+				t.Fatal(err)
+			}
+			thumbnail, err := Thumbnail(buf.Bytes(), THUMBNAIL_SIZE)
+			if err != nil {
+				t.Fatalf("%s %dx%d: %v", format, size.width, size.height, err)
+			}
+			decoded, err := png.Decode(bytes.NewReader(thumbnail))
+			if err != nil {
+				t.Fatalf("%s %dx%d: thumbnail is not a png: %v", format, size.width, size.height, err)
+			}
+			bounds := decoded.Bounds()
+			if bounds.Dx() != size.expectedWidth || bounds.Dy() != size.expectedHeight {
+				t.Errorf("%s %dx%d: expected %dx%d, got %dx%d", format, size.width, size.height,
+					size.expectedWidth, size.expectedHeight, bounds.Dx(), bounds.Dy())
+			}
+		}
+	}
+}
+
+func TestThumbnailKeepsTransparency(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 512, 512))
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	thumbnail, err := Thumbnail(buf.Bytes(), THUMBNAIL_SIZE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := png.Decode(bytes.NewReader(thumbnail))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, a := decoded.At(10, 10).RGBA(); a != 0 {
+		t.Errorf("expected transparent pixel, got alpha %d", a)
+	}
+}
+
+func TestThumbnailRejectsOtherData(t *testing.T) {
+	_, err := Thumbnail([]byte("not an image"), THUMBNAIL_SIZE)
+	if !errors.Is(err, ErrUnsupportedImage) {
+		t.Errorf("expected ErrUnsupportedImage, got %v", err)
+	}
+}
+
+func TestThumbnailRejectsLargeImages(t *testing.T) {
+	// Only the header is read, a PNG claiming 5000x4000 pixels is enough
+	ihdr := []byte("IHDR")
+	ihdr = binary.BigEndian.AppendUint32(ihdr, 5000)
+	ihdr = binary.BigEndian.AppendUint32(ihdr, 4000)
+	ihdr = append(ihdr, 8, 6, 0, 0, 0)
+	data := []byte("\x89PNG\r\n\x1a\n")
+	data = binary.BigEndian.AppendUint32(data, uint32(len(ihdr)-4))
+	data = append(data, ihdr...)
+	data = binary.BigEndian.AppendUint32(data, crc32.ChecksumIEEE(ihdr))
+
+	_, err := Thumbnail(data, THUMBNAIL_SIZE)
+	if !errors.Is(err, ErrUnsupportedImage) {
+		t.Errorf("expected ErrUnsupportedImage, got %v", err)
+	}
+}
@@ -0,0 +1,214 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Uploads must be finished within this time, their chunks are deleted after.
+const UploadTTL = 24 * time.Hour
+
+// Chunks of uploads are kept under this prefix of the blob store
+const UPLOAD_BLOB_PREFIX = "uploads/"
+
+const UPLOAD_KEY_PREFIX = "__upload__"
+
+// Blob keys of the chunks of an upload, in order
+const UPLOAD_CHUNKS_KEY_PREFIX = "__upload_chunks__"
+
+// Ids of the uploads of each user
+const USER_UPLOADS_KEY_PREFIX = "__user_uploads__"
+
+var ErrUploadOffsetMismatch = errors.New("upload offset does not match")
+
+// Upload is a file being uploaded in chunks. Offset is the number of bytes
+// received so far.
+type Upload struct {
+	ID          string
+	UserID      int32
+	Length      int64
+	Offset      int64
+	FileName    string
+	ContentType string
+	ExpiresAt   time.Time
+}
+
+type UploadStore struct {
+	Client *redis.Client
+}
+
+// Appends a chunk if it starts at the current offset, so that a chunk sent
+// twice, e.g. by a retry, is only counted once. Returns the new offset, -1 if
+// the upload doesn't exist and -2 if the offset doesn't match.
+var addUploadChunk = redis.NewScript(`
+local offset = redis.call('HGET', KEYS[1], 'offset')
+if not offset then
+	return -1
+end
+if offset ~= ARGV[1] then
+	return -2
+end
+local new = redis.call('HINCRBY', KEYS[1], 'offset', ARGV[2])
+redis.call('RPUSH', KEYS[2], ARGV[3])
+redis.call('PEXPIRE', KEYS[2], redis.call('PTTL', KEYS[1]))
+return new
+`)
+
+// Sets the finalizing field of an existing upload only, so that a claim never
+// recreates an expired upload. Returns 1 if the upload was claimed, 0 if it
+// already was and -1 if it doesn't exist.
+var claimUpload = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return -1
+end
+return redis.call('HSETNX', KEYS[1], 'finalizing', 1)
+`)
+
+func (r *UploadStore) formatUploadKey(id string) string {
+	return fmt.Sprintf("%s%s", UPLOAD_KEY_PREFIX, id)
+}
+
+func (r *UploadStore) formatChunksKey(id string) string {
+	return fmt.Sprintf("%s%s", UPLOAD_CHUNKS_KEY_PREFIX, id)
+}
+
+func (r *UploadStore) formatUserUploadsKey(userID int32) string {
+	return fmt.Sprintf("%s%d", USER_UPLOADS_KEY_PREFIX, userID)
+}
+
+// Create starts an upload and sets its ID and expiry.
+func (r *UploadStore) Create(upload *Upload) error {
+	id, err := NewToken()
+	if err != nil {
+		return err
+	}
+	upload.ID = id
+	upload.Offset = 0
+	upload.ExpiresAt = time.Now().Add(UploadTTL)
+
+	ctx := context.Background()
+	key := r.formatUploadKey(id)
+	_, err = r.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key,
+			"user_id", upload.UserID,
+			"length", upload.Length,
+			"offset", upload.Offset,
+			"file_name", upload.FileName,
+			"content_type", upload.ContentType,
+			"expires_at", upload.ExpiresAt.Unix(),
+		)
+		pipe.ExpireAt(ctx, key, upload.ExpiresAt)
+		// Uploads all last as long, the latest one expires last
+		pipe.SAdd(ctx, r.formatUserUploadsKey(upload.UserID), id)
+		pipe.ExpireAt(ctx, r.formatUserUploadsKey(upload.UserID), upload.ExpiresAt)
+		return nil
+	})
+	return err
+}
+
+// Get returns redis.Nil for unknown and expired uploads.
+func (r *UploadStore) Get(id string) (*Upload, error) {
+	values, err := r.Client.HGetAll(context.Background(), r.formatUploadKey(id)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return nil, redis.Nil
+	}
+
+	upload := &Upload{ID: id, FileName: values["file_name"], ContentType: values["content_type"]}
+	userID, err := strconv.ParseInt(values["user_id"], 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	upload.UserID = int32(userID)
+	upload.Length, err = strconv.ParseInt(values["length"], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	upload.Offset, err = strconv.ParseInt(values["offset"], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	expiresAt, err := strconv.ParseInt(values["expires_at"], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	upload.ExpiresAt = time.Unix(expiresAt, 0)
+	return upload, nil
+}
+
+// AddChunk records the chunk of size bytes kept in the blob store under
+// blobKey, if it starts at offset. Returns the new offset, redis.Nil if the
+// upload doesn't exist anymore and ErrUploadOffsetMismatch if another chunk
+// was added at offset in the meantime.
+func (r *UploadStore) AddChunk(id string, offset int64, size int64, blobKey string) (int64, error) {
+	keys := []string{r.formatUploadKey(id), r.formatChunksKey(id)}
+	newOffset, err := addUploadChunk.Run(context.Background(), r.Client, keys, offset, size, blobKey).Int64()
+	if err != nil {
+		return 0, err
+	}
+	switch newOffset {
+	case -1:
+		return 0, redis.Nil
+	case -2:
+		return 0, ErrUploadOffsetMismatch
+	}
+	return newOffset, nil
+}
+
+// Chunks returns the blob keys of the chunks of the upload, in order.
+func (r *UploadStore) Chunks(id string) ([]string, error) {
+	return r.Client.LRange(context.Background(), r.formatChunksKey(id), 0, -1).Result()
+}
+
+// Claim marks the upload as being finalized. Returns false if it already is,
+// and redis.Nil if the upload doesn't exist. A claim is kept until the upload
+// is released, deleted or expires.
+func (r *UploadStore) Claim(id string) (bool, error) {
+	claimed, err := claimUpload.Run(context.Background(), r.Client, []string{r.formatUploadKey(id)}).Int()
+	if err != nil {
+		return false, err
+	}
+	if claimed < 0 {
+		return false, redis.Nil
+	}
+	return claimed == 1, nil
+}
+
+// Release lets the upload be finalized again.
+func (r *UploadStore) Release(id string) error {
+	return r.Client.HDel(context.Background(), r.formatUploadKey(id), "finalizing").Err()
+}
+
+// UserUploads returns the uploads of the user that have not expired.
+func (r *UploadStore) UserUploads(userID int32) ([]Upload, error) {
+	ids, err := r.Client.SMembers(context.Background(), r.formatUserUploadsKey(userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	var uploads []Upload
+	for _, id := range ids {
+		upload, err := r.Get(id)
+		if err == redis.Nil {
+			// Finalized, cancelled or expired since
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, *upload)
+	}
+	return uploads, nil
+}
+
+// Delete forgets the upload. Its chunks must be deleted from the blob store
+// separately.
+func (r *UploadStore) Delete(id string) error {
+	return r.Client.Del(context.Background(), r.formatUploadKey(id), r.formatChunksKey(id)).Err()
+}
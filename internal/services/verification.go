@@ -0,0 +1,102 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type EmailVerification struct {
+	UserID int32  `json:"user_id"`
+	Email  string `json:"email"`
+}
+
+// EmailVerificationStore keeps pending email changes until the user confirms
+// the new address. A user has at most one pending change, requesting a new
+// one invalidates the previous token.
+type EmailVerificationStore struct {
+	Client *redis.Client
+}
+
+const EMAIL_VERIFICATION_KEY_PREFIX = "__email_verification__"
+const USER_EMAIL_VERIFICATION_KEY_PREFIX = "__user_email_verification__"
+
+const EmailVerificationTTL = 24 * time.Hour
+
+func (r *EmailVerificationStore) formatTokenKey(token string) string {
+	return fmt.Sprintf("%s%s", EMAIL_VERIFICATION_KEY_PREFIX, token)
+}
+
+func (r *EmailVerificationStore) formatUserKey(userID int32) string {
+	return fmt.Sprintf("%s%d", USER_EMAIL_VERIFICATION_KEY_PREFIX, userID)
+}
+
+// NewToken returns a random url safe token with 256 bits of entropy.
+func NewToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func (r *EmailVerificationStore) Create(data EmailVerification) (string, error) {
+	token, err := NewToken()
+	if err != nil {
+		return "", err
+	}
+	json, _ := json.Marshal(data)
+	ctx := context.Background()
+	userKey := r.formatUserKey(data.UserID)
+
+	previousToken, err := r.Client.Get(ctx, userKey).Result()
+	if err != nil && err != redis.Nil {
+		return "", err
+	}
+	_, err = r.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if previousToken != "" {
+			pipe.Del(ctx, r.formatTokenKey(previousToken))
+		}
+		pipe.Set(ctx, r.formatTokenKey(token), json, EmailVerificationTTL)
+		pipe.Set(ctx, userKey, token, EmailVerificationTTL)
+		return nil
+	})
+	return token, err
+}
+
+// Consume returns the pending change for the token and deletes it, so a
+// token can be used only once. Returns redis.Nil for unknown tokens.
+func (r *EmailVerificationStore) Consume(token string) (*EmailVerification, error) {
+	ctx := context.Background()
+	val, err := r.Client.GetDel(ctx, r.formatTokenKey(token)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var data EmailVerification
+	err = json.Unmarshal([]byte(val), &data)
+	if err != nil {
+		return nil, err
+	}
+	err = r.Client.Del(ctx, r.formatUserKey(data.UserID)).Err()
+	return &data, err
+}
+
+// Cancel deletes the pending change of the user, if any.
+func (r *EmailVerificationStore) Cancel(userID int32) error {
+	ctx := context.Background()
+	userKey := r.formatUserKey(userID)
+	token, err := r.Client.Get(ctx, userKey).Result()
+	if err == redis.Nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return r.Client.Del(ctx, userKey, r.formatTokenKey(token)).Err()
+}
@@ -0,0 +1,195 @@
+package sqlite
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"time"
+
+	"github.com/amns13/shipboard/internal/store"
+	"github.com/jackc/pgx/v5"
+)
+
+// ClipStore keeps clips in SQLite, see store.ClipStore. Payloads are kept
+// inline and uncompressed, there is no blob store.
+type ClipStore struct {
+	DB *sql.DB
+}
+
+const clipColumns = "id, user_id, content, created_at, once, consumed_at, content_type, file_name, size, blob_key, language, sensitive, codec, note"
+
+const insertClipQuery = `
+INSERT INTO clips (user_id, content, created_at, once, sensitive, size, language, content_hash)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+RETURNING ` + clipColumns + `;
+`
+
+const insertFileClipQuery = `
+INSERT INTO clips (user_id, content, created_at, content_type, file_name, data, size, content_hash)
+VALUES ($1, '', $2, $3, $4, $5, $6, $7)
+RETURNING ` + clipColumns + `;
+`
+
+// Moves the latest clip with the same payload within the window to the top of
+// the history
+const bumpDuplicateClipQuery = `
+UPDATE clips SET created_at = $1
+WHERE id = (
+	SELECT id FROM clips
+	WHERE user_id = $2
+		AND content_hash = $3
+		AND content_type = $4
+		AND file_name IS $5
+		AND language IS $6
+		AND created_at > $7
+	ORDER BY created_at DESC, id DESC
+	LIMIT 1
+)
+RETURNING ` + clipColumns + `;
+`
+
+const clipDataQuery = `
+SELECT data FROM clips WHERE id = $1;
+`
+
+const markClipConsumedQuery = `
+UPDATE clips SET consumed_at = $1 WHERE id = $2 AND consumed_at IS NULL;
+`
+
+const clipSelectFromIdQuery = `
+SELECT ` + clipColumns + `
+FROM clips
+WHERE id = $1;
+`
+
+// A negative limit is no limit in SQLite
+const userClipsQuery = `
+SELECT ` + clipColumns + `
+FROM clips
+WHERE user_id = $1
+ORDER BY created_at DESC, id DESC
+LIMIT $2;
+`
+
+const userUsageQuery = `
+SELECT clips, bytes FROM user_usage WHERE user_id = $1;
+`
+
+// rowScanner is a *sql.Row or *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanClip scans a row of clipColumns.
+func scanClip(row rowScanner) (*store.Clip, error) {
+	var clip store.Clip
+	err := row.Scan(
+		&clip.Id, &clip.UserID, &clip.Content, &clip.CreatedAt, &clip.Once, &clip.ConsumedAt,
+		&clip.ContentType, &clip.FileName, &clip.Size, &clip.BlobKey, &clip.Language,
+		&clip.Sensitive, &clip.Codec, &clip.Note,
+	)
+	if err == sql.ErrNoRows {
+		return nil, pgx.ErrNoRows
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &clip, nil
+}
+
+// contentHash returns the hash clips are deduplicated by.
+func contentHash(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+func (s *ClipStore) insertClip(clip store.Clip, hash []byte) (*store.Clip, error) {
+	row := s.DB.QueryRowContext(context.Background(), insertClipQuery,
+		clip.UserID, clip.Content, now(), clip.Once, clip.Sensitive, clip.Size, clip.Language, hash)
+	return scanClip(row)
+}
+
+func (s *ClipStore) CreateClip(userID int32, content string, language string) (*store.Clip, error) {
+	clip := store.Clip{UserID: userID, Content: content, Size: int64(len(content))}
+	if language != "" {
+		clip.Language = &language
+	}
+	return s.insertClip(clip, contentHash([]byte(content)))
+}
+
+func (s *ClipStore) CreateOnceClip(userID int32, size int) (*store.Clip, error) {
+	return s.insertClip(store.Clip{UserID: userID, Once: true, Size: int64(size)}, nil)
+}
+
+func (s *ClipStore) CreateSensitiveClip(userID int32, size int) (*store.Clip, error) {
+	return s.insertClip(store.Clip{UserID: userID, Sensitive: true, Size: int64(size)}, nil)
+}
+
+func (s *ClipStore) CreateFileClip(userID int32, fileName string, contentType string, data []byte) (*store.Clip, error) {
+	row := s.DB.QueryRowContext(context.Background(), insertFileClipQuery,
+		userID, now(), contentType, fileName, data, len(data), contentHash(data))
+	return scanClip(row)
+}
+
+func (s *ClipStore) BumpDuplicateClip(userID int32, fileName *string, contentType string, language string, data []byte, window time.Duration) (*store.Clip, error) {
+	bumpedAt := now()
+	var clipLanguage *string
+	if language != "" {
+		clipLanguage = &language
+	}
+	row := s.DB.QueryRowContext(context.Background(), bumpDuplicateClipQuery,
+		bumpedAt, userID, contentHash(data), contentType, fileName, clipLanguage, bumpedAt.Add(-window))
+	return scanClip(row)
+}
+
+func (s *ClipStore) GetClipByID(id int32) (*store.Clip, error) {
+	return scanClip(s.DB.QueryRowContext(context.Background(), clipSelectFromIdQuery, id))
+}
+
+func (s *ClipStore) GetUserClips(userID int32, limit int) ([]store.Clip, error) {
+	if limit <= 0 {
+		limit = -1
+	}
+	rows, err := s.DB.QueryContext(context.Background(), userClipsQuery, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clips := []store.Clip{}
+	for rows.Next() {
+		clip, err := scanClip(rows)
+		if err != nil {
+			return nil, err
+		}
+		clips = append(clips, *clip)
+	}
+	return clips, rows.Err()
+}
+
+// GetClipData returns the content of text clips and the data of files.
+func (s *ClipStore) GetClipData(clip *store.Clip) ([]byte, error) {
+	if !clip.IsFile() {
+		return []byte(clip.Content), nil
+	}
+	var data []byte
+	err := s.DB.QueryRowContext(context.Background(), clipDataQuery, clip.Id).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, pgx.ErrNoRows
+	}
+	return data, err
+}
+
+func (s *ClipStore) GetUserUsage(userID int32) (store.Usage, error) {
+	usage := store.Usage{UserID: userID}
+	err := s.DB.QueryRowContext(context.Background(), userUsageQuery, userID).Scan(&usage.Clips, &usage.Bytes)
+	if err == sql.ErrNoRows {
+		return usage, nil
+	}
+	return usage, err
+}
+
+func (s *ClipStore) MarkClipConsumed(id int32) error {
+	_, err := s.DB.ExecContext(context.Background(), markClipConsumedQuery, now(), id)
+	return err
+}
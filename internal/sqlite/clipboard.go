@@ -0,0 +1,124 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/amns13/shipboard/internal/services"
+	"github.com/redis/go-redis/v9"
+)
+
+// Clipboard keeps the latest clip of each user in SQLite, see
+// store.Clipboard. Content is kept uncompressed. Clips expire at their
+// expires_at, expired rows are deleted by Sweep.
+type Clipboard struct {
+	DB *sql.DB
+}
+
+// Kinds of clipboards, see migration_0004
+const (
+	clipboardClip      = "clip"
+	clipboardOnce      = "once"
+	clipboardConsumed  = "consumed"
+	clipboardRef       = "ref"
+	clipboardSensitive = "sensitive"
+)
+
+const upsertClipboardQuery = `
+INSERT INTO clipboards (owner, kind, content, clip_id, expires_at)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (owner) DO UPDATE
+SET kind = excluded.kind, content = excluded.content, clip_id = excluded.clip_id, expires_at = excluded.expires_at;
+`
+
+const sensitiveClipboardQuery = `
+SELECT content FROM clipboards
+WHERE owner = $1 AND kind = 'sensitive' AND clip_id = $2 AND (expires_at IS NULL OR expires_at > $3);
+`
+
+const clipboardQuery = `
+SELECT kind, content, clip_id FROM clipboards
+WHERE owner = $1 AND (expires_at IS NULL OR expires_at > $2);
+`
+
+// Consumed clipboards are kept until the next broadcast
+const consumeClipboardQuery = `
+UPDATE clipboards SET kind = 'consumed', content = '', clip_id = NULL, expires_at = NULL
+WHERE owner = $1;
+`
+
+const deleteClipboardQuery = `
+DELETE FROM clipboards WHERE owner = $1;
+`
+
+func (s *Clipboard) set(owner string, kind string, content string, clipID *int32, ttl time.Duration) error {
+	_, err := s.DB.ExecContext(context.Background(), upsertClipboardQuery, owner, kind, content, clipID, expiresAt(ttl))
+	return err
+}
+
+func (s *Clipboard) Set(owner string, content string, ttl time.Duration) error {
+	return s.set(owner, clipboardClip, content, nil, ttl)
+}
+
+func (s *Clipboard) SetOnce(owner string, clipID int32, content string, ttl time.Duration) error {
+	return s.set(owner, clipboardOnce, content, &clipID, ttl)
+}
+
+func (s *Clipboard) SetRef(owner string, clipID int32, ttl time.Duration) error {
+	return s.set(owner, clipboardRef, "", &clipID, ttl)
+}
+
+func (s *Clipboard) SetSensitive(owner string, clipID int32, content string, ttl time.Duration) error {
+	return s.set(owner, clipboardSensitive, content, &clipID, ttl)
+}
+
+func (s *Clipboard) Sensitive(owner string, clipID int32) (string, error) {
+	var content string
+	err := s.DB.QueryRowContext(context.Background(), sensitiveClipboardQuery, owner, clipID, now()).Scan(&content)
+	if err == sql.ErrNoRows {
+		return "", redis.Nil
+	}
+	return content, err
+}
+
+// Paste marks a burn after reading clip consumed in the same transaction it
+// is read in, so that only a single paste can ever get it.
+func (s *Clipboard) Paste(owner string) (*services.PastedClip, error) {
+	ctx := context.Background()
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	clip := &services.PastedClip{}
+	var kind string
+	var clipID sql.NullInt32
+	err = tx.QueryRowContext(ctx, clipboardQuery, owner, now()).Scan(&kind, &clip.Content, &clipID)
+	if err == sql.ErrNoRows {
+		return nil, redis.Nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch kind {
+	case clipboardConsumed:
+		return nil, services.ErrClipConsumed
+	case clipboardOnce:
+		_, err = tx.ExecContext(ctx, consumeClipboardQuery, owner)
+		if err != nil {
+			return nil, err
+		}
+	}
+	clip.Once = kind == clipboardOnce
+	clip.Ref = kind == clipboardRef
+	clip.Sensitive = kind == clipboardSensitive
+	clip.ClipID = clipID.Int32
+	return clip, tx.Commit()
+}
+
+func (s *Clipboard) Clear(owner string) error {
+	_, err := s.DB.ExecContext(context.Background(), deleteClipboardQuery, owner)
+	return err
+}
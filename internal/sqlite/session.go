@@ -0,0 +1,96 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/amns13/shipboard/internal/services"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// SessionStore keeps sessions in SQLite, see store.SessionStore. Sessions
+// expire at their ExpiresAt, expired rows are deleted by Sweep.
+type SessionStore struct {
+	DB *sql.DB
+}
+
+const insertSessionQuery = `
+INSERT INTO sessions (id, user_id, email, login_time, expires_at)
+VALUES ($1, $2, $3, $4, $5);
+`
+
+const sessionQuery = `
+SELECT user_id, email, login_time, expires_at
+FROM sessions
+WHERE id = $1 AND expires_at > $2;
+`
+
+const userSessionsQuery = `
+SELECT user_id, email, login_time, expires_at
+FROM sessions
+WHERE user_id = $1 AND expires_at > $2
+ORDER BY login_time;
+`
+
+const deleteSessionQuery = `
+DELETE FROM sessions WHERE id = $1;
+`
+
+const deleteUserSessionsQuery = `
+DELETE FROM sessions WHERE user_id = $1 AND id != $2;
+`
+
+func scanSession(row rowScanner) (*services.SessionData, error) {
+	var data services.SessionData
+	err := row.Scan(&data.UserID, &data.Email, &data.LoginTime, &data.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+func (s *SessionStore) CreateSession(data services.SessionData) (string, error) {
+	sessionID := uuid.New().String()
+	_, err := s.DB.ExecContext(context.Background(), insertSessionQuery,
+		sessionID, data.UserID, data.Email, data.LoginTime.UTC(), data.ExpiresAt.UTC())
+	return sessionID, err
+}
+
+// Get returns redis.Nil if the session does not exist or has expired, like
+// the redis store.
+func (s *SessionStore) Get(sessionID string) (*services.SessionData, error) {
+	data, err := scanSession(s.DB.QueryRowContext(context.Background(), sessionQuery, sessionID, now()))
+	if err == sql.ErrNoRows {
+		return nil, redis.Nil
+	}
+	return data, err
+}
+
+func (s *SessionStore) Expire(sessionID string) error {
+	_, err := s.DB.ExecContext(context.Background(), deleteSessionQuery, sessionID)
+	return err
+}
+
+func (s *SessionStore) ExpireUserSessions(userID int32, exceptSessionID string) error {
+	_, err := s.DB.ExecContext(context.Background(), deleteUserSessionsQuery, userID, exceptSessionID)
+	return err
+}
+
+func (s *SessionStore) UserSessions(userID int32) ([]services.SessionData, error) {
+	rows, err := s.DB.QueryContext(context.Background(), userSessionsQuery, userID, now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []services.SessionData
+	for rows.Next() {
+		data, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *data)
+	}
+	return sessions, rows.Err()
+}
@@ -0,0 +1,83 @@
+// Package sqlite keeps users, clips, sessions and the clipboard in a single
+// SQLite file, for self-hosting without Postgres and redis. See the store
+// package for the interfaces. The schema is created by cmd/migration from
+// migrations/sqlite.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"net/url"
+	"time"
+
+	"github.com/amns13/shipboard/internal/store"
+	_ "modernc.org/sqlite"
+)
+
+// Times are written as text that sorts chronologically, so that they can be
+// compared in queries. Foreign keys are off by default in SQLite.
+const dsnParams = "_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_time_format=sqlite"
+
+// Open opens the SQLite database at path, creating the file if needed.
+func Open(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", "file:"+url.PathEscape(path)+"?"+dsnParams)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite has a single writer. Sharing one connection queues writes
+	// instead of failing them with SQLITE_BUSY.
+	db.SetMaxOpenConns(1)
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// now returns the current time as stored, in UTC.
+func now() time.Time {
+	return time.Now().UTC()
+}
+
+// expiresAt returns the expiry of a row kept for ttl, nil for 0, which never
+// expires.
+func expiresAt(ttl time.Duration) *time.Time {
+	if ttl <= 0 {
+		return nil
+	}
+	expiry := now().Add(ttl)
+	return &expiry
+}
+
+const deleteExpiredSessionsQuery = `
+DELETE FROM sessions WHERE expires_at <= $1;
+`
+
+const deleteExpiredClipboardsQuery = `
+DELETE FROM clipboards WHERE expires_at <= $1;
+`
+
+// Sweep deletes the expired sessions and clipboards. Reads skip expired rows
+// already, this only reclaims the space. Returns the number of rows deleted.
+func Sweep(db *sql.DB) (int64, error) {
+	ctx := context.Background()
+	var deleted int64
+	for _, query := range []string{deleteExpiredSessionsQuery, deleteExpiredClipboardsQuery} {
+		result, err := db.ExecContext(ctx, query, now())
+		if err != nil {
+			return deleted, err
+		}
+		rows, _ := result.RowsAffected()
+		deleted += rows
+	}
+	return deleted, nil
+}
+
+// Checks that the SQLite stores implement the interfaces
+var (
+	_ store.UserStore    = (*UserStore)(nil)
+	_ store.ClipStore    = (*ClipStore)(nil)
+	_ store.SessionStore = (*SessionStore)(nil)
+	_ store.Clipboard    = (*Clipboard)(nil)
+)
@@ -0,0 +1,256 @@
+package sqlite
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/amns13/shipboard/internal/services"
+	"github.com/amns13/shipboard/internal/store"
+	"github.com/jackc/pgx/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+// openTestDB returns a database in a temporary file, migrated like
+// cmd/migration does.
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := Open(filepath.Join(t.TempDir(), "shipboard.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	files, err := filepath.Glob(filepath.Join("..", "..", "migrations", "sqlite", "migration_*.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		query, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(string(query)); err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+	}
+	return db
+}
+
+func createTestUser(t *testing.T, users *UserStore) *store.User {
+	t.Helper()
+	user, err := users.CreateUser(store.UserCreator{Name: "Test", Email: "test@example.com", PasswordHash: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestUserStore(t *testing.T) {
+	users := &UserStore{DB: openTestDB(t)}
+	user := createTestUser(t, users)
+	if user.Role != store.ROLE_USER || user.IsDisabled() {
+		t.Errorf("expected an active user, got %+v", user)
+	}
+
+	found, err := users.GetUserByEmail("test@example.com")
+	if err != nil || found.Id != user.Id || found.Uid != user.Uid {
+		t.Errorf("expected user %d, got %+v %v", user.Id, found, err)
+	}
+	if _, err := users.GetUserByID(user.Id + 1); err != pgx.ErrNoRows {
+		t.Errorf("expected pgx.ErrNoRows, got %v", err)
+	}
+	if exists, _ := users.UserExists("test@example.com"); !exists {
+		t.Error("expected the email to exist")
+	}
+	if _, err := users.CreateUser(store.UserCreator{Name: "Other", Email: "test@example.com"}); err == nil {
+		t.Error("expected the email to be unique")
+	}
+
+	policy := services.RetentionPolicy{MaxAge: 48 * time.Hour, MaxClips: 10}
+	if err := users.SetUserRetentionPolicy(user.Id, policy); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := users.GetUserRetentionPolicy(user.Id); got != policy {
+		t.Errorf("expected %+v, got %+v", policy, got)
+	}
+
+	if err := users.SetUserDisabled(user.Id, true); err != nil {
+		t.Fatal(err)
+	}
+	disabled, _ := users.GetUserByID(user.Id)
+	if err := users.SetUserDisabled(user.Id, true); err != nil {
+		t.Fatal(err)
+	}
+	if found, _ := users.GetUserByID(user.Id); !found.IsDisabled() || !found.DisabledAt.Equal(*disabled.DisabledAt) {
+		t.Errorf("expected the original disabled_at %v, got %+v", disabled.DisabledAt, found)
+	}
+	if err := users.SetUserDisabled(user.Id, false); err != nil {
+		t.Fatal(err)
+	}
+	if found, _ := users.GetUserByID(user.Id); found.IsDisabled() {
+		t.Errorf("expected an enabled user, got %+v", found)
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	db := openTestDB(t)
+	users := &UserStore{DB: db}
+	user := createTestUser(t, users)
+	if _, err := (&ClipStore{DB: db}).CreateClip(user.Id, "content", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := users.DeleteUser(user.Id, func() error { return sql.ErrConnDone }); err != sql.ErrConnDone {
+		t.Errorf("expected the cleanup error, got %v", err)
+	}
+	if _, err := users.GetUserByID(user.Id); err != nil {
+		t.Errorf("expected the user to be kept when cleanup fails, got %v", err)
+	}
+
+	// Cleanup uses the other stores, on the same connection
+	clipboard := &Clipboard{DB: db}
+	clipboard.Set(user.Uid.String(), "content", 0)
+	err := users.DeleteUser(user.Id, func() error {
+		return clipboard.Clear(user.Uid.String())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.GetUserByID(user.Id); err != pgx.ErrNoRows {
+		t.Errorf("expected pgx.ErrNoRows, got %v", err)
+	}
+	if _, err := clipboard.Paste(user.Uid.String()); err != redis.Nil {
+		t.Errorf("expected a cleared clipboard, got %v", err)
+	}
+	var clips int
+	db.QueryRow("SELECT count(*) FROM clips").Scan(&clips)
+	if clips != 0 {
+		t.Errorf("expected the clips to be deleted with the user, got %d", clips)
+	}
+}
+
+func TestClipStore(t *testing.T) {
+	db := openTestDB(t)
+	user := createTestUser(t, &UserStore{DB: db})
+	clips := &ClipStore{DB: db}
+
+	first, err := clips.CreateClip(user.Id, "first", "go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Language == nil || *first.Language != "go" || first.ContentType != store.TEXT_CLIP_CONTENT_TYPE {
+		t.Errorf("expected a go text clip, got %+v", first)
+	}
+	file, err := clips.CreateFileClip(user.Id, "a.bin", "application/octet-stream", []byte{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := clips.GetClipData(file); string(data) != "\x01\x02\x03" {
+		t.Errorf("expected the file data, got %v", data)
+	}
+	if _, err := clips.CreateOnceClip(user.Id, 6); err != nil {
+		t.Fatal(err)
+	}
+
+	bumped, err := clips.BumpDuplicateClip(user.Id, nil, store.TEXT_CLIP_CONTENT_TYPE, "go", []byte("first"), time.Hour)
+	if err != nil || bumped.Id != first.Id {
+		t.Fatalf("expected clip %d bumped, got %+v %v", first.Id, bumped, err)
+	}
+	if _, err := clips.BumpDuplicateClip(user.Id, nil, store.TEXT_CLIP_CONTENT_TYPE, "go", []byte("other"), time.Hour); err != pgx.ErrNoRows {
+		t.Errorf("expected pgx.ErrNoRows, got %v", err)
+	}
+	if _, err := clips.BumpDuplicateClip(user.Id, nil, store.TEXT_CLIP_CONTENT_TYPE, "", []byte("first"), time.Hour); err != pgx.ErrNoRows {
+		t.Errorf("expected pgx.ErrNoRows for another language, got %v", err)
+	}
+	history, err := clips.GetUserClips(user.Id, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[0].Id != first.Id {
+		t.Errorf("expected the bumped clip first, got %+v", history)
+	}
+
+	usage, err := clips.GetUserUsage(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Clips != 3 || usage.Bytes != 5+3+6 {
+		t.Errorf("expected 3 clips of 14 bytes, got %+v", usage)
+	}
+}
+
+func TestSessionStore(t *testing.T) {
+	db := openTestDB(t)
+	user := createTestUser(t, &UserStore{DB: db})
+	sessions := &SessionStore{DB: db}
+
+	data := services.SessionData{UserID: user.Id, Email: user.Email, LoginTime: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+	kept, err := sessions.CreateSession(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, _ := sessions.CreateSession(data)
+	if got, err := sessions.Get(expired); err != nil || got.UserID != user.Id {
+		t.Fatalf("expected the session of user %d, got %+v %v", user.Id, got, err)
+	}
+
+	if err := sessions.ExpireUserSessions(user.Id, kept); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sessions.Get(expired); err != redis.Nil {
+		t.Errorf("expected redis.Nil, got %v", err)
+	}
+	if active, _ := sessions.UserSessions(user.Id); len(active) != 1 {
+		t.Errorf("expected 1 session left, got %d", len(active))
+	}
+
+	data.ExpiresAt = time.Now().Add(-time.Minute)
+	stale, _ := sessions.CreateSession(data)
+	if _, err := sessions.Get(stale); err != redis.Nil {
+		t.Errorf("expected an expired session to be missing, got %v", err)
+	}
+	if deleted, err := Sweep(db); err != nil || deleted != 1 {
+		t.Errorf("expected the expired session swept, got %d %v", deleted, err)
+	}
+}
+
+func TestClipboard(t *testing.T) {
+	clipboard := &Clipboard{DB: openTestDB(t)}
+
+	if _, err := clipboard.Paste("owner"); err != redis.Nil {
+		t.Errorf("expected redis.Nil, got %v", err)
+	}
+	if err := clipboard.SetOnce("owner", 1, "secret plan", 0); err != nil {
+		t.Fatal(err)
+	}
+	pasted, err := clipboard.Paste("owner")
+	if err != nil || !pasted.Once || pasted.Content != "secret plan" || pasted.ClipID != 1 {
+		t.Errorf("expected the burn after reading clip, got %+v %v", pasted, err)
+	}
+	if _, err := clipboard.Paste("owner"); err != services.ErrClipConsumed {
+		t.Errorf("expected services.ErrClipConsumed, got %v", err)
+	}
+
+	if err := clipboard.SetSensitive("owner", 2, "hunter2", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := clipboard.Sensitive("owner", 2); err != nil || content != "hunter2" {
+		t.Errorf("expected the sensitive clip, got %q %v", content, err)
+	}
+	if _, err := clipboard.Sensitive("owner", 3); err != redis.Nil {
+		t.Errorf("expected redis.Nil for another clip, got %v", err)
+	}
+
+	if err := clipboard.Set("owner", "abcd", time.Nanosecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	if _, err := clipboard.Paste("owner"); err != redis.Nil {
+		t.Errorf("expected an expired clipboard to be empty, got %v", err)
+	}
+}
@@ -0,0 +1,183 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/amns13/shipboard/internal/services"
+	"github.com/amns13/shipboard/internal/store"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// UserStore keeps users in SQLite, see store.UserStore.
+type UserStore struct {
+	DB *sql.DB
+}
+
+const userColumns = "id, uid, email, password_hash, name, created_at, role, disabled_at"
+
+const insertUserQuery = `
+INSERT INTO users (uid, name, email, password_hash, created_at)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING ` + userColumns + `;
+`
+
+const userExistsQuery = `
+SELECT EXISTS(SELECT 1 FROM users WHERE email = $1);
+`
+
+const userSelectFromEmailQuery = `
+SELECT ` + userColumns + `
+FROM users
+WHERE email = $1;
+`
+
+const userSelectFromIdQuery = `
+SELECT ` + userColumns + `
+FROM users
+WHERE id = $1;
+`
+
+const userQuotaOverrideQuery = `
+SELECT max_clips, max_bytes FROM user_quotas WHERE user_id = $1;
+`
+
+const userRetentionPolicyQuery = `
+SELECT max_age, max_clips, max_bytes FROM retention_policies WHERE user_id = $1;
+`
+
+const upsertRetentionPolicyQuery = `
+INSERT INTO retention_policies (user_id, max_age, max_clips, max_bytes)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (user_id) DO UPDATE
+SET max_age = excluded.max_age, max_clips = excluded.max_clips, max_bytes = excluded.max_bytes, updated_at = current_timestamp;
+`
+
+const deleteRetentionPolicyQuery = `
+DELETE FROM retention_policies WHERE user_id = $1;
+`
+
+const updatePasswordHashQuery = `
+UPDATE users SET password_hash = $2 WHERE id = $1;
+`
+
+const updateEmailQuery = `
+UPDATE users SET email = $2 WHERE id = $1;
+`
+
+const setUserDisabledQuery = `
+UPDATE users
+SET disabled_at = CASE WHEN $2 THEN coalesce(disabled_at, $3) END
+WHERE id = $1;
+`
+
+const deleteUserQuery = `
+DELETE FROM users WHERE id = $1;
+`
+
+// scanUser scans a row of userColumns. Returns pgx.ErrNoRows if there is
+// none, like the Postgres store.
+func scanUser(row *sql.Row) (*store.User, error) {
+	var user store.User
+	err := row.Scan(&user.Id, &user.Uid, &user.Email, &user.PasswordHash, &user.Name, &user.CreatedAt, &user.Role, &user.DisabledAt)
+	if err == sql.ErrNoRows {
+		return nil, pgx.ErrNoRows
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (s *UserStore) CreateUser(creator store.UserCreator) (*store.User, error) {
+	row := s.DB.QueryRowContext(context.Background(), insertUserQuery, uuid.New().String(), creator.Name, creator.Email, creator.PasswordHash, now())
+	return scanUser(row)
+}
+
+func (s *UserStore) UserExists(email string) (bool, error) {
+	var exists bool
+	err := s.DB.QueryRowContext(context.Background(), userExistsQuery, email).Scan(&exists)
+	return exists, err
+}
+
+func (s *UserStore) GetUserByEmail(email string) (*store.User, error) {
+	return scanUser(s.DB.QueryRowContext(context.Background(), userSelectFromEmailQuery, email))
+}
+
+func (s *UserStore) GetUserByID(id int32) (*store.User, error) {
+	return scanUser(s.DB.QueryRowContext(context.Background(), userSelectFromIdQuery, id))
+}
+
+func (s *UserStore) GetQuotaOverride(userID int32) (store.QuotaOverride, error) {
+	override := store.QuotaOverride{UserID: userID}
+	err := s.DB.QueryRowContext(context.Background(), userQuotaOverrideQuery, userID).Scan(&override.MaxClips, &override.MaxBytes)
+	if err == sql.ErrNoRows {
+		return override, nil
+	}
+	return override, err
+}
+
+func (s *UserStore) GetUserRetentionPolicy(userID int32) (services.RetentionPolicy, error) {
+	var maxAge, maxBytes sql.NullInt64
+	var maxClips sql.NullInt32
+	err := s.DB.QueryRowContext(context.Background(), userRetentionPolicyQuery, userID).Scan(&maxAge, &maxClips, &maxBytes)
+	if err == sql.ErrNoRows {
+		return services.RetentionPolicy{}, nil
+	}
+	if err != nil {
+		return services.RetentionPolicy{}, err
+	}
+	return services.RetentionPolicy{
+		MaxAge:   time.Duration(maxAge.Int64),
+		MaxClips: int(maxClips.Int32),
+		MaxBytes: maxBytes.Int64,
+	}, nil
+}
+
+// nullIfZero returns nil for the zero value, which is stored as NULL.
+func nullIfZero[T comparable](value T) *T {
+	var zero T
+	if value == zero {
+		return nil
+	}
+	return &value
+}
+
+func (s *UserStore) SetUserRetentionPolicy(userID int32, policy services.RetentionPolicy) error {
+	ctx := context.Background()
+	if policy.IsZero() {
+		_, err := s.DB.ExecContext(ctx, deleteRetentionPolicyQuery, userID)
+		return err
+	}
+	_, err := s.DB.ExecContext(ctx, upsertRetentionPolicyQuery, userID, nullIfZero(int64(policy.MaxAge)), nullIfZero(policy.MaxClips), nullIfZero(policy.MaxBytes))
+	return err
+}
+
+func (s *UserStore) UpdatePasswordHash(id int32, passwordHash string) error {
+	_, err := s.DB.ExecContext(context.Background(), updatePasswordHashQuery, id, passwordHash)
+	return err
+}
+
+func (s *UserStore) UpdateEmail(id int32, email string) error {
+	_, err := s.DB.ExecContext(context.Background(), updateEmailQuery, id, email)
+	return err
+}
+
+func (s *UserStore) SetUserDisabled(id int32, disabled bool) error {
+	_, err := s.DB.ExecContext(context.Background(), setUserDisabledQuery, id, disabled, now())
+	return err
+}
+
+// DeleteUser calls cleanup before deleting the user, outside of a
+// transaction. The other stores share the single connection, cleanup would
+// wait forever for a transaction holding it.
+func (s *UserStore) DeleteUser(id int32, cleanup func() error) error {
+	err := cleanup()
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.ExecContext(context.Background(), deleteUserQuery, id)
+	return err
+}
@@ -0,0 +1,585 @@
+package store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/amns13/shipboard/internal/services"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+// The in-memory stores keep everything in the process, for tests and for
+// running without Postgres and redis. Nothing survives a restart.
+
+var ErrEmailExists = errors.New("email already exists")
+
+type MemoryUserStore struct {
+	mu sync.Mutex
+	// Indexed by id - 1, deleted users are nil so that ids are never reused
+	users      []*User
+	overrides  map[int32]QuotaOverride
+	retentions map[int32]services.RetentionPolicy
+}
+
+func NewMemoryUserStore() *MemoryUserStore {
+	return &MemoryUserStore{
+		overrides:  make(map[int32]QuotaOverride),
+		retentions: make(map[int32]services.RetentionPolicy),
+	}
+}
+
+// CreateUser returns ErrEmailExists if the email is taken, emails are unique
+// like in Postgres.
+func (s *MemoryUserStore) CreateUser(creator UserCreator) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, user := range s.users {
+		if user != nil && user.Email == creator.Email {
+			return nil, ErrEmailExists
+		}
+	}
+	user := &User{
+		Id:          int32(len(s.users) + 1),
+		Uid:         uuid.New(),
+		CreatedAt:   time.Now(),
+		Role:        ROLE_USER,
+		UserCreator: creator,
+	}
+	s.users = append(s.users, user)
+	created := *user
+	return &created, nil
+}
+
+func (s *MemoryUserStore) UserExists(email string) (bool, error) {
+	_, err := s.GetUserByEmail(email)
+	if err == pgx.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *MemoryUserStore) GetUserByEmail(email string) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, user := range s.users {
+		if user != nil && user.Email == email {
+			found := *user
+			return &found, nil
+		}
+	}
+	return nil, pgx.ErrNoRows
+}
+
+// get returns the user with the id, the lock must be held.
+func (s *MemoryUserStore) get(id int32) (*User, error) {
+	if id < 1 || int(id) > len(s.users) || s.users[id-1] == nil {
+		return nil, pgx.ErrNoRows
+	}
+	return s.users[id-1], nil
+}
+
+func (s *MemoryUserStore) GetUserByID(id int32) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.get(id)
+	if err != nil {
+		return nil, err
+	}
+	found := *user
+	return &found, nil
+}
+
+func (s *MemoryUserStore) GetQuotaOverride(userID int32) (QuotaOverride, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	override, ok := s.overrides[userID]
+	if !ok {
+		return QuotaOverride{UserID: userID}, nil
+	}
+	return override, nil
+}
+
+// SetQuotaOverride replaces the quota override of the user. An override
+// without fields restores the global quota.
+func (s *MemoryUserStore) SetQuotaOverride(override QuotaOverride) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if override.MaxClips == nil && override.MaxBytes == nil {
+		delete(s.overrides, override.UserID)
+		return
+	}
+	s.overrides[override.UserID] = override
+}
+
+func (s *MemoryUserStore) GetUserRetentionPolicy(userID int32) (services.RetentionPolicy, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.retentions[userID], nil
+}
+
+func (s *MemoryUserStore) SetUserRetentionPolicy(userID int32, policy services.RetentionPolicy) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if policy.IsZero() {
+		delete(s.retentions, userID)
+	} else {
+		s.retentions[userID] = policy
+	}
+	return nil
+}
+
+// Missing users are not an error in the update methods, like for the updates
+// in Postgres.
+
+func (s *MemoryUserStore) UpdatePasswordHash(id int32, passwordHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.get(id)
+	if err == nil {
+		user.PasswordHash = passwordHash
+	}
+	return nil
+}
+
+// UpdateEmail returns ErrEmailExists if the email is taken.
+func (s *MemoryUserStore) UpdateEmail(id int32, email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, user := range s.users {
+		if user != nil && user.Id != id && user.Email == email {
+			return ErrEmailExists
+		}
+	}
+	user, err := s.get(id)
+	if err == nil {
+		user.Email = email
+	}
+	return nil
+}
+
+func (s *MemoryUserStore) SetUserDisabled(id int32, disabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.get(id)
+	if err != nil {
+		return nil
+	}
+	if !disabled {
+		user.DisabledAt = nil
+	} else if user.DisabledAt == nil {
+		now := time.Now()
+		user.DisabledAt = &now
+	}
+	return nil
+}
+
+// SetRole changes the role of the user. Roles are otherwise only changed in
+// the database, see the README.
+func (s *MemoryUserStore) SetRole(id int32, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.get(id)
+	if err != nil {
+		return err
+	}
+	user.Role = role
+	return nil
+}
+
+// DeleteUser keeps the clips of the user, which are in the clip store and
+// can't be reached without the user.
+func (s *MemoryUserStore) DeleteUser(id int32, cleanup func() error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.get(id)
+	if err != nil {
+		return nil
+	}
+	err = cleanup()
+	if err != nil {
+		return err
+	}
+	s.users[id-1] = nil
+	delete(s.overrides, id)
+	delete(s.retentions, id)
+	return nil
+}
+
+// memoryClip is a clip along with what is not loaded with it.
+type memoryClip struct {
+	Clip
+	data []byte
+	// Nil for burn after reading and sensitive clips, which are never
+	// deduplicated
+	hash []byte
+}
+
+type MemoryClipStore struct {
+	mu    sync.Mutex
+	clips []*memoryClip
+}
+
+func NewMemoryClipStore() *MemoryClipStore {
+	return &MemoryClipStore{}
+}
+
+func contentHash(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+// add keeps a new clip, assigning its id.
+func (s *MemoryClipStore) add(clip *memoryClip) *Clip {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clip.Id = int32(len(s.clips) + 1)
+	clip.CreatedAt = time.Now()
+	s.clips = append(s.clips, clip)
+	created := clip.Clip
+	return &created
+}
+
+func (s *MemoryClipStore) CreateClip(userID int32, content string, language string) (*Clip, error) {
+	clip := &memoryClip{
+		Clip: Clip{
+			UserID:      userID,
+			Content:     content,
+			ContentType: TEXT_CLIP_CONTENT_TYPE,
+			Size:        int64(len(content)),
+		},
+		hash: contentHash([]byte(content)),
+	}
+	if language != "" {
+		clip.Language = &language
+	}
+	return s.add(clip), nil
+}
+
+func (s *MemoryClipStore) CreateOnceClip(userID int32, size int) (*Clip, error) {
+	clip := &memoryClip{
+		Clip: Clip{
+			UserID:      userID,
+			Once:        true,
+			ContentType: TEXT_CLIP_CONTENT_TYPE,
+			Size:        int64(size),
+		},
+	}
+	return s.add(clip), nil
+}
+
+func (s *MemoryClipStore) CreateSensitiveClip(userID int32, size int) (*Clip, error) {
+	clip := &memoryClip{
+		Clip: Clip{
+			UserID:      userID,
+			Sensitive:   true,
+			ContentType: TEXT_CLIP_CONTENT_TYPE,
+			Size:        int64(size),
+		},
+	}
+	return s.add(clip), nil
+}
+
+func (s *MemoryClipStore) CreateFileClip(userID int32, fileName string, contentType string, data []byte) (*Clip, error) {
+	clip := &memoryClip{
+		Clip: Clip{
+			UserID:      userID,
+			ContentType: contentType,
+			FileName:    &fileName,
+			Size:        int64(len(data)),
+		},
+		data: bytes.Clone(data),
+		hash: contentHash(data),
+	}
+	return s.add(clip), nil
+}
+
+func (s *MemoryClipStore) BumpDuplicateClip(userID int32, fileName *string, contentType string, language string, data []byte, window time.Duration) (*Clip, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hash := contentHash(data)
+	since := time.Now().Add(-window)
+	var clipLanguage *string
+	if language != "" {
+		clipLanguage = &language
+	}
+	var latest *memoryClip
+	for _, clip := range s.clips {
+		if clip.UserID != userID || clip.hash == nil || !bytes.Equal(clip.hash, hash) {
+			continue
+		}
+		if clip.ContentType != contentType || !equalNames(clip.FileName, fileName) || !equalNames(clip.Language, clipLanguage) || !clip.CreatedAt.After(since) {
+			continue
+		}
+		if latest == nil || !clip.CreatedAt.Before(latest.CreatedAt) {
+			latest = clip
+		}
+	}
+	if latest == nil {
+		return nil, pgx.ErrNoRows
+	}
+	latest.CreatedAt = time.Now()
+	bumped := latest.Clip
+	return &bumped, nil
+}
+
+func equalNames(a *string, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// get returns the clip with the id, the lock must be held.
+func (s *MemoryClipStore) get(id int32) (*memoryClip, error) {
+	if id < 1 || int(id) > len(s.clips) {
+		return nil, pgx.ErrNoRows
+	}
+	return s.clips[id-1], nil
+}
+
+func (s *MemoryClipStore) GetClipByID(id int32) (*Clip, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clip, err := s.get(id)
+	if err != nil {
+		return nil, err
+	}
+	found := clip.Clip
+	return &found, nil
+}
+
+func (s *MemoryClipStore) GetUserClips(userID int32, limit int) ([]Clip, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var clips []Clip
+	for _, clip := range s.clips {
+		if clip.UserID == userID {
+			clips = append(clips, clip.Clip)
+		}
+	}
+	slices.SortStableFunc(clips, func(a Clip, b Clip) int {
+		if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+			return c
+		}
+		return int(b.Id - a.Id)
+	})
+	if limit > 0 && len(clips) > limit {
+		clips = clips[:limit]
+	}
+	return clips, nil
+}
+
+// GetClipData returns the content of text clips, which are never compressed
+// in memory, and the data of files.
+func (s *MemoryClipStore) GetClipData(clip *Clip) ([]byte, error) {
+	if !clip.IsFile() {
+		return []byte(clip.Content), nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, err := s.get(clip.Id)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.Clone(stored.data), nil
+}
+
+func (s *MemoryClipStore) GetUserUsage(userID int32) (Usage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	usage := Usage{UserID: userID}
+	for _, clip := range s.clips {
+		if clip.UserID == userID {
+			usage.Clips++
+			usage.Bytes += clip.Size
+		}
+	}
+	return usage, nil
+}
+
+func (s *MemoryClipStore) MarkClipConsumed(id int32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Missing clips are not an error, like for the update in Postgres
+	clip, err := s.get(id)
+	if err == nil && clip.ConsumedAt == nil {
+		now := time.Now()
+		clip.ConsumedAt = &now
+	}
+	return nil
+}
+
+// setNote sets the note of the clip, missing clips are ignored.
+func (s *MemoryClipStore) setNote(id int32, note *string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clip, err := s.get(id)
+	if err == nil {
+		clip.Note = note
+	}
+}
+
+type MemorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]services.SessionData
+}
+
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{sessions: make(map[string]services.SessionData)}
+}
+
+func (s *MemorySessionStore) CreateSession(data services.SessionData) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sessionID := uuid.New().String()
+	s.sessions[sessionID] = data
+	return sessionID, nil
+}
+
+// Get returns redis.Nil for unknown sessions. Expired sessions are returned,
+// like redis does until their TTL runs out, see middleware.RequireAuth.
+func (s *MemorySessionStore) Get(sessionID string) (*services.SessionData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.sessions[sessionID]
+	if !ok {
+		return nil, redis.Nil
+	}
+	return &data, nil
+}
+
+func (s *MemorySessionStore) Expire(sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, sessionID)
+	return nil
+}
+
+func (s *MemorySessionStore) ExpireUserSessions(userID int32, exceptSessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for sessionID, data := range s.sessions {
+		if data.UserID == userID && sessionID != exceptSessionID {
+			delete(s.sessions, sessionID)
+		}
+	}
+	return nil
+}
+
+func (s *MemorySessionStore) UserSessions(userID int32) ([]services.SessionData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var sessions []services.SessionData
+	for _, data := range s.sessions {
+		if data.UserID == userID {
+			sessions = append(sessions, data)
+		}
+	}
+	return sessions, nil
+}
+
+type memoryClipboardEntry struct {
+	clip services.PastedClip
+	// Zero if the entry never expires
+	expiresAt time.Time
+}
+
+type MemoryClipboard struct {
+	mu      sync.Mutex
+	entries map[string]memoryClipboardEntry
+	// When the mark of owners whose burn after reading clip was pasted
+	// expires, unless they broadcast before
+	consumed map[string]time.Time
+}
+
+func NewMemoryClipboard() *MemoryClipboard {
+	return &MemoryClipboard{
+		entries:  make(map[string]memoryClipboardEntry),
+		consumed: make(map[string]time.Time),
+	}
+}
+
+func (s *MemoryClipboard) set(owner string, clip services.PastedClip, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry := memoryClipboardEntry{clip: clip}
+	if ttl > 0 {
+		entry.expiresAt = time.Now().Add(ttl)
+	}
+	s.entries[owner] = entry
+	delete(s.consumed, owner)
+	return nil
+}
+
+// entry returns the clipboard of the owner, dropping it if it has expired.
+// The lock must be held.
+func (s *MemoryClipboard) entry(owner string) (memoryClipboardEntry, bool) {
+	entry, ok := s.entries[owner]
+	if ok && !entry.expiresAt.IsZero() && !time.Now().Before(entry.expiresAt) {
+		delete(s.entries, owner)
+		return entry, false
+	}
+	return entry, ok
+}
+
+func (s *MemoryClipboard) Set(owner string, content string, ttl time.Duration) error {
+	return s.set(owner, services.PastedClip{Content: content}, ttl)
+}
+
+func (s *MemoryClipboard) SetOnce(owner string, clipID int32, content string, ttl time.Duration) error {
+	return s.set(owner, services.PastedClip{Content: content, Once: true, ClipID: clipID}, ttl)
+}
+
+func (s *MemoryClipboard) SetRef(owner string, clipID int32, ttl time.Duration) error {
+	return s.set(owner, services.PastedClip{Ref: true, ClipID: clipID}, ttl)
+}
+
+func (s *MemoryClipboard) SetSensitive(owner string, clipID int32, content string, ttl time.Duration) error {
+	return s.set(owner, services.PastedClip{Content: content, Sensitive: true, ClipID: clipID}, ttl)
+}
+
+func (s *MemoryClipboard) Sensitive(owner string, clipID int32) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entry(owner)
+	if !ok || !entry.clip.Sensitive || entry.clip.ClipID != clipID {
+		return "", redis.Nil
+	}
+	return entry.clip.Content, nil
+}
+
+func (s *MemoryClipboard) Paste(owner string) (*services.PastedClip, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entry(owner)
+	if !ok {
+		if expiresAt, ok := s.consumed[owner]; ok && time.Now().Before(expiresAt) {
+			return nil, services.ErrClipConsumed
+		}
+		delete(s.consumed, owner)
+		return nil, redis.Nil
+	}
+	if entry.clip.Once {
+		delete(s.entries, owner)
+		s.consumed[owner] = entry.expiresAt
+		if entry.expiresAt.IsZero() {
+			s.consumed[owner] = time.Now().Add(services.CONSUMED_CLIP_TTL)
+		}
+	}
+	clip := entry.clip
+	return &clip, nil
+}
+
+func (s *MemoryClipboard) Clear(owner string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, owner)
+	delete(s.consumed, owner)
+	return nil
+}
@@ -0,0 +1,228 @@
+package store
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+type memoryDelivery struct {
+	clipID      int32
+	deviceID    int32
+	state       string
+	deliveredAt *time.Time
+	readAt      *time.Time
+}
+
+// MemoryDeviceStore keeps devices in memory. Clips sent to devices are added
+// to the history of clips.
+type MemoryDeviceStore struct {
+	mu    sync.Mutex
+	clips *MemoryClipStore
+	// Indexed by id - 1, deleted devices are nil so that ids are never reused
+	devices    []*Device
+	deliveries []*memoryDelivery
+}
+
+func NewMemoryDeviceStore(clips *MemoryClipStore) *MemoryDeviceStore {
+	return &MemoryDeviceStore{clips: clips}
+}
+
+func (s *MemoryDeviceStore) CreateDevice(userID int32, name string) (*Device, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	device := &Device{
+		Id:        int32(len(s.devices) + 1),
+		Uid:       uuid.New(),
+		UserID:    userID,
+		Name:      name,
+		CreatedAt: time.Now(),
+	}
+	s.devices = append(s.devices, device)
+	created := *device
+	return &created, nil
+}
+
+func (s *MemoryDeviceStore) GetUserDevices(userID int32) ([]Device, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var devices []Device
+	for _, device := range s.devices {
+		if device != nil && device.UserID == userID {
+			devices = append(devices, *device)
+		}
+	}
+	return devices, nil
+}
+
+func (s *MemoryDeviceStore) GetUserDevice(userID int32, uid uuid.UUID) (*Device, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, device := range s.devices {
+		if device != nil && device.UserID == userID && device.Uid == uid {
+			found := *device
+			return &found, nil
+		}
+	}
+	return nil, pgx.ErrNoRows
+}
+
+// get returns the device with the id, or nil if it doesn't exist. The lock
+// must be held.
+func (s *MemoryDeviceStore) get(id int32) *Device {
+	if id < 1 || int(id) > len(s.devices) {
+		return nil
+	}
+	return s.devices[id-1]
+}
+
+// DeleteDevice deletes the inbox of the device along with it.
+func (s *MemoryDeviceStore) DeleteDevice(id int32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.get(id) == nil {
+		return nil
+	}
+	s.devices[id-1] = nil
+	s.deliveries = slices.DeleteFunc(s.deliveries, func(delivery *memoryDelivery) bool {
+		return delivery.deviceID == id
+	})
+	return nil
+}
+
+func (s *MemoryDeviceStore) TouchDevice(id int32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if device := s.get(id); device != nil {
+		now := time.Now()
+		device.LastSeenAt = &now
+	}
+	return nil
+}
+
+// CreateDirectedClip adds a clip that is never deduplicated, like in
+// Postgres.
+func (s *MemoryDeviceStore) CreateDirectedClip(userID int32, content string, language string, deviceIDs []int32) (*Clip, error) {
+	clip := &memoryClip{
+		Clip: Clip{
+			UserID:      userID,
+			Content:     content,
+			ContentType: TEXT_CLIP_CONTENT_TYPE,
+			Size:        int64(len(content)),
+		},
+	}
+	if language != "" {
+		clip.Language = &language
+	}
+	created := s.clips.add(clip)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, deviceID := range deviceIDs {
+		if s.delivery(created.Id, deviceID) == nil {
+			s.deliveries = append(s.deliveries, &memoryDelivery{
+				clipID:   created.Id,
+				deviceID: deviceID,
+				state:    DELIVERY_STATE_PENDING,
+			})
+		}
+	}
+	return created, nil
+}
+
+// delivery returns the delivery of the clip to the device, or nil if it was
+// not sent there. The lock must be held.
+func (s *MemoryDeviceStore) delivery(clipID int32, deviceID int32) *memoryDelivery {
+	for _, delivery := range s.deliveries {
+		if delivery.clipID == clipID && delivery.deviceID == deviceID {
+			return delivery
+		}
+	}
+	return nil
+}
+
+func (s *MemoryDeviceStore) GetDeviceInbox(deviceID int32, states []string) ([]InboxItem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var items []InboxItem
+	for _, delivery := range s.deliveries {
+		if delivery.deviceID != deviceID || !slices.Contains(states, delivery.state) {
+			continue
+		}
+		clip, err := s.clips.GetClipByID(delivery.clipID)
+		if err != nil {
+			return nil, err
+		}
+		data, err := s.clips.GetClipData(clip)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, InboxItem{
+			ClipID:    clip.Id,
+			Content:   string(data),
+			State:     delivery.state,
+			CreatedAt: clip.CreatedAt,
+		})
+	}
+	slices.SortStableFunc(items, func(a InboxItem, b InboxItem) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return items, nil
+}
+
+func (s *MemoryDeviceStore) AckDelivery(clipID int32, deviceID int32, state string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delivery := s.delivery(clipID, deviceID)
+	if delivery == nil {
+		return false, nil
+	}
+	now := time.Now()
+	if delivery.state != DELIVERY_STATE_READ {
+		delivery.state = state
+	}
+	if delivery.deliveredAt == nil {
+		delivery.deliveredAt = &now
+	}
+	if state == DELIVERY_STATE_READ && delivery.readAt == nil {
+		delivery.readAt = &now
+	}
+	return true, nil
+}
+
+// delivered reports whether the clip was sent to the device.
+func (s *MemoryDeviceStore) delivered(clipID int32, deviceID int32) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.delivery(clipID, deviceID) != nil
+}
+
+func (s *MemoryDeviceStore) GetClipDeliveries(clipIDs []int32) (map[int32][]Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	byClip := make(map[int32][]Delivery)
+	for _, delivery := range s.deliveries {
+		device := s.get(delivery.deviceID)
+		if device == nil || !slices.Contains(clipIDs, delivery.clipID) {
+			continue
+		}
+		byClip[delivery.clipID] = append(byClip[delivery.clipID], Delivery{
+			ClipID:      delivery.clipID,
+			DeviceUid:   device.Uid,
+			DeviceName:  device.Name,
+			State:       delivery.state,
+			DeliveredAt: delivery.deliveredAt,
+			ReadAt:      delivery.readAt,
+		})
+	}
+	for _, deliveries := range byClip {
+		slices.SortStableFunc(deliveries, func(a Delivery, b Delivery) int {
+			return strings.Compare(a.DeviceName, b.DeviceName)
+		})
+	}
+	return byClip, nil
+}
@@ -0,0 +1,341 @@
+package store
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type memoryClipTags struct {
+	userID int32
+	// Sorted, without duplicates
+	names []string
+}
+
+// MemoryTagStore keeps the tags of clips in memory. Notes are kept on the
+// clips themselves.
+type MemoryTagStore struct {
+	mu    sync.Mutex
+	clips *MemoryClipStore
+	// Keyed by clip id, untagged clips have no entry
+	tags map[int32]memoryClipTags
+}
+
+func NewMemoryTagStore(clips *MemoryClipStore) *MemoryTagStore {
+	return &MemoryTagStore{clips: clips, tags: make(map[int32]memoryClipTags)}
+}
+
+func uniqueNames(names []string) []string {
+	names = slices.Clone(names)
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+func (s *MemoryTagStore) SetClipTags(userID int32, clipID int32, names []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(names) == 0 {
+		delete(s.tags, clipID)
+		return nil
+	}
+	s.tags[clipID] = memoryClipTags{userID: userID, names: uniqueNames(names)}
+	return nil
+}
+
+func (s *MemoryTagStore) GetClipTags(clipIDs []int32) (map[int32][]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	byClip := make(map[int32][]string)
+	for _, clipID := range clipIDs {
+		if tags, ok := s.tags[clipID]; ok {
+			byClip[clipID] = slices.Clone(tags.names)
+		}
+	}
+	return byClip, nil
+}
+
+func (s *MemoryTagStore) GetUserTags(userID int32) ([]Tag, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := make(map[string]int64)
+	for _, tags := range s.tags {
+		if tags.userID != userID {
+			continue
+		}
+		for _, name := range tags.names {
+			counts[name]++
+		}
+	}
+	var userTags []Tag
+	for name, clips := range counts {
+		userTags = append(userTags, Tag{Name: name, Clips: clips})
+	}
+	slices.SortFunc(userTags, func(a Tag, b Tag) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return userTags, nil
+}
+
+func (s *MemoryTagStore) RenameTag(userID int32, name string, newName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	found := false
+	for clipID, tags := range s.tags {
+		if tags.userID != userID || !slices.Contains(tags.names, name) {
+			continue
+		}
+		found = true
+		names := slices.Clone(tags.names)
+		for i := range names {
+			if names[i] == name {
+				names[i] = newName
+			}
+		}
+		s.tags[clipID] = memoryClipTags{userID: userID, names: uniqueNames(names)}
+	}
+	if !found {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// hasTag reports whether the clip is tagged with the name.
+func (s *MemoryTagStore) hasTag(clipID int32, name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Contains(s.tags[clipID].names, name)
+}
+
+func (s *MemoryTagStore) SetClipNote(clipID int32, note *string) error {
+	s.clips.setNote(clipID, note)
+	return nil
+}
+
+func (s *MemoryTagStore) GetUserClipsByTag(userID int32, name string, limit int) ([]Clip, error) {
+	clips, err := s.clips.GetUserClips(userID, 0)
+	if err != nil {
+		return nil, err
+	}
+	clips = slices.DeleteFunc(clips, func(clip Clip) bool {
+		return !s.hasTag(clip.Id, name)
+	})
+	if limit > 0 && len(clips) > limit {
+		clips = clips[:limit]
+	}
+	return clips, nil
+}
+
+type memorySlot struct {
+	userID    int32
+	name      string
+	clipID    int32
+	updatedAt time.Time
+}
+
+// MemorySlotStore keeps slots in memory, along with the ids of their clips.
+type MemorySlotStore struct {
+	mu    sync.Mutex
+	clips *MemoryClipStore
+	slots []*memorySlot
+}
+
+func NewMemorySlotStore(clips *MemoryClipStore) *MemorySlotStore {
+	return &MemorySlotStore{clips: clips}
+}
+
+// slot returns the slot of the user, or nil if they have no slot with the
+// name. The lock must be held.
+func (s *MemorySlotStore) slot(userID int32, name string) *memorySlot {
+	for _, slot := range s.slots {
+		if slot.userID == userID && slot.name == name {
+			return slot
+		}
+	}
+	return nil
+}
+
+// withClip joins the slot with its clip. The lock must be held.
+func (s *MemorySlotStore) withClip(slot *memorySlot) (*Slot, error) {
+	clip, err := s.clips.GetClipByID(slot.clipID)
+	if err != nil {
+		return nil, err
+	}
+	return &Slot{Name: slot.name, UpdatedAt: slot.updatedAt, Clip: *clip}, nil
+}
+
+func (s *MemorySlotStore) SetSlot(userID int32, name string, clipID int32) (*Slot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	slot := s.slot(userID, name)
+	if slot == nil {
+		slot = &memorySlot{userID: userID, name: name}
+		s.slots = append(s.slots, slot)
+	}
+	slot.clipID = clipID
+	slot.updatedAt = time.Now()
+	return s.withClip(slot)
+}
+
+func (s *MemorySlotStore) GetUserSlots(userID int32) ([]Slot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var slots []Slot
+	for _, slot := range s.slots {
+		if slot.userID != userID {
+			continue
+		}
+		joined, err := s.withClip(slot)
+		if err != nil {
+			return nil, err
+		}
+		slots = append(slots, *joined)
+	}
+	slices.SortFunc(slots, func(a Slot, b Slot) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return slots, nil
+}
+
+func (s *MemorySlotStore) GetUserSlot(userID int32, name string) (*Slot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	slot := s.slot(userID, name)
+	if slot == nil {
+		return nil, pgx.ErrNoRows
+	}
+	return s.withClip(slot)
+}
+
+func (s *MemorySlotStore) DeleteSlot(userID int32, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	slot := s.slot(userID, name)
+	if slot == nil {
+		return pgx.ErrNoRows
+	}
+	s.slots = slices.DeleteFunc(s.slots, func(other *memorySlot) bool {
+		return other == slot
+	})
+	return nil
+}
+
+// MemorySearchIndex searches the clips of the memory stores. Queries are a
+// subset of the websearch syntax: every word must be found, except words
+// prefixed with - which must not. Quotes and "or" have no special meaning.
+type MemorySearchIndex struct {
+	clips   *MemoryClipStore
+	devices *MemoryDeviceStore
+	tags    *MemoryTagStore
+}
+
+func NewMemorySearchIndex(clips *MemoryClipStore, devices *MemoryDeviceStore, tags *MemoryTagStore) *MemorySearchIndex {
+	return &MemorySearchIndex{clips: clips, devices: devices, tags: tags}
+}
+
+// searchWords splits text into lowercase words, like the simple text search
+// configuration of Postgres.
+func searchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func matchesType(clip *Clip, searchType string) bool {
+	switch searchType {
+	case "":
+		return true
+	case SEARCH_TYPE_TEXT:
+		return !clip.IsFile() && strings.HasPrefix(clip.ContentType, "text/plain")
+	case SEARCH_TYPE_FILE:
+		return clip.IsFile()
+	case SEARCH_TYPE_IMAGE:
+		return clip.IsImage()
+	case SEARCH_TYPE_CODE:
+		return clip.Language != nil
+	default:
+		return clip.Language != nil && *clip.Language == searchType
+	}
+}
+
+// SearchClips ranks clips by how often the words of the query occur in their
+// content and file name, then by date.
+func (s *MemorySearchIndex) SearchClips(userID int32, search ClipSearch, limit int) ([]Clip, error) {
+	var words, excluded []string
+	for _, field := range strings.Fields(search.Query) {
+		if strings.HasPrefix(field, "-") {
+			excluded = append(excluded, searchWords(field[1:])...)
+		} else {
+			words = append(words, searchWords(field)...)
+		}
+	}
+
+	clips, err := s.clips.GetUserClips(userID, 0)
+	if err != nil {
+		return nil, err
+	}
+	ranks := make(map[int32]int)
+	var found []Clip
+	for _, clip := range clips {
+		if clip.Once || clip.Sensitive || !matchesType(&clip, search.Type) {
+			continue
+		}
+		if search.From != nil && clip.CreatedAt.Before(*search.From) {
+			continue
+		}
+		if search.To != nil && !clip.CreatedAt.Before(*search.To) {
+			continue
+		}
+		if search.DeviceID != nil && !s.devices.delivered(clip.Id, *search.DeviceID) {
+			continue
+		}
+		if search.Tag != "" && !s.tags.hasTag(clip.Id, search.Tag) {
+			continue
+		}
+		text := clip.Content
+		if clip.IsFile() {
+			text += " " + *clip.FileName
+		}
+		rank, ok := rankWords(searchWords(text), words, excluded)
+		if !ok {
+			continue
+		}
+		ranks[clip.Id] = rank
+		found = append(found, clip)
+	}
+	// Stable, clips of the same rank stay newest first
+	slices.SortStableFunc(found, func(a Clip, b Clip) int {
+		return ranks[b.Id] - ranks[a.Id]
+	})
+	if limit > 0 && len(found) > limit {
+		found = found[:limit]
+	}
+	return found, nil
+}
+
+// rankWords returns the number of occurrences of words in text, and false if
+// one of words is missing or one of excluded is present.
+func rankWords(text []string, words []string, excluded []string) (int, bool) {
+	for _, word := range excluded {
+		if slices.Contains(text, word) {
+			return 0, false
+		}
+	}
+	rank := 0
+	for _, word := range words {
+		occurrences := 0
+		for _, other := range text {
+			if other == word {
+				occurrences++
+			}
+		}
+		if occurrences == 0 {
+			return 0, false
+		}
+		rank += occurrences
+	}
+	return rank, true
+}
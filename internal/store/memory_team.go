@@ -0,0 +1,188 @@
+package store
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+type memoryTeamMember struct {
+	teamID   int32
+	userID   int32
+	role     string
+	joinedAt time.Time
+}
+
+// MemoryTeamStore keeps teams in memory. Members are looked up in the user
+// store, members whose user was deleted are skipped like the cascade in
+// Postgres would delete them.
+type MemoryTeamStore struct {
+	mu    sync.Mutex
+	users *MemoryUserStore
+	// Indexed by id - 1
+	teams    []*Team
+	members  []*memoryTeamMember
+	channels []*TeamChannel
+}
+
+func NewMemoryTeamStore(users *MemoryUserStore) *MemoryTeamStore {
+	return &MemoryTeamStore{users: users}
+}
+
+func (s *MemoryTeamStore) CreateTeam(name string, ownerID int32) (*Team, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	team := &Team{
+		Id:        int32(len(s.teams) + 1),
+		Uid:       uuid.New(),
+		Name:      name,
+		CreatedAt: time.Now(),
+	}
+	s.teams = append(s.teams, team)
+	s.members = append(s.members, &memoryTeamMember{
+		teamID:   team.Id,
+		userID:   ownerID,
+		role:     TEAM_ROLE_OWNER,
+		joinedAt: time.Now(),
+	})
+	s.addChannel(team.Id, DEFAULT_TEAM_CHANNEL)
+	created := *team
+	return &created, nil
+}
+
+// member returns the membership of the user, or nil if they are not a member
+// of the team. The lock must be held.
+func (s *MemoryTeamStore) member(teamID int32, userID int32) *memoryTeamMember {
+	for _, member := range s.members {
+		if member.teamID == teamID && member.userID == userID {
+			return member
+		}
+	}
+	return nil
+}
+
+func (s *MemoryTeamStore) AddTeamMember(teamID int32, userID int32, role string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.member(teamID, userID) != nil {
+		return false, nil
+	}
+	s.members = append(s.members, &memoryTeamMember{
+		teamID:   teamID,
+		userID:   userID,
+		role:     role,
+		joinedAt: time.Now(),
+	})
+	return true, nil
+}
+
+func (s *MemoryTeamStore) RemoveTeamMember(teamID int32, userID int32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.members = slices.DeleteFunc(s.members, func(member *memoryTeamMember) bool {
+		return member.teamID == teamID && member.userID == userID
+	})
+	return nil
+}
+
+// addChannel adds a channel to the team, the lock must be held.
+func (s *MemoryTeamStore) addChannel(teamID int32, name string) *TeamChannel {
+	channel := &TeamChannel{
+		Id:        int32(len(s.channels) + 1),
+		Uid:       uuid.New(),
+		TeamID:    teamID,
+		Name:      name,
+		CreatedAt: time.Now(),
+	}
+	s.channels = append(s.channels, channel)
+	created := *channel
+	return &created
+}
+
+func (s *MemoryTeamStore) CreateTeamChannel(teamID int32, name string) (*TeamChannel, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addChannel(teamID, name), nil
+}
+
+func (s *MemoryTeamStore) GetUserTeams(userID int32) ([]TeamMembership, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var teams []TeamMembership
+	for _, member := range s.members {
+		if member.userID == userID {
+			teams = append(teams, TeamMembership{Team: *s.teams[member.teamID-1], Role: member.role})
+		}
+	}
+	slices.SortStableFunc(teams, func(a TeamMembership, b TeamMembership) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return teams, nil
+}
+
+func (s *MemoryTeamStore) GetUserTeam(userID int32, teamID int32) (*TeamMembership, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	member := s.member(teamID, userID)
+	if member == nil {
+		return nil, pgx.ErrNoRows
+	}
+	return &TeamMembership{Team: *s.teams[teamID-1], Role: member.role}, nil
+}
+
+func (s *MemoryTeamStore) GetTeamMembers(teamID int32) ([]TeamMember, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var members []TeamMember
+	for _, member := range s.members {
+		if member.teamID != teamID {
+			continue
+		}
+		user, err := s.users.GetUserByID(member.userID)
+		if err == pgx.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, TeamMember{
+			UserID:   member.userID,
+			Name:     user.Name,
+			Email:    user.Email,
+			Role:     member.role,
+			JoinedAt: member.joinedAt,
+		})
+	}
+	return members, nil
+}
+
+func (s *MemoryTeamStore) GetTeamChannels(teamID int32) ([]TeamChannel, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var channels []TeamChannel
+	for _, channel := range s.channels {
+		if channel.TeamID == teamID {
+			channels = append(channels, *channel)
+		}
+	}
+	slices.SortStableFunc(channels, func(a TeamChannel, b TeamChannel) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return channels, nil
+}
+
+func (s *MemoryTeamStore) GetTeamChannel(teamID int32, name string) (*TeamChannel, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, channel := range s.channels {
+		if channel.TeamID == teamID && channel.Name == name {
+			found := *channel
+			return &found, nil
+		}
+	}
+	return nil, pgx.ErrNoRows
+}
@@ -0,0 +1,363 @@
+package store
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/amns13/shipboard/internal/services"
+	"github.com/redis/go-redis/v9"
+)
+
+// The memory stores of this file drop what has expired when it is next
+// looked up, like redis does with keys past their TTL.
+
+func expired(expiresAt time.Time) bool {
+	return !time.Now().Before(expiresAt)
+}
+
+type memoryShare struct {
+	share          services.Share
+	expiresAt      time.Time
+	failedAttempts int
+}
+
+type MemoryShareStore struct {
+	mu     sync.Mutex
+	shares map[string]*memoryShare
+}
+
+func NewMemoryShareStore() *MemoryShareStore {
+	return &MemoryShareStore{shares: make(map[string]*memoryShare)}
+}
+
+func (s *MemoryShareStore) Create(share services.Share, ttl time.Duration) (string, error) {
+	token, err := services.NewToken()
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shares[token] = &memoryShare{share: share, expiresAt: time.Now().Add(ttl)}
+	return token, nil
+}
+
+// get returns the share, or nil if it doesn't exist. The lock must be held.
+func (s *MemoryShareStore) get(token string) *memoryShare {
+	share, ok := s.shares[token]
+	if ok && expired(share.expiresAt) {
+		delete(s.shares, token)
+		return nil
+	}
+	return share
+}
+
+func (s *MemoryShareStore) Get(token string) (*services.Share, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	share := s.get(token)
+	if share == nil {
+		return nil, redis.Nil
+	}
+	found := share.share
+	return &found, nil
+}
+
+func (s *MemoryShareStore) ConsumeView(token string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	share := s.get(token)
+	if share == nil || share.share.ViewsLeft <= 0 {
+		return 0, redis.Nil
+	}
+	share.share.ViewsLeft--
+	if share.share.ViewsLeft == 0 {
+		delete(s.shares, token)
+	}
+	return share.share.ViewsLeft, nil
+}
+
+func (s *MemoryShareStore) FailAttempt(token string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	share := s.get(token)
+	if share == nil {
+		return 0, redis.Nil
+	}
+	share.failedAttempts++
+	if share.failedAttempts >= services.SHARE_PASSWORD_ATTEMPTS {
+		delete(s.shares, token)
+		return 0, nil
+	}
+	return services.SHARE_PASSWORD_ATTEMPTS - share.failedAttempts, nil
+}
+
+func (s *MemoryShareStore) DeleteUserShares(userID int32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for token, share := range s.shares {
+		if share.share.UserID == userID {
+			delete(s.shares, token)
+		}
+	}
+	return nil
+}
+
+type memoryAttempts struct {
+	attempts  int
+	expiresAt time.Time
+}
+
+type MemoryRateLimiter struct {
+	mu       sync.Mutex
+	attempts map[string]*memoryAttempts
+}
+
+func NewMemoryRateLimiter() *MemoryRateLimiter {
+	return &MemoryRateLimiter{attempts: make(map[string]*memoryAttempts)}
+}
+
+func (l *MemoryRateLimiter) Allow(key string, limit int, window time.Duration) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	attempts, ok := l.attempts[key]
+	if !ok || expired(attempts.expiresAt) {
+		attempts = &memoryAttempts{expiresAt: time.Now().Add(window)}
+		l.attempts[key] = attempts
+	}
+	attempts.attempts++
+	return attempts.attempts <= limit, nil
+}
+
+type memoryEmailVerification struct {
+	data      services.EmailVerification
+	expiresAt time.Time
+}
+
+type MemoryEmailVerificationStore struct {
+	mu     sync.Mutex
+	tokens map[string]memoryEmailVerification
+	// Token of the pending change of each user
+	users map[int32]string
+}
+
+func NewMemoryEmailVerificationStore() *MemoryEmailVerificationStore {
+	return &MemoryEmailVerificationStore{
+		tokens: make(map[string]memoryEmailVerification),
+		users:  make(map[int32]string),
+	}
+}
+
+func (s *MemoryEmailVerificationStore) Create(data services.EmailVerification) (string, error) {
+	token, err := services.NewToken()
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tokens, s.users[data.UserID])
+	s.tokens[token] = memoryEmailVerification{
+		data:      data,
+		expiresAt: time.Now().Add(services.EmailVerificationTTL),
+	}
+	s.users[data.UserID] = token
+	return token, nil
+}
+
+func (s *MemoryEmailVerificationStore) Consume(token string) (*services.EmailVerification, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	verification, ok := s.tokens[token]
+	if !ok {
+		return nil, redis.Nil
+	}
+	delete(s.tokens, token)
+	delete(s.users, verification.data.UserID)
+	if expired(verification.expiresAt) {
+		return nil, redis.Nil
+	}
+	return &verification.data, nil
+}
+
+func (s *MemoryEmailVerificationStore) Cancel(userID int32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tokens, s.users[userID])
+	delete(s.users, userID)
+	return nil
+}
+
+type memoryUpload struct {
+	upload     services.Upload
+	chunks     []string
+	finalizing bool
+}
+
+type MemoryUploadStore struct {
+	mu      sync.Mutex
+	uploads map[string]*memoryUpload
+}
+
+func NewMemoryUploadStore() *MemoryUploadStore {
+	return &MemoryUploadStore{uploads: make(map[string]*memoryUpload)}
+}
+
+func (s *MemoryUploadStore) Create(upload *services.Upload) error {
+	id, err := services.NewToken()
+	if err != nil {
+		return err
+	}
+	upload.ID = id
+	upload.Offset = 0
+	upload.ExpiresAt = time.Now().Add(services.UploadTTL)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.uploads[id] = &memoryUpload{upload: *upload}
+	return nil
+}
+
+// get returns the upload, or nil if it doesn't exist. The lock must be held.
+func (s *MemoryUploadStore) get(id string) *memoryUpload {
+	upload, ok := s.uploads[id]
+	if ok && expired(upload.upload.ExpiresAt) {
+		delete(s.uploads, id)
+		return nil
+	}
+	return upload
+}
+
+func (s *MemoryUploadStore) Get(id string) (*services.Upload, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	upload := s.get(id)
+	if upload == nil {
+		return nil, redis.Nil
+	}
+	found := upload.upload
+	return &found, nil
+}
+
+func (s *MemoryUploadStore) AddChunk(id string, offset int64, size int64, blobKey string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	upload := s.get(id)
+	if upload == nil {
+		return 0, redis.Nil
+	}
+	if upload.upload.Offset != offset {
+		return 0, services.ErrUploadOffsetMismatch
+	}
+	upload.upload.Offset += size
+	upload.chunks = append(upload.chunks, blobKey)
+	return upload.upload.Offset, nil
+}
+
+func (s *MemoryUploadStore) Chunks(id string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	upload := s.get(id)
+	if upload == nil {
+		return nil, nil
+	}
+	return slices.Clone(upload.chunks), nil
+}
+
+func (s *MemoryUploadStore) Claim(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	upload := s.get(id)
+	if upload == nil {
+		return false, redis.Nil
+	}
+	if upload.finalizing {
+		return false, nil
+	}
+	upload.finalizing = true
+	return true, nil
+}
+
+func (s *MemoryUploadStore) Release(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if upload := s.get(id); upload != nil {
+		upload.finalizing = false
+	}
+	return nil
+}
+
+func (s *MemoryUploadStore) UserUploads(userID int32) ([]services.Upload, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var uploads []services.Upload
+	for id, upload := range s.uploads {
+		if upload.upload.UserID == userID && s.get(id) != nil {
+			uploads = append(uploads, upload.upload)
+		}
+	}
+	return uploads, nil
+}
+
+func (s *MemoryUploadStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.uploads, id)
+	return nil
+}
+
+type MemoryExportStore struct {
+	mu   sync.Mutex
+	jobs map[string]services.ExportJob
+}
+
+func NewMemoryExportStore() *MemoryExportStore {
+	return &MemoryExportStore{jobs: make(map[string]services.ExportJob)}
+}
+
+func (s *MemoryExportStore) Create(job services.ExportJob) (string, error) {
+	token, err := services.NewToken()
+	if err != nil {
+		return "", err
+	}
+	return token, s.Set(token, job)
+}
+
+func (s *MemoryExportStore) Set(token string, job services.ExportJob) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if expired(job.ExpiresAt) {
+		delete(s.jobs, token)
+		return nil
+	}
+	s.jobs[token] = job
+	return nil
+}
+
+func (s *MemoryExportStore) Get(token string) (*services.ExportJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[token]
+	if !ok {
+		return nil, redis.Nil
+	}
+	if expired(job.ExpiresAt) {
+		delete(s.jobs, token)
+		return nil, redis.Nil
+	}
+	return &job, nil
+}
+
+func (s *MemoryExportStore) DeleteUserExports(userID int32) ([]services.ExportJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var jobs []services.ExportJob
+	for token, job := range s.jobs {
+		if job.UserID != userID {
+			continue
+		}
+		delete(s.jobs, token)
+		if !expired(job.ExpiresAt) {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
@@ -0,0 +1,485 @@
+// Package store defines the storage the handlers depend on, along with
+// in-memory implementations. Postgres and redis implement them in production,
+// see model.UsePostgres and conf.LoadEnv.
+//
+// Stores return pgx.ErrNoRows for missing rows, e.g. users, clips and
+// devices, and redis.Nil for missing sessions, tokens and an empty clipboard,
+// whatever they are backed by.
+package store
+
+import (
+	"strings"
+	"time"
+
+	"github.com/amns13/shipboard/internal/services"
+	"github.com/google/uuid"
+)
+
+type UserCreator struct {
+	Name         string `db:"name"`
+	Email        string `db:"email"`
+	PasswordHash string `db:"password_hash"`
+}
+
+const (
+	ROLE_USER  = "user"
+	ROLE_ADMIN = "admin"
+)
+
+type User struct {
+	Id         int32      `db:"id"`
+	Uid        uuid.UUID  `db:"uid"`
+	CreatedAt  time.Time  `db:"created_at"`
+	Role       string     `db:"role"`
+	DisabledAt *time.Time `db:"disabled_at"`
+	UserCreator
+}
+
+func (usr *User) IsDisabled() bool {
+	return usr.DisabledAt != nil
+}
+
+// Content type of text clips
+const TEXT_CLIP_CONTENT_TYPE = "text/plain; charset=utf-8"
+
+type Clip struct {
+	Id        int32     `db:"id"`
+	UserID    int32     `db:"user_id"`
+	Content   string    `db:"content"`
+	CreatedAt time.Time `db:"created_at"`
+	// Burn after reading clips never have their content saved
+	Once       bool       `db:"once"`
+	ConsumedAt *time.Time `db:"consumed_at"`
+	// The bytes of file clips are not loaded with the clip, see GetClipData
+	ContentType string  `db:"content_type"`
+	FileName    *string `db:"file_name"`
+	Size        int64   `db:"size"`
+	// Set when the content or data is kept in the blob store
+	BlobKey *string `db:"blob_key"`
+	// Language of code clips, see services.Languages
+	Language *string `db:"language"`
+	// Sensitive clips never have their content saved, like burn after
+	// reading ones
+	Sensitive bool `db:"sensitive"`
+	// Set when the data or blob is compressed, see services.Compress
+	Codec *string `db:"codec"`
+	// Short note of the user, see model.SetClipNote
+	Note *string `db:"note"`
+}
+
+func (clip *Clip) IsFile() bool {
+	return clip.FileName != nil
+}
+
+// IsCompressed reports whether the payload of the clip is compressed. The
+// content of compressed text clips is kept in data or in the blob store.
+func (clip *Clip) IsCompressed() bool {
+	return clip.Codec != nil
+}
+
+func (clip *Clip) IsImage() bool {
+	return strings.HasPrefix(clip.ContentType, "image/")
+}
+
+// IsStored reports whether the payload of the clip is in the blob store
+// instead of inline.
+func (clip *Clip) IsStored() bool {
+	return clip.BlobKey != nil
+}
+
+// Usage is what a user stores, clips and their representations.
+type Usage struct {
+	UserID int32 `db:"user_id"`
+	Clips  int64 `db:"clips"`
+	Bytes  int64 `db:"bytes"`
+}
+
+// QuotaOverride is the quota an admin set for a user. Nil fields keep the
+// global quota, 0 is unlimited.
+type QuotaOverride struct {
+	UserID   int32  `db:"user_id"`
+	MaxClips *int64 `db:"max_clips"`
+	MaxBytes *int64 `db:"max_bytes"`
+}
+
+// Apply returns the global quota with the overridden fields replaced.
+func (override *QuotaOverride) Apply(quota services.Quota) services.Quota {
+	if override.MaxClips != nil {
+		quota.MaxClips = *override.MaxClips
+	}
+	if override.MaxBytes != nil {
+		quota.MaxBytes = *override.MaxBytes
+	}
+	return quota
+}
+
+// UserStore keeps the accounts of users and their settings.
+type UserStore interface {
+	CreateUser(creator UserCreator) (*User, error)
+	UserExists(email string) (bool, error)
+	GetUserByEmail(email string) (*User, error)
+	GetUserByID(id int32) (*User, error)
+	// GetQuotaOverride returns an override without fields if no admin
+	// overrode the quota of the user.
+	GetQuotaOverride(userID int32) (QuotaOverride, error)
+	// GetUserRetentionPolicy returns the zero policy if the user has not set
+	// one.
+	GetUserRetentionPolicy(userID int32) (services.RetentionPolicy, error)
+	// SetUserRetentionPolicy replaces the policy of the user. The zero policy
+	// removes it.
+	SetUserRetentionPolicy(userID int32, policy services.RetentionPolicy) error
+	UpdatePasswordHash(id int32, passwordHash string) error
+	UpdateEmail(id int32, email string) error
+	// SetUserDisabled disables or re-enables the user. Disabling an already
+	// disabled user keeps the original disabled_at.
+	SetUserDisabled(id int32, disabled bool) error
+	// DeleteUser deletes the user along with everything the store keeps for
+	// them. cleanup deletes what is kept elsewhere, the user is kept if it
+	// fails so that the deletion can be retried.
+	DeleteUser(id int32, cleanup func() error) error
+}
+
+// ClipStore keeps the history of clips.
+type ClipStore interface {
+	// CreateClip adds a text clip. An empty language is saved as prose.
+	CreateClip(userID int32, content string, language string) (*Clip, error)
+	// CreateOnceClip adds a burn after reading clip. Only the metadata is
+	// saved, the content must be kept in the clipboard.
+	CreateOnceClip(userID int32, size int) (*Clip, error)
+	// CreateSensitiveClip adds a clip that looks like a secret. Only the
+	// metadata is saved, like burn after reading clips.
+	CreateSensitiveClip(userID int32, size int) (*Clip, error)
+	CreateFileClip(userID int32, fileName string, contentType string, data []byte) (*Clip, error)
+	// BumpDuplicateClip moves the latest clip with the same payload,
+	// broadcasted within the window, to the top of the history. Text clips
+	// have no file name and the default content type, files have no
+	// language. Returns pgx.ErrNoRows if there is no such clip.
+	BumpDuplicateClip(userID int32, fileName *string, contentType string, language string, data []byte, window time.Duration) (*Clip, error)
+	GetClipByID(id int32) (*Clip, error)
+	// GetUserClips returns the latest clips of the user, newest first. A
+	// limit of 0 returns every clip.
+	GetUserClips(userID int32, limit int) ([]Clip, error)
+	// GetClipData returns the payload of a clip, decompressed.
+	GetClipData(clip *Clip) ([]byte, error)
+	GetUserUsage(userID int32) (Usage, error)
+	MarkClipConsumed(id int32) error
+}
+
+// SessionStore keeps the login sessions of users.
+type SessionStore interface {
+	CreateSession(data services.SessionData) (string, error)
+	Get(sessionID string) (*services.SessionData, error)
+	Expire(sessionID string) error
+	// ExpireUserSessions expires all the sessions of a user, except the
+	// session with id exceptSessionID. Pass an empty string to expire every
+	// session.
+	ExpireUserSessions(userID int32, exceptSessionID string) error
+	// UserSessions returns the data of the active sessions of a user.
+	UserSessions(userID int32) ([]services.SessionData, error)
+}
+
+// Clipboard keeps the latest clip of a user for pasting, keyed by the user
+// uid. Clips expire after ttl, if it is not 0.
+type Clipboard interface {
+	// Set replaces the clipboard, including any pending burn after reading
+	// clip.
+	Set(owner string, content string, ttl time.Duration) error
+	// SetOnce replaces the clipboard with a clip that can be pasted only once.
+	SetOnce(owner string, clipID int32, content string, ttl time.Duration) error
+	// SetRef replaces the clipboard with a clip kept only in the history,
+	// like files and large clips.
+	SetRef(owner string, clipID int32, ttl time.Duration) error
+	// SetSensitive replaces the clipboard with a clip that looks like a
+	// secret.
+	SetSensitive(owner string, clipID int32, content string, ttl time.Duration) error
+	// Sensitive returns the content of the sensitive clip, without pasting
+	// it. Returns redis.Nil if it has expired or been replaced.
+	Sensitive(owner string, clipID int32) (string, error)
+	// Paste returns the clipboard of the owner. Returns redis.Nil if nothing
+	// has been broadcasted, and services.ErrClipConsumed if the last clip was
+	// burn after reading and has already been pasted.
+	Paste(owner string) (*services.PastedClip, error)
+	// Clear empties the clipboard of the owner, forgetting any consumed burn
+	// after reading clip.
+	Clear(owner string) error
+}
+
+const (
+	DELIVERY_STATE_PENDING   = "pending"
+	DELIVERY_STATE_DELIVERED = "delivered"
+	DELIVERY_STATE_READ      = "read"
+)
+
+type Device struct {
+	Id         int32      `db:"id"`
+	Uid        uuid.UUID  `db:"uid"`
+	UserID     int32      `db:"user_id"`
+	Name       string     `db:"name"`
+	CreatedAt  time.Time  `db:"created_at"`
+	LastSeenAt *time.Time `db:"last_seen_at"`
+}
+
+// Delivery is the state of a clip sent to a device
+type Delivery struct {
+	ClipID      int32      `db:"clip_id"`
+	DeviceUid   uuid.UUID  `db:"device_uid"`
+	DeviceName  string     `db:"device_name"`
+	State       string     `db:"state"`
+	DeliveredAt *time.Time `db:"delivered_at"`
+	ReadAt      *time.Time `db:"read_at"`
+}
+
+type InboxItem struct {
+	ClipID    int32     `db:"clip_id" json:"clip_id"`
+	Content   string    `db:"content" json:"content"`
+	State     string    `db:"state" json:"state"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+const (
+	TEAM_ROLE_OWNER  = "owner"
+	TEAM_ROLE_WRITER = "writer"
+	TEAM_ROLE_READER = "reader"
+)
+
+// Every team starts with this channel
+const DEFAULT_TEAM_CHANNEL = "general"
+
+type Team struct {
+	Id        int32     `db:"id"`
+	Uid       uuid.UUID `db:"uid"`
+	Name      string    `db:"name"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// TeamMembership is a team as seen by one of its members
+type TeamMembership struct {
+	Team
+	Role string `db:"role"`
+}
+
+type TeamMember struct {
+	UserID   int32     `db:"user_id"`
+	Name     string    `db:"name"`
+	Email    string    `db:"email"`
+	Role     string    `db:"role"`
+	JoinedAt time.Time `db:"joined_at"`
+}
+
+type TeamChannel struct {
+	Id        int32     `db:"id"`
+	Uid       uuid.UUID `db:"uid"`
+	TeamID    int32     `db:"team_id"`
+	Name      string    `db:"name"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// Tag is a tag of the user, along with the number of clips tagged with it.
+type Tag struct {
+	Name  string `db:"name"`
+	Clips int64  `db:"clips"`
+}
+
+// Slot is a clip pinned under a name, along with the clip itself.
+type Slot struct {
+	Name      string    `db:"name"`
+	UpdatedAt time.Time `db:"updated_at"`
+	Clip
+}
+
+// Types clips can be searched by. Any language of services.Languages is a
+// type as well.
+const (
+	SEARCH_TYPE_TEXT  = "text"
+	SEARCH_TYPE_FILE  = "file"
+	SEARCH_TYPE_IMAGE = "image"
+	SEARCH_TYPE_CODE  = "code"
+)
+
+// ClipSearch filters the history of a user. Zero fields don't filter.
+type ClipSearch struct {
+	// Words to find, in the websearch syntax, e.g. "deploy -staging"
+	Query string
+	From  *time.Time
+	// Exclusive
+	To       *time.Time
+	DeviceID *int32
+	Type     string
+	Tag      string
+}
+
+// DeviceStore keeps the devices of users, and the inboxes of clips sent to
+// them.
+type DeviceStore interface {
+	CreateDevice(userID int32, name string) (*Device, error)
+	// GetUserDevices returns the devices of the user, oldest first.
+	GetUserDevices(userID int32) ([]Device, error)
+	// GetUserDevice returns pgx.ErrNoRows if the device doesn't belong to
+	// the user.
+	GetUserDevice(userID int32, uid uuid.UUID) (*Device, error)
+	DeleteDevice(id int32) error
+	TouchDevice(id int32) error
+	// CreateDirectedClip adds a text clip to the history and to the inbox of
+	// each of the devices. A device listed twice gets the clip once.
+	CreateDirectedClip(userID int32, content string, language string, deviceIDs []int32) (*Clip, error)
+	// GetDeviceInbox returns the clips sent to the device that are in one of
+	// the states, oldest first.
+	GetDeviceInbox(deviceID int32, states []string) ([]InboxItem, error)
+	// AckDelivery moves the delivery to state. States only move forward,
+	// acknowledging a read clip as delivered is a no-op. Returns false if the
+	// clip was not sent to the device.
+	AckDelivery(clipID int32, deviceID int32, state string) (bool, error)
+	// GetClipDeliveries returns the deliveries of the clips, keyed by clip
+	// id. Clips broadcasted to every device have no deliveries.
+	GetClipDeliveries(clipIDs []int32) (map[int32][]Delivery, error)
+}
+
+// TeamStore keeps teams, their members and channels. The clipboards of the
+// channels are kept in the Clipboard.
+type TeamStore interface {
+	// CreateTeam creates the team with the user as its owner, and the
+	// default channel.
+	CreateTeam(name string, ownerID int32) (*Team, error)
+	// AddTeamMember adds the user to the team. Returns false if they are
+	// already a member, whose role is kept.
+	AddTeamMember(teamID int32, userID int32, role string) (bool, error)
+	RemoveTeamMember(teamID int32, userID int32) error
+	CreateTeamChannel(teamID int32, name string) (*TeamChannel, error)
+	// GetUserTeams returns the teams of the user, by name.
+	GetUserTeams(userID int32) ([]TeamMembership, error)
+	// GetUserTeam returns pgx.ErrNoRows if the user is not a member of the
+	// team.
+	GetUserTeam(userID int32, teamID int32) (*TeamMembership, error)
+	// GetTeamMembers returns the members of the team, by join date.
+	GetTeamMembers(teamID int32) ([]TeamMember, error)
+	// GetTeamChannels returns the channels of the team, by name.
+	GetTeamChannels(teamID int32) ([]TeamChannel, error)
+	// GetTeamChannel returns pgx.ErrNoRows if the team has no channel with
+	// the name.
+	GetTeamChannel(teamID int32, name string) (*TeamChannel, error)
+}
+
+// TagStore keeps the tags and notes of clips.
+type TagStore interface {
+	// SetClipTags replaces the tags of a clip of the user. Tags are created
+	// as needed, and only kept while they are on some clip.
+	SetClipTags(userID int32, clipID int32, names []string) error
+	// GetClipTags returns the tag names of the clips, keyed by clip id.
+	GetClipTags(clipIDs []int32) (map[int32][]string, error)
+	// GetUserTags returns the tags of the user, by name.
+	GetUserTags(userID int32) ([]Tag, error)
+	// RenameTag renames a tag of the user on all of its clips. Renaming to a
+	// tag the user already has merges both. Returns pgx.ErrNoRows if the user
+	// has no tag with the name.
+	RenameTag(userID int32, name string, newName string) error
+	// SetClipNote sets the note of a clip, nil removes it.
+	SetClipNote(clipID int32, note *string) error
+	// GetUserClipsByTag returns the latest clips of the user with the tag,
+	// newest first.
+	GetUserClipsByTag(userID int32, name string, limit int) ([]Clip, error)
+}
+
+// SlotStore keeps the clips users pinned under a name.
+type SlotStore interface {
+	// SetSlot pins the clip under the name, replacing the clip pinned there
+	// before if any.
+	SetSlot(userID int32, name string, clipID int32) (*Slot, error)
+	// GetUserSlots returns the slots of the user, by name.
+	GetUserSlots(userID int32) ([]Slot, error)
+	// GetUserSlot returns pgx.ErrNoRows if the user has no slot with the
+	// name.
+	GetUserSlot(userID int32, name string) (*Slot, error)
+	// DeleteSlot unpins the clip of the slot, the clip stays in the history.
+	// Returns pgx.ErrNoRows if the user has no slot with the name.
+	DeleteSlot(userID int32, name string) error
+}
+
+// SearchIndex finds clips of the history by their words. Burn after reading
+// and sensitive clips are never found, their content is not kept.
+type SearchIndex interface {
+	// SearchClips returns the clips of the user matching search, best
+	// matches first.
+	SearchClips(userID int32, search ClipSearch, limit int) ([]Clip, error)
+}
+
+// ShareStore keeps the public links to single clips, until they expire or
+// run out of views.
+type ShareStore interface {
+	// Create returns the token of the new share, which expires after ttl.
+	Create(share services.Share, ttl time.Duration) (string, error)
+	// Get returns the share without counting a view. Returns redis.Nil for
+	// unknown, expired and used up shares.
+	Get(token string) (*services.Share, error)
+	// ConsumeView counts a view of the share, deleting it on its last view,
+	// so that concurrent views can never exceed the limit. Returns the views
+	// left, or redis.Nil if the share has none.
+	ConsumeView(token string) (int, error)
+	// FailAttempt counts a wrong password against the share, deleting it
+	// after services.SHARE_PASSWORD_ATTEMPTS of them. Returns the attempts
+	// left, or redis.Nil if the share doesn't exist.
+	FailAttempt(token string) (int, error)
+	// DeleteUserShares deletes every share of the user.
+	DeleteUserShares(userID int32) error
+}
+
+// RateLimiter counts attempts of anything guessable in fixed windows.
+type RateLimiter interface {
+	// Allow counts an attempt under key, and reports whether at most limit
+	// attempts were made in the current window.
+	Allow(key string, limit int, window time.Duration) (bool, error)
+}
+
+// EmailVerificationStore keeps pending email changes until the user confirms
+// the new address. A user has at most one pending change.
+type EmailVerificationStore interface {
+	// Create returns the token confirming the change, invalidating the token
+	// of the previous change of the user if any.
+	Create(data services.EmailVerification) (string, error)
+	// Consume returns the pending change for the token and deletes it, so a
+	// token can be used only once. Returns redis.Nil for unknown tokens.
+	Consume(token string) (*services.EmailVerification, error)
+	// Cancel deletes the pending change of the user, if any.
+	Cancel(userID int32) error
+}
+
+// UploadStore keeps the state of resumable uploads. Their chunks are kept in
+// the blob store.
+type UploadStore interface {
+	// Create starts an upload and sets its ID and expiry.
+	Create(upload *services.Upload) error
+	// Get returns redis.Nil for unknown and expired uploads.
+	Get(id string) (*services.Upload, error)
+	// AddChunk records the chunk of size bytes kept in the blob store under
+	// blobKey, if it starts at offset. Returns the new offset, redis.Nil if
+	// the upload doesn't exist anymore and services.ErrUploadOffsetMismatch
+	// if another chunk was added at offset in the meantime.
+	AddChunk(id string, offset int64, size int64, blobKey string) (int64, error)
+	// Chunks returns the blob keys of the chunks of the upload, in order.
+	Chunks(id string) ([]string, error)
+	// Claim marks the upload as being finalized, so that concurrent
+	// finalizes broadcast it once. Returns false if it is already claimed,
+	// and redis.Nil if the upload doesn't exist anymore.
+	Claim(id string) (bool, error)
+	// Release lets the upload be finalized again, after finalizing it
+	// failed.
+	Release(id string) error
+	// UserUploads returns the uploads of the user that have not expired.
+	UserUploads(userID int32) ([]services.Upload, error)
+	// Delete forgets the upload. Its chunks must be deleted from the blob
+	// store separately.
+	Delete(id string) error
+}
+
+// ExportStore tracks exports generated in the background. The token of a job
+// is the secret part of its download link.
+type ExportStore interface {
+	// Create returns the token of the new job.
+	Create(job services.ExportJob) (string, error)
+	// Set replaces the job, which expires at its ExpiresAt.
+	Set(token string, job services.ExportJob) error
+	// Get returns redis.Nil for unknown and expired tokens.
+	Get(token string) (*services.ExportJob, error)
+	// DeleteUserExports deletes the jobs of the user, and returns them so
+	// that their files can be deleted.
+	DeleteUserExports(userID int32) ([]services.ExportJob, error)
+}
@@ -0,0 +1,5 @@
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS role varchar(31) DEFAULT 'user' NOT NULL,
+    -- NULL while the account is active
+    ADD COLUMN IF NOT EXISTS disabled_at timestamp,
+    ADD CONSTRAINT users_role_check CHECK (role IN ('user', 'admin'));
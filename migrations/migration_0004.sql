@@ -0,0 +1,10 @@
+-- History of broadcasted clips. The latest clip of a user is also cached in
+-- redis for pasting.
+CREATE TABLE IF NOT EXISTS clips (
+    id integer PRIMARY KEY GENERATED BY DEFAULT AS IDENTITY,
+    user_id integer NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    content text NOT NULL,
+    created_at timestamp DEFAULT current_timestamp NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS clips_user_id_created_at_idx ON clips(user_id, created_at DESC);
@@ -0,0 +1,5 @@
+-- Burn after reading clips are kept in redis until pasted. Their history entry
+-- has an empty content.
+ALTER TABLE clips
+    ADD COLUMN IF NOT EXISTS once boolean DEFAULT false NOT NULL,
+    ADD COLUMN IF NOT EXISTS consumed_at timestamp;
@@ -0,0 +1,9 @@
+-- File clips keep their bytes in data and have an empty content. size is the
+-- size of whichever one is used.
+ALTER TABLE clips
+    ADD COLUMN IF NOT EXISTS content_type varchar(255) DEFAULT 'text/plain; charset=utf-8' NOT NULL,
+    ADD COLUMN IF NOT EXISTS file_name varchar(255),
+    ADD COLUMN IF NOT EXISTS data bytea,
+    ADD COLUMN IF NOT EXISTS size bigint DEFAULT 0 NOT NULL;
+
+UPDATE clips SET size = octet_length(content);
@@ -0,0 +1,5 @@
+-- Payloads above the inline limit are kept in the blob store under blob_key,
+-- with an empty content and no data.
+ALTER TABLE clips ADD COLUMN IF NOT EXISTS blob_key varchar(255);
+
+CREATE UNIQUE INDEX IF NOT EXISTS clips_blob_key_idx ON clips (blob_key) WHERE blob_key IS NOT NULL;
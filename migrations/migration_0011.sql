@@ -0,0 +1,3 @@
+-- Clips that look like secrets keep only their metadata in the history, their
+-- content expires from redis.
+ALTER TABLE clips ADD COLUMN IF NOT EXISTS sensitive boolean NOT NULL DEFAULT false;
@@ -0,0 +1,5 @@
+-- SHA-256 of the payload of clips broadcasted to all devices, to find repeated
+-- broadcasts. NULL for clips that are never deduplicated.
+ALTER TABLE clips ADD COLUMN IF NOT EXISTS content_hash bytea;
+
+CREATE INDEX IF NOT EXISTS clips_user_content_hash_idx ON clips (user_id, content_hash) WHERE content_hash IS NOT NULL;
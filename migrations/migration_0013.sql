@@ -0,0 +1,3 @@
+-- Codec the data or blob of a clip is compressed with, NULL if it is not
+-- compressed. size stays the uncompressed size.
+ALTER TABLE clips ADD COLUMN IF NOT EXISTS codec varchar(15);
@@ -0,0 +1,12 @@
+-- Named slots pin a clip of the user under a name, e.g. deploy-cmd. Pinned
+-- clips are kept until unpinned, whatever the expiry and retention.
+CREATE TABLE IF NOT EXISTS clip_slots (
+    user_id integer NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name varchar(63) NOT NULL,
+    clip_id integer NOT NULL REFERENCES clips(id) ON DELETE CASCADE,
+    created_at timestamp DEFAULT current_timestamp NOT NULL,
+    updated_at timestamp DEFAULT current_timestamp NOT NULL,
+    PRIMARY KEY (user_id, name)
+);
+
+CREATE INDEX IF NOT EXISTS clip_slots_clip_id_idx ON clip_slots(clip_id);
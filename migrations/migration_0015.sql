@@ -0,0 +1,9 @@
+-- Full-text search over the text of clips, and the names of files. Burn after
+-- reading and sensitive clips have no text to index.
+ALTER TABLE clips ADD COLUMN IF NOT EXISTS search tsvector;
+
+UPDATE clips
+SET search = to_tsvector('simple', left(content, 65536) || ' ' || coalesce(file_name, ''))
+WHERE search IS NULL AND NOT once AND NOT sensitive;
+
+CREATE INDEX IF NOT EXISTS clips_search_idx ON clips USING GIN (search);
@@ -0,0 +1,18 @@
+-- Tags are per user, so that renaming one renames it on all of its clips
+CREATE TABLE IF NOT EXISTS tags (
+    id serial PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name varchar(63) NOT NULL,
+    created_at timestamp DEFAULT current_timestamp NOT NULL,
+    UNIQUE (user_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS clip_tags (
+    clip_id integer NOT NULL REFERENCES clips(id) ON DELETE CASCADE,
+    tag_id integer NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+    PRIMARY KEY (clip_id, tag_id)
+);
+
+CREATE INDEX IF NOT EXISTS clip_tags_tag_id_idx ON clip_tags(tag_id);
+
+ALTER TABLE clips ADD COLUMN IF NOT EXISTS note varchar(280);
@@ -0,0 +1,8 @@
+-- Retention policies of users, within the global one. NULL limits don't limit.
+CREATE TABLE IF NOT EXISTS retention_policies (
+    user_id integer PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    max_age interval,
+    max_clips integer,
+    max_bytes bigint,
+    updated_at timestamp DEFAULT current_timestamp NOT NULL
+);
@@ -0,0 +1,72 @@
+-- Clips and bytes stored per user, kept up to date by triggers so that quotas
+-- are checked without summing the whole history
+CREATE TABLE IF NOT EXISTS user_usage (
+    user_id integer PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    clips bigint NOT NULL DEFAULT 0,
+    bytes bigint NOT NULL DEFAULT 0
+);
+
+CREATE OR REPLACE FUNCTION count_clip_usage() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        INSERT INTO user_usage (user_id, clips, bytes) VALUES (NEW.user_id, 1, NEW.size)
+        ON CONFLICT (user_id) DO UPDATE
+        SET clips = user_usage.clips + 1, bytes = user_usage.bytes + EXCLUDED.bytes;
+        RETURN NEW;
+    ELSIF TG_OP = 'DELETE' THEN
+        -- Runs before the representations are deleted along with the clip,
+        -- which can't find the clip anymore to count themselves out
+        UPDATE user_usage
+        SET clips = clips - 1,
+            bytes = bytes - OLD.size - (SELECT coalesce(sum(size), 0) FROM clip_representations WHERE clip_id = OLD.id)
+        WHERE user_id = OLD.user_id;
+        RETURN OLD;
+    END IF;
+    UPDATE user_usage SET bytes = bytes - OLD.size + NEW.size WHERE user_id = NEW.user_id;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Representations count towards the bytes of the user of their clip
+CREATE OR REPLACE FUNCTION count_representation_usage() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        UPDATE user_usage SET bytes = bytes + NEW.size
+        WHERE user_id = (SELECT user_id FROM clips WHERE id = NEW.clip_id);
+        RETURN NEW;
+    END IF;
+    -- Representations deleted along with their clip find no clip, the
+    -- clip counted them out already
+    UPDATE user_usage SET bytes = bytes - OLD.size
+    WHERE user_id = (SELECT user_id FROM clips WHERE id = OLD.clip_id);
+    RETURN OLD;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS clips_usage ON clips;
+CREATE TRIGGER clips_usage AFTER INSERT OR UPDATE OF size ON clips
+FOR EACH ROW EXECUTE FUNCTION count_clip_usage();
+
+DROP TRIGGER IF EXISTS clips_usage_delete ON clips;
+CREATE TRIGGER clips_usage_delete BEFORE DELETE ON clips
+FOR EACH ROW EXECUTE FUNCTION count_clip_usage();
+
+DROP TRIGGER IF EXISTS clip_representations_usage ON clip_representations;
+CREATE TRIGGER clip_representations_usage AFTER INSERT OR DELETE ON clip_representations
+FOR EACH ROW EXECUTE FUNCTION count_representation_usage();
+
+INSERT INTO user_usage (user_id, clips, bytes)
+SELECT c.user_id, count(*), sum(c.size) + coalesce(sum(r.size), 0)
+FROM clips c
+LEFT JOIN (SELECT clip_id, sum(size) AS size FROM clip_representations GROUP BY clip_id) r ON r.clip_id = c.id
+GROUP BY c.user_id
+ON CONFLICT (user_id) DO UPDATE SET clips = EXCLUDED.clips, bytes = EXCLUDED.bytes;
+
+-- Quotas set by admins, overriding the global ones. NULL keeps the global
+-- quota, 0 is unlimited.
+CREATE TABLE IF NOT EXISTS user_quotas (
+    user_id integer PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    max_clips bigint,
+    max_bytes bigint,
+    updated_at timestamp DEFAULT current_timestamp NOT NULL
+);
@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS users (
+    id integer PRIMARY KEY,
+    -- No unique constraint on uid. We go with the assumption that
+    -- uuid is always unique
+    uid text NOT NULL,
+    name varchar(127) NOT NULL,
+    email varchar(127) NOT NULL,
+    password_hash varchar(255) NOT NULL,
+    created_at timestamp DEFAULT current_timestamp NOT NULL,
+    UNIQUE(email)
+);
+
+-- Kept in redis with Postgres. Expired sessions are deleted by the sweeper.
+CREATE TABLE IF NOT EXISTS sessions (
+    id text PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    email varchar(127) NOT NULL,
+    login_time timestamp NOT NULL,
+    expires_at timestamp NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions(user_id);
+CREATE INDEX IF NOT EXISTS sessions_expires_at_idx ON sessions(expires_at);
@@ -0,0 +1,3 @@
+ALTER TABLE users ADD COLUMN role varchar(31) DEFAULT 'user' NOT NULL CHECK (role IN ('user', 'admin'));
+-- NULL while the account is active
+ALTER TABLE users ADD COLUMN disabled_at timestamp;
@@ -0,0 +1,30 @@
+-- Teams are not supported on SQLite yet
+CREATE TABLE IF NOT EXISTS teams (
+    id integer PRIMARY KEY,
+    uid text NOT NULL,
+    name varchar(127) NOT NULL,
+    created_at timestamp DEFAULT current_timestamp NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS team_members (
+    team_id integer NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+    user_id integer NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    -- owner: read, write and manage the team
+    -- writer: read and write
+    -- reader: read only
+    role varchar(31) NOT NULL CHECK (role IN ('owner', 'writer', 'reader')),
+    joined_at timestamp DEFAULT current_timestamp NOT NULL,
+    PRIMARY KEY (team_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS team_members_user_id_idx ON team_members(user_id);
+
+-- The clipboard of a channel is kept in redis, keyed by the channel uid
+CREATE TABLE IF NOT EXISTS team_channels (
+    id integer PRIMARY KEY,
+    uid text NOT NULL,
+    team_id integer NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+    name varchar(63) NOT NULL,
+    created_at timestamp DEFAULT current_timestamp NOT NULL,
+    UNIQUE(team_id, name)
+);
@@ -0,0 +1,23 @@
+-- History of broadcasted clips
+CREATE TABLE IF NOT EXISTS clips (
+    id integer PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    content text NOT NULL,
+    created_at timestamp DEFAULT current_timestamp NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS clips_user_id_created_at_idx ON clips(user_id, created_at DESC);
+
+-- The latest clip of a user for pasting, kept in redis with Postgres. kind is
+-- clip for plain text, once for burn after reading clips, consumed once they
+-- were pasted, ref for clips loaded from the history by clip_id, and
+-- sensitive. Expired rows are deleted by the sweeper.
+CREATE TABLE IF NOT EXISTS clipboards (
+    owner text PRIMARY KEY,
+    kind varchar(15) NOT NULL CHECK (kind IN ('clip', 'once', 'consumed', 'ref', 'sensitive')),
+    content text NOT NULL DEFAULT '',
+    clip_id integer,
+    expires_at timestamp
+);
+
+CREATE INDEX IF NOT EXISTS clipboards_expires_at_idx ON clipboards(expires_at) WHERE expires_at IS NOT NULL;
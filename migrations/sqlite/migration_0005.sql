@@ -0,0 +1,4 @@
+-- Burn after reading clips are kept in the clipboard until pasted. Their
+-- history entry has an empty content.
+ALTER TABLE clips ADD COLUMN once boolean DEFAULT false NOT NULL;
+ALTER TABLE clips ADD COLUMN consumed_at timestamp;
@@ -0,0 +1,23 @@
+-- Devices are not supported on SQLite yet
+CREATE TABLE IF NOT EXISTS devices (
+    id integer PRIMARY KEY,
+    uid text NOT NULL UNIQUE,
+    user_id integer NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name varchar(127) NOT NULL,
+    created_at timestamp DEFAULT current_timestamp NOT NULL,
+    last_seen_at timestamp
+);
+
+CREATE INDEX IF NOT EXISTS devices_user_id_idx ON devices(user_id);
+
+-- Inbox of clips sent to specific devices instead of all of them
+CREATE TABLE IF NOT EXISTS clip_deliveries (
+    clip_id integer NOT NULL REFERENCES clips(id) ON DELETE CASCADE,
+    device_id integer NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
+    state varchar(15) DEFAULT 'pending' NOT NULL CHECK (state IN ('pending', 'delivered', 'read')),
+    delivered_at timestamp,
+    read_at timestamp,
+    PRIMARY KEY (clip_id, device_id)
+);
+
+CREATE INDEX IF NOT EXISTS clip_deliveries_device_id_state_idx ON clip_deliveries(device_id, state);
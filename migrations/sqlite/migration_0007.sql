@@ -0,0 +1,8 @@
+-- File clips keep their bytes in data and have an empty content. size is the
+-- size of whichever one is used.
+ALTER TABLE clips ADD COLUMN content_type varchar(255) DEFAULT 'text/plain; charset=utf-8' NOT NULL;
+ALTER TABLE clips ADD COLUMN file_name varchar(255);
+ALTER TABLE clips ADD COLUMN data blob;
+ALTER TABLE clips ADD COLUMN size bigint DEFAULT 0 NOT NULL;
+
+UPDATE clips SET size = length(CAST(content AS blob));
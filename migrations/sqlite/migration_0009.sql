@@ -0,0 +1,13 @@
+-- Other representations of a clip, e.g. the text/html and image/png flavors
+-- copied along with text/plain. The clip itself holds the primary one.
+-- Representations are not supported on SQLite yet.
+CREATE TABLE IF NOT EXISTS clip_representations (
+    clip_id integer NOT NULL REFERENCES clips(id) ON DELETE CASCADE,
+    content_type varchar(255) NOT NULL,
+    data blob,
+    blob_key varchar(255),
+    size bigint NOT NULL,
+    PRIMARY KEY (clip_id, content_type)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS clip_representations_blob_key_idx ON clip_representations (blob_key) WHERE blob_key IS NOT NULL;
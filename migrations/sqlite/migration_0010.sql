@@ -0,0 +1,3 @@
+-- Language of text clips, detected or picked when broadcasting. NULL for
+-- prose and for clips that are not text.
+ALTER TABLE clips ADD COLUMN language varchar(31);
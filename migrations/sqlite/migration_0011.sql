@@ -0,0 +1,3 @@
+-- Clips that look like secrets keep only their metadata in the history, their
+-- content expires from the clipboard.
+ALTER TABLE clips ADD COLUMN sensitive boolean NOT NULL DEFAULT false;
@@ -0,0 +1,3 @@
+-- Codec the data or blob of a clip is compressed with, NULL if it is not
+-- compressed. size stays the uncompressed size.
+ALTER TABLE clips ADD COLUMN codec varchar(15);
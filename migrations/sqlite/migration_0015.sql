@@ -0,0 +1,2 @@
+-- Full-text search relies on tsvector, it is not supported on SQLite. Kept so
+-- that both dialects share the migration numbers.
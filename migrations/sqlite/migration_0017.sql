@@ -0,0 +1,9 @@
+-- Retention policies of users, within the global one. NULL limits don't limit.
+-- max_age is in nanoseconds, there is no interval type.
+CREATE TABLE IF NOT EXISTS retention_policies (
+    user_id integer PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    max_age bigint,
+    max_clips integer,
+    max_bytes bigint,
+    updated_at timestamp DEFAULT current_timestamp NOT NULL
+);